@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/qujing226/screen_sage/domain/model"
+	"github.com/qujing226/screen_sage/internal/prompt"
+	"github.com/qujing226/screen_sage/internal/storage"
+	"github.com/qujing226/screen_sage/internal/usage"
+)
+
+const (
+	// defaultCompletionTokens 记录没有输出token数时，估算费用使用的回答长度
+	defaultCompletionTokens = 500
+	// imageTokens 估算视觉模型的输入时，一张截图按该token数计算
+	imageTokens = 1000
+)
+
+// ReprocessRequest 批量重新生成回答的参数
+type ReprocessRequest struct {
+	// Filter 选择要重新生成的记录，追问记录和缺少截图的记录会被跳过
+	Filter storage.HistoryFilter
+	// Mode 生成回答的方式，为空时与各记录的原回答相同
+	Mode PipelineMode
+	// Profile 提示词配置名称，为空时使用各记录原来的配置
+	Profile string
+}
+
+// ReprocessPlan 将要重新生成的记录和预计用量
+type ReprocessPlan struct {
+	Records    []int64              `json:"records"`               // 将要重新生成的记录ID，按ID从旧到新排列
+	Skipped    []SkippedRecord      `json:"skipped"`               // 不能重新生成的记录
+	Estimate   storage.UsageSummary `json:"estimate"`              // 预计用量，输入按提示词长度估算，输出参考原回答的长度
+	LimitError string               `json:"limit_error,omitempty"` // 加上预计用量后超出限额的原因
+}
+
+// SkippedRecord 不能重新生成的记录及原因
+type SkippedRecord struct {
+	ID     int64  `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// ReprocessOptions 批量重新生成的执行选项
+type ReprocessOptions struct {
+	// Mode 生成回答的方式，为空时与各记录的原回答相同
+	Mode PipelineMode
+	// Profile 提示词配置名称，为空时使用各记录原来的配置
+	Profile string
+	// Model 生成回答使用的模型名称，为空时使用配置中的模型，指定时 Mode 必须为 ocr 或 vision
+	Model string
+	// Interval 相邻两条记录开始处理的最小间隔，避免触发服务商的频率限制
+	Interval time.Duration
+	// OnProgress 每条记录处理结束后和全部结束时的回调
+	OnProgress func(ReprocessProgress)
+}
+
+// ReprocessProgress 批量重新生成的进度
+type ReprocessProgress struct {
+	Total    int     `json:"total"`
+	Done     int     `json:"done"`                // 已生成新回答的记录数
+	Failed   int     `json:"failed"`              // 生成失败的记录数，这些记录保留原回答
+	Cost     float64 `json:"cost"`                // 新回答的估算费用
+	RecordID int64   `json:"record_id,omitempty"` // 刚处理完的记录
+	Error    string  `json:"error,omitempty"`     // 刚处理完的记录失败的原因
+	Finished bool    `json:"finished"`
+	Stopped  string  `json:"stopped,omitempty"` // 提前结束的原因，例如取消或超出用量限额
+
+	Screenshot *model.Screenshot `json:"-"` // 刚生成新回答的记录
+}
+
+// PlanReprocess 按筛选条件找出可以重新生成的记录并估算用量，不调用任何接口
+func (s *ScreenshotService) PlanReprocess(req ReprocessRequest) (*ReprocessPlan, error) {
+	st := s.Settings()
+	profiles := make(map[string]*storage.PromptProfile)
+	if req.Profile != "" {
+		profile, err := s.resolveProfile(st, req.Profile)
+		if err != nil {
+			return nil, err
+		}
+		profiles[req.Profile] = profile
+	}
+
+	records, err := s.Db.FindHistory(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	plan := &ReprocessPlan{Records: []int64{}, Skipped: []SkippedRecord{}}
+	previousAnswer := s.previousAnswer()
+	for i := range records {
+		record := &records[i]
+		mode := req.Mode
+		if mode == "" {
+			mode = recordMode(record)
+		}
+		if err := CheckRegenerate(record, mode); err != nil {
+			plan.Skipped = append(plan.Skipped, SkippedRecord{record.ID, err.Error()})
+			continue
+		}
+		if mode != ModeOCR || needsOCR(record, mode) {
+			if _, err := os.Stat(record.ImagePath); err != nil {
+				plan.Skipped = append(plan.Skipped, SkippedRecord{record.ID, "截图文件不存在"})
+				continue
+			}
+		}
+		name := req.Profile
+		if name == "" {
+			name = record.PromptProfile
+		}
+		profile, ok := profiles[name]
+		if !ok {
+			if profile, err = s.resolveProfile(st, name); err != nil {
+				plan.Skipped = append(plan.Skipped, SkippedRecord{record.ID, err.Error()})
+				continue
+			}
+			profiles[name] = profile
+		}
+
+		plan.Records = append(plan.Records, record.ID)
+		plan.Estimate.Add(st.estimateUsage(record, mode, profile, previousAnswer))
+	}
+
+	// 加上预计用量后检查限额，实际执行时每条记录开始前还会再检查
+	if st.Limits.Enabled() && len(plan.Records) > 0 {
+		now := time.Now()
+		today, err := s.Db.GetUsage(usage.StartOfDay(now), usage.StartOfDay(now).AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+		month, err := s.Db.GetUsage(usage.StartOfMonth(now), usage.StartOfMonth(now).AddDate(0, 1, 0))
+		if err != nil {
+			return nil, err
+		}
+		today.Add(plan.Estimate)
+		month.Add(plan.Estimate)
+		if err := st.Limits.Check(*today, *month); err != nil {
+			plan.LimitError = err.Error()
+		}
+	}
+	return plan, nil
+}
+
+// Reprocess 依次重新生成记录的回答，原回答保存为历史版本
+// 超出用量限额或ctx取消时停止，剩余的记录保持不变；单条记录失败不影响其他记录
+func (s *ScreenshotService) Reprocess(ctx context.Context, ids []int64, opts ReprocessOptions) ReprocessProgress {
+	progress := ReprocessProgress{Total: len(ids)}
+	var started time.Time
+	for _, id := range ids {
+		if wait := opts.Interval - time.Since(started); !started.IsZero() && wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+		if ctx.Err() != nil {
+			progress.Stopped = "已取消"
+			break
+		}
+		started = time.Now()
+
+		release, err := s.Claim(id)
+		var screenshot *model.Screenshot
+		if err == nil {
+			screenshot, err = s.Regenerate(id, RegenerateOptions{Mode: opts.Mode, Profile: opts.Profile, Model: opts.Model})
+			release()
+		}
+		if errors.Is(err, usage.ErrLimitExceeded) {
+			progress.Stopped = err.Error()
+			break
+		}
+
+		progress.RecordID, progress.Error, progress.Screenshot = id, "", screenshot
+		if err != nil {
+			progress.Failed++
+			progress.Error = err.Error()
+		} else {
+			progress.Done++
+			progress.Cost += s.Settings().answerCost(screenshot)
+		}
+		if opts.OnProgress != nil {
+			opts.OnProgress(progress)
+		}
+	}
+
+	progress.Finished = true
+	progress.RecordID, progress.Error, progress.Screenshot = 0, "", nil
+	if opts.OnProgress != nil {
+		opts.OnProgress(progress)
+	}
+	return progress
+}
+
+// estimateUsage 估算重新生成一条记录的用量
+func (st Settings) estimateUsage(record *storage.HistoryRecord, mode PipelineMode, profile *storage.PromptProfile, previousAnswer string) storage.UsageSummary {
+	estimate := storage.UsageSummary{Requests: 1}
+	if needsOCR(record, mode) {
+		estimate.OCRCalls = 1
+	}
+
+	systemPrompt, userPrompt, err := prompt.Render(*profile, prompt.Data{
+		Text:           record.Text,
+		RawText:        record.RawText,
+		Timestamp:      record.Timestamp,
+		Language:       st.Language,
+		PreviousAnswer: previousAnswer,
+		FromImage:      mode == ModeVision,
+	})
+	if err == nil {
+		estimate.PromptTokens = estimateTokens(systemPrompt) + estimateTokens(userPrompt)
+	}
+	if mode == ModeVision {
+		estimate.PromptTokens += imageTokens
+	}
+	estimate.CompletionTokens = record.CompletionTokens
+	if estimate.CompletionTokens == 0 {
+		estimate.CompletionTokens = defaultCompletionTokens
+	}
+
+	tokens := model.TokenUsage{PromptTokens: estimate.PromptTokens, CompletionTokens: estimate.CompletionTokens}
+	estimate.Cost = st.Prices.Estimate(mode == ModeVision, tokens, estimate.OCRCalls)
+	return estimate
+}
+
+// estimateTokens 粗略估算文本的token数：中文等非ASCII字符每个按1个计算，其余每4个字符按1个计算
+func estimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return other + (ascii+3)/4
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/qujing226/screen_sage/domain/model"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+var (
+	// ErrNothingToRetry 记录的各阶段都已成功，没有需要重试的阶段
+	ErrNothingToRetry = errors.New("记录没有失败的阶段")
+	// ErrFollowUpRecord 追问的回答依赖原记录，不能单独重试或重新生成
+	ErrFollowUpRecord = errors.New("追问记录不支持重新处理，请重新追问")
+	// ErrNoImage 记录没有保存截图，无法重新识别或使用视觉模型
+	ErrNoImage = errors.New("记录没有保存截图")
+	// ErrRecordBusy 记录正在重试或重新生成
+	ErrRecordBusy = errors.New("记录正在重新处理")
+	// ErrModelNeedsMode 文本模型和视觉模型的名称不通用，指定模型时必须同时指定生成方式
+	ErrModelNeedsMode = errors.New("指定模型时生成方式必须为 ocr 或 vision")
+)
+
+// RegenerateOptions 重新生成回答的选项
+type RegenerateOptions struct {
+	// Mode 生成回答的方式，ocr 使用识别文本和文本模型，vision 使用截图和视觉模型，
+	// auto 识别文字过少时使用视觉模型，为空时与原回答相同
+	Mode PipelineMode
+	// Profile 提示词配置名称，为空时使用原回答的配置
+	Profile string
+	// Model 生成回答使用的模型名称，为空时使用配置中的模型；ocr 模式下为文本模型，vision 模式下为视觉模型
+	Model string
+	// OnOCRComplete 需要先识别截图时，OCR完成后的回调
+	OnOCRComplete func(text string)
+}
+
+// CheckModel 检查能否使用指定的模型重新生成回答，modelName 为空时总是可以
+func (s *ScreenshotService) CheckModel(mode PipelineMode, modelName string) error {
+	_, err := s.Settings().withModel(mode, modelName)
+	return err
+}
+
+// withModel 返回使用指定模型生成回答的设置，modelName 为空时不修改
+func (st Settings) withModel(mode PipelineMode, modelName string) (Settings, error) {
+	switch {
+	case modelName == "":
+	case mode == ModeOCR:
+		st.TextModel = modelName
+	case mode == ModeVision:
+		provider, ok := st.VisionProvider.(ModelVisionProvider)
+		if !ok {
+			return st, fmt.Errorf("视觉模型不支持指定模型")
+		}
+		st.VisionProvider = visionModel{provider: provider, modelName: modelName}
+	default:
+		return st, ErrModelNeedsMode
+	}
+	return st, nil
+}
+
+// CheckRetry 检查记录是否有可以重试的阶段
+func CheckRetry(record *storage.HistoryRecord) error {
+	if record.Source == model.SourceFollowUp {
+		return ErrFollowUpRecord
+	}
+	if !stageUnfinished(record.OCRStatus) && !stageUnfinished(record.AnswerStatus) {
+		return ErrNothingToRetry
+	}
+	if stageUnfinished(record.OCRStatus) && record.ImagePath == "" {
+		return ErrNoImage
+	}
+	return nil
+}
+
+// CheckRegenerate 检查记录能否按指定方式重新生成回答，mode 为空时与原回答相同
+func CheckRegenerate(record *storage.HistoryRecord, mode PipelineMode) error {
+	if record.Source == model.SourceFollowUp {
+		return ErrFollowUpRecord
+	}
+	if mode == "" {
+		mode = recordMode(record)
+	}
+	if (mode != ModeOCR || needsOCR(record, mode)) && record.ImagePath == "" {
+		return ErrNoImage
+	}
+	return nil
+}
+
+// Claim 标记记录正在重新处理，同一条记录同时只进行一次重试或重新生成，处理结束后调用release
+func (s *ScreenshotService) Claim(recordID int64) (release func(), err error) {
+	if _, busy := s.busy.LoadOrStore(recordID, true); busy {
+		return nil, ErrRecordBusy
+	}
+	return func() { s.busy.Delete(recordID) }, nil
+}
+
+// Retry 从保存的截图重新执行记录中失败或尚未执行的阶段，已成功的阶段保持不变
+// 待处理记录没有保存处理模式，按当前的默认模式处理
+func (s *ScreenshotService) Retry(recordID int64, onOCRComplete func(text string)) (*model.Screenshot, error) {
+	record, err := s.record(recordID)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckRetry(record); err != nil {
+		return nil, err
+	}
+	st := s.Settings()
+	if err := s.checkLimits(st); err != nil {
+		return nil, err
+	}
+	profile, err := s.resolveProfile(st, record.PromptProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := recordMode(record)
+	if record.Status == model.StatusPending {
+		mode = st.DefaultMode
+		if mode == "" {
+			mode = ModeOCR
+		}
+	}
+	retryOCR, retryAnswer := stageUnfinished(record.OCRStatus), stageUnfinished(record.AnswerStatus)
+
+	var imgBytes []byte
+	var imageBase64 string
+	if retryOCR || (retryAnswer && mode != ModeOCR) {
+		if imgBytes, imageBase64, err = readImage(record); err != nil {
+			return nil, err
+		}
+	}
+
+	screenshot := screenshotFromRecord(record)
+	ocrCalls, answered := screenshot.OCRCalls, false
+	if retryOCR {
+		s.recognize(st, screenshot, imgBytes, imageBase64, onOCRComplete)
+		if mode == ModeAuto && (screenshot.OCRStatus == model.StageFailed || st.tooLittleText(screenshot.Text)) {
+			log.Printf("OCR识别文字过少，改用视觉模型回答")
+			mode = ModeVision
+		}
+	}
+	if retryAnswer && (screenshot.OCRStatus != model.StageFailed || mode == ModeVision) {
+		s.answerScreenshot(st, screenshot, mode, profile, imgBytes, imageBase64)
+		answered = true
+	}
+
+	// 本次的用量按执行时间单独计入，记录原来的用量仍计入截图的日期
+	screenshot.Status = model.StatusDone
+	if err := s.update(screenshot, nil, st.attemptUsage(screenshot, ocrCalls, answered)); err != nil {
+		return nil, err
+	}
+	return screenshot, nil
+}
+
+// Regenerate 重新生成记录的回答，可以换用其他模型或提示词配置
+// 原回答保存为历史版本；生成失败时保留原回答并返回错误，只保存重新识别的结果
+func (s *ScreenshotService) Regenerate(recordID int64, opts RegenerateOptions) (*model.Screenshot, error) {
+	record, err := s.record(recordID)
+	if err != nil {
+		return nil, err
+	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = recordMode(record)
+	}
+	if err := CheckRegenerate(record, mode); err != nil {
+		return nil, err
+	}
+	st, err := s.Settings().withModel(mode, opts.Model)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkLimits(st); err != nil {
+		return nil, err
+	}
+	profileName := opts.Profile
+	if profileName == "" {
+		profileName = record.PromptProfile
+	}
+	profile, err := s.resolveProfile(st, profileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var imgBytes []byte
+	var imageBase64 string
+	ocrNeeded := needsOCR(record, mode)
+	if mode != ModeOCR || ocrNeeded {
+		if imgBytes, imageBase64, err = readImage(record); err != nil {
+			return nil, err
+		}
+	}
+
+	screenshot := screenshotFromRecord(record)
+	ocrCalls := screenshot.OCRCalls
+	if ocrNeeded {
+		s.recognize(st, screenshot, imgBytes, imageBase64, opts.OnOCRComplete)
+		if mode == ModeAuto && (screenshot.OCRStatus == model.StageFailed || st.tooLittleText(screenshot.Text)) {
+			log.Printf("OCR识别文字过少，改用视觉模型回答")
+			mode = ModeVision
+		}
+		if screenshot.OCRStatus == model.StageFailed && mode != ModeVision {
+			if err := s.update(screenshot, nil, st.attemptUsage(screenshot, ocrCalls, false)); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("OCR识别失败: %s", screenshot.OCRError)
+		}
+	}
+
+	var images []string
+	if mode == ModeVision {
+		images = []string{dataURL(imgBytes, imageBase64)}
+	}
+	answer, tokens, err := s.generate(st, profile, s.promptData(st, screenshot), images)
+	if err != nil {
+		log.Printf("重新生成回答失败: %v", err)
+		if ocrNeeded {
+			if err := s.update(screenshot, nil, st.attemptUsage(screenshot, ocrCalls, false)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, fmt.Errorf("生成回答失败: %v", err)
+	}
+
+	// 原回答连同它的用量一起转入历史版本，记录只保留新回答的费用，版本与记录在同一个事务中保存；
+	// 用量统计中原回答仍按生成时间计入，新回答按本次的执行时间计入
+	previousCost := st.answerCost(screenshot)
+	var replaced *storage.AnswerVersion
+	if screenshot.AnswerStatus == model.StageOK {
+		replaced = &storage.AnswerVersion{
+			ReplacedAt:       time.Now(),
+			Answer:           screenshot.Answer,
+			Title:            screenshot.Title,
+			Source:           screenshot.Source,
+			PromptProfile:    screenshot.PromptProfile,
+			PromptVersion:    screenshot.PromptVersion,
+			PromptTokens:     screenshot.PromptTokens,
+			CompletionTokens: screenshot.CompletionTokens,
+			Cost:             previousCost,
+		}
+	}
+	screenshot.Cost -= previousCost
+	if screenshot.Source != model.SourceText {
+		screenshot.Source = model.SourceOCR
+		if mode == ModeVision {
+			screenshot.Source = model.SourceImage
+		}
+	}
+	st.applyAnswer(screenshot, profile, mode == ModeVision, answer, tokens, nil)
+
+	screenshot.Status = model.StatusDone
+	if err := s.update(screenshot, replaced, st.attemptUsage(screenshot, ocrCalls, true)); err != nil {
+		return nil, err
+	}
+	return screenshot, nil
+}
+
+// AnswerVersions 获取记录重新生成前的回答，按时间从旧到新排列
+func (s *ScreenshotService) AnswerVersions(recordID int64) ([]storage.AnswerVersion, error) {
+	if _, err := s.record(recordID); err != nil {
+		return nil, err
+	}
+	return s.Db.ListAnswerVersions(recordID)
+}
+
+// stageUnfinished 判断阶段是否失败或尚未执行
+func stageUnfinished(status string) bool {
+	return status == model.StageFailed || status == model.StagePending
+}
+
+// recordMode 返回记录原来生成回答的方式，文本提问附带多张图片时重新生成只使用第一张
+func recordMode(record *storage.HistoryRecord) PipelineMode {
+	switch {
+	case record.Source == model.SourceImage:
+		return ModeVision
+	case record.Source == model.SourceText && record.ImagePath != "":
+		return ModeVision
+	default:
+		return ModeOCR
+	}
+}
+
+// needsOCR 判断按mode生成回答前是否需要先识别截图，文本提问直接使用提交的文本
+func needsOCR(record *storage.HistoryRecord, mode PipelineMode) bool {
+	return mode != ModeVision && record.Source != model.SourceText && record.OCRStatus != model.StageOK
+}
+
+// readImage 读取记录保存的截图
+func readImage(record *storage.HistoryRecord) ([]byte, string, error) {
+	if record.ImagePath == "" {
+		return nil, "", ErrNoImage
+	}
+	imgBytes, err := os.ReadFile(record.ImagePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("读取截图失败: %v", err)
+	}
+	return imgBytes, base64.StdEncoding.EncodeToString(imgBytes), nil
+}
@@ -1,20 +1,38 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/qujing226/screen_sage/internal/ocr"
+	"github.com/qujing226/screen_sage/internal/prompt"
 	"github.com/qujing226/screen_sage/internal/storage"
+	"github.com/qujing226/screen_sage/internal/textclean"
+	"github.com/qujing226/screen_sage/internal/usage"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/qujing226/screen_sage/domain/model"
 )
 
+// ErrAbandoned 退出期限已到，处理结果被放弃，截图已保存为待处理记录
+var ErrAbandoned = errors.New("截图处理已中止")
+
+// ErrRecordNotFound 指定的历史记录不存在
+var ErrRecordNotFound = errors.New("记录不存在")
+
 // OCRProvider 定义OCR服务提供者接口
 type OCRProvider interface {
 	RecognizeText(imageBase64 string) (string, error)
@@ -25,107 +43,662 @@ type AIProvider interface {
 	GenerateAnswer(text string) (string, error)
 }
 
+// LineRecognizer 可选接口，OCR提供者能返回带位置信息的文本行时实现
+type LineRecognizer interface {
+	RecognizeLines(imageBase64 string) ([]model.OCRLine, error)
+}
+
+// VisionProvider 定义多模态服务提供者接口，直接根据图片和提示词生成回答
+type VisionProvider interface {
+	AnswerImage(imageBase64, systemPrompt, userPrompt string) (string, model.TokenUsage, error)
+}
+
+// MultiImageProvider 可选接口，视觉模型能在一次提问中接收多张图片时实现
+type MultiImageProvider interface {
+	AnswerImages(images []string, systemPrompt, userPrompt string) (string, model.TokenUsage, error)
+}
+
+// ModelVisionProvider 可选接口，视觉模型能按次换用同一服务商的其他模型时实现
+type ModelVisionProvider interface {
+	AnswerImageWithModel(modelName, imageBase64, systemPrompt, userPrompt string) (string, model.TokenUsage, error)
+}
+
+// visionModel 使用指定模型回答的视觉模型
+type visionModel struct {
+	provider  ModelVisionProvider
+	modelName string
+}
+
+func (v visionModel) AnswerImage(imageBase64, systemPrompt, userPrompt string) (string, model.TokenUsage, error) {
+	return v.provider.AnswerImageWithModel(v.modelName, imageBase64, systemPrompt, userPrompt)
+}
+
+// PipelineMode 表示截图的处理模式
+type PipelineMode string
+
+const (
+	// ModeOCR 先OCR识别文本，再将文本交给AI回答
+	ModeOCR PipelineMode = "ocr"
+	// ModeVision 跳过OCR，直接将图片交给多模态模型回答
+	ModeVision PipelineMode = "vision"
+	// ModeAuto 先OCR识别，识别出的文字过少时改用多模态模型
+	ModeAuto PipelineMode = "auto"
+)
+
+// ParsePipelineMode 解析处理模式，空字符串返回空模式表示使用默认值
+func ParsePipelineMode(mode string) (PipelineMode, error) {
+	switch PipelineMode(strings.ToLower(strings.TrimSpace(mode))) {
+	case "":
+		return "", nil
+	case ModeOCR:
+		return ModeOCR, nil
+	case ModeVision:
+		return ModeVision, nil
+	case ModeAuto:
+		return ModeAuto, nil
+	default:
+		return "", fmt.Errorf("未知的处理模式: %s", mode)
+	}
+}
+
+// ProcessOptions 单次截图处理的选项
+type ProcessOptions struct {
+	// Mode 处理模式，为空时使用服务的默认模式
+	Mode PipelineMode
+	// Profile 提示词配置名称，为空时使用服务的默认配置
+	Profile string
+	// OnOCRComplete OCR完成后的回调，用于推送进度
+	OnOCRComplete func(text string)
+	// Context 取消后不再等待识别和回答，截图保存为待处理记录，用于程序退出时的收尾
+	Context context.Context
+}
+
+// TextRequest 直接提交的文本提问，跳过截图和OCR
+type TextRequest struct {
+	// Text 提问内容，作为识别文本填入提示词模板
+	Text string
+	// Images 附带的图片，有图片时使用视觉模型回答
+	Images [][]byte
+	// Profile 提示词配置名称，为空时使用服务的默认配置
+	Profile string
+}
+
+// Settings 截图服务中可在运行时替换的设置
+type Settings struct {
+	OCRProvider    OCRProvider
+	VisionProvider VisionProvider
+	DeepseekKey    string
+	// TextModel 基于识别文本回答使用的DeepSeek模型，为空时使用 deepseek-chat
+	TextModel string
+
+	// DefaultMode 未指定模式时使用的处理模式
+	DefaultMode PipelineMode
+	// VisionFallbackChars 自动模式下OCR文字少于该字符数时改用视觉模型
+	VisionFallbackChars int
+	// DefaultProfile 未指定时使用的提示词配置
+	DefaultProfile string
+	// Language 回答使用的语言，传入提示词模板
+	Language string
+	// Prices 估算费用使用的价格
+	Prices usage.Prices
+	// Limits 每日和每月的用量限额
+	Limits usage.Limits
+}
+
 // ScreenshotService 截图服务
 type ScreenshotService struct {
-	Db          *storage.DBManager
-	OCRProvider OCRProvider
+	Db *storage.DBManager
+	// ImageDir 截图保存目录，为空时使用当前目录下的images
+	ImageDir string
 	//AIProvider  AIProvider
-	DeepseekKey string
+
+	settings Settings
+	mutex    sync.RWMutex
+	busy     sync.Map // 正在重新处理的记录ID，见Claim
 }
 
 // NewScreenshotService 创建截图服务
 func NewScreenshotService(
 	db *storage.DBManager,
 	ocrProvider OCRProvider,
+	visionProvider VisionProvider,
 	deepseekKey string,
 ) *ScreenshotService {
 	return &ScreenshotService{
-		Db:          db,
-		OCRProvider: ocrProvider,
-		DeepseekKey: deepseekKey,
+		Db: db,
+		settings: Settings{
+			OCRProvider:         ocrProvider,
+			VisionProvider:      visionProvider,
+			DeepseekKey:         deepseekKey,
+			DefaultMode:         ModeOCR,
+			VisionFallbackChars: 20,
+			DefaultProfile:      prompt.DefaultProfileName,
+		},
 	}
 }
 
-// ProcessScreenshot 处理截图
+// Settings 返回当前设置的副本
+func (s *ScreenshotService) Settings() Settings {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.settings
+}
+
+// ApplySettings 替换服务设置，正在处理的截图继续使用开始时的设置
+func (s *ScreenshotService) ApplySettings(settings Settings) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.settings = settings
+}
+
+// ProcessScreenshot 使用默认模式处理截图
 func (s *ScreenshotService) ProcessScreenshot(imgBytes []byte) (*model.Screenshot, error) {
-	// 保存图片到文件
-	timestamp := time.Now().Format("20060102150405")
+	return s.ProcessScreenshotWithOptions(imgBytes, ProcessOptions{})
+}
+
+// ProcessScreenshotWithOptions 按指定选项处理截图
+func (s *ScreenshotService) ProcessScreenshotWithOptions(imgBytes []byte, opts ProcessOptions) (*model.Screenshot, error) {
+	// 整个处理过程使用同一份设置，避免中途配置变更导致前后不一致
+	st := s.Settings()
+
+	mode := opts.Mode
+	if mode == "" {
+		mode = st.DefaultMode
+	}
+	if mode == "" {
+		mode = ModeOCR
+	}
 
-	// 获取当前可执行文件所在目录
-	execDir, err := os.Executable()
+	// 超出用量限额时直接拒绝，不再调用任何付费接口
+	if err := s.checkLimits(st); err != nil {
+		return nil, err
+	}
+
+	// 先确认提示词配置存在，避免识别完成后才发现配置错误
+	profile, err := s.resolveProfile(st, opts.Profile)
 	if err != nil {
-		return nil, fmt.Errorf("获取可执行文件路径失败: %v", err)
+		return nil, err
 	}
 
-	// 使用可执行文件所在目录作为基础目录
-	baseDir := filepath.Dir(execDir)
-	imageDir := filepath.Join(baseDir, "images")
-	if err := os.MkdirAll(imageDir, 0755); err != nil {
-		return nil, fmt.Errorf("创建图片目录失败: %v", err)
+	// 保存图片到文件
+	timestamp := time.Now()
+	imagePath, err := saveImage(s.ImageDir, imgBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// 已经在退出时不再调用任何接口
+	if ctx.Err() != nil {
+		return s.savePending(imagePath, imgBytes, timestamp)
 	}
 
-	// 生成文件名
-	imageFilename := fmt.Sprintf("%s.png", timestamp)
-	imagePath := filepath.Join(imageDir, imageFilename)
+	// 识别和回答可能耗时较长，在单独的协程中进行；
+	// 退出期限到达时由先取得保存权的一方写入记录，避免重复保存
+	var claimed atomic.Bool
+	type outcome struct {
+		screenshot *model.Screenshot
+		err        error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		screenshot, err := s.answer(st, mode, profile, opts, imgBytes, imagePath, timestamp, &claimed)
+		done <- outcome{screenshot, err}
+	}()
 
-	// 写入文件
-	if err := os.WriteFile(imagePath, imgBytes, 0644); err != nil {
-		return nil, fmt.Errorf("保存图片失败: %v", err)
+	select {
+	case o := <-done:
+		return o.screenshot, o.err
+	case <-ctx.Done():
+		if !claimed.CompareAndSwap(false, true) {
+			// 结果已在保存中，等待保存完成
+			o := <-done
+			return o.screenshot, o.err
+		}
+		log.Printf("截图尚未处理完成，保存为待处理记录: %s", imagePath)
+		return s.savePending(imagePath, imgBytes, timestamp)
 	}
+}
 
-	// 转换为Base64
+// answer 识别截图并生成回答，claimed 由其他方取得时放弃保存
+// 识别或回答失败时记录对应阶段的失败原因，之后可以只重试失败的阶段
+func (s *ScreenshotService) answer(st Settings, mode PipelineMode, profile *storage.PromptProfile, opts ProcessOptions,
+	imgBytes []byte, imagePath string, timestamp time.Time, claimed *atomic.Bool) (*model.Screenshot, error) {
+	// 转换为Base64，缩略图直接使用Base64
 	imageBase64 := base64.StdEncoding.EncodeToString(imgBytes)
+	screenshot := model.NewScreenshot(imagePath, dataURL(imgBytes, imageBase64), "", "", "")
+	screenshot.Timestamp = timestamp
+	screenshot.PromptProfile = profile.Name
+	screenshot.PromptVersion = profile.Version
+	screenshot.OCRStatus = model.StageSkipped
+
+	// OCR识别，并清洗掉屏幕界面噪声
+	if mode != ModeVision {
+		s.recognize(st, screenshot, imgBytes, imageBase64, opts.OnOCRComplete)
+		if mode == ModeAuto && (screenshot.OCRStatus == model.StageFailed || st.tooLittleText(screenshot.Text)) {
+			log.Printf("OCR识别文字过少，改用视觉模型回答")
+			mode = ModeVision
+		}
+	}
 
-	// OCR识别
-	text, err := s.OcrRecognize(imageBase64)
+	if screenshot.OCRStatus == model.StageFailed && mode != ModeVision {
+		// 没有识别文本时不生成回答，重试时先重新识别
+		screenshot.AnswerStatus = model.StagePending
+	} else {
+		s.answerScreenshot(st, screenshot, mode, profile, imgBytes, imageBase64)
+	}
+
+	// 保存到仓库
+	if !claimed.CompareAndSwap(false, true) {
+		return nil, ErrAbandoned
+	}
+	if err := s.save(screenshot); err != nil {
+		return nil, err
+	}
+	return screenshot, nil
+}
+
+// recognize 执行OCR阶段，结果和状态写入screenshot，并计入OCR调用次数和费用
+func (s *ScreenshotService) recognize(st Settings, screenshot *model.Screenshot, imgBytes []byte, imageBase64 string, onComplete func(text string)) {
+	screenshot.OCRCalls++
+	screenshot.Cost += st.Prices.Estimate(false, model.TokenUsage{}, 1)
+
+	rawText, text, err := s.recognizeAndClean(st, imgBytes, imageBase64)
 	if err != nil {
 		log.Printf("OCR识别失败: %v", err)
-		text = "OCR识别失败"
+		screenshot.Text, screenshot.RawText = "", ""
+		screenshot.OCRStatus, screenshot.OCRError = model.StageFailed, err.Error()
+		return
+	}
+	screenshot.Text, screenshot.RawText = text, rawText
+	screenshot.OCRStatus, screenshot.OCRError = model.StageOK, ""
+	if onComplete != nil {
+		onComplete(text)
+	}
+}
+
+// answerScreenshot 执行回答阶段，vision 模式基于截图回答，其他模式基于识别文本回答
+// 原有回答的费用被新回答的费用替换
+func (s *ScreenshotService) answerScreenshot(st Settings, screenshot *model.Screenshot, mode PipelineMode,
+	profile *storage.PromptProfile, imgBytes []byte, imageBase64 string) {
+	var images []string
+	if mode == ModeVision {
+		images = []string{dataURL(imgBytes, imageBase64)}
+	}
+	answer, tokens, err := s.generate(st, profile, s.promptData(st, screenshot), images)
+
+	screenshot.Cost -= st.answerCost(screenshot)
+	if screenshot.Source != model.SourceText {
+		screenshot.Source = model.SourceOCR
+		if mode == ModeVision {
+			screenshot.Source = model.SourceImage
+		}
+	}
+	st.applyAnswer(screenshot, profile, mode == ModeVision, answer, tokens, err)
+}
+
+// generate 渲染提示词模板并生成回答，有图片时使用视觉模型，否则使用文本模型
+func (s *ScreenshotService) generate(st Settings, profile *storage.PromptProfile, data prompt.Data, images []string) (string, model.TokenUsage, error) {
+	data.FromImage = len(images) > 0
+	systemPrompt, userPrompt, err := prompt.Render(*profile, data)
+	if err != nil {
+		return "", model.TokenUsage{}, err
+	}
+	if len(images) > 0 {
+		return st.answerFromImages(images, systemPrompt, userPrompt)
+	}
+	return ocr.ChatWithDeepSeekOptions(systemPrompt, userPrompt, st.DeepseekKey, ocr.ChatOptions{Model: st.TextModel})
+}
+
+// promptData 由截图的识别结果生成提示词模板的数据
+func (s *ScreenshotService) promptData(st Settings, screenshot *model.Screenshot) prompt.Data {
+	return prompt.Data{
+		Text:           screenshot.Text,
+		RawText:        screenshot.RawText,
+		Timestamp:      screenshot.Timestamp,
+		Language:       st.Language,
+		PreviousAnswer: s.previousAnswer(),
 	}
+}
 
-	// AI生成回答
-	answer, err := ocr.ProcessWithDeepSeek(text, s.DeepseekKey)
+// applyAnswer 写入回答阶段的结果和用量，err 不为空时记录失败原因
+func (st Settings) applyAnswer(screenshot *model.Screenshot, profile *storage.PromptProfile, fromImage bool,
+	answer string, tokens model.TokenUsage, err error) {
+	screenshot.PromptProfile = profile.Name
+	screenshot.PromptVersion = profile.Version
+	screenshot.PromptTokens = tokens.PromptTokens
+	screenshot.CompletionTokens = tokens.CompletionTokens
+	screenshot.Cost += st.Prices.Estimate(fromImage, tokens, 0)
 	if err != nil {
 		log.Printf("生成回答失败: %v", err)
-		answer = "无法生成回答"
+		screenshot.Answer, screenshot.Title = "", ""
+		screenshot.AnswerStatus, screenshot.AnswerError = model.StageFailed, err.Error()
+		return
 	}
+	// 最后一行作为标题，其余内容作为回答正文
+	screenshot.Answer, screenshot.Title = splitTitle(answer)
+	screenshot.AnswerStatus, screenshot.AnswerError = model.StageOK, ""
+}
 
-	// 获取最后一行作为标题
-	title := strings.TrimSpace(strings.Split(answer, "\n")[len(strings.Split(answer, "\n"))-1])
-	// 剩余内容作为回答回答正文
-	answer = strings.Join(strings.Split(answer, "\n")[:len(strings.Split(answer, "\n"))-2], "\n")
-	// 创建截图实体
-	screenshot := model.NewScreenshot(
-		imagePath,
-		"data:image/png;base64,"+imageBase64, // 缩略图直接使用Base64
-		text,
-		answer,
-		title,
-	)
+// attemptUsage 返回一次重试或重新生成的用量：本次新增的OCR调用，answered 为true时加上新生成的回答
+// ocrCalls 为处理前记录的OCR调用次数；被替换的回答的用量已在生成它时计入，这里不再扣除
+func (st Settings) attemptUsage(screenshot *model.Screenshot, ocrCalls int, answered bool) *storage.UsageEvent {
+	spent := &storage.UsageEvent{CreatedAt: time.Now(), OCRCalls: screenshot.OCRCalls - ocrCalls}
+	spent.Cost = st.Prices.Estimate(false, model.TokenUsage{}, spent.OCRCalls)
+	if answered {
+		spent.PromptTokens, spent.CompletionTokens = screenshot.PromptTokens, screenshot.CompletionTokens
+		spent.Cost += st.answerCost(screenshot)
+	}
+	return spent
+}
 
-	// 保存到仓库
-	id, err := s.Db.AddHistory(&storage.HistoryRecord{
+// answerCost 估算截图当前回答的费用，不含OCR
+func (st Settings) answerCost(screenshot *model.Screenshot) float64 {
+	tokens := model.TokenUsage{PromptTokens: screenshot.PromptTokens, CompletionTokens: screenshot.CompletionTokens}
+	fromImage := screenshot.Source == model.SourceImage || (screenshot.Source == model.SourceText && screenshot.ImagePath != "")
+	return min(st.Prices.Estimate(fromImage, tokens, 0), screenshot.Cost)
+}
+
+// save 保存截图记录并设置ID
+func (s *ScreenshotService) save(screenshot *model.Screenshot) error {
+	id, err := s.Db.AddHistory(historyRecord(screenshot))
+	if err != nil {
+		return fmt.Errorf("保存截图记录失败: %v", err)
+	}
+	screenshot.ID = id
+	return nil
+}
+
+// update 保存重新处理后的截图记录、被替换的原回答和本次处理的用量，replaced 为nil时没有替换回答
+func (s *ScreenshotService) update(screenshot *model.Screenshot, replaced *storage.AnswerVersion, spent *storage.UsageEvent) error {
+	if err := s.Db.UpdateHistory(historyRecord(screenshot), replaced, spent); err != nil {
+		return fmt.Errorf("更新截图记录失败: %v", err)
+	}
+	return nil
+}
+
+// historyRecord 由截图实体生成数据库记录
+func historyRecord(screenshot *model.Screenshot) *storage.HistoryRecord {
+	return &storage.HistoryRecord{
+		ID:        screenshot.ID,
 		Timestamp: screenshot.Timestamp,
 		ImagePath: screenshot.ImagePath,
 		Thumbnail: screenshot.Thumbnail,
 		Text:      screenshot.Text,
+		RawText:   screenshot.RawText,
 		Answer:    screenshot.Answer,
 		Title: sql.NullString{
 			String: screenshot.Title,
 			Valid:  screenshot.Title != "",
 		},
-	})
+		Source:        screenshot.Source,
+		PromptProfile: screenshot.PromptProfile,
+		PromptVersion: screenshot.PromptVersion,
+
+		PromptTokens:     screenshot.PromptTokens,
+		CompletionTokens: screenshot.CompletionTokens,
+		OCRCalls:         screenshot.OCRCalls,
+		Cost:             screenshot.Cost,
+		Status:           screenshot.Status,
+
+		OCRStatus:    screenshot.OCRStatus,
+		OCRError:     screenshot.OCRError,
+		AnswerStatus: screenshot.AnswerStatus,
+		AnswerError:  screenshot.AnswerError,
+	}
+}
+
+// screenshotFromRecord 由数据库记录生成截图实体
+func screenshotFromRecord(record *storage.HistoryRecord) *model.Screenshot {
+	screenshot := model.NewScreenshot(
+		record.ImagePath,
+		record.Thumbnail,
+		record.Text,
+		record.Answer,
+		record.Title.String,
+	)
+	screenshot.ID = record.ID
+	screenshot.Timestamp = record.Timestamp
+	screenshot.RawText = record.RawText
+	screenshot.Source = record.Source
+	screenshot.Status = record.Status
+	screenshot.PromptProfile = record.PromptProfile
+	screenshot.PromptVersion = record.PromptVersion
+	screenshot.PromptTokens = record.PromptTokens
+	screenshot.CompletionTokens = record.CompletionTokens
+	screenshot.OCRCalls = record.OCRCalls
+	screenshot.Cost = record.Cost
+	screenshot.OCRStatus = record.OCRStatus
+	screenshot.OCRError = record.OCRError
+	screenshot.AnswerStatus = record.AnswerStatus
+	screenshot.AnswerError = record.AnswerError
+	return screenshot
+}
+
+// FollowUp 针对一条已有记录追问，回答参考该记录的识别文本和回答
+// 追问的结果保存为新的记录（来源为followup），计入用量和限额
+func (s *ScreenshotService) FollowUp(recordID int64, question string) (*model.Screenshot, error) {
+	question = strings.TrimSpace(question)
+	if question == "" {
+		return nil, fmt.Errorf("追问内容不能为空")
+	}
+	st := s.Settings()
+	if err := s.checkLimits(st); err != nil {
+		return nil, err
+	}
+	record, err := s.record(recordID)
+	if err != nil {
+		return nil, err
+	}
+
+	systemPrompt, userPrompt := prompt.FollowUp(record.Text, record.Answer, question, st.Language)
+	answer, tokens, err := ocr.ChatWithDeepSeekOptions(systemPrompt, userPrompt, st.DeepseekKey, ocr.ChatOptions{Model: st.TextModel})
+	if err != nil {
+		return nil, fmt.Errorf("生成回答失败: %v", err)
+	}
+
+	answer, title := splitTitle(answer)
+	screenshot := model.NewScreenshot(record.ImagePath, record.Thumbnail, question, answer, title)
+	screenshot.Source = model.SourceFollowUp
+	screenshot.OCRStatus = model.StageSkipped
+	screenshot.PromptTokens = tokens.PromptTokens
+	screenshot.CompletionTokens = tokens.CompletionTokens
+	screenshot.Cost = st.Prices.Estimate(false, tokens, 0)
+	if err := s.save(screenshot); err != nil {
+		return nil, err
+	}
+	return screenshot, nil
+}
+
+// AskText 使用提示词配置直接回答提交的文本，结果保存为来源为text的记录
+// 附带的图片与截图保存在同一目录，第一张作为记录的图片和缩略图
+func (s *ScreenshotService) AskText(req TextRequest) (*model.Screenshot, error) {
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		return nil, fmt.Errorf("提问内容不能为空")
+	}
+	st := s.Settings()
+	if err := s.checkLimits(st); err != nil {
+		return nil, err
+	}
+	profile, err := s.resolveProfile(st, req.Profile)
 	if err != nil {
-		return nil, fmt.Errorf("保存截图记录失败: %v", err)
+		return nil, err
 	}
 
+	timestamp := time.Now()
+	var imagePath, thumbnail string
+	images := make([]string, 0, len(req.Images))
+	for i, imgBytes := range req.Images {
+		path, err := saveImage(s.ImageDir, imgBytes)
+		if err != nil {
+			return nil, err
+		}
+		url := dataURL(imgBytes, base64.StdEncoding.EncodeToString(imgBytes))
+		if i == 0 {
+			imagePath, thumbnail = path, url
+		}
+		images = append(images, url)
+	}
+
+	screenshot := model.NewScreenshot(imagePath, thumbnail, text, "", "")
+	screenshot.Timestamp = timestamp
+	screenshot.RawText = text
+	screenshot.Source = model.SourceText
+	screenshot.OCRStatus = model.StageSkipped
+	answer, tokens, err := s.generate(st, profile, s.promptData(st, screenshot), images)
+	st.applyAnswer(screenshot, profile, len(images) > 0, answer, tokens, err)
+	if err := s.save(screenshot); err != nil {
+		return nil, err
+	}
+	return screenshot, nil
+}
+
+// savePending 保存未处理完成的截图，之后可以重新处理
+func (s *ScreenshotService) savePending(imagePath string, imgBytes []byte, timestamp time.Time) (*model.Screenshot, error) {
+	screenshot := model.NewScreenshot(imagePath, dataURL(imgBytes, base64.StdEncoding.EncodeToString(imgBytes)), "", "", "")
+	screenshot.Timestamp = timestamp
+	screenshot.Status = model.StatusPending
+	screenshot.OCRStatus = model.StagePending
+	screenshot.AnswerStatus = model.StagePending
+
+	id, err := s.Db.AddHistory(historyRecord(screenshot))
+	if err != nil {
+		return nil, fmt.Errorf("保存待处理记录失败: %v", err)
+	}
 	screenshot.ID = id
 	return screenshot, nil
 }
 
 // OcrRecognize 执行OCR识别
 func (s *ScreenshotService) OcrRecognize(imageBase64 string) (string, error) {
-	return s.OCRProvider.RecognizeText(imageBase64)
+	return s.Settings().OCRProvider.RecognizeText(imageBase64)
+}
+
+// recognizeAndClean 执行OCR识别并清洗文本，返回原始文本和清洗后的文本
+func (s *ScreenshotService) recognizeAndClean(st Settings, imgBytes []byte, imageBase64 string) (string, string, error) {
+	if st.OCRProvider == nil {
+		return "", "", fmt.Errorf("OCR服务未配置")
+	}
+	var lines []model.OCRLine
+	if recognizer, ok := st.OCRProvider.(LineRecognizer); ok {
+		recognized, err := recognizer.RecognizeLines(imageBase64)
+		if err != nil {
+			return "", "", err
+		}
+		lines = recognized
+	} else {
+		text, err := st.OCRProvider.RecognizeText(imageBase64)
+		if err != nil {
+			return "", "", err
+		}
+		for _, line := range strings.Split(text, "\n") {
+			lines = append(lines, model.OCRLine{Text: line})
+		}
+	}
+
+	texts := make([]string, 0, len(lines))
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+	}
+	rawText := strings.Join(texts, "\n")
+
+	// 截图尺寸用于按位置判断界面区域，解析失败时只使用文本规则
+	opts := textclean.Options{}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(imgBytes)); err == nil {
+		opts.ImageWidth, opts.ImageHeight = cfg.Width, cfg.Height
+	}
+
+	cleaned := textclean.Clean(lines, opts)
+	if strings.TrimSpace(cleaned) == "" {
+		// 清洗后没有剩余内容时保留原文，交给AI自行判断
+		cleaned = rawText
+	}
+	return rawText, cleaned, nil
+}
+
+// answerFromImage 使用视觉模型直接回答
+func (st Settings) answerFromImage(imageBase64, systemPrompt, userPrompt string) (string, model.TokenUsage, error) {
+	if st.VisionProvider == nil {
+		return "", model.TokenUsage{}, fmt.Errorf("视觉模型未配置")
+	}
+	return st.VisionProvider.AnswerImage(imageBase64, systemPrompt, userPrompt)
+}
+
+// answerFromImages 使用视觉模型基于一张或多张图片回答
+func (st Settings) answerFromImages(images []string, systemPrompt, userPrompt string) (string, model.TokenUsage, error) {
+	if len(images) == 1 || st.VisionProvider == nil {
+		return st.answerFromImage(images[0], systemPrompt, userPrompt)
+	}
+	multi, ok := st.VisionProvider.(MultiImageProvider)
+	if !ok {
+		return "", model.TokenUsage{}, fmt.Errorf("视觉模型不支持一次提交多张图片")
+	}
+	return multi.AnswerImages(images, systemPrompt, userPrompt)
+}
+
+// checkLimits 检查今日和本月的用量是否已超出限额
+func (s *ScreenshotService) checkLimits(st Settings) error {
+	if !st.Limits.Enabled() {
+		return nil
+	}
+	now := time.Now()
+	today, err := s.Db.GetUsage(usage.StartOfDay(now), usage.StartOfDay(now).AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	month, err := s.Db.GetUsage(usage.StartOfMonth(now), usage.StartOfMonth(now).AddDate(0, 1, 0))
+	if err != nil {
+		return err
+	}
+	return st.Limits.Check(*today, *month)
+}
+
+// resolveProfile 获取指定名称的提示词配置，名称为空时使用默认配置
+func (s *ScreenshotService) resolveProfile(st Settings, name string) (*storage.PromptProfile, error) {
+	if name == "" {
+		name = st.DefaultProfile
+	}
+	if name == "" {
+		name = prompt.DefaultProfileName
+	}
+	profile, err := s.Db.GetPromptProfile(name)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("提示词配置不存在: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// record 获取指定ID的历史记录
+func (s *ScreenshotService) record(id int64) (*storage.HistoryRecord, error) {
+	record, err := s.Db.GetHistoryByID(id)
+	if err == sql.ErrNoRows {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// previousAnswer 获取最近一条记录的回答，供模板引用
+func (s *ScreenshotService) previousAnswer() string {
+	records, err := s.Db.GetHistory(1)
+	if err != nil || len(records) == 0 {
+		return ""
+	}
+	return records[0].Answer
+}
+
+// tooLittleText 判断OCR结果是否过少，不足以回答问题
+func (st Settings) tooLittleText(text string) bool {
+	return utf8.RuneCountInString(strings.TrimSpace(text)) < st.VisionFallbackChars
 }
 
 // GetRecentScreenshots 获取最近的截图
@@ -135,14 +708,74 @@ func (s *ScreenshotService) GetRecentScreenshots(limit int) ([]*model.Screenshot
 		return nil, fmt.Errorf("获取最近截图失败: %v", err)
 	}
 	screenshots := make([]*model.Screenshot, len(res))
-	for i, record := range res {
-		screenshots[i] = model.NewScreenshot(
-			record.ImagePath,
-			record.Thumbnail,
-			record.Text,
-			record.Answer,
-			record.Title.String,
-		)
+	for i := range res {
+		screenshots[i] = screenshotFromRecord(&res[i])
 	}
 	return screenshots, nil
 }
+
+// saveImage 将截图保存到imageDir目录
+// 文件名为时间戳，同一秒内的多张截图依次加上 -1、-2 后缀，扩展名与图片格式一致
+func saveImage(imageDir string, imgBytes []byte) (string, error) {
+	timestamp := time.Now().Format("20060102150405")
+
+	if imageDir == "" {
+		imageDir = "images"
+	}
+	if err := os.MkdirAll(imageDir, 0755); err != nil {
+		return "", fmt.Errorf("创建图片目录失败: %v", err)
+	}
+
+	ext, _ := imageType(imgBytes)
+	for i := 0; ; i++ {
+		// 生成文件名
+		imageFilename := timestamp + ext
+		if i > 0 {
+			imageFilename = fmt.Sprintf("%s-%d%s", timestamp, i, ext)
+		}
+		imagePath := filepath.Join(imageDir, imageFilename)
+
+		// 写入文件，已存在时换下一个文件名
+		file, err := os.OpenFile(imagePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("保存图片失败: %v", err)
+		}
+		_, err = file.Write(imgBytes)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(imagePath)
+			return "", fmt.Errorf("保存图片失败: %v", err)
+		}
+		return imagePath, nil
+	}
+}
+
+// imageType 返回图片格式对应的扩展名和MIME类型，无法识别时按PNG处理
+func imageType(imgBytes []byte) (ext, mimeType string) {
+	if _, format, err := image.DecodeConfig(bytes.NewReader(imgBytes)); err == nil && format == "jpeg" {
+		return ".jpg", "image/jpeg"
+	}
+	return ".png", "image/png"
+}
+
+// dataURL 生成图片的data URL，用作缩略图
+func dataURL(imgBytes []byte, imageBase64 string) string {
+	_, mimeType := imageType(imgBytes)
+	return "data:" + mimeType + ";base64," + imageBase64
+}
+
+// splitTitle 将回答的最后一个非空行拆分为标题，其余内容作为正文
+func splitTitle(answer string) (body, title string) {
+	lines := strings.Split(strings.TrimRight(answer, "\n"), "\n")
+	if len(lines) < 2 {
+		return answer, ""
+	}
+	title = strings.TrimSpace(lines[len(lines)-1])
+	body = strings.TrimRight(strings.Join(lines[:len(lines)-1], "\n"), "\n")
+	return body, title
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qujing226/screen_sage/application/service"
+	"github.com/qujing226/screen_sage/infrastructure/service/ocr"
+	"github.com/qujing226/screen_sage/internal/certs"
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/doctor"
+	"github.com/qujing226/screen_sage/internal/paths"
+	"github.com/qujing226/screen_sage/internal/prompt"
+	"github.com/qujing226/screen_sage/internal/secrets"
+	"github.com/qujing226/screen_sage/internal/storage"
+	"github.com/qujing226/screen_sage/web/api"
+)
+
+// newPassphraseEnv 轮换密钥时读取新口令的环境变量
+const newPassphraseEnv = "SCREENSAGE_NEW_PASSPHRASE"
+
+// runCommand 执行命令行子命令，返回进程退出码
+func runCommand(args []string) int {
+	switch args[0] {
+	case "secrets":
+		return runSecretsCommand(args[1:])
+	case "config":
+		return runConfigCommand(args[1:])
+	case "doctor":
+		return runDoctorCommand()
+	case "paths":
+		return runPathsCommand()
+	case "token":
+		return runTokenCommand(args[1:])
+	case "cert":
+		return runCertCommand(args[1:])
+	case "reprocess":
+		return runReprocessCommand(args[1:])
+	case "help", "-h", "--help":
+		printUsage(os.Stdout)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "未知的命令: %s\n\n", args[0])
+		printUsage(os.Stderr)
+		return 2
+	}
+}
+
+// printUsage 输出命令行用法
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, `用法:
+  screensage [参数]                启动托盘程序和Web服务
+  screensage [参数] <命令>
+
+命令:
+  screensage config show           显示配置文件中的配置
+  screensage config show --effective
+                                   显示叠加环境变量和命令行参数后的生效配置及来源
+  screensage secrets list          列出密钥配置项及是否已设置
+  screensage secrets set <名称>    从标准输入读取并加密保存密钥
+  screensage secrets rotate        更换加密密钥并重新加密全部密钥
+  screensage doctor                检查各服务商的密钥和网络连接
+  screensage paths                 显示配置、数据、缓存和日志目录
+  screensage token                 显示访问Web页面的令牌和登录地址
+  screensage token rotate          生成新的访问令牌，已登录的会话失效
+  screensage cert export [文件]    导出本地CA证书，安装到手机后可信任HTTPS证书
+                                   文件扩展名为 .cer 或 .der 时导出DER格式，否则为PEM格式
+  screensage reprocess [选项]      按筛选条件批量重新生成回答，原回答保存为历史版本
+                                   筛选: --filter-profile --source --status --category --from --to --ids --limit
+                                   生成: --profile --mode --model --rate（每分钟条数）
+                                   没有筛选条件时需要 --all；执行前询问确认，--yes 跳过确认，
+                                   --dry-run 只预估用量
+
+配置按 默认值 → 配置文件 → 环境变量 → 命令行参数 的顺序叠加，后者优先。
+每个配置项都可以用环境变量 %s<名称大写> 或参数 --<名称> 覆盖，
+例如 %s=9000 或 --port 9000；--config 指定配置文件路径。
+
+数据默认保存在系统标准目录（Linux遵循XDG规范）；--data-dir 或 %s 指定数据根目录，
+--portable、%s=1 或在可执行文件旁放置名为 %s 的文件可启用便携模式。
+
+Web服务默认只监听 127.0.0.1，所有接口都需要访问令牌（Authorization: Bearer <令牌>）
+或登录后的会话；bind_address 设置为 0.0.0.0 可供局域网内的设备访问。
+tls_enabled 开启HTTPS，未设置 tls_cert_file 和 tls_key_file 时使用本地CA自动签发证书。
+
+密钥默认使用本机密钥文件加密；设置环境变量 %s 后使用口令加密。
+轮换时设置 %s 可改用新口令，否则生成新的本机密钥文件。
+`, config.EnvPrefix, config.EnvName("port"), paths.DataDirEnv, paths.PortableEnv, paths.PortableMarker,
+		secrets.PassphraseEnv, newPassphraseEnv)
+}
+
+// runConfigCommand 执行 config 子命令
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "show" {
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
+	effective := fs.Bool("effective", false, "显示生效配置及来源")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	entries := config.Describe(*effective)
+	fmt.Printf("配置文件: %s\n\n", config.ConfigFilePath())
+	width := 0
+	for _, entry := range entries {
+		width = max(width, len(entry.Name))
+	}
+	for _, entry := range entries {
+		if *effective {
+			fmt.Printf("%-*s = %-40s [%s]\n", width, entry.Name, entry.Value, entry.Source)
+		} else {
+			fmt.Printf("%-*s = %s\n", width, entry.Name, entry.Value)
+		}
+	}
+	return 0
+}
+
+// runSecretsCommand 执行 secrets 子命令
+func runSecretsCommand(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	switch args[0] {
+	case "list":
+		cfg := config.GetConfig()
+		// 只输出是否已设置，不输出密钥本身
+		for _, name := range config.SecretNames() {
+			status := "未设置"
+			if cfg.HasSecret(name) {
+				status = "已设置"
+			}
+			fmt.Printf("%-26s %s\n", name, status)
+		}
+		return 0
+
+	case "set":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "用法: screensage secrets set <名称>")
+			return 2
+		}
+		name := args[1]
+		if !config.IsSecret(name) {
+			fmt.Fprintf(os.Stderr, "未知的密钥配置项: %s，可选: %s\n", name, strings.Join(config.SecretNames(), ", "))
+			return 2
+		}
+
+		// 从标准输入读取，避免密钥出现在命令行历史中
+		fmt.Fprintf(os.Stderr, "请输入 %s: ", name)
+		value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fmt.Fprintf(os.Stderr, "读取输入失败: %v\n", err)
+			return 1
+		}
+		value = strings.TrimRight(value, "\r\n")
+		if value == "" {
+			fmt.Fprintln(os.Stderr, "密钥不能为空")
+			return 1
+		}
+
+		if err := config.SetSecret(name, value); err != nil {
+			fmt.Fprintf(os.Stderr, "保存密钥失败: %v\n", err)
+			return 1
+		}
+		fmt.Printf("已加密保存 %s\n", name)
+		return 0
+
+	case "rotate":
+		newPassphrase := os.Getenv(newPassphraseEnv)
+		if err := config.RotateSecrets(newPassphrase); err != nil {
+			fmt.Fprintf(os.Stderr, "轮换密钥失败: %v\n", err)
+			return 1
+		}
+		if newPassphrase != "" {
+			fmt.Printf("已使用新口令重新加密，之后启动时请设置环境变量 %s\n", secrets.PassphraseEnv)
+		} else {
+			fmt.Println("已生成新的本机密钥文件并重新加密")
+		}
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "未知的 secrets 子命令: %s\n\n", args[0])
+		printUsage(os.Stderr)
+		return 2
+	}
+}
+
+// runDoctorCommand 检查各服务商能否正常调用，有检查项失败时返回1
+func runDoctorCommand() int {
+	report := doctor.NewChecker(config.GetConfig()).Run()
+	for _, result := range report.Results {
+		fmt.Printf("%-8s %-5s %-7s %6dms", result.Provider, result.Check, result.Status, result.LatencyMS)
+		if result.Detail != "" {
+			fmt.Printf("  %s", result.Detail)
+		}
+		fmt.Println()
+		if result.Error != "" {
+			fmt.Printf("    错误: %s\n", result.Error)
+		}
+		if result.Diagnosis != "" {
+			fmt.Printf("    诊断: %s\n", result.Diagnosis)
+		}
+	}
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
+
+// runPathsCommand 输出程序使用的目录
+func runPathsCommand() int {
+	dirs := config.Dirs()
+	fmt.Printf("配置文件: %s\n", config.ConfigFilePath())
+	fmt.Printf("配置目录: %s\n", dirs.Config)
+	fmt.Printf("数据目录: %s\n", dirs.Data)
+	fmt.Printf("截图目录: %s\n", dirs.Images)
+	fmt.Printf("缓存目录: %s\n", dirs.Cache)
+	fmt.Printf("日志目录: %s\n", dirs.Log)
+	if dirs.Portable {
+		fmt.Println("便携模式: 是")
+	}
+	return 0
+}
+
+// runTokenCommand 显示或更换访问Web API的令牌
+func runTokenCommand(args []string) int {
+	if len(args) > 0 {
+		if args[0] != "rotate" {
+			fmt.Fprintf(os.Stderr, "未知的 token 子命令: %s\n\n", args[0])
+			printUsage(os.Stderr)
+			return 2
+		}
+		// 清空后重新生成，运行中的程序检测到配置变更后使旧会话失效
+		if err := config.SetSecret("api_token", ""); err != nil {
+			fmt.Fprintf(os.Stderr, "清除令牌失败: %v\n", err)
+			return 1
+		}
+	}
+
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("访问令牌: %s\n", token)
+	fmt.Printf("登录地址: %s\n", api.LoginURL(config.GetConfig(), token, "/"))
+	return 0
+}
+
+// runCertCommand 执行 cert 子命令
+func runCertCommand(args []string) int {
+	if len(args) == 0 || args[0] != "export" || len(args) > 2 {
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	manager, err := api.CertManager(config.GetConfig())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	ca, err := manager.ExportCA()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "导出CA证书失败: %v\n", err)
+		return 1
+	}
+
+	if len(args) == 1 {
+		os.Stdout.Write(certs.EncodePEM(ca))
+	} else {
+		data := certs.EncodePEM(ca)
+		switch strings.ToLower(filepath.Ext(args[1])) {
+		case ".cer", ".der":
+			data = ca.Raw
+		}
+		if err := os.WriteFile(args[1], data, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "写入 %s 失败: %v\n", args[1], err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "已导出到 %s\n", args[1])
+	}
+	// 提示信息写到标准错误，不影响重定向导出的证书
+	fmt.Fprintf(os.Stderr, "有效期至: %s\n", ca.NotAfter.Format("2006-01-02"))
+	fmt.Fprintf(os.Stderr, "SHA-256指纹: %s\n", certs.Fingerprint(ca))
+	fmt.Fprintln(os.Stderr, "在手机上安装后请核对指纹；iOS还需要在 设置 → 通用 → 关于本机 → 证书信任设置 中启用完全信任")
+	return 0
+}
+
+// runReprocessCommand 按筛选条件批量重新生成回答，Ctrl+C 后不再处理剩余的记录
+// 没有筛选条件时需要 --all；列出计划后需要确认才会执行，--yes 跳过确认，--dry-run 只列出计划
+func runReprocessCommand(args []string) int {
+	fs := flag.NewFlagSet("reprocess", flag.ContinueOnError)
+	filterProfile := fs.String("filter-profile", "", "只处理使用该提示词配置的记录")
+	source := fs.String("source", "", "只处理该来源的记录")
+	status := fs.String("status", "", "只处理回答阶段为该状态的记录")
+	category := fs.String("category", "", "只处理标题分类（标题开头的【】）为该值的记录")
+	from := fs.String("from", "", "开始日期，格式为 2006-01-02")
+	to := fs.String("to", "", "结束日期，包含当天")
+	ids := fs.String("ids", "", "只处理这些记录，用逗号分隔")
+	limit := fs.Int("limit", 0, "最多处理的条数")
+	profile := fs.String("profile", "", "新回答使用的提示词配置，默认沿用原配置")
+	mode := fs.String("mode", "", "新回答的生成方式: ocr、vision 或 auto，默认与原回答相同")
+	modelName := fs.String("model", "", "新回答使用的模型，默认使用配置中的模型，需要同时指定 --mode ocr 或 vision")
+	rate := fs.Int("rate", 20, "每分钟最多处理的记录数")
+	dryRun := fs.Bool("dry-run", false, "只列出将要处理的记录和预计用量")
+	all := fs.Bool("all", false, "没有筛选条件时处理全部记录")
+	yes := fs.Bool("yes", false, "不询问确认，直接执行")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	req := service.ReprocessRequest{
+		Filter: storage.HistoryFilter{
+			Profile: *filterProfile, Source: *source, AnswerStatus: *status, Category: *category, Limit: *limit,
+		},
+		Profile: *profile,
+	}
+	var err error
+	if req.Mode, err = service.ParsePipelineMode(*mode); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	if *rate <= 0 || *limit < 0 {
+		fmt.Fprintln(os.Stderr, "--rate 必须为正数，--limit 不能为负数")
+		return 2
+	}
+	if *from != "" {
+		if req.Filter.From, err = time.ParseInLocation("2006-01-02", *from, time.Local); err != nil {
+			fmt.Fprintf(os.Stderr, "开始日期无效: %s\n", *from)
+			return 2
+		}
+	}
+	if *to != "" {
+		end, err := time.ParseInLocation("2006-01-02", *to, time.Local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "结束日期无效: %s\n", *to)
+			return 2
+		}
+		req.Filter.To = end.AddDate(0, 0, 1)
+	}
+	for _, field := range strings.FieldsFunc(*ids, func(r rune) bool { return r == ',' }) {
+		id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "记录ID无效: %s\n", field)
+			return 2
+		}
+		req.Filter.IDs = append(req.Filter.IDs, id)
+	}
+	filtered := len(req.Filter.IDs) > 0 || *filterProfile != "" || *source != "" || *status != "" || *category != "" ||
+		*from != "" || *to != ""
+	if !filtered && !*all {
+		fmt.Fprintln(os.Stderr, "没有指定筛选条件，重新生成全部记录时需要 --all")
+		return 2
+	}
+
+	cfg := config.GetConfig()
+	if err := config.EnsureDBPath(); err != nil {
+		fmt.Fprintf(os.Stderr, "确保数据库目录存在失败: %v\n", err)
+		return 1
+	}
+	db, err := storage.NewDBManager(cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "打开数据库失败: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	if err := db.SeedPromptProfiles(prompt.DefaultProfiles()); err != nil {
+		fmt.Fprintf(os.Stderr, "初始化提示词配置失败: %v\n", err)
+	}
+	ocrProvider, err := ocr.NewProvider(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "初始化OCR服务失败: %v\n", err)
+		return 1
+	}
+	pipeline := service.NewScreenshotService(db, nil, nil, "")
+	pipeline.ImageDir = config.Dirs().Images
+	pipeline.ApplySettings(serviceSettings(cfg, ocrProvider))
+	if err := pipeline.CheckModel(req.Mode, *modelName); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	plan, err := pipeline.PlanReprocess(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _, skipped := range plan.Skipped {
+		fmt.Printf("跳过记录 %d: %s\n", skipped.ID, skipped.Reason)
+	}
+	fmt.Printf("将重新生成 %d 条记录，预计 %d 次请求，输入 %d tokens，输出 %d tokens，费用约 %.4f 元\n",
+		len(plan.Records), plan.Estimate.Requests, plan.Estimate.PromptTokens, plan.Estimate.CompletionTokens, plan.Estimate.Cost)
+	if plan.LimitError != "" {
+		fmt.Fprintf(os.Stderr, "预计用量超出限额: %s\n", plan.LimitError)
+		return 1
+	}
+	if *dryRun || len(plan.Records) == 0 {
+		return 0
+	}
+	if !*yes {
+		fmt.Fprint(os.Stderr, "确认重新生成以上记录？原回答会保存为历史版本 [y/N]: ")
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fmt.Fprintf(os.Stderr, "读取输入失败: %v\n", err)
+			return 1
+		}
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Fprintln(os.Stderr, "已取消，没有修改任何记录")
+			return 0
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	progress := pipeline.Reprocess(ctx, plan.Records, service.ReprocessOptions{
+		Mode:     req.Mode,
+		Profile:  req.Profile,
+		Model:    *modelName,
+		Interval: time.Minute / time.Duration(*rate),
+		OnProgress: func(progress service.ReprocessProgress) {
+			switch {
+			case progress.Finished:
+			case progress.Error != "":
+				fmt.Printf("[%d/%d] 记录 %d 失败: %s\n", progress.Done+progress.Failed, progress.Total, progress.RecordID, progress.Error)
+			default:
+				fmt.Printf("[%d/%d] 记录 %d 已重新生成\n", progress.Done+progress.Failed, progress.Total, progress.RecordID)
+			}
+		},
+	})
+	fmt.Printf("完成: 成功 %d 条，失败 %d 条，费用约 %.4f 元\n", progress.Done, progress.Failed, progress.Cost)
+	if progress.Stopped != "" {
+		fmt.Fprintf(os.Stderr, "提前结束: %s，剩余 %d 条未处理\n", progress.Stopped, progress.Total-progress.Done-progress.Failed)
+		return 1
+	}
+	if progress.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/qujing226/screen_sage/internal/storage"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -13,11 +16,16 @@ import (
 
 	"github.com/getlantern/systray"
 	"github.com/qujing226/screen_sage/application/service"
+	"github.com/qujing226/screen_sage/infrastructure/service/ai"
 	"github.com/qujing226/screen_sage/infrastructure/service/ocr"
 	"github.com/qujing226/screen_sage/infrastructure/ui"
 	"github.com/qujing226/screen_sage/internal/config"
 	"github.com/qujing226/screen_sage/internal/hotkey"
+	"github.com/qujing226/screen_sage/internal/lifecycle"
+	"github.com/qujing226/screen_sage/internal/paths"
+	"github.com/qujing226/screen_sage/internal/prompt"
 	"github.com/qujing226/screen_sage/internal/screenshot"
+	"github.com/qujing226/screen_sage/internal/usage"
 	"github.com/qujing226/screen_sage/web/api"
 )
 
@@ -26,8 +34,47 @@ var (
 	serverInstance    *api.Server
 	serverMutex       sync.Mutex
 	screenshotService *service.ScreenshotService
+
+	// 程序生命周期，负责按顺序退出
+	app = lifecycle.New(shutdownTimeout)
+
+	// 当前注册的全局热键
+	hotkeyRegistration *hotkey.Registration
+	hotkeyMutex        sync.Mutex
+
+	// 托盘菜单中选择的快捷键截图处理模式，为空时使用配置的默认模式
+	captureMode      service.PipelineMode
+	captureModeMutex sync.Mutex
 )
 
+// captureModes 托盘菜单“截图模式”中的选项
+var captureModes = []struct {
+	mode  service.PipelineMode
+	title string
+}{
+	{"", "默认模式"},
+	{service.ModeOCR, "OCR识别"},
+	{service.ModeVision, "视觉模型"},
+	{service.ModeAuto, "自动（文字过少时用视觉模型）"},
+}
+
+// getCaptureMode 返回快捷键截图使用的处理模式
+func getCaptureMode() service.PipelineMode {
+	captureModeMutex.Lock()
+	defer captureModeMutex.Unlock()
+	return captureMode
+}
+
+// setCaptureMode 设置快捷键截图使用的处理模式
+func setCaptureMode(mode service.PipelineMode) {
+	captureModeMutex.Lock()
+	defer captureModeMutex.Unlock()
+	captureMode = mode
+}
+
+// shutdownTimeout 退出时停止组件和等待截图处理的期限，超时后未完成的截图保存为待处理记录
+const shutdownTimeout = 15 * time.Second
+
 // 获取服务器实例
 func getServerInstance() *api.Server {
 	serverMutex.Lock()
@@ -47,6 +94,40 @@ func main() {
 	log.SetOutput(os.Stdout)
 	log.SetPrefix("[ScreenSage] ")
 
+	// 解析命令行参数，参数覆盖配置文件和环境变量中的同名配置
+	opts := config.BindFlags(flag.CommandLine)
+	flag.Usage = func() {
+		printUsage(flag.CommandLine.Output())
+	}
+	flag.Parse()
+	config.Init(*opts)
+
+	// 创建数据目录，并将旧版本放在可执行文件旁的数据迁移过来
+	dirs := config.Dirs()
+	if err := dirs.Create(); err != nil {
+		log.Fatalf("创建数据目录失败: %v", err)
+	}
+	migration, err := paths.Migrate(dirs)
+	if err != nil {
+		log.Printf("迁移旧版本数据失败: %v", err)
+	}
+
+	// 执行命令行子命令
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
+	// 日志同时写入日志目录
+	if logFile, err := os.OpenFile(filepath.Join(dirs.Log, "screensage.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		log.Printf("打开日志文件失败: %v", err)
+	} else {
+		defer logFile.Close()
+		log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	}
+	if migration != nil {
+		log.Printf("已从 %s 迁移 %d 个文件到新的数据目录", migration.From, migration.Files)
+	}
+
 	// 初始化配置
 	cfg := config.GetConfig()
 
@@ -62,38 +143,86 @@ func main() {
 	if err != nil {
 		log.Fatalf("初始化数据库仓库失败: %v", err)
 	}
-	defer dbManager.Close()
+	// 数据库最后关闭，确保正在处理的截图能保存
+	app.OnClose("数据库", dbManager.Close)
+
+	// 迁移截图后更新记录中的截图路径
+	if migration != nil && migration.ImagesFrom != "" {
+		if _, err := dbManager.RewriteImagePaths(migration.ImagesFrom, migration.ImagesTo); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	// 写入内置的提示词配置，已存在的配置保留用户的修改
+	if err := dbManager.SeedPromptProfiles(prompt.DefaultProfiles()); err != nil {
+		log.Printf("初始化提示词配置失败: %v", err)
+	}
 
 	// 初始化OCR提供者
-	ocrProvider := ocr.NewBaiduOCRProvider(cfg.BaiduAPIKey, cfg.BaiduSecretKey)
+	ocrProvider, err := ocr.NewProvider(cfg)
+	if err != nil {
+		log.Fatalf("初始化OCR服务失败: %v", err)
+	}
+
+	// 首次启动时生成访问Web API的令牌
+	if _, err := config.EnsureAPIToken(); err != nil {
+		log.Fatalf("%v", err)
+	}
 
-	deepseekKey := cfg.DeepSeekAPIKey
 	// 初始化截图服务
-	screenshotService = service.NewScreenshotService(
-		dbManager,
-		ocrProvider,
-		deepseekKey,
-	)
+	screenshotService = service.NewScreenshotService(dbManager, nil, nil, "")
+	screenshotService.ImageDir = dirs.Images
+	screenshotService.ApplySettings(serviceSettings(cfg, ocrProvider))
 
-	// 启动系统托盘
-	go systray.Run(onReady, onExit)
+	// 设置页面保存快捷键前先校验格式
+	config.RegisterValidator("hotkey", func(spec string) error {
+		_, _, err := hotkey.Parse(spec)
+		return err
+	})
+
+	// 配置变更时更新服务设置、Web服务地址和热键
+	config.Subscribe(onConfigChange)
+	stopWatch := make(chan struct{})
+	go config.Watch(2*time.Second, stopWatch)
+	app.OnStop("配置监听", func(context.Context) error {
+		close(stopWatch)
+		return nil
+	})
 
 	// 启动Web服务
-	server, err := api.StartServer()
-	if err != nil {
+	server := api.New(api.Deps{
+		DB:       dbManager,
+		Pipeline: screenshotService,
+		App:      app,
+	})
+	if err := server.Start(); err != nil {
 		log.Fatalf("启动Web服务失败: %v", err)
 	}
 
 	// 保存服务器实例
 	setServerInstance(server)
 
-	// 等待服务器完全初始化
-	time.Sleep(500 * time.Millisecond)
+	// 启动系统托盘，退出时先注销热键并移除托盘图标，再停止Web服务
+	go systray.Run(onReady, onExit)
+	app.OnStop("热键", func(context.Context) error {
+		unregisterHotkey()
+		return nil
+	})
+	app.OnStop("托盘", func(context.Context) error {
+		systray.Quit()
+		return nil
+	})
+	app.OnStop("Web服务", server.Shutdown)
 
-	// 等待信号，用于系统杀死进程时的退出
+	// 系统杀死进程时同样按顺序退出
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	go func() {
+		sig := <-sigChan
+		app.Shutdown(fmt.Sprintf("收到信号 %v", sig))
+	}()
+
+	app.Run()
 }
 
 func onReady() {
@@ -131,15 +260,29 @@ func onReady() {
 	// 添加菜单项
 	mHistory := systray.AddMenuItem("历史记录", "查看历史记录")
 	mSettings := systray.AddMenuItem("设置", "配置应用")
+	mMode := systray.AddMenuItem("截图模式", "选择快捷键截图的处理方式")
+	modeItems := make([]*systray.MenuItem, len(captureModes))
+	for i, option := range captureModes {
+		modeItems[i] = mMode.AddSubMenuItemCheckbox(option.title, option.title, option.mode == getCaptureMode())
+		go func(i int) {
+			for range modeItems[i].ClickedCh {
+				setCaptureMode(captureModes[i].mode)
+				for j, item := range modeItems {
+					if j == i {
+						item.Check()
+					} else {
+						item.Uncheck()
+					}
+				}
+				log.Printf("快捷键截图模式: %s", captureModes[i].title)
+			}
+		}(i)
+	}
 	systray.AddSeparator()
 	mQuit := systray.AddMenuItem("退出", "退出应用")
 
 	// 注册全局热键
-	if err := hotkey.RegisterHotkey(func() {
-		processScreenshot()
-	}); err != nil {
-		log.Printf("注册热键失败: %v", err)
-	}
+	registerHotkey(config.GetConfig().Hotkey)
 
 	// 处理菜单事件
 	go func() {
@@ -147,28 +290,38 @@ func onReady() {
 			select {
 			case <-mHistory.ClickedCh:
 				// 打开历史记录页面
-				fmt.Println("打开历史记录")
+				openPage("/")
 			case <-mSettings.ClickedCh:
 				// 打开设置页面
 				showSettingsDialog()
 			case <-mQuit.ClickedCh:
-				systray.Quit()
+				app.Shutdown("托盘菜单退出")
+				return
+			case <-app.Stopping():
 				return
 			}
 		}
 	}()
 }
 
+// onExit 托盘退出时调用，资源由生命周期统一清理
 func onExit() {
-	// 清理资源
-	log.Println("应用退出")
-	os.Exit(0)
+	app.Shutdown("托盘退出")
 }
 
-// 显示设置对话框
+// 显示设置页面
 func showSettingsDialog() {
-	// 调用UI包中的设置对话框
-	ui.ShowSettingsDialog()
+	// 在浏览器中打开Web设置页面
+	openPage("/settings")
+}
+
+// openPage 在默认浏览器中打开Web页面，地址中带有令牌，打开后自动登录
+func openPage(path string) {
+	cfg := config.GetConfig()
+	url := api.LoginURL(cfg, cfg.APIToken, path)
+	if err := ui.OpenBrowser(url); err != nil {
+		log.Printf("打开页面失败: %v，请手动访问 %s", err, url)
+	}
 }
 
 // 处理截图
@@ -183,12 +336,16 @@ func processScreenshot() {
 	// 处理截图
 	log.Printf("截图成功，大小: %d bytes", len(imgBytes))
 
-	// 使用截图服务处理
+	// 使用截图服务处理，登记为任务，退出时等待处理完成或保存为待处理记录
 	if screenshotService != nil {
-		go func() {
-			screen, err := screenshotService.ProcessScreenshot(imgBytes)
+		mode := getCaptureMode()
+		started := app.Go(func(ctx context.Context) {
+			screen, err := screenshotService.ProcessScreenshotWithOptions(imgBytes, service.ProcessOptions{Context: ctx, Mode: mode})
 			if err != nil {
 				log.Printf("处理截图失败: %v", err)
+				if server := getServerInstance(); server != nil {
+					server.BroadcastError(err)
+				}
 				return
 			}
 
@@ -198,8 +355,158 @@ func processScreenshot() {
 			if server != nil {
 				server.BroadcastScreenshot(screen)
 			}
-		}()
+		})
+		if !started {
+			log.Printf("程序正在退出，忽略截图")
+		}
 	} else {
 		log.Printf("截图服务未初始化，无法处理截图")
 	}
 }
+
+// serviceSettings 根据配置生成截图服务的设置
+func serviceSettings(cfg *config.Config, ocrProvider service.OCRProvider) service.Settings {
+	// 视觉模型未单独配置密钥时沿用DeepSeek密钥
+	visionKey := cfg.VisionAPIKey
+	if visionKey == "" {
+		visionKey = cfg.DeepSeekAPIKey
+	}
+
+	st := service.Settings{
+		OCRProvider:         ocrProvider,
+		VisionProvider:      ai.NewVisionProvider(visionKey, cfg.VisionEndpoint, cfg.VisionModel),
+		DeepseekKey:         cfg.DeepSeekAPIKey,
+		DefaultMode:         service.ModeOCR,
+		VisionFallbackChars: 20,
+		DefaultProfile:      prompt.DefaultProfileName,
+		Language:            cfg.Language,
+		Prices: usage.Prices{
+			Text:    usage.Pricing{PromptPerMillion: cfg.TextPromptPrice, CompletionPerMillion: cfg.TextCompletionPrice},
+			Vision:  usage.Pricing{PromptPerMillion: cfg.VisionPromptPrice, CompletionPerMillion: cfg.VisionCompletionPrice},
+			OCRCall: cfg.OCRCallPrice,
+		},
+		Limits: usage.Limits{
+			DailyCost:       cfg.DailyCostLimit,
+			MonthlyCost:     cfg.MonthlyCostLimit,
+			DailyRequests:   cfg.DailyRequestLimit,
+			MonthlyRequests: cfg.MonthlyRequestLimit,
+		},
+	}
+	if mode, err := service.ParsePipelineMode(cfg.PipelineMode); err != nil {
+		log.Printf("处理模式配置无效，使用OCR模式: %v", err)
+	} else if mode != "" {
+		st.DefaultMode = mode
+	}
+	if cfg.VisionFallbackChars > 0 {
+		st.VisionFallbackChars = cfg.VisionFallbackChars
+	}
+	if cfg.DefaultPromptProfile != "" {
+		st.DefaultProfile = cfg.DefaultPromptProfile
+	}
+	return st
+}
+
+// ocrFields 变更后需要重建OCR提供者的配置项
+var ocrFields = []string{
+	"ocr_provider", "baidu_api_key", "baidu_secret_key",
+	"tencent_secret_id", "tencent_secret_key", "tencent_region",
+	"aliyun_access_key_id", "aliyun_access_key_secret", "aliyun_region",
+}
+
+// onConfigChange 配置变更时更新各组件
+func onConfigChange(change config.Change) {
+	log.Printf("配置已变更: %v", change.Fields)
+	cfg := change.New
+
+	// OCR相关配置未变化时沿用原有的提供者，保留已获取的访问令牌
+	ocrProvider := screenshotService.Settings().OCRProvider
+	if change.Has(ocrFields...) {
+		provider, err := ocr.NewProvider(cfg)
+		if err != nil {
+			log.Printf("重建OCR服务失败，继续使用原有配置: %v", err)
+		} else {
+			ocrProvider = provider
+		}
+	}
+	screenshotService.ApplySettings(serviceSettings(cfg, ocrProvider))
+
+	if server := getServerInstance(); server != nil {
+		if change.Has("port", "bind_address") {
+			if err := server.SetAddress(cfg.BindAddress, cfg.Port); err != nil {
+				log.Printf("切换Web服务监听地址失败: %v", err)
+			}
+		}
+		if change.Has("tls_enabled", "tls_cert_file", "tls_key_file") {
+			if err := server.SetTLS(cfg); err != nil {
+				log.Printf("更新HTTPS配置失败: %v", err)
+			}
+		}
+		if change.Has("api_token") {
+			// 令牌更换后，用旧令牌建立的会话全部失效
+			server.ClearSessions()
+		}
+		if change.Has("static_path") {
+			server.SetStaticPath(cfg.StaticPath)
+		}
+	}
+
+	if change.Has("hotkey") {
+		registerHotkey(cfg.Hotkey)
+	}
+	if change.Has("db_path") {
+		log.Printf("数据库路径的修改需要重启后生效")
+	}
+}
+
+// unregisterHotkey 注销全局热键，退出时调用
+func unregisterHotkey() {
+	hotkeyMutex.Lock()
+	defer hotkeyMutex.Unlock()
+	if hotkeyRegistration == nil {
+		return
+	}
+	if err := hotkeyRegistration.Unregister(); err != nil {
+		log.Printf("注销热键失败: %v", err)
+	}
+	hotkeyRegistration = nil
+}
+
+// registerHotkey 注册全局热键，替换之前注册的热键
+// 新热键注册失败时保留原有热键
+func registerHotkey(spec string) {
+	hotkeyMutex.Lock()
+	defer hotkeyMutex.Unlock()
+
+	// 退出过程中不再注册
+	select {
+	case <-app.Stopping():
+		return
+	default:
+	}
+
+	old := hotkeyRegistration
+	if old != nil {
+		if old.Spec == spec {
+			return
+		}
+		// 先注销旧热键，避免新旧热键冲突
+		if err := old.Unregister(); err != nil {
+			log.Printf("注销热键失败: %v", err)
+		}
+	}
+
+	registration, err := hotkey.Register(spec, processScreenshot)
+	if err != nil {
+		log.Printf("注册热键 %s 失败: %v", spec, err)
+		if old == nil {
+			return
+		}
+		// 恢复原有热键
+		if registration, err = hotkey.Register(old.Spec, processScreenshot); err != nil {
+			log.Printf("恢复热键 %s 失败: %v", old.Spec, err)
+			hotkeyRegistration = nil
+			return
+		}
+	}
+	hotkeyRegistration = registration
+}
@@ -0,0 +1,16 @@
+package model
+
+// OCRLine 表示OCR识别出的一行文本及其在截图中的位置
+// 坐标单位为像素，服务商未返回位置时宽高为0
+type OCRLine struct {
+	Text   string `json:"text"`
+	Left   int    `json:"left"`
+	Top    int    `json:"top"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// HasBox 判断该行是否带有位置信息
+func (l OCRLine) HasBox() bool {
+	return l.Width > 0 && l.Height > 0
+}
@@ -4,15 +4,53 @@ import (
 	"time"
 )
 
+// 回答来源
+const (
+	SourceOCR      = "ocr"      // 基于OCR识别文本回答
+	SourceImage    = "image"    // 直接基于截图回答
+	SourceFollowUp = "followup" // 针对已有记录的追问
+	SourceText     = "text"     // 直接提交的文本，不经过截图和OCR
+)
+
+// 处理状态
+const (
+	StatusDone    = "done"    // 已完成识别和回答
+	StatusPending = "pending" // 程序退出时尚未处理完成，只保存了截图
+)
+
+// 识别、回答各阶段的状态
+const (
+	StagePending = "pending" // 尚未执行
+	StageOK      = "ok"      // 执行成功
+	StageFailed  = "failed"  // 执行失败，失败原因保存在对应的错误字段
+	StageSkipped = "skipped" // 不需要执行，例如直接基于截图回答时的OCR
+)
+
 // Screenshot 表示一个截图实体
 type Screenshot struct {
 	ID        int64     `json:"id"`
 	Timestamp time.Time `json:"timestamp"`
 	ImagePath string    `json:"image_path"`
 	Thumbnail string    `json:"thumbnail"`
-	Text      string    `json:"text"`
+	Text      string    `json:"text"`     // 清洗后的OCR文本
+	RawText   string    `json:"raw_text"` // OCR原始文本
 	Answer    string    `json:"answer"`
 	Title     string    `json:"title"`
+	Source    string    `json:"source"`
+	Status    string    `json:"status"`
+
+	OCRStatus    string `json:"ocr_status"`             // OCR阶段状态
+	OCRError     string `json:"ocr_error,omitempty"`    // OCR失败的原因
+	AnswerStatus string `json:"answer_status"`          // 回答阶段状态
+	AnswerError  string `json:"answer_error,omitempty"` // 生成回答失败的原因
+
+	PromptProfile string `json:"prompt_profile"` // 生成回答所用的提示词配置
+	PromptVersion int    `json:"prompt_version"` // 提示词模板版本
+
+	PromptTokens     int     `json:"prompt_tokens"`     // 模型输入token数
+	CompletionTokens int     `json:"completion_tokens"` // 模型输出token数
+	OCRCalls         int     `json:"ocr_calls"`         // OCR调用次数
+	Cost             float64 `json:"cost"`              // 估算费用（元）
 }
 
 // NewScreenshot 创建一个新的截图实体
@@ -24,5 +62,10 @@ func NewScreenshot(imagePath, thumbnail, text, answer, title string) *Screenshot
 		Text:      text,
 		Answer:    answer,
 		Title:     title,
+		Source:    SourceOCR,
+		Status:    StatusDone,
+
+		OCRStatus:    StageOK,
+		AnswerStatus: StageOK,
 	}
 }
@@ -0,0 +1,12 @@
+package model
+
+// TokenUsage 表示一次模型调用消耗的token数量
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// Total 返回输入与输出token的总数
+func (u TokenUsage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
@@ -8,6 +8,7 @@ require (
 	github.com/kbinani/screenshot v0.0.0-20230812210009-b87d31814237
 	github.com/mattn/go-sqlite3 v1.14.17
 	golang.design/x/hotkey v0.4.1
+	golang.org/x/image v0.25.0
 )
 
 require (
@@ -0,0 +1,188 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qujing226/screen_sage/domain/model"
+)
+
+const (
+	// DefaultVisionEndpoint 默认的多模态对话接口（OpenAI兼容）
+	DefaultVisionEndpoint = "https://api.openai.com/v1/chat/completions"
+	// DefaultVisionModel 默认的多模态模型
+	DefaultVisionModel = "gpt-4o-mini"
+)
+
+// visionSystemPrompt 未指定提示词时使用的系统提示词
+const visionSystemPrompt = "你是一个专业的屏幕内容分析助手。用户会直接发送一张屏幕截图。请结合图中的文字、图表、界面布局等视觉信息，找出其中包含的问题或关键信息，然后给出清晰、准确的回答或解释。如果截图中包含代码或错误信息，请特别关注并提供相关的解决方案。在回答的最后一行，请用一句简短的话提炼出本次问答的关键信息，作为标题，格式为'【标题】xxx'。"
+
+// visionUserPrompt 未指定提示词时使用的用户提示词
+const visionUserPrompt = "你的任务: a.分析这张截图，找出其中描述的问题，可能是一道算法题、一张图表、一个界面，也可能只是一个问题，给出具体的解答。" +
+	"b.最后一行提炼出本次回答的关键信息，我将作为本次问答的标题，需要单独放在一行，10字内即可。例如：【架构】时序图解读。" +
+	"截图中的浏览器标签栏、任务栏等界面元素与问题无关，请忽略。"
+
+// VisionProvider 是多模态对话接口的客户端，直接以图片提问而不经过OCR
+type VisionProvider struct {
+	APIKey     string
+	Endpoint   string
+	Model      string
+	MaxTokens  int // 最大输出长度
+	HTTPClient *http.Client
+}
+
+// visionContentPart 表示OpenAI风格消息中的一个内容片段
+type visionContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *visionImageURL `json:"image_url,omitempty"`
+}
+
+// visionImageURL 表示图片内容片段中的图片地址
+type visionImageURL struct {
+	URL string `json:"url"`
+}
+
+// NewVisionProvider 创建一个新的多模态提供者
+func NewVisionProvider(apiKey, endpoint, model string) *VisionProvider {
+	if endpoint == "" {
+		endpoint = DefaultVisionEndpoint
+	}
+	if model == "" {
+		model = DefaultVisionModel
+	}
+	return &VisionProvider{
+		APIKey:    apiKey,
+		Endpoint:  endpoint,
+		Model:     model,
+		MaxTokens: 3000,
+		HTTPClient: &http.Client{
+			Timeout: 90 * time.Second,
+		},
+	}
+}
+
+// AnswerImage 将截图连同提示词直接发送给多模态模型并返回回答
+// 提示词为空时使用内置的默认提示词，同时返回本次调用消耗的token数量
+func (p *VisionProvider) AnswerImage(imageBase64, systemPrompt, userPrompt string) (string, model.TokenUsage, error) {
+	return p.AnswerImages([]string{imageBase64}, systemPrompt, userPrompt)
+}
+
+// AnswerImageWithModel 与 AnswerImage 相同，但使用指定的模型，用于重新生成时换用其他模型
+func (p *VisionProvider) AnswerImageWithModel(modelName, imageBase64, systemPrompt, userPrompt string) (string, model.TokenUsage, error) {
+	provider := *p
+	if modelName != "" {
+		provider.Model = modelName
+	}
+	return provider.AnswerImage(imageBase64, systemPrompt, userPrompt)
+}
+
+// AnswerImages 将多张图片放在同一条消息中发送给多模态模型并返回回答
+func (p *VisionProvider) AnswerImages(images []string, systemPrompt, userPrompt string) (string, model.TokenUsage, error) {
+	var usage model.TokenUsage
+	if p.APIKey == "" {
+		return "", usage, fmt.Errorf("视觉模型API密钥未提供")
+	}
+	if len(images) == 0 {
+		return "", usage, fmt.Errorf("图片为空，无法处理")
+	}
+	maxTokens := p.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = 3000
+	}
+
+	if systemPrompt == "" {
+		systemPrompt = visionSystemPrompt
+	}
+	if userPrompt == "" {
+		userPrompt = visionUserPrompt
+	}
+
+	content := []visionContentPart{{Type: "text", Text: userPrompt}}
+	for _, imageBase64 := range images {
+		if imageBase64 == "" {
+			return "", usage, fmt.Errorf("图片为空，无法处理")
+		}
+		// 多模态接口要求以 data URL 形式传递图片
+		imageURL := imageBase64
+		if !strings.HasPrefix(imageURL, "data:") {
+			imageURL = "data:image/png;base64," + imageURL
+		}
+		content = append(content, visionContentPart{Type: "image_url", ImageURL: &visionImageURL{URL: imageURL}})
+	}
+
+	reqData := map[string]interface{}{
+		"model": p.Model,
+		"messages": []map[string]interface{}{
+			{"role": "system", "content": systemPrompt},
+			{"role": "user", "content": content},
+		},
+		"temperature": 0.7,
+		"max_tokens":  maxTokens,
+	}
+
+	reqBody, err := json.Marshal(reqData)
+	if err != nil {
+		return "", usage, fmt.Errorf("编码请求数据失败: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", p.Endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return "", usage, fmt.Errorf("创建HTTP请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return "", usage, fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", usage, fmt.Errorf("读取响应失败: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", usage, fmt.Errorf("API返回错误状态码: %d, 响应: %s", resp.StatusCode, string(respBody))
+	}
+
+	var response struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error,omitempty"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return "", usage, fmt.Errorf("解析响应失败: %v", err)
+	}
+
+	if response.Error.Message != "" {
+		return "", usage, fmt.Errorf("API返回错误: %s", response.Error.Message)
+	}
+
+	usage = model.TokenUsage{
+		PromptTokens:     response.Usage.PromptTokens,
+		CompletionTokens: response.Usage.CompletionTokens,
+	}
+
+	if len(response.Choices) > 0 {
+		return response.Choices[0].Message.Content, usage, nil
+	}
+
+	return "", usage, fmt.Errorf("API未返回有效响应")
+}
@@ -0,0 +1,59 @@
+package ai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVisionProvider_AnswerImage(t *testing.T) {
+	var gotModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Role    string          `json:"role"`
+				Content json.RawMessage `json:"content"`
+			} `json:"messages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("解析请求失败: %v", err)
+		}
+		if len(req.Messages) != 2 {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+		gotModel = req.Model
+
+		var parts []visionContentPart
+		if err := json.Unmarshal(req.Messages[1].Content, &parts); err != nil {
+			t.Fatalf("用户消息应为内容片段数组: %v", err)
+		}
+		if len(parts) != 2 || parts[0].Text != "请总结" || parts[1].Type != "image_url" || parts[1].ImageURL.URL != "data:image/png;base64,aGVsbG8=" {
+			t.Errorf("unexpected content parts: %+v", parts)
+		}
+
+		w.Write([]byte(`{"choices":[{"message":{"content":"回答\n\n【图表】趋势解读"}}],"usage":{"prompt_tokens":812,"completion_tokens":64,"total_tokens":876}}`))
+	}))
+	defer server.Close()
+
+	p := NewVisionProvider("key", server.URL, "test-model")
+	got, usage, err := p.AnswerImage("aGVsbG8=", "", "请总结")
+	if err != nil {
+		t.Fatalf("AnswerImage() error = %v", err)
+	}
+	if want := "回答\n\n【图表】趋势解读"; got != want {
+		t.Errorf("AnswerImage() got = %q, want %q", got, want)
+	}
+	if usage.PromptTokens != 812 || usage.CompletionTokens != 64 {
+		t.Errorf("AnswerImage() usage = %+v", usage)
+	}
+	if gotModel != "test-model" {
+		t.Errorf("AnswerImage() model = %q", gotModel)
+	}
+
+	// 按次指定的模型不影响之后的请求
+	if _, _, err := p.AnswerImageWithModel("other-model", "aGVsbG8=", "", "请总结"); err != nil || gotModel != "other-model" || p.Model != "test-model" {
+		t.Errorf("AnswerImageWithModel() model = %q, provider model = %q, err = %v", gotModel, p.Model, err)
+	}
+}
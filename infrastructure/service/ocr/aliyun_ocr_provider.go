@@ -0,0 +1,242 @@
+package ocr
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qujing226/screen_sage/domain/model"
+)
+
+const (
+	aliyunOCRAction  = "RecognizeGeneral"
+	aliyunOCRVersion = "2021-07-07"
+	acs3Algorithm    = "ACS3-HMAC-SHA256"
+)
+
+// AliyunOCRProvider 是阿里云通用文字识别API的客户端
+type AliyunOCRProvider struct {
+	HTTPClient      *http.Client
+	AccessKeyID     string
+	AccessKeySecret string
+	APIEndpoint     string
+}
+
+// AliyunOCRResponse 表示阿里云OCR响应的结构
+type AliyunOCRResponse struct {
+	RequestID string `json:"RequestId"`
+	Data      string `json:"Data"`
+	Code      string `json:"Code,omitempty"`
+	Message   string `json:"Message,omitempty"`
+}
+
+// AliyunOCRData 表示阿里云OCR响应中Data字段的结构
+type AliyunOCRData struct {
+	Content        string `json:"content"`
+	PrismWordsInfo []struct {
+		Word   string `json:"word"`
+		X      int    `json:"x"`
+		Y      int    `json:"y"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+	} `json:"prism_wordsInfo"`
+}
+
+// NewAliyunOCRProvider 创建一个新的阿里云OCR提供者
+func NewAliyunOCRProvider(accessKeyID, accessKeySecret, region string) *AliyunOCRProvider {
+	if region == "" {
+		region = "cn-hangzhou"
+	}
+	return &AliyunOCRProvider{
+		AccessKeyID:     accessKeyID,
+		AccessKeySecret: accessKeySecret,
+		APIEndpoint:     fmt.Sprintf("https://ocr-api.%s.aliyuncs.com", region),
+		HTTPClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// RecognizeText 实现OCRProvider接口，识别图像中的文本
+func (p *AliyunOCRProvider) RecognizeText(imageBase64 string) (string, error) {
+	lines, err := p.RecognizeLines(imageBase64)
+	if err != nil {
+		return "", err
+	}
+	return joinLines(lines), nil
+}
+
+// RecognizeLines 识别图像中的文本行及其位置
+func (p *AliyunOCRProvider) RecognizeLines(imageBase64 string) ([]model.OCRLine, error) {
+	if p.AccessKeyID == "" || p.AccessKeySecret == "" {
+		return nil, fmt.Errorf("阿里云OCR密钥未配置")
+	}
+
+	endpoint, err := url.Parse(p.APIEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("解析OCR地址失败: %v", err)
+	}
+
+	// 阿里云接口直接接收图片二进制
+	imageBytes, err := base64.StdEncoding.DecodeString(stripDataURLPrefix(imageBase64))
+	if err != nil {
+		return nil, fmt.Errorf("解码图片数据失败: %v", err)
+	}
+
+	nonce, err := newSignatureNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{
+		"host":                  endpoint.Host,
+		"content-type":          "application/octet-stream",
+		"x-acs-action":          aliyunOCRAction,
+		"x-acs-version":         aliyunOCRVersion,
+		"x-acs-date":            time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		"x-acs-signature-nonce": nonce,
+		"x-acs-content-sha256":  sha256Hex(imageBytes),
+	}
+	headers["authorization"] = signACS3(acs3SignInput{
+		AccessKeyID:     p.AccessKeyID,
+		AccessKeySecret: p.AccessKeySecret,
+		Method:          "POST",
+		CanonicalURI:    "/",
+		Headers:         headers,
+	})
+
+	// 创建HTTP请求
+	req, err := http.NewRequest("POST", strings.TrimSuffix(p.APIEndpoint, "/")+"/", bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("创建OCR请求失败: %v", err)
+	}
+	for key, value := range headers {
+		if key == "host" {
+			continue
+		}
+		req.Header.Set(key, value)
+	}
+
+	// 发送请求
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送OCR请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取OCR响应失败: %v", err)
+	}
+
+	// 解析响应
+	var ocrResp AliyunOCRResponse
+	if err := json.Unmarshal(respBody, &ocrResp); err != nil {
+		return nil, fmt.Errorf("解析OCR响应失败: %v, 状态码: %d", err, resp.StatusCode)
+	}
+
+	// 检查错误
+	if resp.StatusCode != http.StatusOK || ocrResp.Code != "" {
+		return nil, fmt.Errorf("OCR API返回错误: %s (错误码: %s, 状态码: %d)", ocrResp.Message, ocrResp.Code, resp.StatusCode)
+	}
+
+	var data AliyunOCRData
+	if err := json.Unmarshal([]byte(ocrResp.Data), &data); err != nil {
+		return nil, fmt.Errorf("解析OCR结果失败: %v", err)
+	}
+
+	// 没有逐行结果时退回到整段文本
+	if len(data.PrismWordsInfo) == 0 {
+		if data.Content == "" {
+			return nil, nil
+		}
+		return []model.OCRLine{{Text: data.Content}}, nil
+	}
+	lines := make([]model.OCRLine, 0, len(data.PrismWordsInfo))
+	for _, word := range data.PrismWordsInfo {
+		lines = append(lines, model.OCRLine{
+			Text:   word.Word,
+			Left:   word.X,
+			Top:    word.Y,
+			Width:  word.Width,
+			Height: word.Height,
+		})
+	}
+
+	return lines, nil
+}
+
+// acs3SignInput 描述参与ACS3-HMAC-SHA256签名的请求要素
+// Headers 的键必须为小写，且应包含 x-acs-content-sha256
+type acs3SignInput struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	Method          string
+	CanonicalURI    string
+	Query           url.Values
+	Headers         map[string]string
+}
+
+// acs3SignedHeaders 返回参与签名的请求头名称（已排序）
+func acs3SignedHeaders(headers map[string]string) []string {
+	var names []string
+	for name := range headers {
+		if name == "host" || name == "content-type" || strings.HasPrefix(name, "x-acs-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// acs3CanonicalRequest 构建规范请求串
+func acs3CanonicalRequest(in acs3SignInput) string {
+	signedHeaders := acs3SignedHeaders(in.Headers)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		canonicalHeaders.WriteString(name)
+		canonicalHeaders.WriteString(":")
+		canonicalHeaders.WriteString(strings.TrimSpace(in.Headers[name]))
+		canonicalHeaders.WriteString("\n")
+	}
+
+	// url.Values.Encode 已按键排序，但空格需编码为%20
+	canonicalQuery := strings.ReplaceAll(in.Query.Encode(), "+", "%20")
+
+	return strings.Join([]string{
+		in.Method,
+		in.CanonicalURI,
+		canonicalQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		in.Headers["x-acs-content-sha256"],
+	}, "\n")
+}
+
+// signACS3 按照阿里云V3签名规范生成Authorization请求头
+func signACS3(in acs3SignInput) string {
+	stringToSign := acs3Algorithm + "\n" + sha256Hex([]byte(acs3CanonicalRequest(in)))
+	signature := hex.EncodeToString(hmacSHA256([]byte(in.AccessKeySecret), stringToSign))
+
+	return fmt.Sprintf("%s Credential=%s,SignedHeaders=%s,Signature=%s",
+		acs3Algorithm, in.AccessKeyID, strings.Join(acs3SignedHeaders(in.Headers), ";"), signature)
+}
+
+// newSignatureNonce 生成签名所需的随机数
+func newSignatureNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成签名随机数失败: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,99 @@
+package ocr
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSignACS3(t *testing.T) {
+	// 阿里云V3签名文档中的示例参数
+	in := acs3SignInput{
+		AccessKeyID:     "YourAccessKeyId",
+		AccessKeySecret: "YourAccessKeySecret",
+		Method:          "POST",
+		CanonicalURI:    "/",
+		Query: url.Values{
+			"ImageId":  {"win2019_1809_x64_dtc_zh-cn_40G_alibase_20230811.vhd"},
+			"RegionId": {"cn-shanghai"},
+		},
+		Headers: map[string]string{
+			"host":                  "ecs.cn-shanghai.aliyuncs.com",
+			"x-acs-action":          "RunInstances",
+			"x-acs-version":         "2014-05-26",
+			"x-acs-date":            "2023-10-26T10:22:32Z",
+			"x-acs-signature-nonce": "3156853299f313e23d1673dc12e1703d",
+			"x-acs-content-sha256":  "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+	}
+
+	if got, want := sha256Hex([]byte(acs3CanonicalRequest(in))), "7ea06492da5221eba5297e897ce16e55f964061054b7695beedaac1145b1e259"; got != want {
+		t.Errorf("acs3CanonicalRequest() hash = %v, want %v", got, want)
+	}
+
+	want := "ACS3-HMAC-SHA256 Credential=YourAccessKeyId," +
+		"SignedHeaders=host;x-acs-action;x-acs-content-sha256;x-acs-date;x-acs-signature-nonce;x-acs-version," +
+		"Signature=06563a9e1b43f5dfe96b81484da74bceab24a1d853912eee15083a6f0f3283c0"
+	if got := signACS3(in); got != want {
+		t.Errorf("signACS3() got = %v, want %v", got, want)
+	}
+}
+
+func TestAliyunOCRProvider_RecognizeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "success",
+			status:   http.StatusOK,
+			response: `{"RequestId":"r1","Data":"{\"content\":\"第一行 second line\",\"prism_wordsInfo\":[{\"word\":\"第一行\"},{\"word\":\"second line\"}]}"}`,
+			want:     "第一行\nsecond line",
+		},
+		{
+			name:     "api error",
+			status:   http.StatusBadRequest,
+			response: `{"RequestId":"r2","Code":"InvalidAccessKeyId.NotFound","Message":"Specified access key is not found."}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("x-acs-action") != aliyunOCRAction {
+					t.Errorf("x-acs-action = %q", r.Header.Get("x-acs-action"))
+				}
+				if !strings.HasPrefix(r.Header.Get("Authorization"), "ACS3-HMAC-SHA256 Credential=id,") {
+					t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+				}
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != "hello" {
+					t.Errorf("body = %q", body)
+				}
+				if r.Header.Get("x-acs-content-sha256") != sha256Hex(body) {
+					t.Errorf("x-acs-content-sha256 = %q", r.Header.Get("x-acs-content-sha256"))
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			p := NewAliyunOCRProvider("id", "secret", "")
+			p.APIEndpoint = server.URL
+			got, err := p.RecognizeText("aGVsbG8=")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RecognizeText() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("RecognizeText() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
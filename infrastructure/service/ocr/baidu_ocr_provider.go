@@ -8,6 +8,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/qujing226/screen_sage/domain/model"
 )
 
 // BaiduOCRProvider 是百度OCR API的客户端
@@ -32,7 +34,13 @@ type BaiduTokenResponse struct {
 // BaiduOCRResponse 表示百度OCR响应的结构
 type BaiduOCRResponse struct {
 	WordsResult []struct {
-		Words string `json:"words"`
+		Words    string `json:"words"`
+		Location *struct {
+			Left   int `json:"left"`
+			Top    int `json:"top"`
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"location,omitempty"` // 仅带位置信息的接口返回
 	} `json:"words_result"`
 	WordsResultNum int    `json:"words_result_num"`
 	ErrorCode      int    `json:"error_code,omitempty"`
@@ -59,9 +67,12 @@ func (p *BaiduOCRProvider) getAccessToken() (string, error) {
 		return p.AccessToken, nil
 	}
 	// 构建请求参数
-	u := fmt.Sprintf("https://aip.baidubce.com/oauth/2.0/token?client_id=%s&client_secret=%s&grant_type=client_credentials", p.APIKey, p.SecretKey)
+	params := url.Values{}
+	params.Set("client_id", p.APIKey)
+	params.Set("client_secret", p.SecretKey)
+	params.Set("grant_type", "client_credentials")
+	u := p.TokenURL + "?" + params.Encode()
 	payload := strings.NewReader(``)
-	client := &http.Client{}
 	req, err := http.NewRequest("POST", u, payload)
 	if err != nil {
 		return "", fmt.Errorf("创建令牌请求失败: %v", err)
@@ -71,9 +82,9 @@ func (p *BaiduOCRProvider) getAccessToken() (string, error) {
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Accept", "application/json")
 	// 发送请求
-	resp, err := client.Do(req)
+	resp, err := p.HTTPClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("发送令牌请求失败: %v", err)
+		return "", fmt.Errorf("发送令牌请求失败: %v", redactURLError(err))
 	}
 	defer resp.Body.Close()
 
@@ -101,12 +112,27 @@ func (p *BaiduOCRProvider) getAccessToken() (string, error) {
 	return p.AccessToken, nil
 }
 
+// FetchToken 获取访问令牌，可用于检查API Key和Secret Key是否有效
+func (p *BaiduOCRProvider) FetchToken() (string, error) {
+	return p.getAccessToken()
+}
+
 // RecognizeText 实现OCRProvider接口，识别图像中的文本
 func (p *BaiduOCRProvider) RecognizeText(imageBase64 string) (string, error) {
+	lines, err := p.RecognizeLines(imageBase64)
+	if err != nil {
+		return "", err
+	}
+	return joinLines(lines), nil
+}
+
+// RecognizeLines 识别图像中的文本行
+// 使用带位置信息的接口（如 general）时会同时返回每行的位置
+func (p *BaiduOCRProvider) RecognizeLines(imageBase64 string) ([]model.OCRLine, error) {
 	// 获取访问令牌
 	token, err := p.getAccessToken()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// 构建请求URL
@@ -122,7 +148,7 @@ func (p *BaiduOCRProvider) RecognizeText(imageBase64 string) (string, error) {
 	// 创建HTTP请求
 	req, err := http.NewRequest("POST", requestURL, strings.NewReader(data.Encode()))
 	if err != nil {
-		return "", fmt.Errorf("创建OCR请求失败: %v", err)
+		return nil, fmt.Errorf("创建OCR请求失败: %v", err)
 	}
 
 	// 设置请求头 - 确保使用正确的Content-Type
@@ -131,40 +157,44 @@ func (p *BaiduOCRProvider) RecognizeText(imageBase64 string) (string, error) {
 	// 发送请求
 	resp, err := p.HTTPClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("发送OCR请求失败: %v", err)
+		return nil, fmt.Errorf("发送OCR请求失败: %v", redactURLError(err))
 	}
 	defer resp.Body.Close()
 
 	// 读取响应体
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("读取OCR响应失败: %v", err)
+		return nil, fmt.Errorf("读取OCR响应失败: %v", err)
 	}
 
 	// 检查响应状态码
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("OCR API返回错误: %s, 状态码: %d", string(respBody), resp.StatusCode)
+		return nil, fmt.Errorf("OCR API返回错误: %s, 状态码: %d", string(respBody), resp.StatusCode)
 	}
 
 	// 解析响应
 	var ocrResp BaiduOCRResponse
 	if err := json.Unmarshal(respBody, &ocrResp); err != nil {
-		return "", fmt.Errorf("解析OCR响应失败: %v", err)
+		return nil, fmt.Errorf("解析OCR响应失败: %v", err)
 	}
 
 	// 检查错误
 	if ocrResp.ErrorCode != 0 {
-		return "", fmt.Errorf("OCR API返回错误: %s (错误码: %d)", ocrResp.ErrorMsg, ocrResp.ErrorCode)
+		return nil, fmt.Errorf("OCR API返回错误: %s (错误码: %d)", ocrResp.ErrorMsg, ocrResp.ErrorCode)
 	}
 
-	// 合并识别结果
-	var textBuilder strings.Builder
-	for i, result := range ocrResp.WordsResult {
-		if i > 0 {
-			textBuilder.WriteString("\n")
+	// 整理识别结果
+	lines := make([]model.OCRLine, 0, len(ocrResp.WordsResult))
+	for _, result := range ocrResp.WordsResult {
+		line := model.OCRLine{Text: result.Words}
+		if result.Location != nil {
+			line.Left = result.Location.Left
+			line.Top = result.Location.Top
+			line.Width = result.Location.Width
+			line.Height = result.Location.Height
 		}
-		textBuilder.WriteString(result.Words)
+		lines = append(lines, line)
 	}
 
-	return textBuilder.String(), nil
+	return lines, nil
 }
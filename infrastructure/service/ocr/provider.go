@@ -0,0 +1,49 @@
+package ocr
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/qujing226/screen_sage/domain/model"
+	"github.com/qujing226/screen_sage/internal/config"
+)
+
+// Provider 是各OCR服务商客户端的通用接口
+type Provider interface {
+	RecognizeText(imageBase64 string) (string, error)
+	RecognizeLines(imageBase64 string) ([]model.OCRLine, error)
+}
+
+// NewProvider 根据配置中的 ocr_provider 创建对应的OCR提供者
+func NewProvider(cfg *config.Config) (Provider, error) {
+	switch strings.ToLower(cfg.OCRProvider) {
+	case "", "baidu":
+		return NewBaiduOCRProvider(cfg.BaiduAPIKey, cfg.BaiduSecretKey), nil
+	case "tencent":
+		return NewTencentOCRProvider(cfg.TencentSecretID, cfg.TencentSecretKey, cfg.TencentRegion), nil
+	case "aliyun":
+		return NewAliyunOCRProvider(cfg.AliyunAccessKeyID, cfg.AliyunAccessKeySecret, cfg.AliyunRegion), nil
+	default:
+		return nil, fmt.Errorf("不支持的OCR服务商: %s", cfg.OCRProvider)
+	}
+}
+
+// joinLines 将识别出的文本行按换行拼接
+func joinLines(lines []model.OCRLine) string {
+	texts := make([]string, 0, len(lines))
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// redactURLError 去掉请求错误中的URL，URL中可能带有密钥或访问令牌
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s 请求失败: %v", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
@@ -0,0 +1,237 @@
+package ocr
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qujing226/screen_sage/domain/model"
+)
+
+const (
+	tencentOCRService = "ocr"
+	tencentOCRAction  = "GeneralBasicOCR"
+	tencentOCRVersion = "2018-11-19"
+	tc3Algorithm      = "TC3-HMAC-SHA256"
+)
+
+// TencentOCRProvider 是腾讯云通用文字识别API的客户端
+type TencentOCRProvider struct {
+	HTTPClient  *http.Client
+	SecretID    string
+	SecretKey   string
+	Region      string
+	APIEndpoint string
+}
+
+// TencentOCRResponse 表示腾讯云OCR响应的结构
+type TencentOCRResponse struct {
+	Response struct {
+		TextDetections []struct {
+			DetectedText string `json:"DetectedText"`
+			Confidence   int    `json:"Confidence"`
+			ItemPolygon  struct {
+				X      int `json:"X"`
+				Y      int `json:"Y"`
+				Width  int `json:"Width"`
+				Height int `json:"Height"`
+			} `json:"ItemPolygon"`
+		} `json:"TextDetections"`
+		Error *struct {
+			Code    string `json:"Code"`
+			Message string `json:"Message"`
+		} `json:"Error,omitempty"`
+		RequestID string `json:"RequestId"`
+	} `json:"Response"`
+}
+
+// NewTencentOCRProvider 创建一个新的腾讯云OCR提供者
+func NewTencentOCRProvider(secretID, secretKey, region string) *TencentOCRProvider {
+	if region == "" {
+		region = "ap-guangzhou"
+	}
+	return &TencentOCRProvider{
+		SecretID:    secretID,
+		SecretKey:   secretKey,
+		Region:      region,
+		APIEndpoint: "https://ocr.tencentcloudapi.com",
+		HTTPClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// RecognizeText 实现OCRProvider接口，识别图像中的文本
+func (p *TencentOCRProvider) RecognizeText(imageBase64 string) (string, error) {
+	lines, err := p.RecognizeLines(imageBase64)
+	if err != nil {
+		return "", err
+	}
+	return joinLines(lines), nil
+}
+
+// RecognizeLines 识别图像中的文本行及其位置
+func (p *TencentOCRProvider) RecognizeLines(imageBase64 string) ([]model.OCRLine, error) {
+	if p.SecretID == "" || p.SecretKey == "" {
+		return nil, fmt.Errorf("腾讯云OCR密钥未配置")
+	}
+
+	endpoint, err := url.Parse(p.APIEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("解析OCR地址失败: %v", err)
+	}
+
+	// 构建请求体
+	payload, err := json.Marshal(map[string]string{
+		"ImageBase64": stripDataURLPrefix(imageBase64),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("编码请求数据失败: %v", err)
+	}
+
+	contentType := "application/json; charset=utf-8"
+	timestamp := time.Now().Unix()
+	authorization := signTC3(tc3SignInput{
+		SecretID:    p.SecretID,
+		SecretKey:   p.SecretKey,
+		Service:     tencentOCRService,
+		Host:        endpoint.Host,
+		Action:      tencentOCRAction,
+		ContentType: contentType,
+		Payload:     payload,
+		Timestamp:   timestamp,
+	})
+
+	// 创建HTTP请求
+	req, err := http.NewRequest("POST", p.APIEndpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("创建OCR请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-TC-Action", tencentOCRAction)
+	req.Header.Set("X-TC-Version", tencentOCRVersion)
+	req.Header.Set("X-TC-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-TC-Region", p.Region)
+
+	// 发送请求
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送OCR请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取OCR响应失败: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCR API返回错误: %s, 状态码: %d", string(respBody), resp.StatusCode)
+	}
+
+	// 解析响应
+	var ocrResp TencentOCRResponse
+	if err := json.Unmarshal(respBody, &ocrResp); err != nil {
+		return nil, fmt.Errorf("解析OCR响应失败: %v", err)
+	}
+
+	// 检查错误
+	if ocrResp.Response.Error != nil {
+		return nil, fmt.Errorf("OCR API返回错误: %s (错误码: %s)", ocrResp.Response.Error.Message, ocrResp.Response.Error.Code)
+	}
+
+	// 整理识别结果
+	lines := make([]model.OCRLine, 0, len(ocrResp.Response.TextDetections))
+	for _, detection := range ocrResp.Response.TextDetections {
+		lines = append(lines, model.OCRLine{
+			Text:   detection.DetectedText,
+			Left:   detection.ItemPolygon.X,
+			Top:    detection.ItemPolygon.Y,
+			Width:  detection.ItemPolygon.Width,
+			Height: detection.ItemPolygon.Height,
+		})
+	}
+
+	return lines, nil
+}
+
+// tc3SignInput 描述参与TC3-HMAC-SHA256签名的请求要素
+type tc3SignInput struct {
+	SecretID    string
+	SecretKey   string
+	Service     string
+	Host        string
+	Action      string
+	ContentType string
+	Payload     []byte
+	Timestamp   int64
+}
+
+// tc3CanonicalRequest 构建规范请求串
+func tc3CanonicalRequest(in tc3SignInput) string {
+	canonicalHeaders := fmt.Sprintf("content-type:%s\nhost:%s\nx-tc-action:%s\n",
+		in.ContentType, in.Host, strings.ToLower(in.Action))
+	return strings.Join([]string{
+		"POST",
+		"/",
+		"",
+		canonicalHeaders,
+		"content-type;host;x-tc-action",
+		sha256Hex(in.Payload),
+	}, "\n")
+}
+
+// signTC3 按照腾讯云API 3.0签名规范生成Authorization请求头
+func signTC3(in tc3SignInput) string {
+	date := time.Unix(in.Timestamp, 0).UTC().Format("2006-01-02")
+	credentialScope := fmt.Sprintf("%s/%s/tc3_request", date, in.Service)
+
+	stringToSign := strings.Join([]string{
+		tc3Algorithm,
+		strconv.FormatInt(in.Timestamp, 10),
+		credentialScope,
+		sha256Hex([]byte(tc3CanonicalRequest(in))),
+	}, "\n")
+
+	// 派生签名密钥
+	secretDate := hmacSHA256([]byte("TC3"+in.SecretKey), date)
+	secretService := hmacSHA256(secretDate, in.Service)
+	secretSigning := hmacSHA256(secretService, "tc3_request")
+	signature := hex.EncodeToString(hmacSHA256(secretSigning, stringToSign))
+
+	return fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=content-type;host;x-tc-action, Signature=%s",
+		tc3Algorithm, in.SecretID, credentialScope, signature)
+}
+
+// hmacSHA256 计算HMAC-SHA256摘要
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sha256Hex 计算SHA256摘要并以小写十六进制返回
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// stripDataURLPrefix 去除形如 data:image/png;base64, 的前缀
+func stripDataURLPrefix(imageBase64 string) string {
+	if strings.HasPrefix(imageBase64, "data:") {
+		if idx := strings.Index(imageBase64, ","); idx != -1 {
+			return imageBase64[idx+1:]
+		}
+	}
+	return imageBase64
+}
@@ -0,0 +1,83 @@
+package ocr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSignTC3(t *testing.T) {
+	// 腾讯云签名文档中的示例参数
+	in := tc3SignInput{
+		SecretID:    "AKIDz8krbsJ5yKBZQpn74WFkmLPx3EXAMPLE",
+		SecretKey:   "Gu5t9xGARNpq86cd98joQYCN3EXAMPLE",
+		Service:     "cvm",
+		Host:        "cvm.tencentcloudapi.com",
+		Action:      "DescribeInstances",
+		ContentType: "application/json; charset=utf-8",
+		Payload:     []byte(`{"Limit": 1, "Filters": [{"Values": ["\u672a\u547d\u540d"], "Name": "instance-name"}]}`),
+		Timestamp:   1551113065,
+	}
+
+	if got, want := sha256Hex([]byte(tc3CanonicalRequest(in))), "7019a55be8395899b900fb5564e4200d984910f34794a27cb3fb7d10ff6a1e84"; got != want {
+		t.Errorf("tc3CanonicalRequest() hash = %v, want %v", got, want)
+	}
+
+	want := "TC3-HMAC-SHA256 Credential=AKIDz8krbsJ5yKBZQpn74WFkmLPx3EXAMPLE/2019-02-25/cvm/tc3_request, " +
+		"SignedHeaders=content-type;host;x-tc-action, " +
+		"Signature=644be983de9a8a3f00db8eadaba61467c3b429e2215758ba897b738ca469fd26"
+	if got := signTC3(in); got != want {
+		t.Errorf("signTC3() got = %v, want %v", got, want)
+	}
+}
+
+func TestTencentOCRProvider_RecognizeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "success",
+			response: `{"Response":{"TextDetections":[{"DetectedText":"第一行"},{"DetectedText":"second line"}],"RequestId":"r1"}}`,
+			want:     "第一行\nsecond line",
+		},
+		{
+			name:     "api error",
+			response: `{"Response":{"Error":{"Code":"AuthFailure.SignatureFailure","Message":"签名错误"},"RequestId":"r2"}}`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-TC-Action") != tencentOCRAction {
+					t.Errorf("X-TC-Action = %q", r.Header.Get("X-TC-Action"))
+				}
+				if !strings.HasPrefix(r.Header.Get("Authorization"), "TC3-HMAC-SHA256 Credential=id/") {
+					t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+				}
+				var body map[string]string
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["ImageBase64"] != "aGVsbG8=" {
+					t.Errorf("ImageBase64 = %q, err = %v", body["ImageBase64"], err)
+				}
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			p := NewTencentOCRProvider("id", "key", "")
+			p.APIEndpoint = server.URL
+			got, err := p.RecognizeText("data:image/png;base64,aGVsbG8=")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RecognizeText() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("RecognizeText() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package ui
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+// OpenBrowser 使用系统默认浏览器打开url
+// url作为独立参数传给系统命令，不经过shell解析
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default: // linux等
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,233 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// 自动生成的文件名
+	caCertFile     = "ca.pem"
+	caKeyFile      = "ca-key.pem"
+	serverCertFile = "server.pem"
+	serverKeyFile  = "server-key.pem"
+
+	// caValidity 本地CA的有效期
+	caValidity = 10 * 365 * 24 * time.Hour
+	// caRenewBefore CA在到期前多久重新生成，重新生成后需要在手机上重新安装
+	caRenewBefore = 90 * 24 * time.Hour
+	// certValidity 服务器证书的有效期，不超过浏览器接受的上限
+	certValidity = 365 * 24 * time.Hour
+	// certRenewBefore 服务器证书在到期前多久重新签发
+	certRenewBefore = 30 * 24 * time.Hour
+	// checkInterval 检查证书到期、局域网地址和证书文件变化的间隔
+	checkInterval = time.Minute
+)
+
+// Manager 为HTTPS服务提供证书
+// 指定了证书文件时使用该证书，文件更新后自动重新加载；
+// 否则使用本地CA签发包含本机局域网地址的服务器证书，临近到期或地址变化时重新签发
+type Manager struct {
+	Dir      string          // 自动生成的CA和服务器证书的保存目录
+	CertFile string          // 指定的证书文件，为空时自动生成
+	KeyFile  string          // 指定的私钥文件
+	Hosts    func() []string // 证书中包含的主机名和IP，默认为LocalHosts
+
+	now     func() time.Time
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	checked time.Time
+	modTime time.Time // 指定证书文件的修改时间
+}
+
+// NewManager 创建证书管理器，certFile和keyFile为空时在dir中自动生成证书
+func NewManager(dir, certFile, keyFile string) (*Manager, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("证书文件和私钥文件需要同时设置")
+	}
+	return &Manager{Dir: dir, CertFile: certFile, KeyFile: keyFile}, nil
+}
+
+// TLSConfig 返回使用该管理器证书的TLS配置
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.GetCertificate,
+	}
+}
+
+// GetCertificate 返回当前的服务器证书，每隔一段时间检查是否需要更换
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.clock()
+	if m.cert != nil && now.Sub(m.checked) < checkInterval {
+		return m.cert, nil
+	}
+	cert, err := m.load(now)
+	if err != nil {
+		// 更换失败时继续使用原有证书，下次检查时重试
+		if m.cert != nil {
+			log.Printf("更新HTTPS证书失败，继续使用原有证书: %v", err)
+			m.checked = now
+			return m.cert, nil
+		}
+		return nil, err
+	}
+	m.cert = cert
+	m.checked = now
+	return cert, nil
+}
+
+// Certificate 立即加载或签发证书，用于启动时检查配置
+func (m *Manager) Certificate() (*tls.Certificate, error) {
+	return m.GetCertificate(nil)
+}
+
+// load 加载指定的证书，或返回仍然有效的自动生成证书，调用方需持有mutex
+func (m *Manager) load(now time.Time) (*tls.Certificate, error) {
+	if m.CertFile != "" {
+		return m.loadFiles()
+	}
+
+	hosts := m.hosts()
+	if m.cert != nil && !needsRenewal(m.cert.Leaf, hosts, now) {
+		return m.cert, nil
+	}
+
+	ca, renewed, err := loadAuthority(m.Dir, now)
+	if err != nil {
+		return nil, err
+	}
+	certPath, keyPath := filepath.Join(m.Dir, serverCertFile), filepath.Join(m.Dir, serverKeyFile)
+	if !renewed {
+		if cert, err := loadKeyPair(certPath, keyPath); err == nil && !needsRenewal(cert.Leaf, hosts, now) &&
+			cert.Leaf.CheckSignatureFrom(ca.cert) == nil {
+			return cert, nil
+		}
+	}
+
+	cert, err := ca.issue(hosts, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyPair(certPath, keyPath, cert); err != nil {
+		return nil, err
+	}
+	log.Printf("已签发新的HTTPS证书，有效期至 %s，包含地址: %v", cert.Leaf.NotAfter.Format("2006-01-02"), hosts)
+	return cert, nil
+}
+
+// loadFiles 加载指定的证书文件，文件未变化时沿用已加载的证书
+func (m *Manager) loadFiles() (*tls.Certificate, error) {
+	info, err := os.Stat(m.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取证书文件失败: %v", err)
+	}
+	if keyInfo, err := os.Stat(m.KeyFile); err == nil && keyInfo.ModTime().After(info.ModTime()) {
+		info = keyInfo
+	}
+	if m.cert != nil && info.ModTime().Equal(m.modTime) {
+		return m.cert, nil
+	}
+
+	cert, err := loadKeyPair(m.CertFile, m.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if m.clock().After(cert.Leaf.NotAfter) {
+		log.Printf("警告: HTTPS证书 %s 已于 %s 过期", m.CertFile, cert.Leaf.NotAfter.Format("2006-01-02"))
+	}
+	if m.cert != nil {
+		log.Printf("已重新加载HTTPS证书 %s", m.CertFile)
+	}
+	m.modTime = info.ModTime()
+	return cert, nil
+}
+
+// ExportCA 返回本地CA证书，尚未生成时先生成
+func (m *Manager) ExportCA() (*x509.Certificate, error) {
+	if m.CertFile != "" {
+		return nil, fmt.Errorf("已配置自定义证书，没有需要导出的本地CA")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ca, _, err := loadAuthority(m.Dir, m.clock())
+	if err != nil {
+		return nil, err
+	}
+	return ca.cert, nil
+}
+
+func (m *Manager) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+func (m *Manager) hosts() []string {
+	if m.Hosts != nil {
+		return m.Hosts()
+	}
+	return LocalHosts()
+}
+
+// needsRenewal 判断证书是否临近到期或未包含全部地址
+func needsRenewal(leaf *x509.Certificate, hosts []string, now time.Time) bool {
+	if leaf == nil || now.Add(certRenewBefore).After(leaf.NotAfter) {
+		return true
+	}
+	for _, host := range hosts {
+		if leaf.VerifyHostname(host) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// LocalHosts 返回本机的主机名、回环地址和局域网地址
+func LocalHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && validHostname(name) {
+		hosts = append(hosts, name, name+".local")
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return hosts
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified() {
+			continue
+		}
+		hosts = append(hosts, ip.String())
+	}
+	return hosts
+}
+
+// validHostname 判断主机名能否写入证书，包含其他字符的主机名无法通过校验
+func validHostname(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package certs
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManagerIssuesAndRenews(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	hosts := []string{"localhost", "127.0.0.1", "192.168.1.20"}
+	m := &Manager{Dir: dir, Hosts: func() []string { return hosts }, now: func() time.Time { return now }}
+
+	cert, err := m.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := m.ExportCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 证书由本地CA签发，包含局域网地址
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	for _, host := range hosts {
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: pool, CurrentTime: now}); err != nil {
+			t.Errorf("%s: %v", host, err)
+		}
+	}
+
+	// 重启后沿用已保存的证书
+	restarted := &Manager{Dir: dir, Hosts: m.Hosts, now: m.now}
+	again, err := restarted.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Error("证书仍然有效时不应重新签发")
+	}
+
+	// 局域网地址变化后重新签发
+	hosts = append(hosts, "10.0.0.5")
+	now = now.Add(2 * checkInterval)
+	changed, err := m.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Leaf.VerifyHostname("10.0.0.5") != nil {
+		t.Error("地址变化后应重新签发证书")
+	}
+
+	// 临近到期时重新签发，CA不变
+	now = changed.Leaf.NotAfter.Add(-certRenewBefore / 2)
+	renewed, err := m.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !renewed.Leaf.NotAfter.After(changed.Leaf.NotAfter) {
+		t.Errorf("证书未续期: %s", renewed.Leaf.NotAfter)
+	}
+	if err := renewed.Leaf.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("续期后的证书应由原CA签发: %v", err)
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, caKeyFile)); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("CA私钥权限不正确: %v %v", info.Mode(), err)
+	}
+}
+
+func TestManagerConfiguredFiles(t *testing.T) {
+	dir := t.TempDir()
+	issuer := &Manager{Dir: dir, Hosts: func() []string { return []string{"example.lan"} }}
+	if _, err := issuer.Certificate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewManager(dir, filepath.Join(dir, serverCertFile), ""); err == nil {
+		t.Error("只设置证书文件时应报错")
+	}
+	m, err := NewManager(t.TempDir(), filepath.Join(dir, serverCertFile), filepath.Join(dir, serverKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := m.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf.VerifyHostname("example.lan") != nil {
+		t.Error("应使用指定的证书")
+	}
+	if _, err := m.ExportCA(); err == nil {
+		t.Error("使用自定义证书时不应导出本地CA")
+	}
+}
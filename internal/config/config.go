@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"sync"
@@ -15,31 +16,108 @@ type Config struct {
 	BaiduSecretKey string `json:"baidu_secret_key"`
 	DeepSeekAPIKey string `json:"deepseek_api_key"`
 
+	// OCR服务商配置，可选 baidu、tencent、aliyun
+	OCRProvider           string `json:"ocr_provider"`
+	TencentSecretID       string `json:"tencent_secret_id"`
+	TencentSecretKey      string `json:"tencent_secret_key"`
+	TencentRegion         string `json:"tencent_region"`
+	AliyunAccessKeyID     string `json:"aliyun_access_key_id"`
+	AliyunAccessKeySecret string `json:"aliyun_access_key_secret"`
+	AliyunRegion          string `json:"aliyun_region"`
+
+	// 视觉模型配置，用于跳过OCR直接以截图提问
+	VisionAPIKey   string `json:"vision_api_key"`
+	VisionEndpoint string `json:"vision_endpoint"`
+	VisionModel    string `json:"vision_model"`
+
+	// 处理模式: ocr(默认)、vision(直接发送图片)、auto(OCR文字过少时改用视觉模型)
+	PipelineMode        string `json:"pipeline_mode"`
+	VisionFallbackChars int    `json:"vision_fallback_chars"`
+
+	// 提示词配置
+	DefaultPromptProfile string `json:"default_prompt_profile"`
+	Language             string `json:"language"` // 回答使用的语言
+
+	// 用量与费用配置，价格单位为元，模型价格按每百万token计
+	TextPromptPrice       float64 `json:"text_prompt_price"`
+	TextCompletionPrice   float64 `json:"text_completion_price"`
+	VisionPromptPrice     float64 `json:"vision_prompt_price"`
+	VisionCompletionPrice float64 `json:"vision_completion_price"`
+	OCRCallPrice          float64 `json:"ocr_call_price"`      // 每次OCR调用价格
+	DailyCostLimit        float64 `json:"daily_cost_limit"`    // 每日费用限额，0表示不限制
+	MonthlyCostLimit      float64 `json:"monthly_cost_limit"`  // 每月费用限额，0表示不限制
+	DailyRequestLimit     int     `json:"daily_request_limit"` // 每日处理次数限额，0表示不限制
+	MonthlyRequestLimit   int     `json:"monthly_request_limit"`
+
+	// 截图快捷键，例如 Ctrl+Shift+Q
+	Hotkey string `json:"hotkey"`
+
 	// 数据库配置
 	DBPath string `json:"db_path"`
 
 	// 服务器配置
-	Port       int    `json:"port"`
-	StaticPath string `json:"static_path"`
+	BindAddress string `json:"bind_address"` // 监听地址，默认只允许本机访问
+	Port        int    `json:"port"`
+	StaticPath  string `json:"static_path"` // 前端目录，用于开发时调试，为空时使用程序内嵌的前端
+	APIToken    string `json:"api_token"`   // 访问Web API的令牌，首次启动时生成
+
+	// HTTPS配置，未指定证书文件时使用自动生成的本地CA签发证书
+	TLSEnabled  bool   `json:"tls_enabled"`
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
 }
 
 var (
+	// instance 生效的配置快照：base 叠加环境变量和命令行参数，发布后不再修改
 	instance *Config
-	once     sync.Once
-	mutex    sync.RWMutex
+	// base 默认值叠加配置文件和加密密钥，保存配置时写入的就是这一层
+	base *Config
+	// sources 生效配置中各配置项的来源
+	sources = map[string]string{}
+	// options 启动时指定的配置文件路径和命令行覆盖项
+	options Options
+	once    sync.Once
+	mutex   sync.RWMutex
 )
 
-// GetConfig 获取配置单例
+// defaultConfig 返回默认配置
+func defaultConfig() *Config {
+	return &Config{
+		// 默认配置
+		BindAddress:   "127.0.0.1",
+		Port:          8081,
+		DBPath:        getDefaultDBPath(),
+		OCRProvider:   "baidu",
+		TencentRegion: "ap-guangzhou",
+		AliyunRegion:  "cn-hangzhou",
+
+		VisionEndpoint:      "https://api.openai.com/v1/chat/completions",
+		VisionModel:         "gpt-4o-mini",
+		PipelineMode:        "ocr",
+		VisionFallbackChars: 20,
+
+		DefaultPromptProfile: "algorithm",
+		Language:             "中文",
+
+		TextPromptPrice:       2,
+		TextCompletionPrice:   8,
+		VisionPromptPrice:     1.1,
+		VisionCompletionPrice: 4.4,
+		OCRCallPrice:          0.004,
+
+		Hotkey: "Ctrl+Shift+Q",
+	}
+}
+
+// GetConfig 获取当前生效的配置快照
+// 配置按 默认值 → 配置文件 → SCREENSAGE_* 环境变量 → 命令行参数 的顺序叠加
+// 返回的快照不会再被修改，配置变化时会生成新的快照，需要感知变化时使用Subscribe
 func GetConfig() *Config {
 	once.Do(func() {
-		instance = &Config{
-			// 默认配置
-			Port:       8081,
-			StaticPath: "./web/frontend/dist",
-			DBPath:     getDefaultDBPath(),
-		}
+		base = defaultConfig()
 		// 尝试加载配置文件
 		loadConfig()
+		applyOverrides()
 	})
 
 	mutex.RLock()
@@ -49,32 +127,15 @@ func GetConfig() *Config {
 
 // 获取默认数据库路径
 func getDefaultDBPath() string {
-	// 获取当前可执行文件所在目录
-	execDir, err := os.Executable()
-	if err != nil {
-		// 如果无法获取可执行文件路径，使用当前目录
-		return "./data/screensage.db"
-	}
-
-	// 使用可执行文件所在目录作为基础目录
-	baseDir := filepath.Dir(execDir)
-	dataDir := filepath.Join(baseDir, "data")
-	return filepath.Join(dataDir, "screensage.db")
+	return filepath.Join(currentDirs().Data, "screensage.db")
 }
 
-// 获取配置文件路径
+// 获取配置文件路径，未通过 --config 指定时使用配置目录中的 config.json
 func getConfigFilePath() string {
-	// 获取当前可执行文件所在目录
-	execDir, err := os.Executable()
-	if err != nil {
-		// 如果无法获取可执行文件路径，使用当前目录
-		return "./config.json"
+	if options.Path != "" {
+		return options.Path
 	}
-
-	// 使用可执行文件所在目录作为基础目录
-	baseDir := filepath.Dir(execDir)
-	configDir := filepath.Join(baseDir, "config")
-	return filepath.Join(configDir, "config.json")
+	return filepath.Join(currentDirs().Config, "config.json")
 }
 
 // 加载配置文件
@@ -84,6 +145,11 @@ func loadConfig() {
 	// 检查配置文件是否存在
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		// 配置文件不存在，创建默认配置
+		if _, err := mergeSecrets(base); err != nil {
+			secretsUnavailable.Store(true)
+			fmt.Printf("读取加密密钥失败: %v\n", err)
+			return
+		}
 		saveConfig()
 		return
 	}
@@ -97,12 +163,30 @@ func loadConfig() {
 
 	// 解析配置文件
 	mutex.Lock()
-	defer mutex.Unlock()
-
-	err = json.Unmarshal(data, instance)
+	err = json.Unmarshal(data, base)
+	mutex.Unlock()
 	if err != nil {
 		fmt.Printf("解析配置文件失败: %v\n", err)
 	}
+
+	// 读取加密保存的密钥
+	mutex.Lock()
+	plaintext, err := mergeSecrets(base)
+	mutex.Unlock()
+	if err != nil {
+		secretsUnavailable.Store(true)
+		fmt.Printf("读取加密密钥失败: %v\n", err)
+		return
+	}
+
+	// 将配置文件中的明文密钥迁移到加密存储
+	if plaintext {
+		if err := saveConfig(); err != nil {
+			fmt.Printf("迁移明文密钥失败: %v\n", err)
+			return
+		}
+		fmt.Println("已将配置文件中的明文密钥迁移到加密存储")
+	}
 }
 
 // SaveConfig 保存配置到文件
@@ -110,8 +194,20 @@ func SaveConfig() error {
 	return saveConfig()
 }
 
-// 保存配置到文件
+// 保存配置到文件，密钥加密保存到secrets.enc，config.json中不含明文密钥
 func saveConfig() error {
+	mutex.RLock()
+	cfg := *base
+	mutex.RUnlock()
+	return writeConfig(cfg)
+}
+
+// writeConfig 将配置文件这一层写入磁盘，不读取共享的配置，调用方可以持有mutex
+func writeConfig(cfg Config) error {
+	if secretsUnavailable.Load() {
+		return fmt.Errorf("加密密钥读取失败，为避免丢失密钥，未保存配置")
+	}
+
 	configPath := getConfigFilePath()
 
 	// 确保配置目录存在
@@ -120,52 +216,141 @@ func saveConfig() error {
 		return fmt.Errorf("创建配置目录失败: %v", err)
 	}
 
-	// 序列化配置
-	mutex.RLock()
-	data, err := json.MarshalIndent(instance, "", "  ")
-	mutex.RUnlock()
+	// 拆分出密钥，只保存配置文件这一层，不写入环境变量和命令行参数的覆盖值
+	plain, values := splitSecrets(cfg)
+
+	// 先保存密钥，成功后再写入不含密钥的配置文件
+	if err := secretStore().Save(values); err != nil {
+		return fmt.Errorf("保存加密密钥失败: %v", err)
+	}
 
+	// 序列化配置
+	data, err := json.MarshalIndent(&plain, "", "  ")
 	if err != nil {
 		return fmt.Errorf("序列化配置失败: %v", err)
 	}
 
 	// 写入配置文件
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
 		return fmt.Errorf("写入配置文件失败: %v", err)
 	}
+	// 旧版本以0644创建的配置文件，WriteFile不会修改其权限
+	if err := os.Chmod(configPath, 0600); err != nil {
+		return fmt.Errorf("修改配置文件权限失败: %v", err)
+	}
+	// 记录本次写入后的文件状态，监视文件时忽略自己的写入
+	rememberSavedStamp(configPath)
 	fmt.Println("配置已保存")
 	return nil
 }
 
-// UpdateConfig 更新配置
-func UpdateConfig(newConfig *Config) {
+// UpdateConfig 更新配置，newConfig 中为空的配置项保持不变
+// 写入文件成功后才替换并通知订阅者，保存失败时内存与文件保持一致
+func UpdateConfig(newConfig *Config) error {
 	mutex.Lock()
+	next := *base
 	// 更新配置
 	if newConfig.BaiduAPIKey != "" {
-		instance.BaiduAPIKey = newConfig.BaiduAPIKey
+		next.BaiduAPIKey = newConfig.BaiduAPIKey
 	}
 	if newConfig.BaiduSecretKey != "" {
-		instance.BaiduSecretKey = newConfig.BaiduSecretKey
+		next.BaiduSecretKey = newConfig.BaiduSecretKey
 	}
 	if newConfig.DeepSeekAPIKey != "" {
-		instance.DeepSeekAPIKey = newConfig.DeepSeekAPIKey
+		next.DeepSeekAPIKey = newConfig.DeepSeekAPIKey
+	}
+	if newConfig.OCRProvider != "" {
+		next.OCRProvider = newConfig.OCRProvider
+	}
+	if newConfig.TencentSecretID != "" {
+		next.TencentSecretID = newConfig.TencentSecretID
+	}
+	if newConfig.TencentSecretKey != "" {
+		next.TencentSecretKey = newConfig.TencentSecretKey
+	}
+	if newConfig.TencentRegion != "" {
+		next.TencentRegion = newConfig.TencentRegion
+	}
+	if newConfig.AliyunAccessKeyID != "" {
+		next.AliyunAccessKeyID = newConfig.AliyunAccessKeyID
+	}
+	if newConfig.AliyunAccessKeySecret != "" {
+		next.AliyunAccessKeySecret = newConfig.AliyunAccessKeySecret
+	}
+	if newConfig.AliyunRegion != "" {
+		next.AliyunRegion = newConfig.AliyunRegion
+	}
+	if newConfig.VisionAPIKey != "" {
+		next.VisionAPIKey = newConfig.VisionAPIKey
+	}
+	if newConfig.VisionEndpoint != "" {
+		next.VisionEndpoint = newConfig.VisionEndpoint
+	}
+	if newConfig.VisionModel != "" {
+		next.VisionModel = newConfig.VisionModel
+	}
+	if newConfig.PipelineMode != "" {
+		next.PipelineMode = newConfig.PipelineMode
+	}
+	if newConfig.VisionFallbackChars != 0 {
+		next.VisionFallbackChars = newConfig.VisionFallbackChars
+	}
+	if newConfig.DefaultPromptProfile != "" {
+		next.DefaultPromptProfile = newConfig.DefaultPromptProfile
+	}
+	if newConfig.Language != "" {
+		next.Language = newConfig.Language
+	}
+	if newConfig.TextPromptPrice != 0 {
+		next.TextPromptPrice = newConfig.TextPromptPrice
+	}
+	if newConfig.TextCompletionPrice != 0 {
+		next.TextCompletionPrice = newConfig.TextCompletionPrice
+	}
+	if newConfig.VisionPromptPrice != 0 {
+		next.VisionPromptPrice = newConfig.VisionPromptPrice
+	}
+	if newConfig.VisionCompletionPrice != 0 {
+		next.VisionCompletionPrice = newConfig.VisionCompletionPrice
+	}
+	if newConfig.OCRCallPrice != 0 {
+		next.OCRCallPrice = newConfig.OCRCallPrice
+	}
+	if newConfig.DailyCostLimit != 0 {
+		next.DailyCostLimit = newConfig.DailyCostLimit
+	}
+	if newConfig.MonthlyCostLimit != 0 {
+		next.MonthlyCostLimit = newConfig.MonthlyCostLimit
+	}
+	if newConfig.DailyRequestLimit != 0 {
+		next.DailyRequestLimit = newConfig.DailyRequestLimit
+	}
+	if newConfig.MonthlyRequestLimit != 0 {
+		next.MonthlyRequestLimit = newConfig.MonthlyRequestLimit
+	}
+	if newConfig.Hotkey != "" {
+		next.Hotkey = newConfig.Hotkey
 	}
 	if newConfig.DBPath != "" {
-		instance.DBPath = newConfig.DBPath
+		next.DBPath = newConfig.DBPath
 	}
 	if newConfig.Port != 0 {
-		instance.Port = newConfig.Port
+		next.Port = newConfig.Port
 	}
 	if newConfig.StaticPath != "" {
-		instance.StaticPath = newConfig.StaticPath
+		next.StaticPath = newConfig.StaticPath
 	}
-	mutex.Unlock()
 	// 保存配置
-	err := saveConfig()
-	if err != nil {
-		fmt.Println(err)
-		return
+	if err := writeConfig(next); err != nil {
+		mutex.Unlock()
+		log.Printf("保存配置失败: %v", err)
+		return err
 	}
+	base = &next
+	mutex.Unlock()
+
+	applyOverrides()
+	return nil
 }
 
 // EnsureDBPath 确保数据库路径存在
@@ -0,0 +1,277 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/qujing226/screen_sage/internal/paths"
+)
+
+// EnvPrefix 覆盖配置的环境变量前缀，例如 SCREENSAGE_PORT 覆盖 port
+const EnvPrefix = "SCREENSAGE_"
+
+// 配置项来源
+const (
+	SourceDefault = "default" // 默认值
+	SourceFile    = "file"    // 配置文件
+	SourceSecrets = "secrets" // 加密密钥存储
+	SourceEnv     = "env"     // 环境变量
+	SourceFlag    = "flag"    // 命令行参数
+)
+
+// Options 启动时指定的配置来源
+type Options struct {
+	Path     string            // 配置文件路径，为空时使用配置目录中的 config.json
+	DataDir  string            // 数据根目录，为空时使用系统标准目录
+	Portable bool              // 便携模式，全部数据放在可执行文件旁
+	Flags    map[string]string // 命令行参数覆盖的配置项，键为配置项名称
+}
+
+var (
+	// dirs 解析后的目录，首次使用时解析
+	dirs      *paths.Dirs
+	dirsMutex sync.Mutex
+)
+
+// Entry 表示一个配置项的值及其来源
+type Entry struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Init 设置配置文件路径、数据目录和命令行覆盖项，须在首次调用GetConfig之前调用
+func Init(opts Options) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	options = opts
+
+	dirsMutex.Lock()
+	dirs = nil
+	dirsMutex.Unlock()
+}
+
+// Dirs 返回程序使用的配置、数据、缓存和日志目录
+func Dirs() paths.Dirs {
+	return currentDirs()
+}
+
+// currentDirs 按启动选项解析目录，解析失败时使用当前目录
+// 调用方可能持有mutex，这里只使用dirsMutex
+func currentDirs() paths.Dirs {
+	dirsMutex.Lock()
+	defer dirsMutex.Unlock()
+	if dirs == nil {
+		resolved, err := paths.Resolve(paths.Options{DataDir: options.DataDir, Portable: options.Portable})
+		if err != nil {
+			fmt.Printf("解析数据目录失败，使用当前目录: %v\n", err)
+			resolved, _ = paths.Resolve(paths.Options{DataDir: "."})
+		}
+		dirs = &resolved
+	}
+	return *dirs
+}
+
+// BindFlags 在fs中注册 --config、--data-dir、--portable 和每个配置项对应的参数
+// 配置项名称中的下划线替换为连字符，例如 --port、--db-path
+// 解析完成后应将返回的Options传给Init
+func BindFlags(fs *flag.FlagSet) *Options {
+	opts := &Options{Flags: map[string]string{}}
+	fs.StringVar(&opts.Path, "config", "", "配置文件路径")
+	fs.StringVar(&opts.DataDir, "data-dir", "", "数据根目录，配置、数据库、截图和日志都放在其下")
+	fs.BoolVar(&opts.Portable, "portable", false, "便携模式，全部数据放在可执行文件旁")
+	for _, name := range fieldNames() {
+		name := name
+		fs.Func(FlagName(name), fmt.Sprintf("覆盖配置项 %s", name), func(value string) error {
+			// 先试着解析，参数格式错误时立即报错
+			if err := setField(&Config{}, name, value); err != nil {
+				return err
+			}
+			opts.Flags[name] = value
+			return nil
+		})
+	}
+	return opts
+}
+
+// EnvName 返回覆盖配置项的环境变量名
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(name)
+}
+
+// FlagName 返回覆盖配置项的命令行参数名（不含前缀 --）
+func FlagName(name string) string {
+	return strings.ReplaceAll(name, "_", "-")
+}
+
+// ConfigFilePath 返回正在使用的配置文件路径
+func ConfigFilePath() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return getConfigFilePath()
+}
+
+// Describe 返回全部配置项的值及来源，密钥已遮盖
+// effective 为 true 时返回叠加环境变量和命令行参数后的生效配置，否则只返回配置文件这一层
+func Describe(effective bool) []Entry {
+	GetConfig()
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	cfg := base
+	if effective {
+		cfg = instance
+	}
+	entries := make([]Entry, 0, len(sources))
+	for _, name := range fieldNames() {
+		value := formatField(cfg, name)
+		if IsSecret(name) {
+			value = MaskSecret(value)
+		}
+		source := baseSource(name)
+		if effective {
+			source = sources[name]
+		}
+		entries = append(entries, Entry{Name: name, Value: value, Source: source})
+	}
+	return entries
+}
+
+// applyOverrides 在base上叠加环境变量和命令行参数，发布新的配置快照并通知订阅者
+// 从发布快照到通知结束都持有notifyMutex，并发修改时订阅者按发布顺序收到变更
+func applyOverrides() {
+	notifyMutex.Lock()
+	defer notifyMutex.Unlock()
+
+	mutex.Lock()
+
+	effective := *base
+	result := make(map[string]string)
+	for _, name := range fieldNames() {
+		result[name] = baseSource(name)
+
+		if value, ok := os.LookupEnv(EnvName(name)); ok {
+			if err := setField(&effective, name, value); err != nil {
+				fmt.Printf("环境变量 %s 无效，已忽略: %v\n", EnvName(name), err)
+			} else {
+				result[name] = SourceEnv + ":" + EnvName(name)
+			}
+		}
+		if value, ok := options.Flags[name]; ok {
+			if err := setField(&effective, name, value); err != nil {
+				fmt.Printf("命令行参数 --%s 无效，已忽略: %v\n", FlagName(name), err)
+			} else {
+				result[name] = SourceFlag + ":--" + FlagName(name)
+			}
+		}
+	}
+
+	old, current := instance, &effective
+	instance = current
+	sources = result
+	mutex.Unlock()
+
+	// 首次加载时没有旧快照，不需要通知
+	if old != nil {
+		notify(old, current)
+	}
+}
+
+// baseSource 返回配置项在base中的来源，调用方需持有锁
+// 保存配置时会写入全部配置项，因此与默认值相同的视为默认值
+func baseSource(name string) string {
+	switch {
+	case IsSecret(name) && formatField(base, name) != "":
+		return SourceSecrets
+	case formatField(base, name) != formatField(defaultConfig(), name):
+		return SourceFile
+	default:
+		return SourceDefault
+	}
+}
+
+// fieldNames 按结构体定义顺序返回全部配置项名称
+func fieldNames() []string {
+	t := reflect.TypeOf(Config{})
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// jsonName 返回字段的json名称
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// field 返回配置项对应的字段
+func field(cfg *Config, name string) (reflect.Value, bool) {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// setField 按字段类型解析字符串并赋值
+func setField(cfg *Config, name, value string) error {
+	f, ok := field(cfg, name)
+	if !ok {
+		return fmt.Errorf("未知的配置项: %s", name)
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s 应为整数", name)
+		}
+		f.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("%s 应为数字", name)
+		}
+		f.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s 应为 true 或 false", name)
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("配置项 %s 不支持覆盖", name)
+	}
+	return nil
+}
+
+// formatField 将配置项的值格式化为字符串
+func formatField(cfg *Config, name string) string {
+	f, ok := field(cfg, name)
+	if !ok {
+		return ""
+	}
+	switch f.Kind() {
+	case reflect.Float64:
+		return strconv.FormatFloat(f.Float(), 'f', -1, 64)
+	default:
+		return fmt.Sprint(f.Interface())
+	}
+}
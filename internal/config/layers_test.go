@@ -0,0 +1,73 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+// resetForTest 重置全局状态并模拟配置文件中设置了 ocr_provider，不读取磁盘
+func resetForTest(t *testing.T, opts Options) {
+	t.Helper()
+	once.Do(func() {})
+	base = defaultConfig()
+	instance = nil
+	base.OCRProvider = "tencent"
+	options = opts
+	applyOverrides()
+}
+
+func TestApplyOverrides_Precedence(t *testing.T) {
+	t.Setenv("SCREENSAGE_PORT", "9000")
+	t.Setenv("SCREENSAGE_LANGUAGE", "English")
+	t.Setenv("SCREENSAGE_DEEPSEEK_API_KEY", "sk-from-env-0123456789")
+	resetForTest(t, Options{Flags: map[string]string{"port": "9100"}})
+
+	if instance.Port != 9100 || sources["port"] != "flag:--port" {
+		t.Errorf("命令行参数应覆盖环境变量: port=%d source=%s", instance.Port, sources["port"])
+	}
+	if instance.Language != "English" || sources["language"] != "env:SCREENSAGE_LANGUAGE" {
+		t.Errorf("环境变量应覆盖默认值: language=%s source=%s", instance.Language, sources["language"])
+	}
+	if sources["ocr_provider"] != SourceFile || sources["vision_model"] != SourceDefault {
+		t.Errorf("来源不符合预期: %v", sources)
+	}
+	// 覆盖值不应写回保存的那一层
+	if base.Port != 8081 || base.DeepSeekAPIKey != "" {
+		t.Errorf("覆盖值不应修改base: port=%d", base.Port)
+	}
+
+	entries := Describe(true)
+	for _, entry := range entries {
+		if entry.Name == "deepseek_api_key" && entry.Value != "****6789" {
+			t.Errorf("密钥应被遮盖: %q", entry.Value)
+		}
+	}
+}
+
+func TestApplyOverrides_InvalidEnvIgnored(t *testing.T) {
+	t.Setenv("SCREENSAGE_PORT", "not-a-number")
+	resetForTest(t, Options{})
+
+	if instance.Port != 8081 || sources["port"] != SourceDefault {
+		t.Errorf("无效的环境变量应被忽略: port=%d source=%s", instance.Port, sources["port"])
+	}
+}
+
+func TestBindFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts := BindFlags(fs)
+	if err := fs.Parse([]string{"--config", "/tmp/a.json", "--db-path", "/tmp/a.db", "--daily-cost-limit", "1.5"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if opts.Path != "/tmp/a.json" || opts.Flags["db_path"] != "/tmp/a.db" || opts.Flags["daily_cost_limit"] != "1.5" {
+		t.Errorf("BindFlags() = %+v", opts)
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	BindFlags(fs)
+	if err := fs.Parse([]string{"--port", "abc"}); err == nil {
+		t.Error("格式错误的参数应当报错")
+	}
+}
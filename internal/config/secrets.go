@@ -0,0 +1,182 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/qujing226/screen_sage/internal/secrets"
+)
+
+// secretField 描述一个需要加密保存的配置项
+type secretField struct {
+	name  string
+	field func(*Config) *string
+}
+
+// secretFields 需要加密保存的配置项，这些字段不会以明文写入config.json
+var secretFields = []secretField{
+	{"baidu_api_key", func(c *Config) *string { return &c.BaiduAPIKey }},
+	{"baidu_secret_key", func(c *Config) *string { return &c.BaiduSecretKey }},
+	{"deepseek_api_key", func(c *Config) *string { return &c.DeepSeekAPIKey }},
+	{"tencent_secret_key", func(c *Config) *string { return &c.TencentSecretKey }},
+	{"aliyun_access_key_secret", func(c *Config) *string { return &c.AliyunAccessKeySecret }},
+	{"vision_api_key", func(c *Config) *string { return &c.VisionAPIKey }},
+	{"api_token", func(c *Config) *string { return &c.APIToken }},
+}
+
+// secretsUnavailable 加密密钥读取失败时为true，此时不再覆盖加密文件，避免丢失密钥
+// 加载和重新加载时写入，HTTP请求和监视配置文件的goroutine中读取
+var secretsUnavailable atomic.Bool
+
+// SecretNames 返回全部需要加密保存的配置项名称
+func SecretNames() []string {
+	names := make([]string, 0, len(secretFields))
+	for _, f := range secretFields {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// IsSecret 判断配置项是否为密钥
+func IsSecret(name string) bool {
+	for _, f := range secretFields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSecret 判断指定的密钥是否已设置
+func (c *Config) HasSecret(name string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, f := range secretFields {
+		if f.name == name {
+			return *f.field(c) != ""
+		}
+	}
+	return false
+}
+
+// MaskSecret 遮盖密钥，只保留末尾几位便于辨认
+func MaskSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	if len(value) < 12 {
+		return "****"
+	}
+	return "****" + value[len(value)-4:]
+}
+
+// Masked 返回遮盖了全部密钥的配置副本，用于日志和接口响应
+func (c *Config) Masked() *Config {
+	masked := *c
+	for _, f := range secretFields {
+		ptr := f.field(&masked)
+		*ptr = MaskSecret(*ptr)
+	}
+	return &masked
+}
+
+// String 以遮盖密钥后的形式输出配置，避免密钥被打印到日志中
+func (c *Config) String() string {
+	data, err := json.Marshal(c.Masked())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// secretStore 返回与配置文件同目录的加密密钥存储
+func secretStore() *secrets.Store {
+	return secrets.NewStore(filepath.Dir(getConfigFilePath()), os.Getenv(secrets.PassphraseEnv))
+}
+
+// mergeSecrets 从加密存储读取密钥并填入cfg，返回配置文件中是否残留明文密钥
+// 配置文件中的明文密钥优先，随后由saveConfig迁移到加密存储；cfg不能是已发布的快照
+func mergeSecrets(cfg *Config) (bool, error) {
+	values, err := secretStore().Load()
+	if err != nil {
+		return false, err
+	}
+
+	plaintext := false
+	for _, f := range secretFields {
+		ptr := f.field(cfg)
+		if *ptr != "" {
+			plaintext = true
+			continue
+		}
+		*ptr = values[f.name]
+	}
+	return plaintext, nil
+}
+
+// splitSecrets 拆分配置，返回去除密钥后的配置副本和密钥集合
+func splitSecrets(cfg Config) (Config, map[string]string) {
+	values := make(map[string]string, len(secretFields))
+	for _, f := range secretFields {
+		ptr := f.field(&cfg)
+		if *ptr != "" {
+			values[f.name] = *ptr
+		}
+		*ptr = ""
+	}
+	return cfg, values
+}
+
+// SetSecret 设置一个密钥并加密保存，保存成功后才替换并通知订阅者
+func SetSecret(name, value string) error {
+	GetConfig()
+	for _, f := range secretFields {
+		if f.name != name {
+			continue
+		}
+		mutex.Lock()
+		next := *base
+		*f.field(&next) = value
+		if err := writeConfig(next); err != nil {
+			mutex.Unlock()
+			return err
+		}
+		base = &next
+		mutex.Unlock()
+
+		applyOverrides()
+		return nil
+	}
+	return fmt.Errorf("未知的密钥配置项: %s", name)
+}
+
+// EnsureAPIToken 返回访问Web API的令牌，尚未设置时生成一个随机令牌并加密保存
+func EnsureAPIToken() (string, error) {
+	if token := GetConfig().APIToken; token != "" {
+		return token, nil
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成API令牌失败: %v", err)
+	}
+	token := hex.EncodeToString(buf)
+	if err := SetSecret("api_token", token); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateSecrets 更换加密密钥并重新加密全部密钥
+// newPassphrase 为空时改用新生成的本机密钥文件
+func RotateSecrets(newPassphrase string) error {
+	GetConfig()
+	if secretsUnavailable.Load() {
+		return fmt.Errorf("加密密钥读取失败，无法轮换")
+	}
+	return secretStore().Rotate(newPassphrase)
+}
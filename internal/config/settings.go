@@ -0,0 +1,234 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Setting 描述设置页面中的一个配置项
+type Setting struct {
+	Name      string      `json:"name"`
+	Type      string      `json:"type"`      // string、int、float、bool
+	Value     interface{} `json:"value"`     // 保存在配置文件中的值，密钥已遮盖
+	Effective interface{} `json:"effective"` // 叠加环境变量和命令行参数后的生效值，密钥已遮盖
+	Source    string      `json:"source"`    // 生效值的来源
+	Secret    bool        `json:"secret"`    // 是否为密钥
+	Set       bool        `json:"set"`       // 密钥是否已设置
+	Options   []string    `json:"options,omitempty"`
+}
+
+// FieldErrors 按配置项名称记录的校验错误
+type FieldErrors map[string]string
+
+// Error 按配置项名称排序输出全部错误
+func (e FieldErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, e[name]))
+	}
+	return "配置校验失败: " + strings.Join(parts, "; ")
+}
+
+// 可选值有限的配置项
+var fieldOptions = map[string][]string{
+	"ocr_provider":  {"baidu", "tencent", "aliyun"},
+	"pipeline_mode": {"ocr", "vision", "auto"},
+}
+
+var (
+	// validators 由其他包注册的额外校验，例如快捷键格式
+	validators     = map[string]func(string) error{}
+	validatorMutex sync.RWMutex
+)
+
+// RegisterValidator 为配置项注册额外的校验函数，Update时调用
+func RegisterValidator(name string, fn func(string) error) {
+	validatorMutex.Lock()
+	defer validatorMutex.Unlock()
+	validators[name] = fn
+}
+
+// Settings 返回全部配置项的当前值、类型和来源，供设置页面使用
+func Settings() []Setting {
+	GetConfig()
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	settings := make([]Setting, 0, len(sources))
+	for _, name := range fieldNames() {
+		f, _ := field(base, name)
+		setting := Setting{
+			Name:      name,
+			Type:      kindName(f.Kind()),
+			Value:     f.Interface(),
+			Source:    sources[name],
+			Secret:    IsSecret(name),
+			Options:   fieldOptions[name],
+			Effective: fieldValue(instance, name),
+		}
+		if setting.Secret {
+			setting.Set = formatField(base, name) != ""
+			setting.Value = MaskSecret(formatField(base, name))
+			setting.Effective = MaskSecret(formatField(instance, name))
+		}
+		settings = append(settings, setting)
+	}
+	return settings
+}
+
+// Validate 校验要修改的配置项，全部通过时返回nil
+func Validate(values map[string]string) FieldErrors {
+	GetConfig()
+
+	mutex.RLock()
+	next := *base
+	mutex.RUnlock()
+
+	if errs := apply(&next, values); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Update 校验并保存配置文件这一层的配置项，未提供的配置项保持不变
+// 密钥提交遮盖后的原值时视为未修改；任一配置项校验失败时不做任何修改并返回FieldErrors
+func Update(values map[string]string) error {
+	GetConfig()
+
+	// 从读取到替换全程持有写锁，并发修改不会丢失；写入文件成功后才替换，失败时内存与文件保持一致
+	mutex.Lock()
+	next := *base
+	if errs := apply(&next, values); len(errs) > 0 {
+		mutex.Unlock()
+		return errs
+	}
+	if err := writeConfig(next); err != nil {
+		mutex.Unlock()
+		return err
+	}
+	base = &next
+	mutex.Unlock()
+
+	applyOverrides()
+	return nil
+}
+
+// apply 将values逐项写入cfg并校验，返回校验错误
+func apply(cfg *Config, values map[string]string) FieldErrors {
+	errs := FieldErrors{}
+	for name, value := range values {
+		if _, ok := field(cfg, name); !ok {
+			errs[name] = "未知的配置项"
+			continue
+		}
+		if IsSecret(name) && value != "" && value == MaskSecret(formatField(cfg, name)) {
+			continue
+		}
+		if err := setField(cfg, name, value); err != nil {
+			errs[name] = err.Error()
+			continue
+		}
+		if err := validateField(cfg, name); err != nil {
+			errs[name] = err.Error()
+		}
+	}
+	return errs
+}
+
+// validateField 校验配置项的取值
+func validateField(cfg *Config, name string) error {
+	value := formatField(cfg, name)
+
+	if options, ok := fieldOptions[name]; ok {
+		valid := false
+		for _, option := range options {
+			valid = valid || strings.EqualFold(value, option)
+		}
+		if !valid {
+			return fmt.Errorf("应为 %s 之一", strings.Join(options, "、"))
+		}
+	}
+
+	switch name {
+	case "port":
+		if cfg.Port < 1 || cfg.Port > 65535 {
+			return fmt.Errorf("端口应在 1 到 65535 之间")
+		}
+	case "bind_address":
+		if value != "localhost" && net.ParseIP(value) == nil {
+			return fmt.Errorf("应为IP地址或 localhost")
+		}
+	case "vision_endpoint":
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("应为 http 或 https 地址")
+		}
+	case "tls_cert_file", "tls_key_file":
+		if value != "" {
+			if _, err := os.Stat(value); err != nil {
+				return fmt.Errorf("文件不存在或无法读取")
+			}
+		}
+	case "static_path":
+		// 为空时使用程序内嵌的前端
+		if value != "" {
+			if info, err := os.Stat(value); err != nil || !info.IsDir() {
+				return fmt.Errorf("目录不存在")
+			}
+		}
+	case "db_path", "hotkey", "default_prompt_profile", "vision_model", "api_token":
+		// 令牌为空时所有请求都无法通过认证
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("不能为空")
+		}
+	}
+
+	// 数值类配置项均不能为负数
+	if f, _ := field(cfg, name); (f.Kind() == reflect.Int && f.Int() < 0) || (f.Kind() == reflect.Float64 && f.Float() < 0) {
+		return fmt.Errorf("不能为负数")
+	}
+
+	validatorMutex.RLock()
+	fn := validators[name]
+	validatorMutex.RUnlock()
+	if fn != nil {
+		return fn(value)
+	}
+	return nil
+}
+
+// fieldValue 返回配置项的值
+func fieldValue(cfg *Config, name string) interface{} {
+	f, ok := field(cfg, name)
+	if !ok {
+		return nil
+	}
+	return f.Interface()
+}
+
+// kindName 返回字段类型在设置页面中的名称
+func kindName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int:
+		return "int"
+	case reflect.Float64:
+		return "float"
+	case reflect.Bool:
+		return "bool"
+	default:
+		return "string"
+	}
+}
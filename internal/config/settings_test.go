@@ -0,0 +1,133 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestUpdate_ValidatesEveryField(t *testing.T) {
+	resetForTest(t, Options{Path: filepath.Join(t.TempDir(), "config.json")})
+
+	err := Update(map[string]string{
+		"port":             "70000",
+		"ocr_provider":     "google",
+		"daily_cost_limit": "-1",
+		"vision_endpoint":  "ftp://example.com",
+		"language":         "English",
+		"no_such_field":    "x",
+	})
+	var fieldErrs FieldErrors
+	if !errors.As(err, &fieldErrs) {
+		t.Fatalf("Update() error = %v, 应为FieldErrors", err)
+	}
+	for _, name := range []string{"port", "ocr_provider", "daily_cost_limit", "vision_endpoint", "no_such_field"} {
+		if fieldErrs[name] == "" {
+			t.Errorf("%s 应当报错: %v", name, fieldErrs)
+		}
+	}
+	if _, ok := fieldErrs["language"]; ok {
+		t.Errorf("language 不应报错: %v", fieldErrs)
+	}
+	// 校验失败时不做任何修改
+	if GetConfig().Language != "中文" {
+		t.Errorf("校验失败时不应修改配置: language=%s", GetConfig().Language)
+	}
+}
+
+func TestUpdate_KeepsMaskedSecret(t *testing.T) {
+	resetForTest(t, Options{Path: filepath.Join(t.TempDir(), "config.json")})
+	if err := Update(map[string]string{"deepseek_api_key": "sk-0123456789abcdef"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	// 设置页面提交遮盖后的原值时密钥保持不变
+	if err := Update(map[string]string{"deepseek_api_key": "****cdef", "port": "9300"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	cfg := GetConfig()
+	if cfg.DeepSeekAPIKey != "sk-0123456789abcdef" || cfg.Port != 9300 {
+		t.Errorf("Update() 结果不符合预期: key=%s port=%d", MaskSecret(cfg.DeepSeekAPIKey), cfg.Port)
+	}
+
+	for _, s := range Settings() {
+		if s.Name == "deepseek_api_key" && (s.Value != "****cdef" || !s.Set || !s.Secret) {
+			t.Errorf("密钥应被遮盖: %+v", s)
+		}
+		if s.Name == "port" && (s.Value != 9300 || s.Type != "int") {
+			t.Errorf("port 不符合预期: %+v", s)
+		}
+	}
+}
+
+func TestUpdate_RejectsEmptyToken(t *testing.T) {
+	resetForTest(t, Options{Path: filepath.Join(t.TempDir(), "config.json")})
+	token, err := EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fieldErrs FieldErrors
+	if err := Update(map[string]string{"api_token": ""}); !errors.As(err, &fieldErrs) || fieldErrs["api_token"] == "" {
+		t.Fatalf("Update() error = %v, 空令牌应当报错", err)
+	}
+	if GetConfig().APIToken != token {
+		t.Error("校验失败时令牌不应改变")
+	}
+}
+
+func TestUpdate_Concurrent(t *testing.T) {
+	resetForTest(t, Options{Path: filepath.Join(t.TempDir(), "config.json")})
+
+	// 并发修改不同的配置项，每项修改都应保留
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "vision_model"
+			if i%2 == 1 {
+				name = "language"
+			}
+			if err := Update(map[string]string{name: name + strconv.Itoa(i), "port": strconv.Itoa(9000 + i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	cfg := GetConfig()
+	if cfg.VisionModel == "" || cfg.Language == "中文" {
+		t.Errorf("并发修改丢失: vision_model=%s language=%s", cfg.VisionModel, cfg.Language)
+	}
+}
+
+func TestUpdate_SaveFailureKeepsConfig(t *testing.T) {
+	// 配置目录无法创建，保存一定失败
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	resetForTest(t, Options{Path: filepath.Join(file, "config.json")})
+	notified := 0
+	unsubscribe := Subscribe(func(Change) { notified++ })
+	defer unsubscribe()
+
+	if err := Update(map[string]string{"port": "9300"}); err == nil {
+		t.Fatal("保存失败时应返回错误")
+	}
+	if err := UpdateConfig(&Config{Port: 9300}); err == nil {
+		t.Fatal("UpdateConfig 保存失败时应返回错误")
+	}
+	if err := SetSecret("deepseek_api_key", "sk-unsaved-0123456789"); err == nil {
+		t.Fatal("SetSecret 保存失败时应返回错误")
+	}
+	if cfg := GetConfig(); cfg.Port != 8081 || cfg.DeepSeekAPIKey != "" {
+		t.Errorf("保存失败时不应修改配置: port=%d key=%q", cfg.Port, cfg.DeepSeekAPIKey)
+	}
+	if notified != 0 {
+		t.Errorf("保存失败时不应通知订阅者: %d 次", notified)
+	}
+}
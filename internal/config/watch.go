@@ -0,0 +1,178 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Change 描述一次配置变更
+type Change struct {
+	Old    *Config  // 变更前的配置快照
+	New    *Config  // 变更后的配置快照
+	Fields []string // 发生变化的配置项名称
+}
+
+// Has 判断指定的配置项中是否有任一项发生了变化
+func (c Change) Has(names ...string) bool {
+	for _, changed := range c.Fields {
+		for _, name := range names {
+			if changed == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fileStamp 配置文件的修改时间和大小，用于判断文件是否被修改
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+var (
+	subscribers = map[int]func(Change){}
+	nextSubID   int
+	subMutex    sync.Mutex
+
+	// notifyMutex 保证配置快照的发布和通知按相同顺序进行
+	notifyMutex sync.Mutex
+
+	// savedStamp 最近一次由本程序写入后的配置文件状态
+	savedStamp fileStamp
+	stampMutex sync.Mutex
+)
+
+// Subscribe 注册配置变更回调，返回取消订阅的函数
+// 回调在发起变更的goroutine中依次同步调用，不应长时间阻塞，也不能在回调中修改配置
+func Subscribe(fn func(Change)) func() {
+	subMutex.Lock()
+	defer subMutex.Unlock()
+
+	id := nextSubID
+	nextSubID++
+	subscribers[id] = fn
+	return func() {
+		subMutex.Lock()
+		defer subMutex.Unlock()
+		delete(subscribers, id)
+	}
+}
+
+// notify 比较新旧快照，有变化时通知全部订阅者
+func notify(old, current *Config) {
+	var fields []string
+	for _, name := range fieldNames() {
+		if formatField(old, name) != formatField(current, name) {
+			fields = append(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	subMutex.Lock()
+	callbacks := make([]func(Change), 0, len(subscribers))
+	for _, fn := range subscribers {
+		callbacks = append(callbacks, fn)
+	}
+	subMutex.Unlock()
+
+	change := Change{Old: old, New: current, Fields: fields}
+	for _, fn := range callbacks {
+		fn(change)
+	}
+}
+
+// Reload 重新读取配置文件，配置有变化时通知订阅者
+// 读取或解析失败时保留当前配置
+func Reload() error {
+	GetConfig()
+
+	data, err := os.ReadFile(getConfigFilePath())
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	next := defaultConfig()
+	if err := json.Unmarshal(data, next); err != nil {
+		return fmt.Errorf("解析配置文件失败: %v", err)
+	}
+	plaintext, err := mergeSecrets(next)
+	if err != nil {
+		return fmt.Errorf("读取加密密钥失败: %v", err)
+	}
+	// 密钥已能正常读取，例如启动后修复了密钥文件，之后可以正常保存
+	secretsUnavailable.Store(false)
+
+	mutex.Lock()
+	base = next
+	mutex.Unlock()
+
+	// 手动写入配置文件的明文密钥同样迁移到加密存储
+	if plaintext {
+		if err := saveConfig(); err != nil {
+			return fmt.Errorf("迁移明文密钥失败: %v", err)
+		}
+	}
+	applyOverrides()
+	return nil
+}
+
+// Watch 定期检查配置文件，被外部修改时重新加载，直到stop被关闭
+func Watch(interval time.Duration, stop <-chan struct{}) {
+	path := getConfigFilePath()
+	last, _ := statFile(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		current, err := statFile(path)
+		if err != nil || current == last {
+			continue
+		}
+		last = current
+
+		// 忽略本程序自己写入造成的变化
+		stampMutex.Lock()
+		own := current == savedStamp
+		stampMutex.Unlock()
+		if own {
+			continue
+		}
+
+		if err := Reload(); err != nil {
+			fmt.Printf("重新加载配置失败: %v\n", err)
+			continue
+		}
+		fmt.Println("配置文件已修改，已重新加载")
+	}
+}
+
+// rememberSavedStamp 记录本程序写入后的配置文件状态
+func rememberSavedStamp(path string) {
+	stamp, err := statFile(path)
+	if err != nil {
+		return
+	}
+	stampMutex.Lock()
+	savedStamp = stamp
+	stampMutex.Unlock()
+}
+
+// statFile 获取文件的修改时间和大小
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}, nil
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReload_NotifiesSubscribers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	resetForTest(t, Options{Path: path})
+	before := GetConfig()
+
+	changes := make(chan Change, 1)
+	unsubscribe := Subscribe(func(c Change) { changes <- c })
+	defer unsubscribe()
+
+	if err := os.WriteFile(path, []byte(`{"port": 9200, "hotkey": "Alt+F1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	select {
+	case c := <-changes:
+		if !c.Has("port") || !c.Has("hotkey") || c.Has("db_path") {
+			t.Errorf("变更项不符合预期: %v", c.Fields)
+		}
+		if c.New.Port != 9200 || c.Old.Port != 8081 {
+			t.Errorf("新旧快照不符合预期: old=%d new=%d", c.Old.Port, c.New.Port)
+		}
+	default:
+		t.Fatal("配置变化后应通知订阅者")
+	}
+
+	// 旧快照保持不变
+	if before.Port != 8081 || GetConfig().Port != 9200 {
+		t.Errorf("快照不应被修改: before=%d current=%d", before.Port, GetConfig().Port)
+	}
+
+	// 内容未变化时不通知
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-changes:
+		t.Errorf("配置未变化时不应通知: %v", c.Fields)
+	default:
+	}
+}
+
+func TestWatch_ReloadsExternalEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	resetForTest(t, Options{Path: path})
+	if err := os.WriteFile(path, []byte(`{"port": 9300}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan Change, 4)
+	unsubscribe := Subscribe(func(c Change) { changes <- c })
+	defer unsubscribe()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go Watch(10*time.Millisecond, stop)
+
+	// 等待监视开始后再修改文件
+	time.Sleep(30 * time.Millisecond)
+	if err := os.WriteFile(path, []byte(`{"port": 9400, "language": "English"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case c := <-changes:
+		if c.New.Port != 9400 || c.New.Language != "English" {
+			t.Errorf("重新加载的配置不符合预期: %+v", c.Fields)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("修改配置文件后应重新加载")
+	}
+}
+
+func TestUpdate_NotifiesInOrder(t *testing.T) {
+	resetForTest(t, Options{Path: filepath.Join(t.TempDir(), "config.json")})
+	last := GetConfig()
+
+	// 每次变更的旧快照都应是上一次通知的新快照
+	var outOfOrder int
+	unsubscribe := Subscribe(func(c Change) {
+		if c.Old != last {
+			outOfOrder++
+		}
+		last = c.New
+	})
+	defer unsubscribe()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(port int) {
+			defer wg.Done()
+			if err := Update(map[string]string{"port": strconv.Itoa(port)}); err != nil {
+				t.Error(err)
+			}
+		}(9000 + i)
+	}
+	wg.Wait()
+
+	if outOfOrder > 0 || last != GetConfig() {
+		t.Errorf("通知顺序与发布顺序不一致: %d 次", outOfOrder)
+	}
+}
+
+func TestWatch_ConcurrentSetSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	resetForTest(t, Options{Path: path})
+	if err := SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Watch(time.Millisecond, stop)
+	}()
+
+	// 外部修改触发重新加载的同时保存密钥和配置，配合 -race 检查共享状态的访问
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			os.WriteFile(path, []byte(`{"port": `+strconv.Itoa(9500+i)+`}`), 0600)
+			time.Sleep(2 * time.Millisecond)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := SetSecret("deepseek_api_key", "sk-concurrent-"+strconv.Itoa(i)); err != nil {
+				t.Errorf("SetSecret() error = %v", err)
+			}
+			if err := SaveConfig(); err != nil {
+				t.Errorf("SaveConfig() error = %v", err)
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-done
+}
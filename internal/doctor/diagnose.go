@@ -0,0 +1,62 @@
+package doctor
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// statusCodePattern 匹配各服务商错误信息中的HTTP状态码
+var statusCodePattern = regexp.MustCompile(`状态码: (\d+)`)
+
+// errorHints 错误信息中的关键字及对应的诊断，按顺序匹配
+var errorHints = []struct {
+	keywords  []string
+	diagnosis string
+}{
+	{[]string{"未提供", "未配置"}, "密钥未配置，请在设置页面填写"},
+	{[]string{"invalid_client", "unknown client id"}, "百度 API Key 错误，请核对百度智能云应用的 API Key"},
+	{[]string{"client authentication failed"}, "百度 Secret Key 错误，请核对百度智能云应用的 Secret Key"},
+	{[]string{"错误码: 17)"}, "百度OCR今日调用量已用完，请明天再试或在控制台购买额度"},
+	{[]string{"错误码: 18)"}, "请求过于频繁，超出了百度OCR的QPS限制"},
+	{[]string{"错误码: 6)"}, "没有接口调用权限，请在百度智能云控制台开通通用文字识别服务"},
+	{[]string{"错误码: 110)", "错误码: 111)"}, "访问令牌无效或已过期，请检查密钥后重试"},
+	{[]string{"authfailure"}, "腾讯云 SecretId 或 SecretKey 错误，或密钥已被禁用"},
+	{[]string{"invalidaccesskeyid", "signaturedoesnotmatch"}, "阿里云 AccessKey ID 或 AccessKey Secret 错误"},
+	{[]string{"insufficient balance", "insufficient_quota"}, "账户余额不足，请充值后重试"},
+	{[]string{"timeout", "deadline exceeded"}, "请求超时，请检查网络连接或代理设置"},
+	{[]string{"no such host", "connection refused", "network is unreachable"}, "无法连接到服务，请检查网络、代理或接口地址"},
+	{[]string{"x509", "certificate"}, "TLS证书校验失败，可能被代理或防火墙拦截"},
+}
+
+// Diagnose 根据错误信息给出面向用户的诊断说明
+func Diagnose(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := strings.ToLower(err.Error())
+	for _, hint := range errorHints {
+		for _, keyword := range hint.keywords {
+			if strings.Contains(msg, strings.ToLower(keyword)) {
+				return hint.diagnosis
+			}
+		}
+	}
+
+	if match := statusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		code, _ := strconv.Atoi(match[1])
+		switch {
+		case code == 401 || code == 403:
+			return "密钥无效、已过期或没有权限，请检查密钥"
+		case code == 402:
+			return "账户余额不足，请充值后重试"
+		case code == 404:
+			return "接口地址或模型名称错误"
+		case code == 429:
+			return "请求过于频繁或超出额度，请稍后重试"
+		case code >= 500:
+			return "服务商暂时不可用，请稍后重试"
+		}
+	}
+	return "未能识别的错误，请查看错误详情"
+}
@@ -0,0 +1,236 @@
+package doctor
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qujing226/screen_sage/infrastructure/service/ai"
+	ocrprovider "github.com/qujing226/screen_sage/infrastructure/service/ocr"
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/ocr"
+)
+
+// 检查结果状态
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Result 一项检查的结果
+type Result struct {
+	Provider  string `json:"provider"`         // 服务商，例如 baidu、deepseek、vision
+	Check     string `json:"check"`            // 检查项: token、ocr、chat
+	Status    string `json:"status"`           // ok、failed、skipped
+	LatencyMS int64  `json:"latency_ms"`       // 耗时，毫秒
+	Detail    string `json:"detail,omitempty"` // 识别出的文字或模型的回复
+	Error     string `json:"error,omitempty"`
+	Diagnosis string `json:"diagnosis,omitempty"` // 面向用户的诊断说明
+}
+
+// Report 全部检查的结果
+type Report struct {
+	OK      bool     `json:"ok"` // 没有失败的检查项
+	Results []Result `json:"results"`
+}
+
+// Endpoints 各服务的接口地址，为空时使用默认地址，测试时可指向本地的替身服务
+type Endpoints struct {
+	BaiduToken string
+	BaiduOCR   string
+	Tencent    string
+	Aliyun     string
+	DeepSeek   string
+}
+
+// Checker 检查配置中的各服务商能否正常调用
+type Checker struct {
+	Config    *config.Config
+	Endpoints Endpoints
+	Timeout   time.Duration // 单次请求的超时时间
+}
+
+// NewChecker 创建检查器
+func NewChecker(cfg *config.Config) *Checker {
+	return &Checker{Config: cfg, Timeout: 15 * time.Second}
+}
+
+// Run 并发检查OCR、DeepSeek和视觉模型，按固定顺序返回结果
+func (c *Checker) Run() Report {
+	groups := []func() []Result{c.checkOCR, c.checkDeepSeek, c.checkVision}
+	results := make([][]Result, len(groups))
+
+	var wg sync.WaitGroup
+	for i, check := range groups {
+		wg.Add(1)
+		go func(i int, check func() []Result) {
+			defer wg.Done()
+			results[i] = check()
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{OK: true}
+	for _, group := range results {
+		for _, result := range group {
+			if result.Status == StatusFailed {
+				report.OK = false
+			}
+			report.Results = append(report.Results, result)
+		}
+	}
+	return report
+}
+
+// checkOCR 检查当前选择的OCR服务商，百度会先单独检查访问令牌
+func (c *Checker) checkOCR() []Result {
+	cfg := c.Config
+	name := strings.ToLower(cfg.OCRProvider)
+	if name == "" {
+		name = "baidu"
+	}
+
+	var provider ocrprovider.Provider
+	switch name {
+	case "baidu":
+		if cfg.BaiduAPIKey == "" || cfg.BaiduSecretKey == "" {
+			return []Result{missing(name, "ocr", "baidu_api_key 和 baidu_secret_key")}
+		}
+		baidu := ocrprovider.NewBaiduOCRProvider(cfg.BaiduAPIKey, cfg.BaiduSecretKey)
+		baidu.HTTPClient = c.httpClient()
+		if c.Endpoints.BaiduToken != "" {
+			baidu.TokenURL = c.Endpoints.BaiduToken
+		}
+		if c.Endpoints.BaiduOCR != "" {
+			baidu.APIEndpoint = c.Endpoints.BaiduOCR
+		}
+
+		token := run(name, "token", func() (string, error) {
+			_, err := baidu.FetchToken()
+			return "", err
+		})
+		if token.Status != StatusOK {
+			return []Result{token, {Provider: name, Check: "ocr", Status: StatusSkipped, Diagnosis: "访问令牌获取失败，未执行识别"}}
+		}
+		return []Result{token, run(name, "ocr", func() (string, error) {
+			return baidu.RecognizeText(sampleImage())
+		})}
+
+	case "tencent":
+		if cfg.TencentSecretID == "" || cfg.TencentSecretKey == "" {
+			return []Result{missing(name, "ocr", "tencent_secret_id 和 tencent_secret_key")}
+		}
+		tencent := ocrprovider.NewTencentOCRProvider(cfg.TencentSecretID, cfg.TencentSecretKey, cfg.TencentRegion)
+		tencent.HTTPClient = c.httpClient()
+		if c.Endpoints.Tencent != "" {
+			tencent.APIEndpoint = c.Endpoints.Tencent
+		}
+		provider = tencent
+
+	case "aliyun":
+		if cfg.AliyunAccessKeyID == "" || cfg.AliyunAccessKeySecret == "" {
+			return []Result{missing(name, "ocr", "aliyun_access_key_id 和 aliyun_access_key_secret")}
+		}
+		aliyun := ocrprovider.NewAliyunOCRProvider(cfg.AliyunAccessKeyID, cfg.AliyunAccessKeySecret, cfg.AliyunRegion)
+		aliyun.HTTPClient = c.httpClient()
+		if c.Endpoints.Aliyun != "" {
+			aliyun.APIEndpoint = c.Endpoints.Aliyun
+		}
+		provider = aliyun
+
+	default:
+		return []Result{{Provider: name, Check: "ocr", Status: StatusFailed,
+			Error: "不支持的OCR服务商", Diagnosis: "ocr_provider 应为 baidu、tencent 或 aliyun"}}
+	}
+
+	return []Result{run(name, "ocr", func() (string, error) {
+		return provider.RecognizeText(sampleImage())
+	})}
+}
+
+// checkDeepSeek 以1个token的对话检查DeepSeek密钥，视觉模式下未配置密钥时跳过
+func (c *Checker) checkDeepSeek() []Result {
+	cfg := c.Config
+	if cfg.DeepSeekAPIKey == "" {
+		if strings.EqualFold(cfg.PipelineMode, "vision") {
+			return []Result{{Provider: "deepseek", Check: "chat", Status: StatusSkipped, Diagnosis: "视觉模式下不使用DeepSeek"}}
+		}
+		return []Result{missing("deepseek", "chat", "deepseek_api_key")}
+	}
+
+	return []Result{run("deepseek", "chat", func() (string, error) {
+		answer, _, err := ocr.ChatWithDeepSeekOptions("Reply with OK.", "ping", cfg.DeepSeekAPIKey, ocr.ChatOptions{
+			Endpoint:   c.Endpoints.DeepSeek,
+			MaxTokens:  1,
+			HTTPClient: c.httpClient(),
+		})
+		return answer, err
+	})}
+}
+
+// checkVision 以1个token的图片问答检查视觉模型，OCR模式下未单独配置密钥时跳过
+func (c *Checker) checkVision() []Result {
+	cfg := c.Config
+	mode := strings.ToLower(cfg.PipelineMode)
+	if cfg.VisionAPIKey == "" && mode != "vision" && mode != "auto" {
+		return []Result{{Provider: "vision", Check: "chat", Status: StatusSkipped, Diagnosis: "未启用视觉模型"}}
+	}
+
+	// 与截图处理保持一致，未单独配置密钥时沿用DeepSeek密钥
+	key := cfg.VisionAPIKey
+	if key == "" {
+		key = cfg.DeepSeekAPIKey
+	}
+	if key == "" {
+		return []Result{missing("vision", "chat", "vision_api_key")}
+	}
+
+	provider := ai.NewVisionProvider(key, cfg.VisionEndpoint, cfg.VisionModel)
+	provider.MaxTokens = 1
+	provider.HTTPClient = c.httpClient()
+	return []Result{run("vision", "chat", func() (string, error) {
+		answer, _, err := provider.AnswerImage(sampleImage(), "Reply with the word in the image.", "What does the image say?")
+		return answer, err
+	})}
+}
+
+// httpClient 返回带超时的HTTP客户端
+func (c *Checker) httpClient() *http.Client {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// run 执行一项检查并记录耗时和诊断
+func run(provider, check string, fn func() (string, error)) Result {
+	start := time.Now()
+	detail, err := fn()
+	result := Result{
+		Provider:  provider,
+		Check:     check,
+		Status:    StatusOK,
+		LatencyMS: time.Since(start).Milliseconds(),
+		Detail:    strings.TrimSpace(detail),
+	}
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+		result.Diagnosis = Diagnose(err)
+	}
+	return result
+}
+
+// missing 返回未配置密钥的检查结果
+func missing(provider, check, fields string) Result {
+	return Result{
+		Provider:  provider,
+		Check:     check,
+		Status:    StatusFailed,
+		Error:     "密钥未配置",
+		Diagnosis: "请在设置页面填写 " + fields,
+	}
+}
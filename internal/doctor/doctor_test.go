@@ -0,0 +1,122 @@
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qujing226/screen_sage/internal/config"
+)
+
+// newStandIns 启动百度和DeepSeek的本地替身服务
+func newStandIns(t *testing.T, validKey string) Endpoints {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("client_id") != validKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"unknown client id"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"token-1","expires_in":2592000}`)
+	})
+	mux.HandleFunc("/ocr", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") != "token-1" || r.FormValue("image") == "" {
+			fmt.Fprint(w, `{"error_code":110,"error_msg":"Access token invalid or no longer valid"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"words_result":[{"words":%q}],"words_result_num":1}`, SampleText)
+	})
+	mux.HandleFunc("/chat", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			MaxTokens int `json:"max_tokens"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Header.Get("Authorization") != "Bearer "+validKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"message":"Authentication Fails"}}`)
+			return
+		}
+		if body.MaxTokens != 1 {
+			t.Errorf("max_tokens = %d, 应为1", body.MaxTokens)
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"OK"}}],"usage":{"prompt_tokens":9,"completion_tokens":1}}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return Endpoints{
+		BaiduToken: server.URL + "/token",
+		BaiduOCR:   server.URL + "/ocr",
+		DeepSeek:   server.URL + "/chat",
+	}
+}
+
+func TestChecker_Run(t *testing.T) {
+	cfg := &config.Config{
+		OCRProvider:    "baidu",
+		BaiduAPIKey:    "good-key",
+		BaiduSecretKey: "secret",
+		DeepSeekAPIKey: "good-key",
+		PipelineMode:   "ocr",
+	}
+	checker := NewChecker(cfg)
+	checker.Endpoints = newStandIns(t, "good-key")
+
+	report := checker.Run()
+	if !report.OK {
+		t.Fatalf("全部检查应通过: %+v", report.Results)
+	}
+	want := []string{"baidu/token/ok", "baidu/ocr/ok", "deepseek/chat/ok", "vision/chat/skipped"}
+	if len(report.Results) != len(want) {
+		t.Fatalf("结果数量 = %d, 应为 %d: %+v", len(report.Results), len(want), report.Results)
+	}
+	for i, r := range report.Results {
+		if got := r.Provider + "/" + r.Check + "/" + r.Status; got != want[i] {
+			t.Errorf("结果[%d] = %s, 应为 %s", i, got, want[i])
+		}
+	}
+	if report.Results[1].Detail != SampleText {
+		t.Errorf("识别结果 = %q", report.Results[1].Detail)
+	}
+}
+
+func TestChecker_RunDiagnosesBadKeys(t *testing.T) {
+	cfg := &config.Config{
+		OCRProvider:    "baidu",
+		BaiduAPIKey:    "wrong-key",
+		BaiduSecretKey: "secret",
+		DeepSeekAPIKey: "wrong-key",
+	}
+	checker := NewChecker(cfg)
+	checker.Endpoints = newStandIns(t, "good-key")
+
+	report := checker.Run()
+	if report.OK {
+		t.Fatal("密钥错误时检查不应通过")
+	}
+	byCheck := map[string]Result{}
+	for _, r := range report.Results {
+		byCheck[r.Provider+"/"+r.Check] = r
+	}
+	if r := byCheck["baidu/token"]; r.Status != StatusFailed || r.Diagnosis != "百度 API Key 错误，请核对百度智能云应用的 API Key" {
+		t.Errorf("百度令牌检查 = %+v", r)
+	}
+	if r := byCheck["baidu/ocr"]; r.Status != StatusSkipped {
+		t.Errorf("令牌获取失败时应跳过识别: %+v", r)
+	}
+	if r := byCheck["deepseek/chat"]; r.Status != StatusFailed || r.Diagnosis != "密钥无效、已过期或没有权限，请检查密钥" {
+		t.Errorf("DeepSeek检查 = %+v", r)
+	}
+}
+
+func TestChecker_RunMissingKeys(t *testing.T) {
+	report := NewChecker(&config.Config{OCRProvider: "tencent", PipelineMode: "auto"}).Run()
+	for _, r := range report.Results {
+		if r.Status != StatusFailed || r.Diagnosis == "" {
+			t.Errorf("未配置密钥时应失败并给出诊断: %+v", r)
+		}
+	}
+}
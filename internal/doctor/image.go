@@ -0,0 +1,53 @@
+package doctor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// SampleText 测试图片中的文字
+const SampleText = "HELLO"
+
+// glyphs 5x7点阵字形，只包含SampleText用到的字母
+var glyphs = map[rune][7]string{
+	'H': {"X...X", "X...X", "X...X", "XXXXX", "X...X", "X...X", "X...X"},
+	'E': {"XXXXX", "X....", "X....", "XXXX.", "X....", "X....", "XXXXX"},
+	'L': {"X....", "X....", "X....", "X....", "X....", "X....", "XXXXX"},
+	'O': {".XXX.", "X...X", "X...X", "X...X", "X...X", "X...X", ".XXX."},
+}
+
+// sampleImage 生成写有SampleText的白底黑字PNG图片，返回Base64编码
+// 图片在运行时绘制，不依赖外部文件和字体
+func sampleImage() string {
+	const scale, margin = 6, 12
+	width := margin*2 + len(SampleText)*6*scale
+	height := margin*2 + 7*scale
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for i, ch := range SampleText {
+		glyph := glyphs[ch]
+		for row, line := range glyph {
+			for col, dot := range line {
+				if dot != 'X' {
+					continue
+				}
+				x0, y0 := margin+(i*6+col)*scale, margin+row*scale
+				for y := y0; y < y0+scale; y++ {
+					for x := x0; x < x0+scale; x++ {
+						img.SetGray(x, y, color.Gray{Y: 0})
+					}
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
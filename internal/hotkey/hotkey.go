@@ -2,6 +2,7 @@ package hotkey
 
 import (
 	"log"
+	"sync"
 
 	"golang.design/x/hotkey"
 )
@@ -9,29 +10,67 @@ import (
 // KeyCallback 定义热键触发时的回调函数类型
 type KeyCallback func()
 
-// RegisterHotkey 注册全局热键 Ctrl+Shift+Q
+// Registration 表示一个已注册的全局热键
+type Registration struct {
+	Spec string // 快捷键描述，例如 Ctrl+Shift+Q
+
+	hk   *hotkey.Hotkey
+	done chan struct{}
+	once sync.Once
+}
+
+// RegisterHotkey 注册默认的全局热键 Ctrl+Shift+Q
 func RegisterHotkey(callback KeyCallback) error {
-	// 创建热键组合 Ctrl+Shift+Q
-	hk := hotkey.New([]hotkey.Modifier{hotkey.ModCtrl, hotkey.ModShift}, hotkey.KeyQ)
+	_, err := Register(DefaultHotkey, callback)
+	return err
+}
 
-	// 注册热键
-	err := hk.Register()
+// Register 按快捷键描述注册全局热键，返回的Registration可用于注销
+func Register(spec string, callback KeyCallback) (*Registration, error) {
+	mods, key, err := Parse(spec)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// 启动监听循环
+	// 创建并注册热键
+	hk := hotkey.New(mods, key)
+	if err := hk.Register(); err != nil {
+		return nil, err
+	}
+
+	r := &Registration{Spec: spec, hk: hk, done: make(chan struct{})}
+
+	// 启动监听循环，注销后退出
 	go func() {
 		for {
 			// 等待热键按下事件
-			<-hk.Keydown()
+			select {
+			case <-hk.Keydown():
+			case <-r.done:
+				return
+			}
 			// 热键被触发，执行回调
 			callback()
 			// 等待热键释放，准备下一次触发
-			<-hk.Keyup()
+			select {
+			case <-hk.Keyup():
+			case <-r.done:
+				return
+			}
 		}
 	}()
 
-	log.Println("已注册全局热键: Ctrl+Shift+Q")
-	return nil
+	log.Printf("已注册全局热键: %s", spec)
+	return r, nil
+}
+
+// Unregister 注销热键并停止监听
+func (r *Registration) Unregister() error {
+	var err error
+	r.once.Do(func() {
+		close(r.done)
+		err = r.hk.Unregister()
+		log.Printf("已注销全局热键: %s", r.Spec)
+	})
+	return err
 }
@@ -0,0 +1,12 @@
+package hotkey
+
+import "golang.design/x/hotkey"
+
+// modifiers macOS下可用的修饰键
+var modifiers = map[string]hotkey.Modifier{
+	"CTRL":   hotkey.ModCtrl,
+	"SHIFT":  hotkey.ModShift,
+	"ALT":    hotkey.ModOption,
+	"OPTION": hotkey.ModOption,
+	"CMD":    hotkey.ModCmd,
+}
@@ -0,0 +1,12 @@
+package hotkey
+
+import "golang.design/x/hotkey"
+
+// modifiers Linux(X11)下可用的修饰键，Alt和Super分别对应Mod1和Mod4
+var modifiers = map[string]hotkey.Modifier{
+	"CTRL":  hotkey.ModCtrl,
+	"SHIFT": hotkey.ModShift,
+	"ALT":   hotkey.Mod1,
+	"SUPER": hotkey.Mod4,
+	"WIN":   hotkey.Mod4,
+}
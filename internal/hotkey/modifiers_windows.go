@@ -0,0 +1,12 @@
+package hotkey
+
+import "golang.design/x/hotkey"
+
+// modifiers Windows下可用的修饰键
+var modifiers = map[string]hotkey.Modifier{
+	"CTRL":  hotkey.ModCtrl,
+	"SHIFT": hotkey.ModShift,
+	"ALT":   hotkey.ModAlt,
+	"WIN":   hotkey.ModWin,
+	"SUPER": hotkey.ModWin,
+}
@@ -0,0 +1,57 @@
+package hotkey
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.design/x/hotkey"
+)
+
+// DefaultHotkey 默认的截图快捷键
+const DefaultHotkey = "Ctrl+Shift+Q"
+
+// keys 可用作快捷键的按键，各平台的按键名称相同
+var keys = map[string]hotkey.Key{
+	"SPACE": hotkey.KeySpace, "RETURN": hotkey.KeyReturn, "ENTER": hotkey.KeyReturn,
+	"ESCAPE": hotkey.KeyEscape, "ESC": hotkey.KeyEscape, "DELETE": hotkey.KeyDelete, "TAB": hotkey.KeyTab,
+	"LEFT": hotkey.KeyLeft, "RIGHT": hotkey.KeyRight, "UP": hotkey.KeyUp, "DOWN": hotkey.KeyDown,
+
+	"0": hotkey.Key0, "1": hotkey.Key1, "2": hotkey.Key2, "3": hotkey.Key3, "4": hotkey.Key4,
+	"5": hotkey.Key5, "6": hotkey.Key6, "7": hotkey.Key7, "8": hotkey.Key8, "9": hotkey.Key9,
+
+	"A": hotkey.KeyA, "B": hotkey.KeyB, "C": hotkey.KeyC, "D": hotkey.KeyD, "E": hotkey.KeyE,
+	"F": hotkey.KeyF, "G": hotkey.KeyG, "H": hotkey.KeyH, "I": hotkey.KeyI, "J": hotkey.KeyJ,
+	"K": hotkey.KeyK, "L": hotkey.KeyL, "M": hotkey.KeyM, "N": hotkey.KeyN, "O": hotkey.KeyO,
+	"P": hotkey.KeyP, "Q": hotkey.KeyQ, "R": hotkey.KeyR, "S": hotkey.KeyS, "T": hotkey.KeyT,
+	"U": hotkey.KeyU, "V": hotkey.KeyV, "W": hotkey.KeyW, "X": hotkey.KeyX, "Y": hotkey.KeyY,
+	"Z": hotkey.KeyZ,
+
+	"F1": hotkey.KeyF1, "F2": hotkey.KeyF2, "F3": hotkey.KeyF3, "F4": hotkey.KeyF4,
+	"F5": hotkey.KeyF5, "F6": hotkey.KeyF6, "F7": hotkey.KeyF7, "F8": hotkey.KeyF8,
+	"F9": hotkey.KeyF9, "F10": hotkey.KeyF10, "F11": hotkey.KeyF11, "F12": hotkey.KeyF12,
+}
+
+// Parse 解析形如 Ctrl+Shift+Q 的快捷键描述，不区分大小写
+// 必须包含至少一个修饰键和一个普通按键
+func Parse(spec string) ([]hotkey.Modifier, hotkey.Key, error) {
+	parts := strings.Split(spec, "+")
+	if len(parts) < 2 {
+		return nil, 0, fmt.Errorf("快捷键 %q 至少需要一个修饰键和一个按键", spec)
+	}
+
+	var mods []hotkey.Modifier
+	for _, part := range parts[:len(parts)-1] {
+		mod, ok := modifiers[strings.ToUpper(strings.TrimSpace(part))]
+		if !ok {
+			return nil, 0, fmt.Errorf("快捷键 %q 中的修饰键 %q 无效", spec, part)
+		}
+		mods = append(mods, mod)
+	}
+
+	last := parts[len(parts)-1]
+	key, ok := keys[strings.ToUpper(strings.TrimSpace(last))]
+	if !ok {
+		return nil, 0, fmt.Errorf("快捷键 %q 中的按键 %q 无效", spec, last)
+	}
+	return mods, key, nil
+}
@@ -0,0 +1,159 @@
+// Package lifecycle 协调程序退出：停止接收新任务、等待正在进行的任务、最后关闭数据库等资源
+package lifecycle
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// persistGrace 退出期限到达后，留给任务保存待处理记录的时间
+const persistGrace = 3 * time.Second
+
+// hook 退出时执行的一个步骤
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// App 程序的生命周期
+// 退出分三步：按注册顺序执行停止步骤（注销热键、关闭Web服务等），
+// 在期限内等待正在进行的任务，最后按注册的相反顺序关闭资源（数据库等）
+type App struct {
+	// Timeout 停止组件和等待任务的总期限
+	Timeout time.Duration
+
+	jobCtx    context.Context // 期限到达时取消，任务据此放弃等待并保存进度
+	abortJobs context.CancelFunc
+	jobs      sync.WaitGroup
+
+	stopping chan struct{} // 开始退出时关闭
+	done     chan struct{} // 退出完成时关闭
+	once     sync.Once
+	reason   string
+
+	mutex    sync.Mutex
+	closing  bool
+	stoppers []hook
+	closers  []hook
+}
+
+// New 创建生命周期，timeout为退出时停止组件和等待任务的总期限
+func New(timeout time.Duration) *App {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &App{
+		Timeout:   timeout,
+		jobCtx:    ctx,
+		abortJobs: cancel,
+		stopping:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// OnStop 注册开始退出时执行的停止步骤，用于停止接收新任务的组件
+func (a *App) OnStop(name string, fn func(ctx context.Context) error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.stoppers = append(a.stoppers, hook{name, fn})
+}
+
+// OnClose 注册任务结束后关闭的资源，按注册的相反顺序关闭
+func (a *App) OnClose(name string, fn func() error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.closers = append(a.closers, hook{name, func(context.Context) error { return fn() }})
+}
+
+// Track 登记一个正在进行的任务，返回的ctx在退出期限到达时取消，任务结束后调用done
+// 已经开始退出时返回ok为false，调用方不应再开始任务
+func (a *App) Track() (ctx context.Context, done func(), ok bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.closing {
+		return nil, nil, false
+	}
+	a.jobs.Add(1)
+	var once sync.Once
+	return a.jobCtx, func() { once.Do(a.jobs.Done) }, true
+}
+
+// Go 在后台执行任务，已经开始退出时不执行并返回false
+func (a *App) Go(fn func(ctx context.Context)) bool {
+	ctx, done, ok := a.Track()
+	if !ok {
+		return false
+	}
+	go func() {
+		defer done()
+		fn(ctx)
+	}()
+	return true
+}
+
+// Shutdown 开始退出，可以重复调用，只有第一次生效
+func (a *App) Shutdown(reason string) {
+	a.once.Do(func() {
+		a.mutex.Lock()
+		a.closing = true
+		a.reason = reason
+		a.mutex.Unlock()
+		close(a.stopping)
+	})
+}
+
+// Stopping 返回开始退出时关闭的通道
+func (a *App) Stopping() <-chan struct{} {
+	return a.stopping
+}
+
+// Done 返回退出完成时关闭的通道
+func (a *App) Done() <-chan struct{} {
+	return a.done
+}
+
+// Run 等待Shutdown被调用，然后依次停止组件、等待任务、关闭资源
+func (a *App) Run() {
+	<-a.stopping
+	defer close(a.done)
+
+	a.mutex.Lock()
+	reason, stoppers, closers := a.reason, a.stoppers, a.closers
+	a.mutex.Unlock()
+	log.Printf("开始退出（%s）", reason)
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.Timeout)
+	defer cancel()
+
+	for _, h := range stoppers {
+		if err := h.fn(ctx); err != nil {
+			log.Printf("停止%s失败: %v", h.name, err)
+		}
+	}
+
+	// 等待正在进行的任务，期限到达后通知任务保存进度
+	finished := make(chan struct{})
+	go func() {
+		a.jobs.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		log.Printf("等待任务超时，未完成的截图将保存为待处理记录")
+		a.abortJobs()
+		select {
+		case <-finished:
+		case <-time.After(persistGrace):
+			log.Printf("仍有任务未结束，直接退出")
+		}
+	}
+	a.abortJobs()
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].fn(context.Background()); err != nil {
+			log.Printf("关闭%s失败: %v", closers[i].name, err)
+		}
+	}
+	log.Printf("已退出")
+}
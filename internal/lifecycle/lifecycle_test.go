@@ -0,0 +1,87 @@
+package lifecycle
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownOrder(t *testing.T) {
+	app := New(time.Second)
+	var mutex sync.Mutex
+	var steps []string
+	record := func(step string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		steps = append(steps, step)
+	}
+
+	app.OnClose("数据库", func() error { record("db"); return nil })
+	app.OnClose("日志", func() error { record("log"); return nil })
+	app.OnStop("热键", func(context.Context) error { record("hotkey"); return nil })
+	app.OnStop("Web服务", func(context.Context) error { record("http"); return nil })
+
+	release := make(chan struct{})
+	if !app.Go(func(ctx context.Context) {
+		<-release
+		record("job")
+	}) {
+		t.Fatal("退出前应能开始任务")
+	}
+
+	app.Shutdown("测试")
+	app.Shutdown("重复调用")
+	if app.Go(func(context.Context) {}) {
+		t.Error("开始退出后不应再接受任务")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	app.Run()
+
+	// 停止步骤按注册顺序，任务完成后再按相反顺序关闭资源
+	want := []string{"hotkey", "http", "job", "log", "db"}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("退出顺序 = %v, 期望 %v", steps, want)
+	}
+	select {
+	case <-app.Done():
+	default:
+		t.Error("退出完成后Done应已关闭")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	app := New(20 * time.Millisecond)
+	closed := false
+	saved := false
+	app.OnClose("数据库", func() error {
+		closed = true
+		return nil
+	})
+
+	ctx, done, ok := app.Track()
+	if !ok {
+		t.Fatal("退出前应能登记任务")
+	}
+	go func() {
+		defer done()
+		// 模拟一直未完成的识别，期限到达后保存进度
+		<-ctx.Done()
+		saved = !closed
+	}()
+
+	app.Shutdown("测试")
+	start := time.Now()
+	app.Run()
+	if !saved {
+		t.Error("任务应在关闭数据库前保存进度")
+	}
+	if elapsed := time.Since(start); elapsed > persistGrace {
+		t.Errorf("任务结束后应立即退出，耗时 %s", elapsed)
+	}
+}
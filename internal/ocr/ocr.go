@@ -10,6 +10,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/qujing226/screen_sage/domain/model"
 )
 
 // OCRClient 是OCR API的通用接口
@@ -278,9 +280,6 @@ func ProcessWithDeepSeek(text string, apiKey string) (string, error) {
 		return "", fmt.Errorf("DeepSeek API密钥未提供")
 	}
 
-	// DeepSeek API端点
-	endpoint := "https://api.deepseek.com/v1/chat/completions"
-
 	// 构建专门的提示词模板
 	systemPrompt := "你是一个专业的屏幕内容分析助手。以下是通过OCR技术从屏幕截图中识别出的文本内容。请分析这些文本，找出其中包含的问题或关键信息，然后给出清晰、准确的回答或解释。如果文本中包含代码或错误信息，请特别关注并提供相关的解决方案。在回答的最后一行，请用一句简短的话提炼出本次问答的关键信息，作为标题，格式为'【标题】xxx'。"
 
@@ -291,30 +290,86 @@ func ProcessWithDeepSeek(text string, apiKey string) (string, error) {
 			"提炼关键内容，思考，并给出解答。在问答的末尾，我应该做一个标题，单独放在一行。"+
 			"以下是从屏幕截图中识别出的文本内容：\n\n%s\n\n", text)
 
+	answer, _, err := ChatWithDeepSeek(systemPrompt, userPrompt, apiKey)
+	return answer, err
+}
+
+// DefaultDeepSeekEndpoint DeepSeek对话接口地址
+const DefaultDeepSeekEndpoint = "https://api.deepseek.com/v1/chat/completions"
+
+// DefaultDeepSeekModel 默认的DeepSeek对话模型
+const DefaultDeepSeekModel = "deepseek-chat"
+
+// ChatOptions 调用DeepSeek对话接口的可选参数，零值使用默认值
+type ChatOptions struct {
+	Endpoint   string       // 接口地址，默认 DefaultDeepSeekEndpoint
+	Model      string       // 模型名称，默认 DefaultDeepSeekModel
+	MaxTokens  int          // 最大输出长度，默认3000
+	HTTPClient *http.Client // 默认超时60秒
+}
+
+// ChatWithDeepSeek 使用给定的系统提示词和用户提示词调用DeepSeek对话接口
+// 参数:
+//   - systemPrompt: 系统提示词
+//   - userPrompt: 用户提示词，通常已包含OCR识别的文本
+//   - apiKey: DeepSeek API密钥
+//
+// 返回:
+//   - 模型的回答
+//   - 本次调用消耗的token数量
+//   - 错误信息（如果有）
+func ChatWithDeepSeek(systemPrompt string, userPrompt string, apiKey string) (string, model.TokenUsage, error) {
+	return ChatWithDeepSeekOptions(systemPrompt, userPrompt, apiKey, ChatOptions{})
+}
+
+// ChatWithDeepSeekOptions 按指定的接口地址和输出长度调用DeepSeek对话接口
+func ChatWithDeepSeekOptions(systemPrompt string, userPrompt string, apiKey string, opts ChatOptions) (string, model.TokenUsage, error) {
+	var usage model.TokenUsage
+	if apiKey == "" {
+		return "", usage, fmt.Errorf("DeepSeek API密钥未提供")
+	}
+
+	// DeepSeek API端点
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultDeepSeekEndpoint
+	}
+	maxTokens := opts.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = 3000
+	}
+	modelName := opts.Model
+	if modelName == "" {
+		modelName = DefaultDeepSeekModel
+	}
+
 	// 准备请求数据
 	reqData := map[string]interface{}{
-		"model": "deepseek-chat",
+		"model": modelName,
 		"messages": []map[string]string{
 			{"role": "system", "content": systemPrompt},
 			{"role": "user", "content": userPrompt},
 		},
 		"temperature": 0.7,
-		"max_tokens":  3000, // 设置最大输出长度
+		"max_tokens":  maxTokens, // 设置最大输出长度
 	}
 
 	// 将请求数据转换为JSON
 	reqBody, err := json.Marshal(reqData)
 	if err != nil {
-		return "", fmt.Errorf("编码请求数据失败: %v", err)
+		return "", usage, fmt.Errorf("编码请求数据失败: %v", err)
 	}
 
 	// 创建HTTP客户端
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
 
 	// 创建HTTP请求
 	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return "", fmt.Errorf("创建HTTP请求失败: %v", err)
+		return "", usage, fmt.Errorf("创建HTTP请求失败: %v", err)
 	}
 
 	// 设置请求头
@@ -324,19 +379,19 @@ func ProcessWithDeepSeek(text string, apiKey string) (string, error) {
 	// 发送请求
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("发送请求失败: %v", err)
+		return "", usage, fmt.Errorf("发送请求失败: %v", err)
 	}
 	defer resp.Body.Close()
 
 	// 读取响应
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("读取响应失败: %v", err)
+		return "", usage, fmt.Errorf("读取响应失败: %v", err)
 	}
 
 	// 检查HTTP状态码
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API返回错误状态码: %d, 响应: %s", resp.StatusCode, string(respBody))
+		return "", usage, fmt.Errorf("API返回错误状态码: %d, 响应: %s", resp.StatusCode, string(respBody))
 	}
 
 	// 解析响应
@@ -346,26 +401,35 @@ func ProcessWithDeepSeek(text string, apiKey string) (string, error) {
 				Content string `json:"content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
 		Error struct {
 			Message string `json:"message"`
 		} `json:"error,omitempty"`
 	}
 
 	if err := json.Unmarshal(respBody, &response); err != nil {
-		return "", fmt.Errorf("解析响应失败: %v", err)
+		return "", usage, fmt.Errorf("解析响应失败: %v", err)
 	}
 
 	// 检查API错误
 	if response.Error.Message != "" {
-		return "", fmt.Errorf("API返回错误: %s", response.Error.Message)
+		return "", usage, fmt.Errorf("API返回错误: %s", response.Error.Message)
+	}
+
+	usage = model.TokenUsage{
+		PromptTokens:     response.Usage.PromptTokens,
+		CompletionTokens: response.Usage.CompletionTokens,
 	}
 
 	// 返回处理结果
 	if len(response.Choices) > 0 {
-		return response.Choices[0].Message.Content, nil
+		return response.Choices[0].Message.Content, usage, nil
 	}
 
-	return "", fmt.Errorf("API未返回有效响应")
+	return "", usage, fmt.Errorf("API未返回有效响应")
 }
 
 // ImageToBase64 将图像文件转换为Base64编码的字符串
@@ -0,0 +1,160 @@
+package paths
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// migratedMarker 迁移完成后写入配置目录的标记文件
+const migratedMarker = ".migrated"
+
+// Migration 一次迁移的结果
+type Migration struct {
+	From       string // 旧版本的数据根目录，即可执行文件所在目录
+	Files      int    // 复制的文件数
+	ImagesFrom string // 旧的截图目录，数据库中的截图路径需要据此改写
+	ImagesTo   string
+}
+
+// Migrate 将旧版本放在可执行文件旁的配置、数据库和截图复制到新目录，只执行一次
+// 便携模式或指定了数据根目录时不迁移；新目录中已有配置文件时只写入标记
+// 旧文件保留不删除，没有需要迁移的内容时返回nil
+func Migrate(d Dirs) (*Migration, error) {
+	if d.Portable {
+		return nil, nil
+	}
+	exeDir, err := executableDir()
+	if err != nil {
+		return nil, nil
+	}
+	return migrate(d, exeDir)
+}
+
+// migrate 从legacyRoot迁移，便于测试
+func migrate(d Dirs, legacyRoot string) (*Migration, error) {
+	legacy := under(legacyRoot, false)
+	// 旧布局与新目录相同（例如 --data-dir 指向可执行文件所在目录）时不需要迁移
+	if legacy.Config == d.Config {
+		return nil, nil
+	}
+
+	marker := filepath.Join(d.Config, migratedMarker)
+	if _, err := os.Stat(marker); err == nil {
+		return nil, nil
+	}
+	if err := d.Create(); err != nil {
+		return nil, err
+	}
+
+	var m *Migration
+	if _, err := os.Stat(filepath.Join(d.Config, "config.json")); os.IsNotExist(err) {
+		m, err = copyLegacy(d, legacy, legacyRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.WriteFile(marker, []byte(legacyRoot+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("写入迁移标记失败: %v", err)
+	}
+	return m, nil
+}
+
+// copyLegacy 复制旧布局中的文件，已存在的目标文件不覆盖
+func copyLegacy(d, legacy Dirs, legacyRoot string) (*Migration, error) {
+	m := &Migration{From: legacyRoot}
+
+	pairs := [][2]string{
+		{filepath.Join(legacy.Config, "secrets.enc"), filepath.Join(d.Config, "secrets.enc")},
+		{filepath.Join(legacy.Config, "secret.key"), filepath.Join(d.Config, "secret.key")},
+		{filepath.Join(legacy.Data, "screensage.db"), filepath.Join(d.Data, "screensage.db")},
+	}
+	for _, pair := range pairs {
+		copied, err := copyFile(pair[0], pair[1])
+		if err != nil {
+			return nil, err
+		}
+		if copied {
+			m.Files++
+		}
+	}
+
+	// 旧配置文件中保存的数据库路径指向旧位置，改为新的默认路径
+	if data, err := os.ReadFile(filepath.Join(legacy.Config, "config.json")); err == nil {
+		var values map[string]interface{}
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("解析旧配置文件失败: %v", err)
+		}
+		if values["db_path"] == filepath.Join(legacy.Data, "screensage.db") {
+			values["db_path"] = filepath.Join(d.Data, "screensage.db")
+		}
+		data, err = json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("序列化配置失败: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(d.Config, "config.json"), data, 0600); err != nil {
+			return nil, fmt.Errorf("写入配置文件失败: %v", err)
+		}
+		m.Files++
+	}
+
+	entries, err := os.ReadDir(legacy.Images)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取旧截图目录失败: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		copied, err := copyFile(filepath.Join(legacy.Images, entry.Name()), filepath.Join(d.Images, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if copied {
+			m.Files++
+			m.ImagesFrom, m.ImagesTo = legacy.Images, d.Images
+		}
+	}
+
+	if m.Files == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// copyFile 复制文件并保留权限，源文件不存在或目标已存在时返回false
+func copyFile(src, dst string) (bool, error) {
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("打开 %s 失败: %v", src, err)
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return false, fmt.Errorf("读取 %s 失败: %v", src, err)
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if os.IsExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("创建 %s 失败: %v", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return false, fmt.Errorf("复制 %s 失败: %v", src, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return false, fmt.Errorf("复制 %s 失败: %v", src, err)
+	}
+	return true, nil
+}
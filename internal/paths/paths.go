@@ -0,0 +1,166 @@
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// appDir Linux下的目录名
+	appDir = "screensage"
+	// appDirTitle Windows和macOS下的目录名
+	appDirTitle = "ScreenSage"
+
+	// PortableMarker 可执行文件旁存在该文件时启用便携模式
+	PortableMarker = "portable"
+	// DataDirEnv 指定数据根目录的环境变量，与 --data-dir 等价
+	DataDirEnv = "SCREENSAGE_DATA_DIR"
+	// PortableEnv 设置为 1 或 true 时启用便携模式，与 --portable 等价
+	PortableEnv = "SCREENSAGE_PORTABLE"
+)
+
+// Dirs 程序使用的各个目录
+type Dirs struct {
+	Config   string `json:"config"` // config.json 和加密密钥
+	Data     string `json:"data"`   // 数据库
+	Images   string `json:"images"` // 截图
+	Cache    string `json:"cache"`  // 可随时删除的缓存
+	Log      string `json:"log"`    // 日志
+	Portable bool   `json:"portable"`
+}
+
+// Options 目录解析选项，对应命令行参数
+type Options struct {
+	DataDir  string // 数据根目录，所有目录都放在其下
+	Portable bool   // 便携模式，所有目录都放在可执行文件旁
+}
+
+// Resolve 解析程序使用的目录，优先级为 数据根目录 → 便携模式 → 系统标准目录
+// Linux遵循XDG基础目录规范，Windows使用 %APPDATA% 和 %LOCALAPPDATA%，macOS使用 ~/Library
+func Resolve(opts Options) (Dirs, error) {
+	exeDir, err := executableDir()
+	if err != nil {
+		exeDir = ""
+	}
+	home, _ := os.UserHomeDir()
+	return resolve(runtime.GOOS, os.Getenv, home, exeDir, opts)
+}
+
+// resolve 按给定的平台和环境解析目录，便于测试
+func resolve(goos string, getenv func(string) string, home, exeDir string, opts Options) (Dirs, error) {
+	if opts.DataDir == "" {
+		opts.DataDir = getenv(DataDirEnv)
+	}
+	if opts.DataDir != "" {
+		dir, err := filepath.Abs(opts.DataDir)
+		if err != nil {
+			return Dirs{}, fmt.Errorf("解析数据目录失败: %v", err)
+		}
+		return under(dir, false), nil
+	}
+
+	portable := opts.Portable || isTrue(getenv(PortableEnv))
+	if !portable && exeDir != "" {
+		if _, err := os.Stat(filepath.Join(exeDir, PortableMarker)); err == nil {
+			portable = true
+		}
+	}
+	if portable {
+		if exeDir == "" {
+			return Dirs{}, fmt.Errorf("便携模式需要获取可执行文件所在目录")
+		}
+		return under(exeDir, true), nil
+	}
+
+	// 环境变量中的路径必须是绝对路径，否则按规范忽略
+	env := func(name, fallback string) string {
+		if value := getenv(name); filepath.IsAbs(value) {
+			return value
+		}
+		return fallback
+	}
+
+	switch goos {
+	case "windows":
+		roaming := env("APPDATA", filepath.Join(home, "AppData", "Roaming"))
+		local := env("LOCALAPPDATA", filepath.Join(home, "AppData", "Local"))
+		return Dirs{
+			Config: filepath.Join(roaming, appDirTitle),
+			Data:   filepath.Join(local, appDirTitle, "data"),
+			Images: filepath.Join(local, appDirTitle, "images"),
+			Cache:  filepath.Join(local, appDirTitle, "cache"),
+			Log:    filepath.Join(local, appDirTitle, "logs"),
+		}, nil
+	case "darwin":
+		if home == "" {
+			return Dirs{}, fmt.Errorf("无法获取用户主目录")
+		}
+		support := filepath.Join(home, "Library", "Application Support", appDirTitle)
+		return Dirs{
+			Config: support,
+			Data:   support,
+			Images: filepath.Join(support, "images"),
+			Cache:  filepath.Join(home, "Library", "Caches", appDirTitle),
+			Log:    filepath.Join(home, "Library", "Logs", appDirTitle),
+		}, nil
+	default:
+		if home == "" {
+			return Dirs{}, fmt.Errorf("无法获取用户主目录")
+		}
+		data := filepath.Join(env("XDG_DATA_HOME", filepath.Join(home, ".local", "share")), appDir)
+		return Dirs{
+			Config: filepath.Join(env("XDG_CONFIG_HOME", filepath.Join(home, ".config")), appDir),
+			Data:   data,
+			Images: filepath.Join(data, "images"),
+			Cache:  filepath.Join(env("XDG_CACHE_HOME", filepath.Join(home, ".cache")), appDir),
+			Log:    filepath.Join(env("XDG_STATE_HOME", filepath.Join(home, ".local", "state")), appDir, "logs"),
+		}, nil
+	}
+}
+
+// under 返回放在同一根目录下的目录布局，与旧版本在可执行文件旁的布局一致
+func under(root string, portable bool) Dirs {
+	return Dirs{
+		Config:   filepath.Join(root, "config"),
+		Data:     filepath.Join(root, "data"),
+		Images:   filepath.Join(root, "images"),
+		Cache:    filepath.Join(root, "cache"),
+		Log:      filepath.Join(root, "logs"),
+		Portable: portable,
+	}
+}
+
+// Create 创建全部目录
+func (d Dirs) Create() error {
+	for _, dir := range []string{d.Config, d.Data, d.Images, d.Cache, d.Log} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("创建目录 %s 失败: %v", dir, err)
+		}
+	}
+	// 配置目录中有加密密钥，只允许当前用户访问
+	return os.Chmod(d.Config, 0700)
+}
+
+// executableDir 返回可执行文件所在目录，解析符号链接
+func executableDir() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return filepath.Dir(exe), nil
+}
+
+// isTrue 判断环境变量是否表示开启
+func isTrue(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
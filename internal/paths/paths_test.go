@@ -0,0 +1,108 @@
+package paths
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeEnv 返回读取给定环境变量的函数
+func fakeEnv(values map[string]string) func(string) string {
+	return func(name string) string { return values[name] }
+}
+
+func TestResolve_XDG(t *testing.T) {
+	env := fakeEnv(map[string]string{"XDG_CONFIG_HOME": "/xdg/config", "XDG_CACHE_HOME": "relative/ignored"})
+	dirs, err := resolve("linux", env, "/home/u", "/usr/bin", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Dirs{
+		Config: "/xdg/config/screensage",
+		Data:   "/home/u/.local/share/screensage",
+		Images: "/home/u/.local/share/screensage/images",
+		Cache:  "/home/u/.cache/screensage",
+		Log:    "/home/u/.local/state/screensage/logs",
+	}
+	if dirs != want {
+		t.Errorf("resolve() = %+v, 应为 %+v", dirs, want)
+	}
+}
+
+func TestResolve_DataDirAndPortable(t *testing.T) {
+	exeDir := t.TempDir()
+
+	dirs, err := resolve("linux", fakeEnv(nil), "/home/u", exeDir, Options{DataDir: "/srv/sage"})
+	if err != nil || dirs.Config != "/srv/sage/config" || dirs.Images != "/srv/sage/images" || dirs.Portable {
+		t.Errorf("--data-dir: %+v, %v", dirs, err)
+	}
+
+	dirs, err = resolve("linux", fakeEnv(map[string]string{PortableEnv: "1"}), "/home/u", exeDir, Options{})
+	if err != nil || !dirs.Portable || dirs.Data != filepath.Join(exeDir, "data") {
+		t.Errorf("环境变量启用便携模式: %+v, %v", dirs, err)
+	}
+
+	// 可执行文件旁的标记文件同样启用便携模式
+	if err := os.WriteFile(filepath.Join(exeDir, PortableMarker), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dirs, err = resolve("linux", fakeEnv(nil), "/home/u", exeDir, Options{})
+	if err != nil || !dirs.Portable || dirs.Config != filepath.Join(exeDir, "config") {
+		t.Errorf("标记文件启用便携模式: %+v, %v", dirs, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	legacyRoot := t.TempDir()
+	legacy := under(legacyRoot, false)
+	for _, dir := range []string{legacy.Config, legacy.Data, legacy.Images} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldDB := filepath.Join(legacy.Data, "screensage.db")
+	config, _ := json.Marshal(map[string]interface{}{"db_path": oldDB, "port": 9000})
+	files := map[string]string{
+		filepath.Join(legacy.Config, "config.json"): string(config),
+		filepath.Join(legacy.Config, "secrets.enc"): "secrets",
+		oldDB:                                 "db",
+		filepath.Join(legacy.Images, "1.png"): "png",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs := under(t.TempDir(), false)
+	m, err := migrate(dirs, legacyRoot)
+	if err != nil {
+		t.Fatalf("migrate() error = %v", err)
+	}
+	if m == nil || m.Files != 4 || m.ImagesFrom != legacy.Images || m.ImagesTo != dirs.Images {
+		t.Fatalf("migrate() = %+v", m)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dirs.Config, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values map[string]interface{}
+	json.Unmarshal(data, &values)
+	if values["db_path"] != filepath.Join(dirs.Data, "screensage.db") || values["port"] != 9000.0 {
+		t.Errorf("迁移后的配置 = %v", values)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dirs.Images, "1.png")); string(got) != "png" {
+		t.Errorf("截图未迁移: %q", got)
+	}
+	// 旧文件保留
+	if _, err := os.Stat(oldDB); err != nil {
+		t.Errorf("旧数据库不应被删除: %v", err)
+	}
+
+	// 只迁移一次
+	if m, err := migrate(dirs, legacyRoot); m != nil || err != nil {
+		t.Errorf("再次迁移应无操作: %+v, %v", m, err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package prompt 负责提示词配置的模板渲染和内置配置
+package prompt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+// DefaultProfileName 未配置时使用的提示词配置名称
+const DefaultProfileName = "algorithm"
+
+// Data 渲染提示词模板时可用的数据
+type Data struct {
+	Text           string    // 清洗后的OCR文本，直接基于图片回答时为空
+	RawText        string    // OCR原始文本
+	Timestamp      time.Time // 截图时间
+	Language       string    // 回答使用的语言
+	PreviousAnswer string    // 上一条记录的回答
+	FromImage      bool      // 是否直接基于图片回答
+}
+
+// titleInstruction 各内置配置共用的标题要求，保证回答最后一行可作为标题
+const titleInstruction = "在回答的最后一行，请用一句简短的话提炼出本次问答的关键信息，作为标题，格式为'【标题】xxx'。"
+
+// sourceBlock 按来源插入截图内容的模板片段
+const sourceBlock = `{{if .FromImage}}截图已作为图片附上，请直接阅读图片内容。{{else}}以下是从屏幕截图中识别出的文本内容：
+
+{{.Text}}
+{{end}}`
+
+// DefaultProfiles 返回内置的提示词配置
+func DefaultProfiles() []storage.PromptProfile {
+	return []storage.PromptProfile{
+		{
+			Name:           "algorithm",
+			Description:    "分析算法题或一般问题并给出解答",
+			SystemTemplate: "你是一个专业的屏幕内容分析助手。以下是从屏幕截图中获取的内容。请分析这些内容，找出其中包含的问题或关键信息，然后给出清晰、准确的回答或解释。如果内容中包含代码或错误信息，请特别关注并提供相关的解决方案。请使用{{.Language}}回答。" + titleInstruction,
+			UserTemplate: "你的任务: a.分析上述内容，找出其中描述的问题,可能是一道算法题，也可能只是一个问题，给出具体的答。 " +
+				"b.最后一行提炼出本次回答的关键信息，我将作为本次问答的标题，需要单独放在一行，10字内即可。例如：【算法】字符串解码" +
+				"提炼关键内容，思考，并给出解答。在问答的末尾，我应该做一个标题，单独放在一行。" + sourceBlock,
+		},
+		{
+			Name:           "stacktrace",
+			Description:    "解释报错信息和堆栈并给出修复建议",
+			SystemTemplate: "你是一名经验丰富的软件工程师，擅长排查程序错误。请阅读截图中的报错信息或堆栈，指出最可能的根本原因，说明出错位置，并给出具体的修复步骤和示例代码。请使用{{.Language}}回答。" + titleInstruction,
+			UserTemplate: "请解释下面的错误并给出修复建议。" +
+				"{{if .PreviousAnswer}}\n\n上一次的分析供参考：\n{{.PreviousAnswer}}\n{{end}}\n\n" + sourceBlock,
+		},
+		{
+			Name:           "translate",
+			Description:    "将截图中的文档翻译为目标语言",
+			SystemTemplate: "你是一名专业的技术文档译者。请将截图中的正文内容准确、通顺地翻译为{{.Language}}，保留代码、命令和专有名词的原文，保持原有的段落和列表结构，不要添加额外解释。" + titleInstruction,
+			UserTemplate:   "请翻译以下内容。\n\n" + sourceBlock,
+		},
+		{
+			Name:           "summarize",
+			Description:    "总结文章要点",
+			SystemTemplate: "你是一名善于提炼信息的编辑。请阅读截图中的文章，用{{.Language}}给出不超过五条的要点总结，并在最后给出一句话概括。" + titleInstruction,
+			UserTemplate:   "请总结以下内容（截图时间：{{.Timestamp.Format \"2006-01-02 15:04\"}}）。\n\n" + sourceBlock,
+		},
+	}
+}
+
+// Validate 检查配置中的模板能否被解析
+func Validate(profile storage.PromptProfile) error {
+	if strings.TrimSpace(profile.Name) == "" {
+		return fmt.Errorf("提示词配置名称不能为空")
+	}
+	if strings.TrimSpace(profile.UserTemplate) == "" {
+		return fmt.Errorf("提示词配置 %s 的用户模板不能为空", profile.Name)
+	}
+	// 用两种来源的示例数据试渲染，以发现引用不存在字段之类的错误
+	for _, sample := range []Data{
+		{Text: "示例文本", RawText: "示例文本", Timestamp: time.Now(), Language: "中文"},
+		{Timestamp: time.Now(), Language: "中文", PreviousAnswer: "示例回答", FromImage: true},
+	} {
+		if _, err := execute("system", profile.SystemTemplate, sample); err != nil {
+			return fmt.Errorf("提示词配置 %s 的系统模板无效: %v", profile.Name, err)
+		}
+		if _, err := execute("user", profile.UserTemplate, sample); err != nil {
+			return fmt.Errorf("提示词配置 %s 的用户模板无效: %v", profile.Name, err)
+		}
+	}
+	return nil
+}
+
+// Render 渲染提示词配置，返回系统提示词和用户提示词
+func Render(profile storage.PromptProfile, data Data) (string, string, error) {
+	if data.Language == "" {
+		data.Language = "中文"
+	}
+	if data.Timestamp.IsZero() {
+		data.Timestamp = time.Now()
+	}
+
+	system, err := execute("system", profile.SystemTemplate, data)
+	if err != nil {
+		return "", "", fmt.Errorf("渲染系统提示词失败: %v", err)
+	}
+	user, err := execute("user", profile.UserTemplate, data)
+	if err != nil {
+		return "", "", fmt.Errorf("渲染用户提示词失败: %v", err)
+	}
+	return system, user, nil
+}
+
+// FollowUp 生成追问的系统提示词和用户提示词
+// text 和 answer 为原记录的识别文本和回答，直接基于图片回答的记录没有识别文本
+func FollowUp(text, answer, question, language string) (string, string) {
+	if language == "" {
+		language = "中文"
+	}
+	system := "你是一个专业的屏幕内容分析助手。用户看过之前对一张屏幕截图的分析后提出了追问，请结合截图内容和之前的回答，直接回答追问。请使用" + language + "回答。" + titleInstruction
+
+	var user strings.Builder
+	if strings.TrimSpace(text) != "" {
+		user.WriteString("截图中识别出的文本内容：\n\n" + text + "\n\n")
+	}
+	user.WriteString("之前的回答：\n\n" + answer + "\n\n")
+	user.WriteString("追问：" + question)
+	return system, user.String()
+}
+
+// parse 解析模板，引用不存在的字段时报错
+func parse(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// execute 解析并执行模板
+func execute(name, text string, data Data) (string, error) {
+	tmpl, err := parse(name, text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
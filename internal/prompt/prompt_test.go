@@ -0,0 +1,75 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+func TestDefaultProfilesAreValid(t *testing.T) {
+	for _, profile := range DefaultProfiles() {
+		if err := Validate(profile); err != nil {
+			t.Errorf("内置配置 %s 无效: %v", profile.Name, err)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	profile := storage.PromptProfile{
+		Name:           "test",
+		SystemTemplate: "请使用{{.Language}}回答",
+		UserTemplate:   "{{if .PreviousAnswer}}上次: {{.PreviousAnswer}}\n{{end}}" + sourceBlock,
+	}
+
+	system, user, err := Render(profile, Data{Text: "二分查找", PreviousAnswer: "O(log n)"})
+	if err != nil {
+		t.Fatalf("渲染失败: %v", err)
+	}
+	if system != "请使用中文回答" {
+		t.Errorf("系统提示词不符合预期: %q", system)
+	}
+	if !strings.Contains(user, "上次: O(log n)") || !strings.Contains(user, "二分查找") {
+		t.Errorf("用户提示词不符合预期: %q", user)
+	}
+
+	_, user, err = Render(profile, Data{FromImage: true})
+	if err != nil {
+		t.Fatalf("渲染失败: %v", err)
+	}
+	if !strings.Contains(user, "截图已作为图片附上") {
+		t.Errorf("图片来源的提示词不符合预期: %q", user)
+	}
+}
+
+func TestValidateRejectsUnknownField(t *testing.T) {
+	err := Validate(storage.PromptProfile{
+		Name:         "broken",
+		UserTemplate: "{{.Nonexistent}}",
+	})
+	if err == nil {
+		t.Fatal("引用不存在的字段应当校验失败")
+	}
+
+	err = Validate(storage.PromptProfile{Name: "broken", UserTemplate: "{{if .Text}}"})
+	if err == nil {
+		t.Fatal("语法错误的模板应当校验失败")
+	}
+}
+
+func TestFollowUp(t *testing.T) {
+	system, user := FollowUp("func main() {}", "这是Go程序的入口。", "为什么没有返回值？", "English")
+	if !strings.Contains(system, "English") || !strings.Contains(system, "【标题】") {
+		t.Errorf("系统提示词不符合预期: %q", system)
+	}
+	for _, want := range []string{"func main() {}", "这是Go程序的入口。", "追问：为什么没有返回值？"} {
+		if !strings.Contains(user, want) {
+			t.Errorf("用户提示词缺少 %q: %q", want, user)
+		}
+	}
+
+	// 基于图片回答的记录没有识别文本
+	if _, user := FollowUp("", "回答", "问题", ""); strings.Contains(user, "识别出的文本") {
+		t.Errorf("没有识别文本时不应包含文本段落: %q", user)
+	}
+}
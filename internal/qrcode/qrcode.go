@@ -0,0 +1,448 @@
+// Package qrcode 生成QR码，只实现配对链接需要的部分：字节模式、M级纠错、版本1到10
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// quietZone 四周空白区的模块数，规范要求至少4个
+const quietZone = 4
+
+// blockSpec M级纠错下每个版本的分块参数
+type blockSpec struct {
+	ecPerBlock int    // 每块的纠错码字数
+	blocks     [2]int // 两组的块数
+	dataLen    [2]int // 两组每块的数据码字数
+}
+
+// versions 版本1到10的分块参数和校正图形位置，下标为版本号
+var versions = [...]struct {
+	spec      blockSpec
+	alignment []int
+}{
+	1:  {blockSpec{10, [2]int{1, 0}, [2]int{16, 0}}, nil},
+	2:  {blockSpec{16, [2]int{1, 0}, [2]int{28, 0}}, []int{6, 18}},
+	3:  {blockSpec{26, [2]int{1, 0}, [2]int{44, 0}}, []int{6, 22}},
+	4:  {blockSpec{18, [2]int{2, 0}, [2]int{32, 0}}, []int{6, 26}},
+	5:  {blockSpec{24, [2]int{2, 0}, [2]int{43, 0}}, []int{6, 30}},
+	6:  {blockSpec{16, [2]int{4, 0}, [2]int{27, 0}}, []int{6, 34}},
+	7:  {blockSpec{18, [2]int{4, 0}, [2]int{31, 0}}, []int{6, 22, 38}},
+	8:  {blockSpec{22, [2]int{2, 2}, [2]int{38, 39}}, []int{6, 24, 42}},
+	9:  {blockSpec{22, [2]int{3, 2}, [2]int{36, 37}}, []int{6, 26, 46}},
+	10: {blockSpec{26, [2]int{4, 1}, [2]int{43, 44}}, []int{6, 28, 50}},
+}
+
+// dataCapacity 返回数据码字总数
+func (b blockSpec) dataCapacity() int {
+	return b.blocks[0]*b.dataLen[0] + b.blocks[1]*b.dataLen[1]
+}
+
+// Code 生成的QR码
+type Code struct {
+	Version  int
+	Size     int
+	modules  [][]bool // [行][列]，true为深色
+	function [][]bool // 功能图形占用的模块，不放置数据也不掩模
+}
+
+// Encode 将text以字节模式编码为QR码，自动选择能容纳的最小版本
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+	version := 0
+	for v := 1; v < len(versions); v++ {
+		// 模式指示符4位，版本1到9的字符计数8位，版本10为16位
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+len(data)*8 <= versions[v].spec.dataCapacity()*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("内容过长，无法生成二维码: %d 字节", len(data))
+	}
+
+	c := newCode(version)
+	c.drawFunctionPatterns()
+	c.drawCodewords(c.addErrorCorrection(encodeData(data, version)))
+	c.applyBestMask()
+	return c, nil
+}
+
+func newCode(version int) *Code {
+	size := version*4 + 17
+	c := &Code{Version: version, Size: size}
+	c.modules = make([][]bool, size)
+	c.function = make([][]bool, size)
+	for i := range c.modules {
+		c.modules[i] = make([]bool, size)
+		c.function[i] = make([]bool, size)
+	}
+	return c
+}
+
+// Dark 判断第row行第col列的模块是否为深色
+func (c *Code) Dark(row, col int) bool {
+	return c.modules[row][col]
+}
+
+// Image 返回每个模块占scale像素、带空白区的黑白图像
+func (c *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	width := (c.Size + quietZone*2) * scale
+	img := image.NewPaletted(image.Rect(0, 0, width, width), color.Palette{color.White, color.Black})
+	for row := 0; row < c.Size; row++ {
+		for col := 0; col < c.Size; col++ {
+			if !c.modules[row][col] {
+				continue
+			}
+			for y := 0; y < scale; y++ {
+				for x := 0; x < scale; x++ {
+					img.SetColorIndex((col+quietZone)*scale+x, (row+quietZone)*scale+y, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// PNG 返回PNG格式的二维码图片
+func (c *Code) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(scale)); err != nil {
+		return nil, fmt.Errorf("编码二维码图片失败: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeData 生成数据码字：模式指示符、字符计数、数据、终止符和填充
+func encodeData(data []byte, version int) []byte {
+	var bits bitBuffer
+	bits.append(0b0100, 4) // 字节模式
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	capacity := versions[version].spec.dataCapacity() * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	return bits.bytes()
+}
+
+// addErrorCorrection 分块计算纠错码并交织排列
+func (c *Code) addErrorCorrection(data []byte) []byte {
+	spec := versions[c.Version].spec
+	divisor := reedSolomonDivisor(spec.ecPerBlock)
+
+	var dataBlocks, ecBlocks [][]byte
+	offset := 0
+	for group := 0; group < 2; group++ {
+		for i := 0; i < spec.blocks[group]; i++ {
+			block := data[offset : offset+spec.dataLen[group]]
+			offset += spec.dataLen[group]
+			dataBlocks = append(dataBlocks, block)
+			ecBlocks = append(ecBlocks, reedSolomonRemainder(block, divisor))
+		}
+	}
+
+	var result []byte
+	for i := 0; i < max(spec.dataLen[0], spec.dataLen[1]); i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < spec.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// drawFunctionPatterns 绘制定位、分隔、定时、校正图形，并预留格式和版本信息区域
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	// 三个定位图形，外围一圈为分隔符
+	for _, center := range [][2]int{{3, 3}, {3, c.Size - 4}, {c.Size - 4, 3}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				row, col := center[0]+dy, center[1]+dx
+				if row < 0 || row >= c.Size || col < 0 || col >= c.Size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				c.setFunction(row, col, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// 校正图形，与定位图形重叠的三个位置除外
+	positions := versions[c.Version].alignment
+	last := len(positions) - 1
+	for i, row := range positions {
+		for j, col := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.setFunction(row+dy, col+dx, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	c.drawFormatBits(0)
+	c.drawVersion()
+}
+
+// drawFormatBits 绘制两份格式信息（纠错等级和掩模编号）
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatBits(mask)
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(i, 8, bit(i))
+	}
+	c.setFunction(7, 8, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(8, 7, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(8, 14-i, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(8, c.Size-1-i, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(c.Size-15+i, 8, bit(i))
+	}
+	c.setFunction(c.Size-8, 8, true) // 固定的深色模块
+}
+
+// formatBits 计算M级纠错和给定掩模的15位格式信息
+func formatBits(mask int) int {
+	data := 0b00<<3 | mask // M级的纠错等级指示符为00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawVersion 版本7及以上绘制两份版本信息
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	bits := versionBits(c.Version)
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 != 0
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(b, a, dark)
+		c.setFunction(a, b, dark)
+	}
+}
+
+// versionBits 计算18位版本信息
+func versionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	return version<<12 | rem
+}
+
+// drawCodewords 按之字形顺序从右下角开始放置数据，剩余位为0
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // 跳过竖直的定时图形
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			row := vert
+			if upward {
+				row = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				col := right - j
+				if c.function[row][col] {
+					continue
+				}
+				if i < len(codewords)*8 {
+					c.modules[row][col] = codewords[i>>3]>>(7-i&7)&1 != 0
+				}
+				i++
+			}
+		}
+	}
+}
+
+// applyBestMask 尝试8种掩模，使用罚分最低的一种
+func (c *Code) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if penalty := c.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		c.applyMask(mask) // 异或两次即还原
+	}
+	c.applyMask(best)
+	c.drawFormatBits(best)
+}
+
+// applyMask 对数据区域应用掩模
+func (c *Code) applyMask(mask int) {
+	for row := 0; row < c.Size; row++ {
+		for col := 0; col < c.Size; col++ {
+			if !c.function[row][col] && maskBit(mask, row, col) {
+				c.modules[row][col] = !c.modules[row][col]
+			}
+		}
+	}
+}
+
+// maskBit 判断掩模在该位置是否翻转模块
+func maskBit(mask, i, j int) bool {
+	switch mask {
+	case 0:
+		return (i+j)%2 == 0
+	case 1:
+		return i%2 == 0
+	case 2:
+		return j%3 == 0
+	case 3:
+		return (i+j)%3 == 0
+	case 4:
+		return (i/2+j/3)%2 == 0
+	case 5:
+		return i*j%2+i*j%3 == 0
+	case 6:
+		return (i*j%2+i*j%3)%2 == 0
+	default:
+		return ((i+j)%2+i*j%3)%2 == 0
+	}
+}
+
+// penalty 按规范的四条规则计算掩模罚分
+func (c *Code) penalty() int {
+	penalty := 0
+	finderLike := []bool{true, false, true, true, true, false, true}
+
+	for _, vertical := range []bool{false, true} {
+		for a := 0; a < c.Size; a++ {
+			line := make([]bool, c.Size)
+			for b := range line {
+				if vertical {
+					line[b] = c.modules[b][a]
+				} else {
+					line[b] = c.modules[a][b]
+				}
+			}
+
+			// 规则1：连续5个及以上同色模块
+			run := 1
+			for b := 1; b <= c.Size; b++ {
+				if b < c.Size && line[b] == line[b-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+
+			// 规则3：类似定位图形的 1:1:3:1:1 序列，一侧有4个浅色模块
+			for b := 0; b+7 <= c.Size; b++ {
+				if !matches(line[b:b+7], finderLike) {
+					continue
+				}
+				if lightRun(line, b-4, b) || lightRun(line, b+7, b+11) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	// 规则2：2×2的同色块
+	dark := 0
+	for row := 0; row < c.Size; row++ {
+		for col := 0; col < c.Size; col++ {
+			if c.modules[row][col] {
+				dark++
+			}
+			if row+1 < c.Size && col+1 < c.Size {
+				v := c.modules[row][col]
+				if c.modules[row][col+1] == v && c.modules[row+1][col] == v && c.modules[row+1][col+1] == v {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// 规则4：深色模块比例偏离50%
+	total := c.Size * c.Size
+	deviation := abs(dark*20 - total*10)
+	penalty += deviation / total * 10
+	return penalty
+}
+
+// setFunction 设置功能图形模块
+func (c *Code) setFunction(row, col int, dark bool) {
+	c.modules[row][col] = dark
+	c.function[row][col] = true
+}
+
+// matches 判断两段模块是否相同
+func matches(a, b []bool) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lightRun 判断[from, to)是否全为浅色，超出边界的部分视为空白区
+func lightRun(line []bool, from, to int) bool {
+	for i := from; i < to; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
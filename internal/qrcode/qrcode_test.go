@@ -0,0 +1,126 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// 规范附录中 "HELLO WORLD" 1-M 的数据码字和纠错码字
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomonRemainder(data, reedSolomonDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("纠错码字 = %v, 期望 %v", got, want)
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	if got := formatBits(0); got != 0b101010000010010 {
+		t.Errorf("M级掩模0的格式信息 = %015b", got)
+	}
+	if got := formatBits(5); got != 0b100000011001110 {
+		t.Errorf("M级掩模5的格式信息 = %015b", got)
+	}
+	if got := versionBits(7); got != 0x07C94 {
+		t.Errorf("版本7的版本信息 = %#x", got)
+	}
+	if got := versionBits(10); got != 0x0A4D3 {
+		t.Errorf("版本10的版本信息 = %#x", got)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, text := range []string{
+		"https://192.168.1.20:8081/pair?code=ABCD2345",
+		strings.Repeat("screensage", 20), // 版本8，分两组
+	} {
+		c, err := Encode(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 从图形中读出格式信息，还原掩模并按放置顺序读回码字
+		format := 0
+		for i := 0; i <= 5; i++ {
+			format |= b2i(c.Dark(i, 8)) << i
+		}
+		format |= b2i(c.Dark(7, 8))<<6 | b2i(c.Dark(8, 8))<<7 | b2i(c.Dark(8, 7))<<8
+		for i := 9; i < 15; i++ {
+			format |= b2i(c.Dark(8, 14-i)) << i
+		}
+		mask := -1
+		for m := 0; m < 8; m++ {
+			if formatBits(m) == format {
+				mask = m
+			}
+		}
+		if mask < 0 {
+			t.Fatalf("无法识别格式信息 %015b", format)
+		}
+
+		c.applyMask(mask)
+		var read bitBuffer
+		for right := c.Size - 1; right >= 1; right -= 2 {
+			if right == 6 {
+				right = 5
+			}
+			for vert := 0; vert < c.Size; vert++ {
+				row := vert
+				if (right+1)&2 == 0 {
+					row = c.Size - 1 - vert
+				}
+				for j := 0; j < 2; j++ {
+					if !c.function[row][right-j] {
+						read = append(read, c.modules[row][right-j])
+					}
+				}
+			}
+		}
+		c.applyMask(mask)
+
+		want := c.addErrorCorrection(encodeData([]byte(text), c.Version))
+		if got := read[:len(want)*8].bytes(); !bytes.Equal(got, want) {
+			t.Errorf("版本%d: 读回的码字与编码结果不一致", c.Version)
+		}
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := Encode(strings.Repeat("x", 300)); err == nil {
+		t.Error("超出容量时应报错")
+	}
+}
+
+func TestPNG(t *testing.T) {
+	c, err := Encode("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.PNG(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width := (c.Size + quietZone*2) * 4; img.Bounds().Dx() != width {
+		t.Errorf("图片宽度 = %d, 期望 %d", img.Bounds().Dx(), width)
+	}
+	// 左上角定位图形的外框为深色，空白区为浅色
+	if r, _, _, _ := img.At(quietZone*4, quietZone*4).RGBA(); r != 0 {
+		t.Error("定位图形应为深色")
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Error("空白区应为浅色")
+	}
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
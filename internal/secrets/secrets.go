@@ -0,0 +1,309 @@
+// Package secrets 使用AES-GCM加密保存API密钥等敏感配置
+//
+// 加密密钥来自用户口令（PBKDF2-SHA256派生）或本机随机生成的密钥文件，
+// 密文与加密方式一起保存在 secrets.enc 中。
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// FileName 加密密钥文件名
+	FileName = "secrets.enc"
+	// KeyFileName 本机密钥文件名，未设置口令时使用
+	KeyFileName = "secret.key"
+	// PassphraseEnv 设置后使用该口令派生加密密钥
+	PassphraseEnv = "SCREENSAGE_PASSPHRASE"
+
+	kdfPassphrase = "passphrase"
+	kdfKeyFile    = "keyfile"
+
+	envelopeVersion  = 1
+	pbkdf2Iterations = 600000
+	keySize          = 32
+	saltSize         = 16
+)
+
+// additionalData 参与认证的附加数据，防止密文被挪作他用
+var additionalData = []byte("screensage-secrets-v1")
+
+// ErrDecrypt 口令或本机密钥不正确，无法解密
+var ErrDecrypt = errors.New("无法解密密钥，口令或本机密钥文件不正确")
+
+// envelope 加密文件的结构
+type envelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"` // passphrase 或 keyfile
+	Salt       string `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Nonce      string `json:"nonce"`
+	Data       string `json:"data"`
+}
+
+// Store 保存在指定目录中的加密密钥存储
+type Store struct {
+	Dir        string // secrets.enc 和 secret.key 所在目录
+	Passphrase string // 口令，为空时使用本机密钥文件
+}
+
+// NewStore 创建加密密钥存储
+func NewStore(dir, passphrase string) *Store {
+	return &Store{Dir: dir, Passphrase: passphrase}
+}
+
+// Path 返回加密文件路径
+func (s *Store) Path() string {
+	return filepath.Join(s.Dir, FileName)
+}
+
+// keyPath 返回本机密钥文件路径
+func (s *Store) keyPath() string {
+	return filepath.Join(s.Dir, KeyFileName)
+}
+
+// Exists 判断加密文件是否存在
+func (s *Store) Exists() bool {
+	_, err := os.Stat(s.Path())
+	return err == nil
+}
+
+// Load 读取并解密全部密钥，加密文件不存在时返回空集合
+func (s *Store) Load() (map[string]string, error) {
+	values := make(map[string]string)
+
+	env, err := s.readEnvelope()
+	if err != nil || env == nil {
+		return values, err
+	}
+
+	key, err := s.keyFor(env, false)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decrypt(key, env)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("解析密钥数据失败: %v", err)
+	}
+	return values, nil
+}
+
+// Save 加密保存全部密钥
+// 已有加密文件时沿用其加密方式，否则有口令时使用口令，没有时使用本机密钥文件
+func (s *Store) Save(values map[string]string) error {
+	env, err := s.readEnvelope()
+	if err != nil {
+		return err
+	}
+	if env == nil {
+		env = &envelope{KDF: kdfKeyFile}
+		if s.Passphrase != "" {
+			env.KDF = kdfPassphrase
+		}
+	}
+	return s.write(env.KDF, values, false)
+}
+
+// Rotate 更换加密密钥并重新加密全部密钥
+// newPassphrase 为空时改用新生成的本机密钥文件，否则改用新口令
+func (s *Store) Rotate(newPassphrase string) error {
+	values, err := s.Load()
+	if err != nil {
+		return err
+	}
+
+	s.Passphrase = newPassphrase
+	if newPassphrase == "" {
+		return s.write(kdfKeyFile, values, true)
+	}
+	if err := s.write(kdfPassphrase, values, false); err != nil {
+		return err
+	}
+	// 改用口令后旧的本机密钥文件不再需要
+	if err := os.Remove(s.keyPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除旧的本机密钥文件失败: %v", err)
+	}
+	return nil
+}
+
+// write 使用指定的加密方式加密并写入密钥，newKey 为 true 时重新生成本机密钥文件
+func (s *Store) write(kdf string, values map[string]string, newKey bool) error {
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("编码密钥数据失败: %v", err)
+	}
+
+	env := &envelope{Version: envelopeVersion, KDF: kdf}
+	if kdf == kdfPassphrase {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("生成随机盐失败: %v", err)
+		}
+		env.Salt = base64.StdEncoding.EncodeToString(salt)
+		env.Iterations = pbkdf2Iterations
+	}
+	if newKey {
+		if err := os.Remove(s.keyPath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除旧的本机密钥文件失败: %v", err)
+		}
+	}
+
+	key, err := s.keyFor(env, true)
+	if err != nil {
+		return err
+	}
+	if err := encrypt(key, plaintext, env); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return fmt.Errorf("编码加密文件失败: %v", err)
+	}
+	return writeFileAtomic(s.Path(), data)
+}
+
+// readEnvelope 读取加密文件，文件不存在时返回nil
+func (s *Store) readEnvelope() (*envelope, error) {
+	data, err := os.ReadFile(s.Path())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取加密密钥文件失败: %v", err)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("解析加密密钥文件失败: %v", err)
+	}
+	if env.Version != envelopeVersion {
+		return nil, fmt.Errorf("不支持的加密密钥文件版本: %d", env.Version)
+	}
+	return &env, nil
+}
+
+// keyFor 按加密方式获取加密密钥，create 为 true 时本机密钥文件不存在则生成
+func (s *Store) keyFor(env *envelope, create bool) ([]byte, error) {
+	switch env.KDF {
+	case kdfPassphrase:
+		if s.Passphrase == "" {
+			return nil, fmt.Errorf("密钥使用口令加密，请设置环境变量 %s", PassphraseEnv)
+		}
+		salt, err := base64.StdEncoding.DecodeString(env.Salt)
+		if err != nil {
+			return nil, fmt.Errorf("解析随机盐失败: %v", err)
+		}
+		key, err := pbkdf2.Key(sha256.New, s.Passphrase, salt, env.Iterations, keySize)
+		if err != nil {
+			return nil, fmt.Errorf("派生加密密钥失败: %v", err)
+		}
+		return key, nil
+
+	case kdfKeyFile:
+		key, err := os.ReadFile(s.keyPath())
+		if os.IsNotExist(err) && create {
+			return s.createKeyFile()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取本机密钥文件失败: %v", err)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("本机密钥文件已损坏")
+		}
+		return key, nil
+
+	default:
+		return nil, fmt.Errorf("未知的加密方式: %s", env.KDF)
+	}
+}
+
+// createKeyFile 生成新的本机密钥文件，仅当前用户可读
+func (s *Store) createKeyFile() ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("生成本机密钥失败: %v", err)
+	}
+	if err := writeFileAtomic(s.keyPath(), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// encrypt 使用AES-GCM加密，并把随机数和密文写入env
+func encrypt(key, plaintext []byte, env *envelope) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("生成随机数失败: %v", err)
+	}
+	env.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	env.Data = base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, additionalData))
+	return nil
+}
+
+// decrypt 使用AES-GCM解密env中的密文
+func decrypt(key []byte, env *envelope) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil || len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("加密密钥文件已损坏")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Data)
+	if err != nil {
+		return nil, fmt.Errorf("加密密钥文件已损坏")
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// newGCM 创建AES-GCM加密器
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("创建加密器失败: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("创建加密器失败: %v", err)
+	}
+	return gcm, nil
+}
+
+// writeFileAtomic 先写入临时文件再重命名，避免写入中断导致文件损坏
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+	return nil
+}
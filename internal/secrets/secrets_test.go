@@ -0,0 +1,86 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStore_KeyFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir, "")
+
+	values := map[string]string{"deepseek_api_key": "sk-1234567890"}
+	if err := store.Save(values); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	data, err := os.ReadFile(store.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "sk-1234567890") {
+		t.Fatal("加密文件中不应出现明文密钥")
+	}
+	if info, err := os.Stat(store.keyPath()); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("本机密钥文件应为0600: %v %v", info, err)
+	}
+
+	got, err := NewStore(dir, "").Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got["deepseek_api_key"] != "sk-1234567890" {
+		t.Errorf("Load() = %v", got)
+	}
+}
+
+func TestStore_Passphrase(t *testing.T) {
+	dir := t.TempDir()
+	if err := NewStore(dir, "correct horse").Save(map[string]string{"baidu_secret_key": "s3cret"}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	if _, err := NewStore(dir, "wrong").Load(); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("口令错误时应返回ErrDecrypt, got %v", err)
+	}
+	if _, err := NewStore(dir, "").Load(); err == nil {
+		t.Error("未提供口令时应报错")
+	}
+
+	got, err := NewStore(dir, "correct horse").Load()
+	if err != nil || got["baidu_secret_key"] != "s3cret" {
+		t.Errorf("Load() = %v, %v", got, err)
+	}
+}
+
+func TestStore_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(dir, "")
+	if err := store.Save(map[string]string{"vision_api_key": "v-key"}); err != nil {
+		t.Fatal(err)
+	}
+	oldKey, _ := os.ReadFile(store.keyPath())
+
+	// 更换为新的本机密钥
+	if err := store.Rotate(""); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	newKey, _ := os.ReadFile(store.keyPath())
+	if string(oldKey) == string(newKey) {
+		t.Error("轮换后本机密钥应当改变")
+	}
+
+	// 改用口令，本机密钥文件应被删除
+	if err := store.Rotate("new passphrase"); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if _, err := os.Stat(store.keyPath()); !os.IsNotExist(err) {
+		t.Error("改用口令后应删除本机密钥文件")
+	}
+	got, err := NewStore(dir, "new passphrase").Load()
+	if err != nil || got["vision_api_key"] != "v-key" {
+		t.Errorf("Load() = %v, %v", got, err)
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// AnswerVersion 表示记录重新生成回答之前的一个回答
+type AnswerVersion struct {
+	ID            int64     `json:"id"`
+	HistoryID     int64     `json:"history_id"`
+	ReplacedAt    time.Time `json:"replaced_at"` // 被新回答替换的时间
+	Answer        string    `json:"answer"`
+	Title         string    `json:"title"`
+	Source        string    `json:"source"` // 回答来源: ocr、image 或 text
+	PromptProfile string    `json:"prompt_profile"`
+	PromptVersion int       `json:"prompt_version"`
+
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// initVersionTables 初始化回答版本表
+func (m *DBManager) initVersionTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS answer_versions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		history_id INTEGER NOT NULL,
+		replaced_at DATETIME NOT NULL,
+		answer TEXT NOT NULL,
+		title TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT 'ocr',
+		prompt_profile TEXT NOT NULL DEFAULT '',
+		prompt_version INTEGER NOT NULL DEFAULT 0,
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		cost REAL NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_answer_versions_history ON answer_versions (history_id);
+	`
+	if _, err := m.db.Exec(query); err != nil {
+		return fmt.Errorf("创建回答版本表失败: %v", err)
+	}
+	return nil
+}
+
+// addAnswerVersion 保存记录被替换的回答并设置ID，费用只包含生成回答的部分
+// 由 UpdateHistory 在更新记录的事务中调用，避免记录更新失败时留下重复的版本
+func addAnswerVersion(db execer, version *AnswerVersion) error {
+	query := `
+	INSERT INTO answer_versions (history_id, replaced_at, answer, title, source, prompt_profile, prompt_version,
+		prompt_tokens, completion_tokens, cost)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`
+	result, err := db.Exec(
+		query,
+		version.HistoryID,
+		version.ReplacedAt,
+		version.Answer,
+		version.Title,
+		sourceOrDefault(version.Source),
+		version.PromptProfile,
+		version.PromptVersion,
+		version.PromptTokens,
+		version.CompletionTokens,
+		version.Cost,
+	)
+	if err != nil {
+		return fmt.Errorf("保存回答版本失败: %v", err)
+	}
+	if version.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("获取回答版本ID失败: %v", err)
+	}
+	return nil
+}
+
+// ListAnswerVersions 获取记录之前的回答，按替换时间从旧到新排列
+func (m *DBManager) ListAnswerVersions(historyID int64) ([]AnswerVersion, error) {
+	query := `
+	SELECT id, history_id, replaced_at, answer, title, source, prompt_profile, prompt_version,
+		prompt_tokens, completion_tokens, cost
+	FROM answer_versions
+	WHERE history_id = ?
+	ORDER BY id;
+	`
+	rows, err := m.db.Query(query, historyID)
+	if err != nil {
+		return nil, fmt.Errorf("查询回答版本失败: %v", err)
+	}
+	defer rows.Close()
+
+	versions := []AnswerVersion{}
+	for rows.Next() {
+		var version AnswerVersion
+		if err := rows.Scan(
+			&version.ID,
+			&version.HistoryID,
+			&version.ReplacedAt,
+			&version.Answer,
+			&version.Title,
+			&version.Source,
+			&version.PromptProfile,
+			&version.PromptVersion,
+			&version.PromptTokens,
+			&version.CompletionTokens,
+			&version.Cost,
+		); err != nil {
+			return nil, fmt.Errorf("解析回答版本失败: %v", err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("查询回答版本失败: %v", err)
+	}
+	return versions, nil
+}
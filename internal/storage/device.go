@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Device 表示一台通过扫码配对的设备
+type Device struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	UserAgent  string    `json:"user_agent"`
+	TokenHash  string    `json:"-"` // 设备令牌的SHA-256，不保存令牌本身
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// initDeviceTables 初始化已配对设备表
+func (m *DBManager) initDeviceTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS devices (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		user_agent TEXT NOT NULL DEFAULT '',
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL,
+		last_seen_at DATETIME NOT NULL
+	);
+	`
+	if _, err := m.db.Exec(query); err != nil {
+		return fmt.Errorf("创建设备表失败: %v", err)
+	}
+	return nil
+}
+
+// AddDevice 保存新配对的设备
+func (m *DBManager) AddDevice(name, userAgent, tokenHash string) (*Device, error) {
+	now := time.Now()
+	query := `
+	INSERT INTO devices (name, user_agent, token_hash, created_at, last_seen_at)
+	VALUES (?, ?, ?, ?, ?);
+	`
+	result, err := m.db.Exec(query, name, userAgent, tokenHash, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("保存设备失败: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("获取设备ID失败: %v", err)
+	}
+	return &Device{ID: id, Name: name, UserAgent: userAgent, TokenHash: tokenHash, CreatedAt: now, LastSeenAt: now}, nil
+}
+
+// GetDeviceByTokenHash 根据令牌哈希查找设备，不存在时返回 sql.ErrNoRows
+func (m *DBManager) GetDeviceByTokenHash(tokenHash string) (*Device, error) {
+	query := `
+	SELECT id, name, user_agent, token_hash, created_at, last_seen_at
+	FROM devices
+	WHERE token_hash = ?;
+	`
+	var device Device
+	err := m.db.QueryRow(query, tokenHash).Scan(
+		&device.ID,
+		&device.Name,
+		&device.UserAgent,
+		&device.TokenHash,
+		&device.CreatedAt,
+		&device.LastSeenAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询设备失败: %v", err)
+	}
+	return &device, nil
+}
+
+// TouchDevice 更新设备的最后访问时间
+func (m *DBManager) TouchDevice(id int64, at time.Time) error {
+	if _, err := m.db.Exec(`UPDATE devices SET last_seen_at = ? WHERE id = ?;`, at, id); err != nil {
+		return fmt.Errorf("更新设备访问时间失败: %v", err)
+	}
+	return nil
+}
+
+// ListDevices 获取全部已配对设备，最近访问的在前
+func (m *DBManager) ListDevices() ([]Device, error) {
+	query := `
+	SELECT id, name, user_agent, token_hash, created_at, last_seen_at
+	FROM devices
+	ORDER BY last_seen_at DESC;
+	`
+	rows, err := m.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("查询设备失败: %v", err)
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		var device Device
+		if err := rows.Scan(
+			&device.ID,
+			&device.Name,
+			&device.UserAgent,
+			&device.TokenHash,
+			&device.CreatedAt,
+			&device.LastSeenAt,
+		); err != nil {
+			return nil, fmt.Errorf("解析设备失败: %v", err)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// DeleteDevice 删除设备，使其令牌失效，设备不存在时返回false
+func (m *DBManager) DeleteDevice(id int64) (bool, error) {
+	result, err := m.db.Exec(`DELETE FROM devices WHERE id = ?;`, id)
+	if err != nil {
+		return false, fmt.Errorf("删除设备失败: %v", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("删除设备失败: %v", err)
+	}
+	return affected > 0, nil
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PromptProfile 表示一组命名的提示词模板
+type PromptProfile struct {
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	SystemTemplate string    `json:"system_template"`
+	UserTemplate   string    `json:"user_template"`
+	Version        int       `json:"version"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// initPromptTables 初始化提示词配置表
+func (m *DBManager) initPromptTables() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS prompt_profiles (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL DEFAULT '',
+		system_template TEXT NOT NULL,
+		user_template TEXT NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1,
+		updated_at DATETIME NOT NULL
+	);
+	`
+	if _, err := m.db.Exec(query); err != nil {
+		return fmt.Errorf("创建提示词配置表失败: %v", err)
+	}
+	return nil
+}
+
+// SeedPromptProfiles 写入内置提示词配置，已存在的同名配置保持不变
+func (m *DBManager) SeedPromptProfiles(profiles []PromptProfile) error {
+	query := `
+	INSERT OR IGNORE INTO prompt_profiles (name, description, system_template, user_template, version, updated_at)
+	VALUES (?, ?, ?, ?, 1, ?);
+	`
+	for _, profile := range profiles {
+		if _, err := m.db.Exec(query, profile.Name, profile.Description, profile.SystemTemplate, profile.UserTemplate, time.Now()); err != nil {
+			return fmt.Errorf("写入内置提示词配置失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// ListPromptProfiles 获取全部提示词配置
+func (m *DBManager) ListPromptProfiles() ([]PromptProfile, error) {
+	query := `
+	SELECT name, description, system_template, user_template, version, updated_at
+	FROM prompt_profiles
+	ORDER BY name;
+	`
+	rows, err := m.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("查询提示词配置失败: %v", err)
+	}
+	defer rows.Close()
+
+	var profiles []PromptProfile
+	for rows.Next() {
+		var profile PromptProfile
+		if err := rows.Scan(
+			&profile.Name,
+			&profile.Description,
+			&profile.SystemTemplate,
+			&profile.UserTemplate,
+			&profile.Version,
+			&profile.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("解析提示词配置失败: %v", err)
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+// GetPromptProfile 根据名称获取提示词配置，不存在时返回 sql.ErrNoRows
+func (m *DBManager) GetPromptProfile(name string) (*PromptProfile, error) {
+	query := `
+	SELECT name, description, system_template, user_template, version, updated_at
+	FROM prompt_profiles
+	WHERE name = ?;
+	`
+	var profile PromptProfile
+	err := m.db.QueryRow(query, name).Scan(
+		&profile.Name,
+		&profile.Description,
+		&profile.SystemTemplate,
+		&profile.UserTemplate,
+		&profile.Version,
+		&profile.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取提示词配置失败: %v", err)
+	}
+
+	return &profile, nil
+}
+
+// SavePromptProfile 新建或更新提示词配置
+// 模板内容发生变化时版本号加一，返回保存后的配置
+func (m *DBManager) SavePromptProfile(profile PromptProfile) (*PromptProfile, error) {
+	existing, err := m.GetPromptProfile(profile.Name)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	profile.UpdatedAt = time.Now()
+	if existing == nil {
+		profile.Version = 1
+		query := `
+		INSERT INTO prompt_profiles (name, description, system_template, user_template, version, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?);
+		`
+		if _, err := m.db.Exec(query, profile.Name, profile.Description, profile.SystemTemplate, profile.UserTemplate, profile.Version, profile.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("新建提示词配置失败: %v", err)
+		}
+		return &profile, nil
+	}
+
+	profile.Version = existing.Version
+	if existing.SystemTemplate != profile.SystemTemplate || existing.UserTemplate != profile.UserTemplate {
+		profile.Version++
+	}
+	query := `
+	UPDATE prompt_profiles
+	SET description = ?, system_template = ?, user_template = ?, version = ?, updated_at = ?
+	WHERE name = ?;
+	`
+	if _, err := m.db.Exec(query, profile.Description, profile.SystemTemplate, profile.UserTemplate, profile.Version, profile.UpdatedAt, profile.Name); err != nil {
+		return nil, fmt.Errorf("更新提示词配置失败: %v", err)
+	}
+	return &profile, nil
+}
@@ -4,7 +4,10 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"path/filepath"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -16,8 +19,24 @@ type HistoryRecord struct {
 	ImagePath string         `json:"image_path"`
 	Thumbnail string         `json:"thumbnail"`
 	Text      string         `json:"text"`
+	RawText   string         `json:"raw_text"` // OCR原始文本，Text为清洗后的文本
 	Answer    string         `json:"answer"`
-	Title     sql.NullString `json:"title"` // 修改此处
+	Title     sql.NullString `json:"title"`  // 修改此处
+	Source    string         `json:"source"` // 回答来源: ocr、image、followup 或 text
+	Status    string         `json:"status"` // 处理状态: done 或 pending
+
+	OCRStatus    string `json:"ocr_status"`             // OCR阶段状态: pending、ok、failed 或 skipped
+	OCRError     string `json:"ocr_error,omitempty"`    // OCR失败的原因
+	AnswerStatus string `json:"answer_status"`          // 回答阶段状态: pending、ok 或 failed
+	AnswerError  string `json:"answer_error,omitempty"` // 生成回答失败的原因
+
+	PromptProfile string `json:"prompt_profile"` // 生成回答所用的提示词配置
+	PromptVersion int    `json:"prompt_version"` // 提示词模板版本
+
+	PromptTokens     int     `json:"prompt_tokens"`     // 模型输入token数
+	CompletionTokens int     `json:"completion_tokens"` // 模型输出token数
+	OCRCalls         int     `json:"ocr_calls"`         // OCR调用次数
+	Cost             float64 `json:"cost"`              // 估算费用（元）
 }
 
 // DBManager 数据库管理器
@@ -64,8 +83,21 @@ func (m *DBManager) initTables() error {
 		image_path TEXT NOT NULL,
 		thumbnail TEXT NOT NULL,
 		text TEXT NOT NULL,
+		raw_text TEXT NOT NULL DEFAULT '',
 		answer TEXT NOT NULL,
-		title TEXT
+		title TEXT,
+		source TEXT NOT NULL DEFAULT 'ocr',
+		prompt_profile TEXT NOT NULL DEFAULT '',
+		prompt_version INTEGER NOT NULL DEFAULT 0,
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		ocr_calls INTEGER NOT NULL DEFAULT 0,
+		cost REAL NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'done',
+		ocr_status TEXT NOT NULL DEFAULT 'ok',
+		ocr_error TEXT NOT NULL DEFAULT '',
+		answer_status TEXT NOT NULL DEFAULT 'ok',
+		answer_error TEXT NOT NULL DEFAULT ''
 	);
 	`
 
@@ -74,43 +106,100 @@ func (m *DBManager) initTables() error {
 		return fmt.Errorf("创建历史记录表失败: %v", err)
 	}
 
-	// 检查title列是否存在，如果不存在则添加
-	query = `
-	PRAGMA table_info(history);
-	`
-	rows, err := m.db.Query(query)
+	// 补齐旧版本数据库缺少的列
+	if err := m.ensureColumn("history", "title", "TEXT"); err != nil {
+		return err
+	}
+	if err := m.ensureColumn("history", "source", "TEXT NOT NULL DEFAULT 'ocr'"); err != nil {
+		return err
+	}
+	if err := m.ensureColumn("history", "raw_text", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := m.ensureColumn("history", "prompt_profile", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := m.ensureColumn("history", "prompt_version", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := m.ensureColumn("history", "prompt_tokens", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := m.ensureColumn("history", "completion_tokens", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := m.ensureColumn("history", "ocr_calls", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := m.ensureColumn("history", "cost", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := m.ensureColumn("history", "status", "TEXT NOT NULL DEFAULT 'done'"); err != nil {
+		return err
+	}
+	for _, column := range []string{"ocr_status", "answer_status"} {
+		if err := m.ensureColumn("history", column, "TEXT NOT NULL DEFAULT 'ok'"); err != nil {
+			return err
+		}
+	}
+	for _, column := range []string{"ocr_error", "answer_error"} {
+		if err := m.ensureColumn("history", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	if err := m.migrateStageStatus(); err != nil {
+		return err
+	}
+
+	if err := m.initPromptTables(); err != nil {
+		return err
+	}
+	if err := m.initVersionTables(); err != nil {
+		return err
+	}
+	if err := m.initUsageTables(); err != nil {
+		return err
+	}
+	if err := m.initDeviceTables(); err != nil {
+		return err
+	}
+
+	log.Println("数据库表初始化成功")
+	return nil
+}
+
+// ensureColumn 检查表中是否存在指定列，如果不存在则添加
+func (m *DBManager) ensureColumn(table, column, definition string) error {
+	rows, err := m.db.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
 	if err != nil {
 		return fmt.Errorf("检查表结构失败: %v", err)
 	}
-	defer rows.Close()
 
-	titleExists := false
+	exists := false
 	for rows.Next() {
 		var cid, notnull, pk int
 		var name, type_name string
 		var dflt_value sql.NullString
 		if err := rows.Scan(&cid, &name, &type_name, &notnull, &dflt_value, &pk); err != nil {
+			rows.Close()
 			return fmt.Errorf("读取表结构失败: %v", err)
 		}
-		if name == "title" {
-			titleExists = true
+		if name == column {
+			exists = true
 			break
 		}
 	}
+	rows.Close()
 
-	if !titleExists {
-		// 添加title列
-		query = `
-		ALTER TABLE history ADD COLUMN title TEXT;
-		`
-		_, err = m.db.Exec(query)
-		if err != nil {
-			return fmt.Errorf("添加title列失败: %v", err)
-		}
-		log.Println("添加title列成功")
+	if exists {
+		return nil
 	}
 
-	log.Println("数据库表初始化成功")
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition)
+	if _, err := m.db.Exec(query); err != nil {
+		return fmt.Errorf("添加%s列失败: %v", column, err)
+	}
+	log.Printf("添加%s列成功", column)
 	return nil
 }
 
@@ -118,19 +207,40 @@ func (m *DBManager) initTables() error {
 func (m *DBManager) AddHistory(record *HistoryRecord) (int64, error) {
 	// 准备SQL语句
 	query := `
-	INSERT INTO history (timestamp, image_path, thumbnail, text, answer, title)
-	VALUES (?, ?, ?, ?, ?, ?);
+	INSERT INTO history (timestamp, image_path, thumbnail, text, raw_text, answer, title, source, prompt_profile, prompt_version,
+		prompt_tokens, completion_tokens, ocr_calls, cost, status, ocr_status, ocr_error, answer_status, answer_error)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
 	`
 
+	// 记录和首次处理的用量在同一个事务中写入
+	tx, err := m.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("插入历史记录失败: %v", err)
+	}
+	defer tx.Rollback()
+
 	// 执行插入
-	result, err := m.db.Exec(
+	result, err := tx.Exec(
 		query,
 		record.Timestamp,
 		record.ImagePath,
 		record.Thumbnail,
 		record.Text,
+		record.RawText,
 		record.Answer,
 		record.Title,
+		sourceOrDefault(record.Source),
+		record.PromptProfile,
+		record.PromptVersion,
+		record.PromptTokens,
+		record.CompletionTokens,
+		record.OCRCalls,
+		record.Cost,
+		statusOrDefault(record.Status),
+		stageOrDefault(record.OCRStatus),
+		record.OCRError,
+		stageOrDefault(record.AnswerStatus),
+		record.AnswerError,
 	)
 	if err != nil {
 		return 0, fmt.Errorf("插入历史记录失败: %v", err)
@@ -141,6 +251,19 @@ func (m *DBManager) AddHistory(record *HistoryRecord) (int64, error) {
 	if err != nil {
 		return 0, fmt.Errorf("获取插入ID失败: %v", err)
 	}
+	if err := addUsageEvent(tx, &UsageEvent{
+		HistoryID:        id,
+		CreatedAt:        record.Timestamp,
+		OCRCalls:         record.OCRCalls,
+		PromptTokens:     record.PromptTokens,
+		CompletionTokens: record.CompletionTokens,
+		Cost:             record.Cost,
+	}); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("插入历史记录失败: %v", err)
+	}
 
 	return id, nil
 }
@@ -149,7 +272,7 @@ func (m *DBManager) AddHistory(record *HistoryRecord) (int64, error) {
 func (m *DBManager) GetHistory(limit int) ([]HistoryRecord, error) {
 	// 准备SQL语句
 	query := `
-	SELECT id, timestamp, image_path, thumbnail, text, answer, title
+	SELECT ` + historyColumns + `
 	FROM history
 	ORDER BY timestamp DESC
 	LIMIT ?;
@@ -165,16 +288,8 @@ func (m *DBManager) GetHistory(limit int) ([]HistoryRecord, error) {
 	// 解析结果
 	var records []HistoryRecord
 	for rows.Next() {
-		var record HistoryRecord
-		if err := rows.Scan(
-			&record.ID,
-			&record.Timestamp,
-			&record.ImagePath,
-			&record.Thumbnail,
-			&record.Text,
-			&record.Answer,
-			&record.Title, // 现在是 sql.NullString
-		); err != nil {
+		record, err := scanHistory(rows)
+		if err != nil {
 			return nil, fmt.Errorf("解析历史记录失败: %v", err)
 		}
 
@@ -184,11 +299,42 @@ func (m *DBManager) GetHistory(limit int) ([]HistoryRecord, error) {
 	return records, nil
 }
 
-// GetHistoryByID 根据ID获取历史记录
+// GetHistoryPage 按ID从新到旧分页获取历史记录，before为0时从最新的记录开始，否则返回ID小于before的记录
+func (m *DBManager) GetHistoryPage(before int64, limit int) ([]HistoryRecord, error) {
+	query := `
+	SELECT ` + historyColumns + `
+	FROM history
+	WHERE ? = 0 OR id < ?
+	ORDER BY id DESC
+	LIMIT ?;
+	`
+
+	rows, err := m.db.Query(query, before, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("查询历史记录失败: %v", err)
+	}
+	defer rows.Close()
+
+	var records []HistoryRecord
+	for rows.Next() {
+		record, err := scanHistory(rows)
+		if err != nil {
+			return nil, fmt.Errorf("解析历史记录失败: %v", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("查询历史记录失败: %v", err)
+	}
+
+	return records, nil
+}
+
+// GetHistoryByID 根据ID获取历史记录，不存在时返回 sql.ErrNoRows
 func (m *DBManager) GetHistoryByID(id int64) (*HistoryRecord, error) {
 	// 准备SQL语句
 	query := `
-	SELECT id, timestamp, image_path, thumbnail, text, answer, title
+	SELECT ` + historyColumns + `
 	FROM history
 	WHERE id = ?;
 	`
@@ -197,18 +343,246 @@ func (m *DBManager) GetHistoryByID(id int64) (*HistoryRecord, error) {
 	row := m.db.QueryRow(query, id)
 
 	// 解析结果
+	record, err := scanHistory(row)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取历史记录失败: %v", err)
+	}
+
+	return &record, nil
+}
+
+// HistoryFilter 筛选历史记录的条件，为零值的条件不限制
+type HistoryFilter struct {
+	IDs          []int64   // 只包含这些记录
+	Profile      string    // 生成回答所用的提示词配置
+	Source       string    // 回答来源
+	AnswerStatus string    // 回答阶段状态，例如只选择失败的记录
+	Category     string    // 标题开头【】中的分类，例如"算法"匹配"【算法】字符串解码"
+	From         time.Time // 截图时间不早于From
+	To           time.Time // 截图时间早于To
+	Limit        int       // 最多返回的条数，为0时不限制
+}
+
+// FindHistory 获取符合条件的历史记录，按ID从旧到新排列
+func (m *DBManager) FindHistory(filter HistoryFilter) ([]HistoryRecord, error) {
+	var conditions []string
+	var args []interface{}
+	if len(filter.IDs) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(filter.IDs)), ", ")
+		conditions = append(conditions, "id IN ("+placeholders+")")
+		for _, id := range filter.IDs {
+			args = append(args, id)
+		}
+	}
+	if filter.Profile != "" {
+		conditions = append(conditions, "prompt_profile = ?")
+		args = append(args, filter.Profile)
+	}
+	if filter.Source != "" {
+		conditions = append(conditions, "source = ?")
+		args = append(args, filter.Source)
+	}
+	if filter.AnswerStatus != "" {
+		conditions = append(conditions, "answer_status = ?")
+		args = append(args, filter.AnswerStatus)
+	}
+	// 按前缀比较而不用LIKE，分类中的 % 和 _ 不会被当作通配符
+	if category := strings.TrimSuffix(strings.TrimPrefix(filter.Category, "【"), "】"); category != "" {
+		tag := "【" + category + "】"
+		conditions = append(conditions, "substr(title, 1, ?) = ?")
+		args = append(args, utf8.RuneCountInString(tag), tag)
+	}
+	// 使用julianday比较时间，避免时区偏移不同导致按字符串比较出错
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "julianday(timestamp) >= julianday(?)")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "julianday(timestamp) < julianday(?)")
+		args = append(args, filter.To)
+	}
+
+	query := `SELECT ` + historyColumns + ` FROM history`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("查询历史记录失败: %v", err)
+	}
+	defer rows.Close()
+
+	var records []HistoryRecord
+	for rows.Next() {
+		record, err := scanHistory(rows)
+		if err != nil {
+			return nil, fmt.Errorf("解析历史记录失败: %v", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("查询历史记录失败: %v", err)
+	}
+	return records, nil
+}
+
+// UpdateHistory 重新处理后更新记录的识别结果、回答、用量和各阶段状态
+// replaced 为被新回答替换的原回答，spent 为本次重新处理的用量，
+// 两者与记录在同一个事务中写入，为nil时不写入
+func (m *DBManager) UpdateHistory(record *HistoryRecord, replaced *AnswerVersion, spent *UsageEvent) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("更新历史记录失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE history SET text = ?, raw_text = ?, answer = ?, title = ?, source = ?, prompt_profile = ?, prompt_version = ?,
+		prompt_tokens = ?, completion_tokens = ?, ocr_calls = ?, cost = ?, status = ?,
+		ocr_status = ?, ocr_error = ?, answer_status = ?, answer_error = ?
+	WHERE id = ?;
+	`
+	result, err := tx.Exec(
+		query,
+		record.Text,
+		record.RawText,
+		record.Answer,
+		record.Title,
+		sourceOrDefault(record.Source),
+		record.PromptProfile,
+		record.PromptVersion,
+		record.PromptTokens,
+		record.CompletionTokens,
+		record.OCRCalls,
+		record.Cost,
+		statusOrDefault(record.Status),
+		stageOrDefault(record.OCRStatus),
+		record.OCRError,
+		stageOrDefault(record.AnswerStatus),
+		record.AnswerError,
+		record.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("更新历史记录失败: %v", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	if replaced != nil {
+		replaced.HistoryID = record.ID
+		if err := addAnswerVersion(tx, replaced); err != nil {
+			return err
+		}
+	}
+	if spent != nil {
+		spent.HistoryID = record.ID
+		if err := addUsageEvent(tx, spent); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("更新历史记录失败: %v", err)
+	}
+	return nil
+}
+
+// RewriteImagePaths 将截图路径中的目录前缀oldDir替换为newDir，用于迁移截图目录后更新记录
+func (m *DBManager) RewriteImagePaths(oldDir, newDir string) (int64, error) {
+	oldPrefix := strings.TrimRight(oldDir, `/\`) + string(filepath.Separator)
+	newPrefix := strings.TrimRight(newDir, `/\`) + string(filepath.Separator)
+
+	result, err := m.db.Exec(`
+	UPDATE history SET image_path = ? || substr(image_path, length(?) + 1)
+	WHERE substr(image_path, 1, length(?)) = ?;
+	`, newPrefix, oldPrefix, oldPrefix, oldPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("更新截图路径失败: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// historyColumns 查询历史记录的列，顺序与scanHistory一致
+const historyColumns = `id, timestamp, image_path, thumbnail, text, raw_text, answer, title, source, prompt_profile, prompt_version,
+		prompt_tokens, completion_tokens, ocr_calls, cost, status, ocr_status, ocr_error, answer_status, answer_error`
+
+// scanHistory 按historyColumns的顺序解析一条历史记录
+func scanHistory(row interface{ Scan(...interface{}) error }) (HistoryRecord, error) {
 	var record HistoryRecord
-	if err := row.Scan(
+	err := row.Scan(
 		&record.ID,
 		&record.Timestamp,
 		&record.ImagePath,
 		&record.Thumbnail,
 		&record.Text,
+		&record.RawText,
 		&record.Answer,
 		&record.Title,
-	); err != nil {
-		return nil, fmt.Errorf("获取历史记录失败: %v", err)
+		&record.Source,
+		&record.PromptProfile,
+		&record.PromptVersion,
+		&record.PromptTokens,
+		&record.CompletionTokens,
+		&record.OCRCalls,
+		&record.Cost,
+		&record.Status,
+		&record.OCRStatus,
+		&record.OCRError,
+		&record.AnswerStatus,
+		&record.AnswerError,
+	)
+	return record, err
+}
+
+// migrateStageStatus 旧版本把失败写成固定文本，转换为对应阶段的失败状态；
+// 待处理记录的两个阶段都尚未执行。新记录不会再写入这些文本，重复执行不影响结果
+func (m *DBManager) migrateStageStatus() error {
+	queries := []string{
+		`UPDATE history SET ocr_status = 'failed', ocr_error = text, text = ''
+		WHERE text = 'OCR识别失败' AND ocr_status = 'ok';`,
+		`UPDATE history SET answer_status = 'failed', answer_error = answer, answer = ''
+		WHERE answer = '无法生成回答' AND answer_status = 'ok';`,
+		`UPDATE history SET ocr_status = 'pending', answer_status = 'pending'
+		WHERE status = 'pending' AND ocr_status = 'ok' AND answer_status = 'ok';`,
+		`UPDATE history SET ocr_status = 'skipped'
+		WHERE source IN ('image', 'followup') AND ocr_status = 'ok' AND ocr_calls = 0;`,
+	}
+	for _, query := range queries {
+		if _, err := m.db.Exec(query); err != nil {
+			return fmt.Errorf("迁移处理状态失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// statusOrDefault 未指定状态时视为已完成
+func statusOrDefault(status string) string {
+	if status == "" {
+		return "done"
 	}
+	return status
+}
 
-	return &record, nil
+// sourceOrDefault 未指定来源时视为OCR
+func sourceOrDefault(source string) string {
+	if source == "" {
+		return "ocr"
+	}
+	return source
+}
+
+// stageOrDefault 未指定阶段状态时视为成功
+func stageOrDefault(stage string) string {
+	if stage == "" {
+		return "ok"
+	}
+	return stage
 }
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// UsageSummary 表示一段时间内的用量汇总
+type UsageSummary struct {
+	Date             string  `json:"date,omitempty"` // 日期，格式为 2006-01-02，汇总整个区间时为空
+	Requests         int     `json:"requests"`
+	OCRCalls         int     `json:"ocr_calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Add 将另一份用量累加到汇总中
+func (u *UsageSummary) Add(other UsageSummary) {
+	u.Requests += other.Requests
+	u.OCRCalls += other.OCRCalls
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.Cost += other.Cost
+}
+
+// UsageEvent 表示一次处理的用量，按处理时间计入统计
+// 新记录的首次处理在保存记录时写入，之后每次重试或重新生成各写入一条，
+// 被替换的回答的用量保留在生成它的那一条中，因此历史日期的用量不会因为重新处理而改变
+type UsageEvent struct {
+	ID               int64
+	HistoryID        int64
+	CreatedAt        time.Time
+	OCRCalls         int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+// initUsageTables 初始化用量表，首次创建时由已有的记录和回答版本生成用量
+func (m *DBManager) initUsageTables() error {
+	var exists int
+	if err := m.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'usage_events';`).Scan(&exists); err != nil {
+		return fmt.Errorf("检查用量表失败: %v", err)
+	}
+	query := `
+	CREATE TABLE IF NOT EXISTS usage_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		history_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		ocr_calls INTEGER NOT NULL DEFAULT 0,
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		cost REAL NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_usage_events_created_at ON usage_events (created_at);
+	`
+	if _, err := m.db.Exec(query); err != nil {
+		return fmt.Errorf("创建用量表失败: %v", err)
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	// 旧版本的用量保存在记录和回答版本中，每个回答按生成时间计入：
+	// 第一个回答生成于记录的时间，之后的回答生成于上一个回答被替换的时间
+	_, err := m.db.Exec(`
+	INSERT INTO usage_events (history_id, created_at, ocr_calls, prompt_tokens, completion_tokens, cost)
+	SELECT h.id, COALESCE((SELECT v.replaced_at FROM answer_versions v WHERE v.history_id = h.id ORDER BY v.id DESC LIMIT 1), h.timestamp),
+		h.ocr_calls, h.prompt_tokens, h.completion_tokens, h.cost
+	FROM history h;
+	INSERT INTO usage_events (history_id, created_at, ocr_calls, prompt_tokens, completion_tokens, cost)
+	SELECT v.history_id, COALESCE((SELECT p.replaced_at FROM answer_versions p WHERE p.history_id = v.history_id AND p.id < v.id ORDER BY p.id DESC LIMIT 1),
+		h.timestamp, v.replaced_at),
+		0, v.prompt_tokens, v.completion_tokens, v.cost
+	FROM answer_versions v LEFT JOIN history h ON h.id = v.history_id;
+	`)
+	if err != nil {
+		return fmt.Errorf("迁移用量失败: %v", err)
+	}
+	return nil
+}
+
+// execer 可以执行SQL语句的数据库或事务
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// addUsageEvent 写入一次处理的用量
+func addUsageEvent(db execer, event *UsageEvent) error {
+	_, err := db.Exec(`
+	INSERT INTO usage_events (history_id, created_at, ocr_calls, prompt_tokens, completion_tokens, cost)
+	VALUES (?, ?, ?, ?, ?, ?);
+	`, event.HistoryID, event.CreatedAt, event.OCRCalls, event.PromptTokens, event.CompletionTokens, event.Cost)
+	if err != nil {
+		return fmt.Errorf("保存用量失败: %v", err)
+	}
+	return nil
+}
+
+// GetUsage 汇总 [from, to) 时间段内的用量
+func (m *DBManager) GetUsage(from, to time.Time) (*UsageSummary, error) {
+	// 使用julianday比较时间，避免时区偏移不同导致按字符串比较出错
+	query := `
+	SELECT COUNT(*), COALESCE(SUM(ocr_calls), 0), COALESCE(SUM(prompt_tokens), 0),
+		COALESCE(SUM(completion_tokens), 0), COALESCE(SUM(cost), 0)
+	FROM usage_events
+	WHERE julianday(created_at) >= julianday(?) AND julianday(created_at) < julianday(?);
+	`
+
+	var summary UsageSummary
+	if err := m.db.QueryRow(query, from, to).Scan(
+		&summary.Requests,
+		&summary.OCRCalls,
+		&summary.PromptTokens,
+		&summary.CompletionTokens,
+		&summary.Cost,
+	); err != nil {
+		return nil, fmt.Errorf("统计用量失败: %v", err)
+	}
+	return &summary, nil
+}
+
+// GetDailyUsage 按本地日期汇总 [from, to) 时间段内的用量，没有记录的日期不返回
+func (m *DBManager) GetDailyUsage(from, to time.Time) ([]UsageSummary, error) {
+	query := `
+	SELECT created_at, ocr_calls, prompt_tokens, completion_tokens, cost
+	FROM usage_events
+	WHERE julianday(created_at) >= julianday(?) AND julianday(created_at) < julianday(?)
+	ORDER BY julianday(created_at);
+	`
+
+	rows, err := m.db.Query(query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("查询用量失败: %v", err)
+	}
+	defer rows.Close()
+
+	var days []UsageSummary
+	for rows.Next() {
+		var timestamp time.Time
+		record := UsageSummary{Requests: 1}
+		if err := rows.Scan(
+			&timestamp,
+			&record.OCRCalls,
+			&record.PromptTokens,
+			&record.CompletionTokens,
+			&record.Cost,
+		); err != nil {
+			return nil, fmt.Errorf("解析用量失败: %v", err)
+		}
+
+		// 记录按时间排序，同一天的记录总是相邻
+		date := timestamp.In(time.Local).Format("2006-01-02")
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, UsageSummary{Date: date})
+		}
+		days[len(days)-1].Add(record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("查询用量失败: %v", err)
+	}
+
+	return days, nil
+}
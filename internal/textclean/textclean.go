@@ -0,0 +1,392 @@
+// Package textclean 对OCR识别结果做确定性的后处理
+// 去除浏览器标签栏、地址栏、任务栏时钟、菜单栏等屏幕噪声，
+// 合并软换行形成段落，统一全角/半角标点，并修复代码中常见的OCR混淆字符
+package textclean
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/qujing226/screen_sage/domain/model"
+)
+
+const (
+	// 截图顶部和底部被视为浏览器/系统界面的区域比例
+	topChromeBand    = 0.08
+	bottomChromeBand = 0.06
+	// 界面区域内不超过该长度的行被视为标签、按钮等界面文字
+	chromeMaxRunes = 30
+	// 无位置信息时，达到该长度且未以标点结尾的行视为被自动折行
+	softWrapMinRunes = 20
+)
+
+// Options 清洗选项
+type Options struct {
+	// ImageWidth 与 ImageHeight 为截图尺寸，未知时为0，此时只使用文本规则
+	ImageWidth  int
+	ImageHeight int
+}
+
+var (
+	urlRe       = regexp.MustCompile(`^(?i)(https?://|www\.)\S+$|^[\w-]+(\.[\w-]+)*\.(com|cn|net|org|io|dev|edu|gov|co)(:\d+)?(/\S*)?$`)
+	clockRe     = regexp.MustCompile(`^(?i)(上午|下午)?\s*\d{1,2}:\d{2}(:\d{2})?\s*(am|pm)?$`)
+	dateRe      = regexp.MustCompile(`^\d{4}[/.-]\d{1,2}[/.-]\d{1,2}$|^\d{1,2}/\d{1,2}/\d{4}$`)
+	browserRe   = regexp.MustCompile(`(?i)\s[-—–]\s(google chrome|microsoft\s?edge|mozilla firefox|safari|brave|opera)$`)
+	listItemRe  = regexp.MustCompile(`^\s*([-*•·]|\d+[.、)]|[(（]\d+[)）]|[a-zA-Z][.)])\s*`)
+	codeStartRe = regexp.MustCompile(`^\s*(def|func|function|class|return|for|while|if|else|elif|switch|case|import|from|package|public|private|protected|static|int|long|void|var|let|const|#include|using|struct|try|catch|except)\b`)
+	callRe      = regexp.MustCompile(`^\s*[\w.]+\([^()]*\)\s*$`)
+	tokenRe     = regexp.MustCompile(`[A-Za-z0-9_]+`)
+)
+
+// menuWords 常见应用菜单栏文字
+var menuWords = map[string]bool{
+	"文件": true, "编辑": true, "查看": true, "视图": true, "历史": true, "历史记录": true,
+	"书签": true, "工具": true, "帮助": true, "窗口": true, "选择": true, "转到": true,
+	"运行": true, "终端": true, "格式": true, "插入": true,
+	"file": true, "edit": true, "view": true, "history": true, "bookmarks": true,
+	"tools": true, "help": true, "window": true, "selection": true, "go": true,
+	"run": true, "terminal": true, "format": true, "insert": true, "profiles": true, "tab": true,
+}
+
+// lonelySymbols 标签页关闭按钮、工具栏图标等被识别成的孤立符号
+var lonelySymbols = map[string]bool{
+	"×": true, "+": true, "…": true, "⋮": true, "☆": true, "★": true,
+	"<": true, ">": true, "←": true, "→": true, "↻": true, "⟳": true,
+}
+
+// codeKeywords 用于修复代码中0/o、1/l混淆的关键字表
+var codeKeywords = map[string]bool{
+	"for": true, "while": true, "if": true, "else": true, "elif": true, "return": true,
+	"def": true, "class": true, "import": true, "from": true, "func": true, "function": true,
+	"print": true, "len": true, "range": true, "self": true, "true": true, "false": true,
+	"null": true, "nil": true, "None": true, "True": true, "False": true, "int": true,
+	"void": true, "public": true, "private": true, "static": true, "const": true, "let": true,
+	"var": true, "new": true, "this": true, "break": true, "continue": true, "not": true,
+	"and": true, "or": true, "list": true, "bool": true, "long": true, "double": true,
+	"float": true, "string": true, "String": true, "vector": true, "include": true,
+}
+
+// Clean 对OCR识别出的文本行进行清洗，返回整理后的文本
+func Clean(lines []model.OCRLine, opts Options) string {
+	normalized := make([]model.OCRLine, 0, len(lines))
+	for _, line := range lines {
+		line.Text = strings.TrimSpace(normalizeWidth(line.Text))
+		if line.Text == "" {
+			continue
+		}
+		normalized = append(normalized, line)
+	}
+
+	kept := dropChrome(normalized, opts)
+	for i := range kept {
+		// 关键字本身被识别错误时（如 f0r），修复后才能看出是代码
+		if repaired := repairCode(kept[i].Text); isCodeLine(kept[i].Text) || isCodeLine(repaired) {
+			kept[i].Text = repaired
+		}
+	}
+
+	return strings.Join(mergeSoftWraps(kept), "\n")
+}
+
+// dropChrome 去除浏览器、任务栏、菜单栏等屏幕界面文字
+func dropChrome(lines []model.OCRLine, opts Options) []model.OCRLine {
+	kept := make([]model.OCRLine, 0, len(lines))
+	for i, line := range lines {
+		if isStrongNoise(line.Text) {
+			continue
+		}
+
+		if opts.ImageHeight > 0 && line.HasBox() {
+			// 位于顶部/底部界面区域的短行和网址
+			if inChromeBand(line, opts.ImageHeight) &&
+				(urlRe.MatchString(line.Text) || utf8.RuneCountInString(line.Text) <= chromeMaxRunes) {
+				continue
+			}
+		} else if urlRe.MatchString(line.Text) && (i < 3 || i >= len(lines)-3) {
+			// 没有位置信息时，只去除开头和结尾附近的网址
+			continue
+		}
+
+		kept = append(kept, line)
+	}
+	return kept
+}
+
+// inChromeBand 判断该行是否位于截图顶部或底部的界面区域
+func inChromeBand(line model.OCRLine, imageHeight int) bool {
+	center := float64(line.Top) + float64(line.Height)/2
+	return center < float64(imageHeight)*topChromeBand ||
+		center > float64(imageHeight)*(1-bottomChromeBand)
+}
+
+// isStrongNoise 判断无论出现在哪里都可以安全去除的界面文字
+func isStrongNoise(text string) bool {
+	if lonelySymbols[text] || clockRe.MatchString(text) || dateRe.MatchString(text) || browserRe.MatchString(text) {
+		return true
+	}
+
+	// 由菜单词组成的整行，如“文件 编辑 查看 历史 书签 工具 帮助”
+	fields := strings.Fields(text)
+	if len(fields) < 3 {
+		return false
+	}
+	for _, field := range fields {
+		if !menuWords[strings.ToLower(field)] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeSoftWraps 将被自动折行的句子合并为段落，代码行保持原样
+func mergeSoftWraps(lines []model.OCRLine) []string {
+	maxRight := 0
+	for _, line := range lines {
+		if right := line.Left + line.Width; right > maxRight {
+			maxRight = right
+		}
+	}
+
+	var result []string
+	for i, line := range lines {
+		if i > 0 && isSoftWrap(lines[i-1], line, maxRight) {
+			result[len(result)-1] = joinWrapped(result[len(result)-1], line.Text)
+		} else {
+			result = append(result, line.Text)
+		}
+	}
+	return result
+}
+
+// isSoftWrap 判断 next 是否是 prev 的折行延续
+func isSoftWrap(prev, next model.OCRLine, maxRight int) bool {
+	if isCodeLine(prev.Text) || isCodeLine(next.Text) {
+		return false
+	}
+	if endsSentence(prev.Text) || listItemRe.MatchString(next.Text) {
+		return false
+	}
+
+	if prev.HasBox() && next.HasBox() {
+		// 左对齐、行距正常且上一行几乎占满整行宽度
+		gap := next.Top - (prev.Top + prev.Height)
+		tolerance := prev.Height
+		if tolerance < 10 {
+			tolerance = 10
+		}
+		return gap >= -prev.Height/3 && gap < prev.Height &&
+			abs(next.Left-prev.Left) <= tolerance &&
+			float64(prev.Left+prev.Width) >= float64(maxRight)*0.85
+	}
+
+	return utf8.RuneCountInString(prev.Text) >= softWrapMinRunes && !listItemRe.MatchString(prev.Text)
+}
+
+// joinWrapped 拼接折行，中文之间不加空格，英文断词连字符去除
+func joinWrapped(prev, next string) string {
+	last, _ := utf8.DecodeLastRuneInString(prev)
+	first, _ := utf8.DecodeRuneInString(next)
+
+	if last == '-' && len(prev) > 1 && unicode.IsLower(first) {
+		before, _ := utf8.DecodeLastRuneInString(prev[:len(prev)-1])
+		if unicode.IsLetter(before) {
+			return prev[:len(prev)-1] + next
+		}
+	}
+	if isCJK(last) || isCJK(first) || last == '_' {
+		return prev + next
+	}
+	return prev + " " + next
+}
+
+// endsSentence 判断文本是否以句末标点结尾
+func endsSentence(text string) bool {
+	last, _ := utf8.DecodeLastRuneInString(text)
+	return strings.ContainsRune("。！？!?：:；;", last)
+}
+
+// isCodeLine 判断该行是否像代码
+func isCodeLine(text string) bool {
+	if codeStartRe.MatchString(text) || strings.ContainsAny(text, "{};") {
+		return true
+	}
+	for _, op := range []string{"==", "!=", "+=", "-=", "->", ":=", "=>", "&&", "||", "++"} {
+		if strings.Contains(text, op) {
+			return true
+		}
+	}
+	return callRe.MatchString(text) || (asciiRatio(text) > 0.9 && strings.Contains(text, " = "))
+}
+
+// normalizeWidth 统一全角/半角字符
+// 全角字母数字与空格总是转为半角；英文和代码行中的全角标点转为半角，
+// 中文行中紧跟汉字的半角标点转为全角
+func normalizeWidth(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '　':
+			b.WriteRune(' ')
+		case (r >= '０' && r <= '９') || (r >= 'Ａ' && r <= 'Ｚ') || (r >= 'ａ' && r <= 'ｚ'):
+			b.WriteRune(r - 0xFEE0)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	text = b.String()
+
+	// 夹带少量中文注释的代码行同样按代码处理
+	half := toHalfWidth(text)
+	if !containsCJK(text) || (cjkRatio(text) < 0.3 && isCodeLine(half)) {
+		return half
+	}
+	return toFullWidthAfterCJK(text)
+}
+
+// halfWidthPunct 全角区段(U+FF01-U+FF5E)以外的中文标点到半角的映射
+var halfWidthPunct = map[rune]rune{
+	'“': '"', '”': '"', '‘': '\'', '’': '\'', '【': '[', '】': ']', '、': ',', '。': '.',
+}
+
+// fullWidthPunct 半角标点到全角的映射，只用于中文语境
+var fullWidthPunct = map[rune]rune{
+	',': '，', ';': '；', ':': '：', '!': '！', '?': '？',
+}
+
+// toHalfWidth 将全角标点转为半角
+func toHalfWidth(text string) string {
+	return strings.Map(func(r rune) rune {
+		if h, ok := halfWidthPunct[r]; ok {
+			return h
+		}
+		if r >= '！' && r <= '～' {
+			return r - 0xFEE0
+		}
+		return r
+	}, text)
+}
+
+// toFullWidthAfterCJK 将紧跟汉字的半角标点转为全角
+func toFullWidthAfterCJK(text string) string {
+	runes := []rune(text)
+	for i := 1; i < len(runes); i++ {
+		f, ok := fullWidthPunct[runes[i]]
+		if !ok || !isCJK(runes[i-1]) {
+			continue
+		}
+		// 冒号后跟数字多为时间或比例，保持原样
+		if i+1 < len(runes) && unicode.IsDigit(runes[i+1]) {
+			continue
+		}
+		runes[i] = f
+	}
+	return string(runes)
+}
+
+// repairCode 修复代码中常见的OCR混淆：数字中的O/l，关键字中的0/1
+func repairCode(text string) string {
+	return tokenRe.ReplaceAllStringFunc(text, func(token string) string {
+		if fixed, ok := repairNumber(token); ok {
+			return fixed
+		}
+		if fixed, ok := repairKeyword(token); ok {
+			return fixed
+		}
+		return token
+	})
+}
+
+// repairNumber 将数字中被误识别的 O/o 还原为0，l/I 还原为1
+func repairNumber(token string) (string, bool) {
+	// 只处理以数字开头的记号，避免误改 l1、O2 之类的变量名
+	if !unicode.IsDigit(rune(token[0])) {
+		return "", false
+	}
+	hasConfusable := false
+	for i, r := range token {
+		switch {
+		case unicode.IsDigit(r):
+		case r == 'O' || r == 'o' || r == 'I':
+			hasConfusable = true
+		case r == 'l':
+			// 数字结尾的l通常是long类型后缀
+			if i == len(token)-1 {
+				return "", false
+			}
+			hasConfusable = true
+		default:
+			return "", false
+		}
+	}
+	if !hasConfusable {
+		return "", false
+	}
+	return strings.NewReplacer("O", "0", "o", "0", "l", "1", "I", "1").Replace(token), true
+}
+
+// repairKeyword 将被误识别成数字的关键字还原，如 f0r -> for、whi1e -> while
+func repairKeyword(token string) (string, bool) {
+	if codeKeywords[token] || !strings.ContainsAny(token, "01I") {
+		return "", false
+	}
+	fixed := strings.NewReplacer("0", "o", "1", "l", "I", "l").Replace(token)
+	if fixed != token && codeKeywords[fixed] {
+		return fixed, true
+	}
+	return "", false
+}
+
+// containsCJK 判断文本中是否包含汉字
+func containsCJK(text string) bool {
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// isCJK 判断字符是否为汉字或中文标点
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || (r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
+}
+
+// cjkRatio 计算文本中汉字的比例
+func cjkRatio(text string) float64 {
+	total, cjk := 0, 0
+	for _, r := range text {
+		total++
+		if unicode.Is(unicode.Han, r) {
+			cjk++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(cjk) / float64(total)
+}
+
+// asciiRatio 计算文本中ASCII字符的比例
+func asciiRatio(text string) float64 {
+	total, ascii := 0, 0
+	for _, r := range text {
+		total++
+		if r < utf8.RuneSelf {
+			ascii++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(ascii) / float64(total)
+}
+
+// abs 返回整数的绝对值
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,76 @@
+package textclean
+
+import (
+	"testing"
+
+	"github.com/qujing226/screen_sage/domain/model"
+)
+
+// textLines 构造不带位置信息的文本行
+func textLines(texts ...string) []model.OCRLine {
+	lines := make([]model.OCRLine, len(texts))
+	for i, text := range texts {
+		lines[i] = model.OCRLine{Text: text}
+	}
+	return lines
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []model.OCRLine
+		opts  Options
+		want  string
+	}{
+		{
+			name: "drop chrome by geometry",
+			lines: []model.OCRLine{
+				{Text: "394. 字符串解码 - 力扣", Left: 120, Top: 10, Width: 300, Height: 20},
+				{Text: "leetcode.cn/problems/decode-string", Left: 200, Top: 45, Width: 400, Height: 20},
+				{Text: "给定一个经过编码的字符串，返回它解码后的字符串。", Left: 100, Top: 300, Width: 800, Height: 24},
+				{Text: "搜索", Left: 60, Top: 1050, Width: 60, Height: 20},
+				{Text: "14:32", Left: 1800, Top: 1048, Width: 60, Height: 20},
+			},
+			opts: Options{ImageWidth: 1920, ImageHeight: 1080},
+			want: "给定一个经过编码的字符串，返回它解码后的字符串。",
+		},
+		{
+			name:  "drop noise without geometry",
+			lines: textLines("https://leetcode.cn/problems/decode-string/", "文件 编辑 查看 历史 书签 工具 帮助", "示例 1：", "×", "2024/05/01"),
+			want:  "示例 1：",
+		},
+		{
+			name: "merge soft wraps by geometry",
+			lines: []model.OCRLine{
+				{Text: "The encoding rule is: k[encoded_string], where the encoded", Left: 100, Top: 300, Width: 780, Height: 20},
+				{Text: "string inside the square brackets is being repeated exactly", Left: 100, Top: 326, Width: 790, Height: 20},
+				{Text: "k times.", Left: 100, Top: 352, Width: 90, Height: 20},
+				{Text: "Example 1:", Left: 100, Top: 420, Width: 120, Height: 20},
+			},
+			opts: Options{ImageWidth: 1920, ImageHeight: 1080},
+			want: "The encoding rule is: k[encoded_string], where the encoded string inside the square brackets is being repeated exactly k times.\nExample 1:",
+		},
+		{
+			name:  "merge chinese soft wraps",
+			lines: textLines("给定一个经过编码的字符串，返回它解码后的字符串。编码规则为：k[encoded_", "string]，表示其中方括号内部的字符串正好重复k次。", "- 1 <= s.length <= 30"),
+			want:  "给定一个经过编码的字符串，返回它解码后的字符串。编码规则为：k[encoded_string]，表示其中方括号内部的字符串正好重复k次。\n- 1 <= s.length <= 30",
+		},
+		{
+			name:  "normalize width",
+			lines: textLines("ｓ　＝　＂３［ａ］２［ｂｃ］＂", "输入,输出?", "print（ｘ，ｙ）"),
+			want:  "s = \"3[a]2[bc]\"\n输入，输出？\nprint(x,y)",
+		},
+		{
+			name:  "repair code confusions",
+			lines: textLines("f0r i in range(1O0):", "    whi1e stack and stack[-1] == 'a':", "        retum nu11;", "x = 10l;"),
+			want:  "for i in range(100):\nwhile stack and stack[-1] == 'a':\nretum null;\nx = 10l;",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Clean(tt.lines, tt.opts); got != tt.want {
+				t.Errorf("Clean() got =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Package usage 负责模型调用的费用估算和用量限额检查
+package usage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/qujing226/screen_sage/domain/model"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+// ErrLimitExceeded 用量超出限额时返回的错误
+var ErrLimitExceeded = errors.New("已超出用量限额")
+
+// Pricing 模型的token价格，单位为元/百万token
+type Pricing struct {
+	PromptPerMillion     float64
+	CompletionPerMillion float64
+}
+
+// Cost 计算一次模型调用的费用
+func (p Pricing) Cost(tokens model.TokenUsage) float64 {
+	return (float64(tokens.PromptTokens)*p.PromptPerMillion +
+		float64(tokens.CompletionTokens)*p.CompletionPerMillion) / 1e6
+}
+
+// Prices 各类调用的价格
+type Prices struct {
+	Text    Pricing // 文本模型价格
+	Vision  Pricing // 视觉模型价格
+	OCRCall float64 // 每次OCR调用的价格（元）
+}
+
+// Estimate 估算一次截图处理的费用
+func (p Prices) Estimate(fromImage bool, tokens model.TokenUsage, ocrCalls int) float64 {
+	pricing := p.Text
+	if fromImage {
+		pricing = p.Vision
+	}
+	return pricing.Cost(tokens) + float64(ocrCalls)*p.OCRCall
+}
+
+// Limits 每日和每月的用量限额，为0表示不限制
+type Limits struct {
+	DailyCost       float64 `json:"daily_cost"`
+	MonthlyCost     float64 `json:"monthly_cost"`
+	DailyRequests   int     `json:"daily_requests"`
+	MonthlyRequests int     `json:"monthly_requests"`
+}
+
+// Enabled 判断是否设置了任一限额
+func (l Limits) Enabled() bool {
+	return l.DailyCost > 0 || l.MonthlyCost > 0 || l.DailyRequests > 0 || l.MonthlyRequests > 0
+}
+
+// Check 根据今日和本月已用量判断是否还能继续处理，超出时返回包装了ErrLimitExceeded的错误
+func (l Limits) Check(today, month storage.UsageSummary) error {
+	switch {
+	case l.DailyCost > 0 && today.Cost >= l.DailyCost:
+		return fmt.Errorf("%w: 今日费用 %.4f 元已达到每日限额 %.2f 元", ErrLimitExceeded, today.Cost, l.DailyCost)
+	case l.MonthlyCost > 0 && month.Cost >= l.MonthlyCost:
+		return fmt.Errorf("%w: 本月费用 %.4f 元已达到每月限额 %.2f 元", ErrLimitExceeded, month.Cost, l.MonthlyCost)
+	case l.DailyRequests > 0 && today.Requests >= l.DailyRequests:
+		return fmt.Errorf("%w: 今日已处理 %d 次，达到每日限额 %d 次", ErrLimitExceeded, today.Requests, l.DailyRequests)
+	case l.MonthlyRequests > 0 && month.Requests >= l.MonthlyRequests:
+		return fmt.Errorf("%w: 本月已处理 %d 次，达到每月限额 %d 次", ErrLimitExceeded, month.Requests, l.MonthlyRequests)
+	}
+	return nil
+}
+
+// StartOfDay 返回t所在日期的零点
+func StartOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// StartOfMonth 返回t所在月份第一天的零点
+func StartOfMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,54 @@
+package usage
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/qujing226/screen_sage/domain/model"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+func TestPrices_Estimate(t *testing.T) {
+	prices := Prices{
+		Text:    Pricing{PromptPerMillion: 2, CompletionPerMillion: 8},
+		Vision:  Pricing{PromptPerMillion: 1, CompletionPerMillion: 4},
+		OCRCall: 0.004,
+	}
+	tokens := model.TokenUsage{PromptTokens: 1000, CompletionTokens: 500}
+
+	if got, want := prices.Estimate(false, tokens, 1), 0.002+0.004+0.004; math.Abs(got-want) > 1e-9 {
+		t.Errorf("文本模式费用 = %v, want %v", got, want)
+	}
+	if got, want := prices.Estimate(true, tokens, 0), 0.001+0.002; math.Abs(got-want) > 1e-9 {
+		t.Errorf("视觉模式费用 = %v, want %v", got, want)
+	}
+}
+
+func TestLimits_Check(t *testing.T) {
+	limits := Limits{DailyCost: 1, MonthlyRequests: 100}
+
+	if err := limits.Check(storage.UsageSummary{Cost: 0.5}, storage.UsageSummary{Requests: 99}); err != nil {
+		t.Errorf("未超出限额时不应报错: %v", err)
+	}
+	if err := limits.Check(storage.UsageSummary{Cost: 1}, storage.UsageSummary{}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("达到每日费用限额时应返回ErrLimitExceeded, got %v", err)
+	}
+	if err := limits.Check(storage.UsageSummary{}, storage.UsageSummary{Requests: 100}); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("达到每月次数限额时应返回ErrLimitExceeded, got %v", err)
+	}
+	if (Limits{}).Enabled() {
+		t.Error("零值限额不应启用")
+	}
+}
+
+func TestStartOfMonth(t *testing.T) {
+	now := time.Date(2025, 3, 17, 15, 4, 5, 0, time.Local)
+	if got := StartOfMonth(now); !got.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("StartOfMonth() = %v", got)
+	}
+	if got := StartOfDay(now); !got.Equal(time.Date(2025, 3, 17, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("StartOfDay() = %v", got)
+	}
+}
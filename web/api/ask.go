@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/qujing226/screen_sage/application/service"
+)
+
+// maxAskText 文本提问的最大字节数
+const maxAskText = 32 * 1024
+
+// askRequest JSON格式的文本提问
+type askRequest struct {
+	Text    string   `json:"text"`
+	Profile string   `json:"profile"` // 提示词配置名称，为空时使用默认配置
+	Images  []string `json:"images"`  // 附带的Base64图片，可以带 data URL 前缀
+}
+
+// handleAsk 处理直接提交文本的提问，跳过截图和OCR
+// 请求体可以是 JSON、text/plain（profile 为查询参数）或 multipart/form-data（text、profile 字段和图片文件），
+// 结果保存为来源为 text 的记录，进度与截图一样通过WebSocket推送
+func (s *Server) handleAsk(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var request service.TextRequest
+	var err error
+	switch mediaType {
+	case "multipart/form-data":
+		images, fields, readErr := readMultipart(r)
+		request = service.TextRequest{Text: fields.Get("text"), Images: images, Profile: fields.Get("profile")}
+		err = readErr
+	case "text/plain":
+		var data []byte
+		data, err = io.ReadAll(io.LimitReader(r.Body, maxAskText+1))
+		request = service.TextRequest{Text: string(data), Profile: r.URL.Query().Get("profile")}
+	case "application/json", "":
+		request, err = readJSONAsk(r)
+	default:
+		http.Error(w, "不支持的请求格式: "+mediaType, http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		log.Printf("读取提问失败: %v", err)
+		uploadError(w, err)
+		return
+	}
+	if len(request.Text) > maxAskText {
+		http.Error(w, fmt.Sprintf("提问内容超过 %d KB", maxAskText>>10), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	processID, err := s.askText(request)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":     processID,
+		"status": "处理中",
+	})
+}
+
+// readJSONAsk 读取JSON格式的提问并校验附带的图片
+func readJSONAsk(r *http.Request) (service.TextRequest, error) {
+	var body askRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return service.TextRequest{}, fmt.Errorf("解析请求失败: %v", err)
+	}
+	if len(body.Images) > maxUploadImages {
+		return service.TextRequest{}, fmt.Errorf("一次最多上传 %d 张图片", maxUploadImages)
+	}
+	request := service.TextRequest{Text: body.Text, Profile: body.Profile}
+	for i, imageBase64 := range body.Images {
+		data, err := decodeBase64Image(imageBase64)
+		if err == nil {
+			data, err = decodeImage(data)
+		}
+		if err != nil {
+			return request, fmt.Errorf("第 %d 张图片: %w", i+1, err)
+		}
+		request.Images = append(request.Images, data)
+	}
+	return request, nil
+}
+
+// askText 开始回答文本提问，返回任务ID
+func (s *Server) askText(request service.TextRequest) (string, error) {
+	if strings.TrimSpace(request.Text) == "" {
+		return "", &protocolError{CodeBadRequest, "提问内容不能为空"}
+	}
+	if s.ScreenshotService == nil {
+		return "", errServiceUnavailable
+	}
+
+	_, done, ok := s.startJob()
+	if !ok {
+		return "", errShuttingDown
+	}
+	processID := fmt.Sprintf("text_%d_%d", time.Now().UnixNano(), s.jobSeq.Add(1))
+	s.broadcastJob(processID, TypeProcessStart, JobStarted{ID: processID, Status: "正在生成回答..."})
+	go func() {
+		defer done()
+		screenshot, err := s.ScreenshotService.AskText(request)
+		if err != nil {
+			log.Printf("文本提问失败: %v", err)
+			s.broadcastError(processID, err)
+			return
+		}
+		s.broadcastComplete(processID, screenshot)
+	}()
+	return processID, nil
+}
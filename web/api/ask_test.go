@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qujing226/screen_sage/application/service"
+	"github.com/qujing226/screen_sage/domain/model"
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/prompt"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+func TestAsk(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.SeedPromptProfiles(prompt.DefaultProfiles()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未配置模型密钥，回答很快失败并保存记录，不会调用外部接口
+	pipeline := service.NewScreenshotService(db, nil, nil, "")
+	pipeline.ImageDir = filepath.Join(dir, "images")
+	s := New(Deps{DB: db, Pipeline: pipeline})
+	ask := func(target, contentType string, body io.Reader) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, target, body)
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+
+	// JSON、纯文本和带图片的表单都返回任务ID
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("text", "图中的错误是什么原因？")
+	fw, _ := mw.CreateFormFile("image", "error.png")
+	fw.Write(pngData.Bytes())
+	mw.Close()
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"JSON": ask("/api/ask", "application/json", strings.NewReader(`{"text":"什么是快速排序？","profile":"algorithm"}`)),
+		"纯文本":  ask("/api/ask?profile=algorithm", "text/plain; charset=utf-8", strings.NewReader("二分查找的时间复杂度")),
+		"表单":   ask("/api/ask", mw.FormDataContentType(), &form),
+	} {
+		var result map[string]string
+		if json.Unmarshal(w.Body.Bytes(), &result); w.Code != http.StatusOK || !strings.HasPrefix(result["id"], "text_") {
+			t.Errorf("%s: status=%d body=%s", name, w.Code, w.Body)
+		}
+	}
+
+	// 请求不合法时返回错误
+	for name, c := range map[string]struct {
+		contentType, body string
+		status            int
+	}{
+		"没有内容":   {"application/json", `{"text":"  "}`, http.StatusBadRequest},
+		"损坏的图片":  {"application/json", `{"text":"?","images":["` + base64.StdEncoding.EncodeToString([]byte("not an image")) + `"]}`, http.StatusBadRequest},
+		"内容过长":   {"text/plain", strings.Repeat("长", maxAskText), http.StatusRequestEntityTooLarge},
+		"不支持的格式": {"application/xml", "<text/>", http.StatusUnsupportedMediaType},
+	} {
+		if w := ask("/api/ask", c.contentType, strings.NewReader(c.body)); w.Code != c.status {
+			t.Errorf("%s: status=%d, 期望 %d, body=%s", name, w.Code, c.status, w.Body)
+		}
+	}
+
+	// 结果保存为来源为text的记录，附带的图片作为记录的图片
+	var records []storage.HistoryRecord
+	for deadline := time.Now().Add(5 * time.Second); len(records) < 3 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		records, _ = db.GetHistoryPage(0, 10)
+	}
+	if len(records) != 3 {
+		t.Fatalf("记录数 = %d", len(records))
+	}
+	withImage := 0
+	for _, record := range records {
+		if record.Source != model.SourceText || record.Text == "" || record.PromptProfile != "algorithm" {
+			t.Errorf("记录: %+v", record)
+		}
+		if record.ImagePath != "" && strings.HasPrefix(record.Thumbnail, "data:image/png;base64,") {
+			withImage++
+		}
+	}
+	if withImage != 1 {
+		t.Errorf("带图片的记录数 = %d", withImage)
+	}
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+const (
+	// sessionCookie 登录后保存会话的Cookie名称
+	sessionCookie = "screensage_session"
+	// sessionTTL 会话有效期
+	sessionTTL = 7 * 24 * time.Hour
+)
+
+// loginPage 登录页面，输入API令牌后建立会话
+//
+//go:embed login.html
+var loginPage []byte
+
+// sessionStore 保存已登录的会话，只保存在内存中，重启后需要重新登录
+type sessionStore struct {
+	sessions map[string]time.Time // 会话ID → 过期时间
+	mutex    sync.Mutex
+}
+
+// create 创建一个新会话并返回会话ID
+func (s *sessionStore) create() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]time.Time)
+	}
+	now := time.Now()
+	// 顺便清理过期的会话
+	for sid, expires := range s.sessions {
+		if now.After(expires) {
+			delete(s.sessions, sid)
+		}
+	}
+	s.sessions[id] = now.Add(sessionTTL)
+	return id, nil
+}
+
+// valid 判断会话是否存在且未过期
+func (s *sessionStore) valid(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	expires, ok := s.sessions[id]
+	return ok && time.Now().Before(expires)
+}
+
+// clear 清除全部会话，API令牌变更后调用
+func (s *sessionStore) clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions = nil
+}
+
+// LoginURL 返回带令牌的登录地址，打开后建立会话并跳转到next
+func LoginURL(cfg *config.Config, token, next string) string {
+	host := cfg.BindAddress
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	scheme := "http"
+	if cfg.TLSEnabled {
+		scheme = "https"
+	}
+	query := url.Values{"token": {token}, "next": {next}}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(cfg.Port)) + "/login?" + query.Encode()
+}
+
+// ClearSessions 使已登录的会话全部失效
+func (s *Server) ClearSessions() {
+	s.sessions.clear()
+}
+
+// tokenMatches 以固定耗时比较令牌，未配置令牌时拒绝全部请求
+func (s *Server) tokenMatches(token string) bool {
+	expected := s.currentConfig().APIToken
+	if expected == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// access 请求的访问权限
+type access int
+
+const (
+	accessNone   access = iota
+	accessDevice        // 扫码配对的设备，可以查看记录和提交截图
+	accessOwner         // 持有API令牌或登录会话的本机用户，还可以修改设置和管理设备
+)
+
+// deviceKey 请求上下文中保存配对设备ID的键
+type deviceKey struct{}
+
+// authenticate 判断请求的访问权限，Bearer令牌可以是API令牌或设备令牌
+// 通过Cookie认证的非GET请求还需要通过Origin检查，防止跨站请求伪造
+func (s *Server) authenticate(r *http.Request) (access, *storage.Device) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if s.tokenMatches(token) {
+			return accessOwner, nil
+		}
+		if device := s.deviceForToken(token); device != nil {
+			return accessDevice, device
+		}
+		return accessNone, nil
+	}
+
+	level, device := accessNone, (*storage.Device)(nil)
+	if cookie, err := r.Cookie(sessionCookie); err == nil && s.sessions.valid(cookie.Value) {
+		level = accessOwner
+	} else if cookie, err := r.Cookie(deviceCookie); err == nil {
+		if device = s.deviceForToken(cookie.Value); device != nil {
+			level = accessDevice
+		}
+	}
+	if level != accessNone && r.Method != http.MethodGet && r.Method != http.MethodHead && !sameOrigin(r) {
+		return accessNone, nil
+	}
+	return level, device
+}
+
+// requireAuth 要求本机用户或已配对的设备
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireAccess(accessDevice, next)
+}
+
+// requireOwner 要求本机用户，已配对的设备无权访问
+func (s *Server) requireOwner(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireAccess(accessOwner, next)
+}
+
+// requireAccess 要求请求至少具有level权限，未认证时API返回401，页面跳转到登录页
+func (s *Server) requireAccess(level access, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, device := s.authenticate(r)
+		if got >= level {
+			if device != nil {
+				r = r.WithContext(context.WithValue(r.Context(), deviceKey{}, device.ID))
+			}
+			next(w, r)
+			return
+		}
+		isAPI := r.URL.Path == "/ws" || strings.HasPrefix(r.URL.Path, "/api/")
+		switch {
+		case got != accessNone && isAPI:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case isAPI:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="screensage"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		default:
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		}
+	}
+}
+
+// requestDevice 返回发起请求的配对设备ID，不是配对设备时返回0
+func requestDevice(r *http.Request) int64 {
+	id, _ := r.Context().Value(deviceKey{}).(int64)
+	return id
+}
+
+// handleLogin 校验令牌并建立会话
+// GET 带 token 参数时直接登录（托盘菜单打开的地址），否则返回登录页面；POST 接收表单中的令牌
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var token string
+	switch r.Method {
+	case http.MethodGet:
+		token = r.URL.Query().Get("token")
+		if token == "" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(loginPage)
+			return
+		}
+	case http.MethodPost:
+		if !sameOrigin(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		token = r.PostFormValue("token")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.tokenMatches(token) {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	id, err := s.sessions.create()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(sessionTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, safeNext(r.FormValue("next")), http.StatusFound)
+}
+
+// safeNext 只允许跳转到本站的路径，防止开放重定向
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// sameOrigin 检查请求的Origin是否与Host一致，没有Origin头的请求（非浏览器客户端）视为同源
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
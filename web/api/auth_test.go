@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/lifecycle"
+)
+
+func TestRequireAuth(t *testing.T) {
+	config.Init(config.Options{Path: filepath.Join(t.TempDir(), "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", s.handleLogin)
+	mux.HandleFunc("/api/history", s.requireAuth(ok))
+	mux.HandleFunc("/api/exit", s.requireAuth(s.handleExit))
+	mux.HandleFunc("/", s.requireAuth(ok))
+
+	do := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	// 未认证的API请求返回401，页面跳转到登录页
+	if w := do(httptest.NewRequest(http.MethodGet, "/api/history", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("未认证: status=%d", w.Code)
+	}
+	if w := do(httptest.NewRequest(http.MethodGet, "/settings", nil)); w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), "/login?next=") {
+		t.Errorf("页面未跳转到登录页: status=%d location=%s", w.Code, w.Header().Get("Location"))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/history", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	if w := do(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("错误令牌: status=%d", w.Code)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api/history", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if w := do(r); w.Code != http.StatusNoContent {
+		t.Errorf("Bearer令牌: status=%d", w.Code)
+	}
+
+	// 退出只接受POST
+	r = httptest.NewRequest(http.MethodGet, "/api/exit", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if w := do(r); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET退出: status=%d", w.Code)
+	}
+
+	// POST退出交给生命周期按顺序关闭，不直接结束进程
+	s.App = lifecycle.New(time.Second)
+	r = httptest.NewRequest(http.MethodPost, "/api/exit", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if w := do(r); w.Code != http.StatusOK {
+		t.Errorf("POST退出: status=%d", w.Code)
+	}
+	select {
+	case <-s.App.Stopping():
+	default:
+		t.Error("退出请求应触发生命周期退出")
+	}
+
+	// 登录后使用会话Cookie，不允许跳转到其他站点
+	w := do(httptest.NewRequest(http.MethodGet, "/login?"+url.Values{"token": {token}, "next": {"//evil.example"}}.Encode(), nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/" {
+		t.Fatalf("登录: status=%d location=%s", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("会话Cookie不正确: %v", cookies)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/api/history", nil)
+	r.AddCookie(cookies[0])
+	if w := do(r); w.Code != http.StatusNoContent {
+		t.Errorf("会话Cookie: status=%d", w.Code)
+	}
+
+	// 其他站点通过Cookie发起的写请求被拒绝
+	r = httptest.NewRequest(http.MethodPost, "/api/history", nil)
+	r.AddCookie(cookies[0])
+	r.Header.Set("Origin", "http://evil.example")
+	if w := do(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("跨站请求: status=%d", w.Code)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/api/history", nil)
+	r.AddCookie(cookies[0])
+	r.Header.Set("Origin", "http://"+r.Host)
+	if w := do(r); w.Code != http.StatusNoContent {
+		t.Errorf("同源请求: status=%d", w.Code)
+	}
+
+	// 令牌更换后会话失效
+	s.ClearSessions()
+	r = httptest.NewRequest(http.MethodGet, "/api/history", nil)
+	r.AddCookie(cookies[0])
+	if w := do(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("清除会话后: status=%d", w.Code)
+	}
+
+	if w := do(httptest.NewRequest(http.MethodGet, "/login?token=wrong", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("错误令牌登录: status=%d", w.Code)
+	}
+}
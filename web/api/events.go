@@ -0,0 +1,192 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxEvents 保留的最近广播事件数，断线重连的客户端可以补收这些事件
+const maxEvents = 500
+
+// eventTypes SSE可以按类型过滤的事件
+var eventTypes = []string{TypeProcessStart, TypeOCRComplete, TypeProcessComplete, TypeProcessError, TypeReprocess, TypeHistory}
+
+// eventLog 最近的广播事件，由Server.ClientsMux保护
+// 序号从服务器启动时的微秒时间戳开始递增，重启前的序号总是小于重启后记录的事件，
+// 客户端带着旧序号重连时会因为缺口过大而收到历史记录快照，不会误收不相关的事件
+type eventLog struct {
+	seq    int64               // 最后一个事件的序号
+	events []*BroadcastMessage // 按序号排列的最近事件，最多maxEvents个
+}
+
+// newEventLog 创建事件记录
+func newEventLog() eventLog {
+	return eventLog{seq: time.Now().UnixMicro()}
+}
+
+// append 为事件分配序号并记录，超出maxEvents时丢弃最早的事件
+func (l *eventLog) append(msg *BroadcastMessage) {
+	l.seq++
+	msg.Seq = l.seq
+	l.events = append(l.events, msg)
+	if len(l.events) > maxEvents {
+		l.events = l.events[len(l.events)-maxEvents:]
+	}
+}
+
+// since 返回序号大于seq的全部事件
+// seq之后有事件已不在记录中，或seq不是本次启动分配的序号时返回false
+func (l *eventLog) since(seq int64) ([]*BroadcastMessage, bool) {
+	first := l.seq - int64(len(l.events)) + 1 // events[0]的序号
+	if seq > l.seq || seq+1 < first {
+		return nil, false
+	}
+	return append([]*BroadcastMessage(nil), l.events[seq+1-first:]...), true
+}
+
+// parseSince 解析补收事件的起始序号，value为空时返回false
+func parseSince(value string) (int64, bool, error) {
+	if value == "" {
+		return 0, false, nil
+	}
+	seq, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, false, fmt.Errorf("无效的事件序号: %s", value)
+	}
+	return seq, true, nil
+}
+
+// handleEvents 以SSE推送与WebSocket相同的广播事件
+// 事件的 id 为序号，断线后浏览器会带着 Last-Event-ID 重连（也可以使用 ?since=），补发错过的事件，
+// 序号过旧时发送历史记录快照；?type= 可以指定一个或多个事件类型（可重复或用逗号分隔）
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = query.Get("since")
+	}
+	since, resume, err := parseSince(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var types map[string]bool
+	for _, param := range query["type"] {
+		for _, typ := range strings.Split(param, ",") {
+			typ = strings.TrimSpace(typ)
+			if !slices.Contains(eventTypes, typ) {
+				http.Error(w, fmt.Sprintf("不支持的事件类型: %s，可选 %s", typ, strings.Join(eventTypes, ", ")), http.StatusBadRequest)
+				return
+			}
+			if types == nil {
+				types = make(map[string]bool)
+			}
+			types[typ] = true
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "不支持流式响应", http.StatusInternalServerError)
+		return
+	}
+
+	client := newSubscriber(nil, r)
+	client.types = types
+
+	// 与WebSocket相同，在同一次加锁中取出错过的事件并注册客户端
+	var missed []*BroadcastMessage
+	resumed := false
+	s.ClientsMux.Lock()
+	if resume {
+		missed, resumed = s.events.since(since)
+	}
+	s.clients[client] = true
+	s.ClientsMux.Unlock()
+	s.wsCounters.connected.Add(1)
+
+	defer func() {
+		client.close(0, "")
+		s.ClientsMux.Lock()
+		delete(s.clients, client)
+		s.ClientsMux.Unlock()
+	}()
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // 禁止反向代理缓冲
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	send := func(msg *BroadcastMessage) error {
+		s.ClientsMux.Lock()
+		wanted := client.wants(msg)
+		s.ClientsMux.Unlock()
+		if !wanted {
+			return nil
+		}
+		rc.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := writeEvent(w, msg); err != nil {
+			return err
+		}
+		s.wsCounters.sent.Add(1)
+		return nil
+	}
+
+	// 浏览器断线后等待3秒重连，与前端的WebSocket客户端一致
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if resumed {
+		for _, msg := range missed {
+			if err := send(msg); err != nil {
+				return
+			}
+		}
+	} else if resume {
+		if page, err := s.historyPage(0, initialHistory); err == nil {
+			if err := send(newMessage(TypeHistory, page)); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	// 定时发送注释行，避免代理因连接空闲而断开
+	heartbeat := time.NewTicker(s.pongWait / 4)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case msg := <-client.queue:
+			if err := send(msg); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-client.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent 写入一条SSE事件，event为消息类型，data为与WebSocket相同的完整消息
+func writeEvent(w http.ResponseWriter, msg *BroadcastMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if msg.Seq != 0 {
+		fmt.Fprintf(w, "id: %d\n", msg.Seq)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Type, data)
+	return err
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+func TestEvents(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := New(Deps{DB: db})
+	s.pongWait = 200 * time.Millisecond
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	defer s.Shutdown(context.Background()) // 结束事件流，否则ts.Close会一直等待
+
+	// event 一条SSE事件，注释行的comment不为空
+	type event struct {
+		id, typ, data, retry, comment string
+	}
+	open := func(query string, header http.Header) (*http.Response, func() event) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/events"+query, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil
+		}
+		lines := make(chan string)
+		go func() {
+			defer close(lines)
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+		}()
+		next := func() event {
+			t.Helper()
+			var ev event
+			timeout := time.After(2 * time.Second)
+			for {
+				select {
+				case line, ok := <-lines:
+					if !ok {
+						t.Fatal("事件流已结束")
+					}
+					switch {
+					case line == "":
+						if ev != (event{}) {
+							return ev
+						}
+					case strings.HasPrefix(line, ":"):
+						ev.comment = line
+					default:
+						field, value, _ := strings.Cut(line, ": ")
+						switch field {
+						case "id":
+							ev.id = value
+						case "event":
+							ev.typ = value
+						case "data":
+							ev.data = value
+						case "retry":
+							ev.retry = value
+						}
+					}
+				case <-timeout:
+					t.Fatal("等待事件超时")
+				}
+			}
+		}
+		// 第一条是重连间隔
+		if ev := next(); ev.retry == "" {
+			t.Fatalf("第一条应为重连间隔: %+v", ev)
+		}
+		return resp, next
+	}
+	// nextEvent 跳过心跳，返回下一个事件
+	nextEvent := func(next func() event) event {
+		t.Helper()
+		for {
+			if ev := next(); ev.comment == "" {
+				return ev
+			}
+		}
+	}
+
+	// 只推送指定类型的事件，id为序号，data为完整的消息
+	resp, next := open("?type=process_complete,process_error", nil)
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Content-Type = %s", resp.Header.Get("Content-Type"))
+	}
+	s.broadcastJob("job_1", TypeProcessStart, JobStarted{ID: "job_1"})
+	s.broadcastJob("job_1", TypeProcessComplete, JobCompleted{ProcessID: "job_1"})
+	ev := nextEvent(next)
+	var msg BroadcastMessage
+	if err := json.Unmarshal([]byte(ev.data), &msg); err != nil || ev.typ != TypeProcessComplete || ev.id != strconv.FormatInt(msg.Seq, 10) {
+		t.Fatalf("事件: %+v %v", ev, err)
+	}
+
+	// 空闲时发送心跳注释
+	for ev := next(); ev.comment != ": ping"; ev = next() {
+	}
+
+	// 带着Last-Event-ID重连时补发之后的事件
+	s.broadcastJob("job_2", TypeProcessStart, JobStarted{ID: "job_2"})
+	s.broadcastJob("job_2", TypeProcessError, JobFailed{ID: "job_2", Error: "失败"})
+	_, next = open("", http.Header{"Last-Event-ID": {ev.id}})
+	for _, typ := range []string{TypeProcessStart, TypeProcessError} {
+		if ev := nextEvent(next); ev.typ != typ {
+			t.Errorf("补发的事件 = %s, 期望 %s", ev.typ, typ)
+		}
+	}
+
+	// 序号过旧时发送历史记录快照
+	_, next = open("?since=1", nil)
+	if ev := nextEvent(next); ev.typ != TypeHistory || ev.id != "" {
+		t.Errorf("快照: %+v", ev)
+	}
+
+	// 参数错误
+	for _, query := range []string{"?type=hello", "?since=abc"} {
+		if resp, _ := open(query, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status=%d", query, resp.StatusCode)
+		}
+	}
+
+	// 退出时结束事件流
+	resp, _ = open("", nil)
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, resp.Body)
+		close(done)
+	}()
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Error("退出后事件流没有结束")
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/qujing226/screen_sage/internal/qrcode"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+const (
+	// pairCodeTTL 配对码的有效期
+	pairCodeTTL = 5 * time.Minute
+	// pairCodeAlphabet 配对码字符集，去掉了容易混淆的 0、O、1、I
+	pairCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	// pairCodeLength 配对码长度
+	pairCodeLength = 8
+
+	// deviceCookie 保存设备令牌的Cookie名称
+	deviceCookie = "screensage_device"
+	// deviceCookieTTL 设备令牌Cookie的有效期，撤销设备前一直有效
+	deviceCookieTTL = 365 * 24 * time.Hour
+	// deviceTouchInterval 更新设备最后访问时间的最小间隔
+	deviceTouchInterval = time.Minute
+)
+
+// pairingStore 保存尚未使用的配对码，每个配对码只能使用一次
+type pairingStore struct {
+	codes map[string]time.Time // 配对码 → 过期时间
+	mutex sync.Mutex
+}
+
+// create 生成新的配对码
+func (p *pairingStore) create(now time.Time) (string, error) {
+	buf := make([]byte, pairCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = pairCodeAlphabet[int(b)%len(pairCodeAlphabet)]
+	}
+	code := string(buf)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.codes == nil {
+		p.codes = make(map[string]time.Time)
+	}
+	for c, expires := range p.codes {
+		if now.After(expires) {
+			delete(p.codes, c)
+		}
+	}
+	p.codes[code] = now.Add(pairCodeTTL)
+	return code, nil
+}
+
+// consume 校验并作废配对码
+func (p *pairingStore) consume(code string, now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	expires, ok := p.codes[strings.ToUpper(code)]
+	if !ok {
+		return false
+	}
+	delete(p.codes, strings.ToUpper(code))
+	return now.Before(expires)
+}
+
+// pairResponse 配对码信息
+type pairResponse struct {
+	Code      string    `json:"code"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handlePair 生成一次性配对码，默认返回包含配对地址的二维码PNG，format=json 时返回JSON
+func (s *Server) handlePair(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	base, err := s.lanBaseURL()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	now := time.Now()
+	code, err := s.pairing.create(now)
+	if err != nil {
+		log.Printf("生成配对码失败: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	resp := pairResponse{
+		Code:      code,
+		URL:       base + "/pair?code=" + url.QueryEscape(code),
+		ExpiresAt: now.Add(pairCodeTTL),
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	qr, err := qrcode.Encode(resp.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	image, err := qr.PNG(8)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Pair-Code", code)
+	w.Header().Set("X-Pair-Expires", resp.ExpiresAt.Format(time.RFC3339))
+	w.Write(image)
+}
+
+// handlePairExchange 手机扫码后用配对码换取设备令牌
+// 浏览器访问时令牌保存在Cookie中并跳转到首页，Accept为JSON时直接返回令牌
+func (s *Server) handlePairExchange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.pairing.consume(r.FormValue("code"), time.Now()) {
+		http.Error(w, "配对码无效或已过期，请在电脑上重新生成二维码", http.StatusUnauthorized)
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(buf)
+	name := r.FormValue("name")
+	if name == "" {
+		name = deviceName(r.UserAgent())
+	}
+	device, err := s.DBManager.AddDevice(name, r.UserAgent(), hashToken(token))
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("设备 %s 已配对", device.Name)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"token": token, "device": device})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(deviceCookieTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode, // 扫码打开的页面来自其他应用，Strict时跳转后的首个请求不带Cookie
+	})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// handleDevices 列出已配对的设备，DELETE /api/devices/{id} 或 DELETE ?id= 撤销设备
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		devices, err := s.DBManager.ListDevices()
+		if err != nil {
+			log.Printf("%v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(devices)
+
+	case http.MethodDelete:
+		value := r.PathValue("id")
+		if value == "" {
+			value = r.URL.Query().Get("id")
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "设备ID无效", http.StatusBadRequest)
+			return
+		}
+		deleted, err := s.DBManager.DeleteDevice(id)
+		if err != nil {
+			log.Printf("%v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "设备不存在", http.StatusNotFound)
+			return
+		}
+		s.disconnectDevice(id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// deviceForToken 根据设备令牌查找已配对的设备，并记录访问时间
+func (s *Server) deviceForToken(token string) *storage.Device {
+	if s.DBManager == nil || token == "" {
+		return nil
+	}
+	device, err := s.DBManager.GetDeviceByTokenHash(hashToken(token))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("%v", err)
+		}
+		return nil
+	}
+	if now := time.Now(); now.Sub(device.LastSeenAt) > deviceTouchInterval {
+		if err := s.DBManager.TouchDevice(device.ID, now); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	return device
+}
+
+// disconnectDevice 断开已撤销设备的WebSocket连接
+func (s *Server) disconnectDevice(id int64) {
+	s.ClientsMux.Lock()
+	defer s.ClientsMux.Unlock()
+	for client := range s.clients {
+		if client.device == id {
+			client.close(websocket.ClosePolicyViolation, "设备已撤销")
+		}
+	}
+}
+
+// lanBaseURL 返回局域网内其他设备访问本服务的地址
+func (s *Server) lanBaseURL() (string, error) {
+	s.listenMux.Lock()
+	bind, port, secure := s.BindAddress, s.Port, s.tlsConfig != nil
+	s.listenMux.Unlock()
+
+	host := bind
+	ip := net.ParseIP(bind)
+	switch {
+	case bind == "localhost" || (ip != nil && ip.IsLoopback()):
+		return "", fmt.Errorf("当前只监听本机地址，手机无法访问，请将 bind_address 设置为 0.0.0.0 或本机的局域网地址")
+	case bind == "" || (ip != nil && ip.IsUnspecified()):
+		lan, err := lanAddress()
+		if err != nil {
+			return "", err
+		}
+		host = lan
+	}
+
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// lanAddress 返回本机的局域网IPv4地址，优先使用私有地址
+func lanAddress() (string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", fmt.Errorf("获取本机地址失败: %v", err)
+	}
+	fallback := ""
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP.To4()
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip.IsPrivate() {
+			return ip.String(), nil
+		}
+		if fallback == "" {
+			fallback = ip.String()
+		}
+	}
+	if fallback == "" {
+		return "", fmt.Errorf("没有找到本机的局域网地址")
+	}
+	return fallback, nil
+}
+
+// deviceName 根据User-Agent生成设备名称
+func deviceName(userAgent string) string {
+	for _, name := range []string{"iPhone", "iPad", "Android", "Windows", "Macintosh", "Linux"} {
+		if strings.Contains(userAgent, name) {
+			return name
+		}
+	}
+	return "未知设备"
+}
+
+// hashToken 返回设备令牌的SHA-256，数据库中只保存哈希
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+func TestPairing(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := &Server{DBManager: db, BindAddress: "192.168.1.20", Port: 8081}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pair", s.handlePairExchange)
+	mux.HandleFunc("/api/pair", s.requireOwner(s.handlePair))
+	mux.HandleFunc("/api/devices", s.requireOwner(s.handleDevices))
+	mux.HandleFunc("/api/history", s.requireAuth(ok))
+	do := func(method, target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		} else {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	// 默认返回二维码图片
+	w := do(http.MethodGet, "/api/pair", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || !bytes.HasPrefix(w.Body.Bytes(), []byte("\x89PNG")) {
+		t.Fatalf("二维码: status=%d type=%s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	w = do(http.MethodGet, "/api/pair?format=json", nil)
+	var pair pairResponse
+	if err := json.NewDecoder(w.Body).Decode(&pair); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(pair.URL)
+	if err != nil || u.Host != "192.168.1.20:8081" || u.Query().Get("code") != pair.Code {
+		t.Fatalf("配对地址不正确: %s", pair.URL)
+	}
+
+	// 扫码后换取设备令牌，配对码只能使用一次
+	w = do(http.MethodGet, "/pair?code="+pair.Code, &http.Cookie{Name: "none", Value: "x"})
+	if w.Code != http.StatusFound {
+		t.Fatalf("配对: status=%d body=%s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != deviceCookie {
+		t.Fatalf("设备Cookie不正确: %v", cookies)
+	}
+	device := cookies[0]
+	if w := do(http.MethodGet, "/pair?code="+pair.Code, &http.Cookie{Name: "none", Value: "x"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("重复使用配对码: status=%d", w.Code)
+	}
+
+	// 设备可以查看记录，但不能管理设备
+	if w := do(http.MethodGet, "/api/history", device); w.Code != http.StatusNoContent {
+		t.Errorf("设备访问记录: status=%d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/devices", device); w.Code != http.StatusForbidden {
+		t.Errorf("设备管理设备: status=%d", w.Code)
+	}
+
+	w = do(http.MethodGet, "/api/devices", nil)
+	var devices []storage.Device
+	if err := json.NewDecoder(w.Body).Decode(&devices); err != nil || len(devices) != 1 || devices[0].Name != "iPhone" {
+		t.Fatalf("设备列表不正确: %v %v", devices, err)
+	}
+
+	// 撤销后设备令牌失效
+	if w := do(http.MethodDelete, "/api/devices?id="+strconv.FormatInt(devices[0].ID, 10), nil); w.Code != http.StatusNoContent {
+		t.Fatalf("撤销设备: status=%d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/history", device); w.Code != http.StatusUnauthorized {
+		t.Errorf("撤销后访问: status=%d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/api/devices?id="+strconv.FormatInt(devices[0].ID, 10), nil); w.Code != http.StatusNotFound {
+		t.Errorf("重复撤销: status=%d", w.Code)
+	}
+
+	// 只监听本机地址时无法配对
+	s.BindAddress = "127.0.0.1"
+	if w := do(http.MethodGet, "/api/pair", nil); w.Code != http.StatusConflict {
+		t.Errorf("本机地址: status=%d", w.Code)
+	}
+}
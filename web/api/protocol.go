@@ -0,0 +1,294 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/qujing226/screen_sage/application/service"
+	"github.com/qujing226/screen_sage/domain/model"
+	"github.com/qujing226/screen_sage/internal/storage"
+	"github.com/qujing226/screen_sage/internal/usage"
+)
+
+// ProtocolVersion WebSocket协议版本，消息格式发生不兼容的变化时递增
+// 每条消息的 v 字段为发送方使用的版本，客户端命令不带版本时按当前版本处理
+const ProtocolVersion = 1
+
+// 服务器发送的消息类型，payload 的结构见各类型对应的结构体和 protocol.schema.json
+const (
+	TypeHello           = "hello"            // 连接建立后发送，HelloPayload
+	TypeHistory         = "history"          // 历史记录，HistoryPage
+	TypeProcessStart    = "process_start"    // 任务开始，JobStarted
+	TypeOCRComplete     = "ocr_complete"     // OCR识别完成，OCRCompleted
+	TypeProcessComplete = "process_complete" // 任务完成，JobCompleted
+	TypeProcessError    = "process_error"    // 任务失败，JobFailed
+	TypeReprocess       = "reprocess"        // 批量重新生成的进度，ReprocessStatus
+	TypeJob             = "job"              // capture、ask 命令的回复，JobAccepted
+	TypeSubscribed      = "subscribed"       // subscribe、unsubscribe 命令的回复，Subscription
+	TypeError           = "error"            // 命令执行失败，ErrorPayload
+)
+
+// 客户端可以发送的命令
+const (
+	CommandCapture     = "capture"     // 截取当前屏幕并处理，CapturePayload
+	CommandAsk         = "ask"         // 针对已有记录追问，AskPayload
+	CommandSubscribe   = "subscribe"   // 只接收订阅的任务的进度，SubscribePayload
+	CommandUnsubscribe = "unsubscribe" // 取消订阅，job 为空时恢复接收全部任务，SubscribePayload
+	CommandHistory     = "history"     // 分页获取历史记录，HistoryRequest
+)
+
+// commands 支持的命令，在 hello 消息中告知客户端
+var commands = []string{CommandCapture, CommandAsk, CommandSubscribe, CommandUnsubscribe, CommandHistory}
+
+// 错误码，出现在 error 消息和 process_error 消息中
+const (
+	CodeBadRequest         = "bad_request"         // 命令格式或参数错误
+	CodeUnknownCommand     = "unknown_command"     // 不支持的命令
+	CodeUnsupportedVersion = "unsupported_version" // 命令使用了更高的协议版本
+	CodeNotFound           = "not_found"           // 记录或任务不存在
+	CodeUnavailable        = "unavailable"         // 截图服务不可用或程序正在退出
+	CodeConflict           = "conflict"            // 记录正在重新处理
+	CodeLimitExceeded      = "limit_exceeded"      // 超出用量限额
+	CodeInternal           = "internal"            // 其他错误
+)
+
+// protocolSchema 描述全部消息和命令的JSON Schema
+//
+//go:embed protocol.schema.json
+var protocolSchema []byte
+
+// BroadcastMessage 服务器发送的消息
+type BroadcastMessage struct {
+	Version   int         `json:"v"`
+	Type      string      `json:"type"`
+	RequestID string      `json:"request_id,omitempty"` // 回复客户端命令时为命令的 id
+	Seq       int64       `json:"seq,omitempty"`        // 广播事件的序号，递增，重连时作为 since 补收错过的事件
+	Payload   interface{} `json:"payload,omitempty"`
+
+	job string // 任务消息对应的任务ID，用于按订阅过滤
+}
+
+// Command 客户端发送的命令
+type Command struct {
+	Version int             `json:"v"`
+	ID      string          `json:"id"` // 客户端生成，回复中的 request_id 与之相同
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// HelloPayload 连接建立后告知客户端协议版本和支持的命令
+type HelloPayload struct {
+	Version  int      `json:"version"`
+	Commands []string `json:"commands"`
+	Seq      int64    `json:"seq"`     // 最新事件的序号，没有收到后续事件就重连时作为 since
+	Resumed  bool     `json:"resumed"` // 是否补发了 since 之后的全部事件，为false时随后发送历史记录快照
+}
+
+// HistoryPage 一页历史记录，按ID从新到旧排列
+type HistoryPage struct {
+	Records []storage.HistoryRecord `json:"records"`
+	HasMore bool                    `json:"has_more"` // 是否还有更早的记录，以最后一条的ID作为下一页的before
+}
+
+// JobStarted 任务开始
+type JobStarted struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// OCRCompleted OCR识别完成
+type OCRCompleted struct {
+	ID     string `json:"id"`
+	Text   string `json:"text"`
+	Status string `json:"status"`
+}
+
+// JobCompleted 任务完成，包含保存的记录
+type JobCompleted struct {
+	ID        int64     `json:"id"`         // 记录ID
+	ProcessID string    `json:"process_id"` // 任务ID
+	Text      string    `json:"text"`
+	RawText   string    `json:"raw_text"`
+	Answer    string    `json:"answer"`
+	Timestamp time.Time `json:"timestamp"`
+	Thumbnail string    `json:"thumbnail"`
+	Title     string    `json:"title"`
+	Source    string    `json:"source"`
+	Status    string    `json:"status"`
+
+	OCRStatus    string `json:"ocr_status"`
+	OCRError     string `json:"ocr_error,omitempty"`
+	AnswerStatus string `json:"answer_status"`
+	AnswerError  string `json:"answer_error,omitempty"`
+
+	PromptProfile string `json:"prompt_profile"`
+	PromptVersion int    `json:"prompt_version"`
+
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	OCRCalls         int     `json:"ocr_calls"`
+	Cost             float64 `json:"cost"`
+}
+
+// JobFailed 任务失败
+type JobFailed struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// JobAccepted capture、ask 命令已开始执行，进度通过任务消息推送
+type JobAccepted struct {
+	Job string `json:"job"`
+}
+
+// Subscription 订阅状态
+type Subscription struct {
+	Job   string `json:"job,omitempty"`
+	State string `json:"state,omitempty"` // running、done、error，任务不再保留时为空
+	All   bool   `json:"all"`             // 是否接收全部任务的进度
+}
+
+// ReprocessStatus 批量重新生成的进度，开始时、每处理完一条记录和全部结束时各发送一次
+type ReprocessStatus struct {
+	ID       string  `json:"id"` // 批量任务ID
+	Total    int     `json:"total"`
+	Done     int     `json:"done"`                // 已生成新回答的记录数
+	Failed   int     `json:"failed"`              // 生成失败的记录数，这些记录保留原回答
+	Cost     float64 `json:"cost"`                // 新回答的估算费用
+	RecordID int64   `json:"record_id,omitempty"` // 刚处理完的记录
+	Error    string  `json:"error,omitempty"`     // 刚处理完的记录失败的原因
+	Finished bool    `json:"finished"`
+	Stopped  string  `json:"stopped,omitempty"` // 提前结束的原因，例如取消或超出用量限额
+}
+
+// ErrorPayload 命令执行失败的原因
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// CapturePayload capture 命令的参数
+type CapturePayload struct {
+	Mode    string `json:"mode"`    // 处理模式，为空时使用默认模式
+	Profile string `json:"profile"` // 提示词配置名称，为空时使用默认配置
+}
+
+// AskPayload ask 命令的参数
+type AskPayload struct {
+	RecordID int64  `json:"record_id"`
+	Question string `json:"question"`
+}
+
+// SubscribePayload subscribe、unsubscribe 命令的参数
+type SubscribePayload struct {
+	Job string `json:"job"`
+}
+
+// HistoryRequest history 命令的参数
+type HistoryRequest struct {
+	Before int64 `json:"before"` // 返回ID小于before的记录，为0时从最新的记录开始
+	Limit  int   `json:"limit"`  // 每页条数，默认20，最多100
+}
+
+// protocolError 带错误码的命令错误
+type protocolError struct {
+	code    string
+	message string
+}
+
+func (e *protocolError) Error() string {
+	return e.message
+}
+
+var (
+	errServiceUnavailable = &protocolError{CodeUnavailable, "截图服务不可用"}
+	errShuttingDown       = &protocolError{CodeUnavailable, "程序正在退出"}
+)
+
+// httpStatus 返回HTTP接口中错误对应的状态码
+func httpStatus(err error) int {
+	switch errorCode(err) {
+	case CodeBadRequest:
+		return http.StatusBadRequest
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeLimitExceeded:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// newMessage 创建当前协议版本的消息
+func newMessage(typ string, payload interface{}) *BroadcastMessage {
+	return &BroadcastMessage{Version: ProtocolVersion, Type: typ, Payload: payload}
+}
+
+// errorMessage 创建命令失败的回复
+func errorMessage(requestID string, err error) *BroadcastMessage {
+	msg := newMessage(TypeError, ErrorPayload{Code: errorCode(err), Message: err.Error()})
+	msg.RequestID = requestID
+	return msg
+}
+
+// errorCode 返回错误对应的错误码
+func errorCode(err error) string {
+	var pe *protocolError
+	switch {
+	case errors.As(err, &pe):
+		return pe.code
+	case errors.Is(err, usage.ErrLimitExceeded):
+		return CodeLimitExceeded
+	case errors.Is(err, service.ErrRecordNotFound):
+		return CodeNotFound
+	case errors.Is(err, service.ErrRecordBusy):
+		return CodeConflict
+	case errors.Is(err, service.ErrNothingToRetry), errors.Is(err, service.ErrFollowUpRecord), errors.Is(err, service.ErrNoImage):
+		return CodeBadRequest
+	default:
+		return CodeInternal
+	}
+}
+
+// jobCompleted 由处理完成的截图生成任务完成消息
+func jobCompleted(processID string, screenshot *model.Screenshot) JobCompleted {
+	return JobCompleted{
+		ID:        screenshot.ID,
+		ProcessID: processID,
+		Text:      screenshot.Text,
+		RawText:   screenshot.RawText,
+		Answer:    screenshot.Answer,
+		Timestamp: screenshot.Timestamp,
+		Thumbnail: screenshot.Thumbnail,
+		Title:     screenshot.Title,
+		Source:    screenshot.Source,
+		Status:    screenshot.Status,
+
+		OCRStatus:    screenshot.OCRStatus,
+		OCRError:     screenshot.OCRError,
+		AnswerStatus: screenshot.AnswerStatus,
+		AnswerError:  screenshot.AnswerError,
+
+		PromptProfile: screenshot.PromptProfile,
+		PromptVersion: screenshot.PromptVersion,
+
+		PromptTokens:     screenshot.PromptTokens,
+		CompletionTokens: screenshot.CompletionTokens,
+		OCRCalls:         screenshot.OCRCalls,
+		Cost:             screenshot.Cost,
+	}
+}
+
+// handleProtocolSchema 返回WebSocket协议的JSON Schema
+func (s *Server) handleProtocolSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(protocolSchema)
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/qujing226/screen_sage/application/service"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+const (
+	// defaultReprocessRate 批量重新生成默认每分钟处理的记录数
+	defaultReprocessRate = 20
+	// maxReprocessRate 每分钟最多处理的记录数
+	maxReprocessRate = 600
+	// maxReprocessBatches 保留的批量任务数，超出时丢弃最早结束的任务
+	maxReprocessBatches = 20
+)
+
+// reprocessRequest POST /api/reprocess 的请求体
+type reprocessRequest struct {
+	Filter  reprocessFilter `json:"filter"`
+	Mode    string          `json:"mode"`    // ocr 使用文本模型，vision 使用视觉模型，为空时与各记录的原回答相同
+	Profile string          `json:"profile"` // 提示词配置名称，为空时使用各记录原来的配置
+	Model   string          `json:"model"`   // 模型名称，为空时使用配置中的模型，指定时 mode 必须为 ocr 或 vision
+	Rate    int             `json:"rate"`    // 每分钟最多处理的记录数，默认20
+	DryRun  bool            `json:"dry_run"` // 只返回将要处理的记录和预计用量
+	All     bool            `json:"all"`     // 没有筛选条件时必须为true，避免误操作重新生成全部记录
+}
+
+// reprocessFilter 选择要重新生成的记录，省略的条件不限制，全部省略时需要在请求中指定 all
+type reprocessFilter struct {
+	IDs          []int64 `json:"ids"`
+	Profile      string  `json:"profile"`       // 原回答所用的提示词配置
+	Source       string  `json:"source"`        // 回答来源
+	AnswerStatus string  `json:"answer_status"` // 回答阶段状态
+	Category     string  `json:"category"`      // 标题开头【】中的分类，例如 算法
+	From         string  `json:"from"`          // 开始日期，格式为 2006-01-02
+	To           string  `json:"to"`            // 结束日期，包含当天
+	Limit        int     `json:"limit"`         // 最多处理的条数
+}
+
+// reprocessResponse 批量重新生成的计划，dry_run 时没有任务ID
+type reprocessResponse struct {
+	ID     string                 `json:"id,omitempty"`
+	Status string                 `json:"status"`
+	DryRun bool                   `json:"dry_run"`
+	Plan   *service.ReprocessPlan `json:"plan"`
+}
+
+// reprocessBatch 一个批量重新生成任务
+type reprocessBatch struct {
+	cancel context.CancelFunc
+	status ReprocessStatus
+}
+
+// reprocessBatches 正在执行和最近结束的批量任务
+type reprocessBatches struct {
+	mutex   sync.Mutex
+	batches map[string]*reprocessBatch
+	order   []string
+}
+
+// add 登记新的批量任务，超出数量时丢弃最早结束的任务
+func (b *reprocessBatches) add(batch *reprocessBatch) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.batches == nil {
+		b.batches = make(map[string]*reprocessBatch)
+	}
+	b.batches[batch.status.ID] = batch
+	b.order = append(b.order, batch.status.ID)
+	for i := 0; len(b.order) > maxReprocessBatches && i < len(b.order); {
+		if id := b.order[i]; b.batches[id].status.Finished {
+			delete(b.batches, id)
+			b.order = append(b.order[:i], b.order[i+1:]...)
+		} else {
+			i++
+		}
+	}
+}
+
+// get 返回批量任务的当前进度
+func (b *reprocessBatches) get(id string) (ReprocessStatus, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	batch, ok := b.batches[id]
+	if !ok {
+		return ReprocessStatus{}, false
+	}
+	return batch.status, true
+}
+
+// update 保存批量任务的进度
+func (b *reprocessBatches) update(status ReprocessStatus) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if batch, ok := b.batches[status.ID]; ok {
+		batch.status = status
+	}
+}
+
+// cancel 取消批量任务中尚未开始的记录，任务不存在时返回false
+func (b *reprocessBatches) cancel(id string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	batch, ok := b.batches[id]
+	if ok {
+		batch.cancel()
+	}
+	return ok
+}
+
+// handleReprocess 按筛选条件批量重新生成回答，可以换用其他模型或提示词配置
+// dry_run 时只返回将要处理的记录和预计用量；否则在后台依次处理，原回答保存为历史版本，
+// 进度通过 reprocess 消息推送，也可以通过 GET /api/reprocess/{id} 查询
+func (s *Server) handleReprocess(w http.ResponseWriter, r *http.Request) {
+	var request reprocessRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("解析请求失败: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	req, err := request.serviceRequest()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rate := request.Rate
+	if rate == 0 {
+		rate = defaultReprocessRate
+	}
+	if rate < 0 || rate > maxReprocessRate {
+		http.Error(w, fmt.Sprintf("rate 应在 1 到 %d 之间", maxReprocessRate), http.StatusBadRequest)
+		return
+	}
+	if s.ScreenshotService == nil {
+		http.Error(w, errServiceUnavailable.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err := s.ScreenshotService.CheckModel(req.Mode, request.Model); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Profile != "" {
+		if _, err := s.DBManager.GetPromptProfile(req.Profile); errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "提示词配置不存在: "+req.Profile, http.StatusBadRequest)
+			return
+		}
+	}
+
+	plan, err := s.ScreenshotService.PlanReprocess(req)
+	if err != nil {
+		log.Printf("查询要重新生成的记录失败: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	response := reprocessResponse{Status: "预估", DryRun: true, Plan: plan}
+	if !request.DryRun {
+		if len(plan.Records) == 0 {
+			http.Error(w, "没有可以重新生成的记录", http.StatusBadRequest)
+			return
+		}
+		if plan.LimitError != "" {
+			http.Error(w, "预计用量超出限额: "+plan.LimitError, http.StatusTooManyRequests)
+			return
+		}
+		id, err := s.startReprocess(plan.Records, service.ReprocessOptions{
+			Mode:     req.Mode,
+			Profile:  req.Profile,
+			Model:    request.Model,
+			Interval: time.Minute / time.Duration(rate),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+		response = reprocessResponse{ID: id, Status: "处理中", Plan: plan}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleReprocessBatch GET 返回批量任务的进度，DELETE 取消尚未开始的记录
+func (s *Server) handleReprocessBatch(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method == http.MethodDelete {
+		if !s.batches.cancel(id) {
+			http.Error(w, "任务不存在", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	status, ok := s.batches.get(id)
+	if !ok {
+		http.Error(w, "任务不存在", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// startReprocess 在后台依次重新生成记录的回答，返回批量任务ID
+// 取消或程序开始退出时不再处理剩余的记录，正在处理的记录会继续完成
+func (s *Server) startReprocess(ids []int64, opts service.ReprocessOptions) (string, error) {
+	ctx, done, ok := s.startJob()
+	if !ok {
+		return "", errShuttingDown
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	batchID := fmt.Sprintf("reprocess_%d_%d", time.Now().UnixNano(), s.jobSeq.Add(1))
+	s.batches.add(&reprocessBatch{cancel: cancel, status: ReprocessStatus{ID: batchID, Total: len(ids)}})
+
+	var stopping <-chan struct{}
+	if s.App != nil {
+		stopping = s.App.Stopping()
+	}
+	go func() {
+		select {
+		case <-stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	report := func(progress service.ReprocessProgress) {
+		status := reprocessStatus(batchID, progress)
+		s.batches.update(status)
+		s.broadcastJob(batchID, TypeReprocess, status)
+	}
+	opts.OnProgress = func(progress service.ReprocessProgress) {
+		if progress.Error != "" {
+			log.Printf("重新生成记录 %d 失败: %v", progress.RecordID, progress.Error)
+		}
+		report(progress)
+	}
+	report(service.ReprocessProgress{Total: len(ids)})
+
+	go func() {
+		defer done()
+		defer cancel()
+		progress := s.ScreenshotService.Reprocess(ctx, ids, opts)
+		log.Printf("批量重新生成结束，任务ID: %s，成功 %d 条，失败 %d 条", batchID, progress.Done, progress.Failed)
+	}()
+	return batchID, nil
+}
+
+// reprocessStatus 由服务的进度生成推送给客户端的进度消息
+func reprocessStatus(batchID string, progress service.ReprocessProgress) ReprocessStatus {
+	return ReprocessStatus{
+		ID:       batchID,
+		Total:    progress.Total,
+		Done:     progress.Done,
+		Failed:   progress.Failed,
+		Cost:     progress.Cost,
+		RecordID: progress.RecordID,
+		Error:    progress.Error,
+		Finished: progress.Finished,
+		Stopped:  progress.Stopped,
+	}
+}
+
+// empty 是否没有任何筛选条件，limit 只限制条数，不算作筛选条件
+func (f reprocessFilter) empty() bool {
+	return len(f.IDs) == 0 && f.Profile == "" && f.Source == "" && f.AnswerStatus == "" &&
+		f.Category == "" && f.From == "" && f.To == ""
+}
+
+// serviceRequest 校验参数并转换为服务的请求
+func (r reprocessRequest) serviceRequest() (service.ReprocessRequest, error) {
+	mode, err := service.ParsePipelineMode(r.Mode)
+	if err != nil {
+		return service.ReprocessRequest{}, err
+	}
+	if r.Filter.Limit < 0 {
+		return service.ReprocessRequest{}, fmt.Errorf("limit 不能为负数")
+	}
+	if r.Filter.empty() && !r.All {
+		return service.ReprocessRequest{}, fmt.Errorf("没有指定筛选条件，重新生成全部记录时需要指定 \"all\": true")
+	}
+	filter := storage.HistoryFilter{
+		IDs:          r.Filter.IDs,
+		Profile:      r.Filter.Profile,
+		Source:       r.Filter.Source,
+		AnswerStatus: r.Filter.AnswerStatus,
+		Category:     r.Filter.Category,
+		Limit:        r.Filter.Limit,
+	}
+	if r.Filter.From != "" {
+		if filter.From, err = time.ParseInLocation("2006-01-02", r.Filter.From, time.Local); err != nil {
+			return service.ReprocessRequest{}, fmt.Errorf("开始日期无效: %s", r.Filter.From)
+		}
+	}
+	if r.Filter.To != "" {
+		to, err := time.ParseInLocation("2006-01-02", r.Filter.To, time.Local)
+		if err != nil {
+			return service.ReprocessRequest{}, fmt.Errorf("结束日期无效: %s", r.Filter.To)
+		}
+		// 结束日期包含当天
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	return service.ReprocessRequest{Filter: filter, Mode: mode, Profile: r.Profile}, nil
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qujing226/screen_sage/application/service"
+	"github.com/qujing226/screen_sage/domain/model"
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/prompt"
+	"github.com/qujing226/screen_sage/internal/storage"
+	"github.com/qujing226/screen_sage/internal/usage"
+)
+
+func TestReprocess(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.SeedPromptProfiles(prompt.DefaultProfiles()); err != nil {
+		t.Fatal(err)
+	}
+
+	visionStub := &stubModel{}
+	visionStub.set("新的回答\n新标题", nil)
+	pipeline := service.NewScreenshotService(db, nil, visionStub, "")
+	settings := pipeline.Settings()
+	settings.Prices = usage.Prices{Vision: usage.Pricing{PromptPerMillion: 2, CompletionPerMillion: 8}}
+	pipeline.ApplySettings(settings)
+	s := New(Deps{DB: db, Pipeline: pipeline})
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+	// wait 等待批量任务结束并返回最终进度
+	wait := func(id string) ReprocessStatus {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if status, _ := s.batches.get(id); status.Finished {
+				return status
+			}
+		}
+		t.Fatalf("批量任务 %s 没有结束", id)
+		return ReprocessStatus{}
+	}
+
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	imagePath := filepath.Join(dir, "shot.png")
+	if err := os.WriteFile(imagePath, pngData.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 记录都是十天前处理的，重新生成的用量计入今天
+	processedAt := time.Now().AddDate(0, 0, -10)
+	for _, record := range []storage.HistoryRecord{
+		{ImagePath: imagePath, Answer: "旧回答一", Source: model.SourceImage, PromptProfile: "algorithm", CompletionTokens: 200},
+		{ImagePath: imagePath, Answer: "旧回答二", Title: sql.NullString{String: "【算法】旧标题", Valid: true}, Source: model.SourceImage, PromptProfile: "algorithm"},
+		{ImagePath: filepath.Join(dir, "missing.png"), Answer: "截图已删除", Source: model.SourceImage, PromptProfile: "algorithm"},
+		{Answer: "追问", Source: model.SourceFollowUp, PromptProfile: "algorithm"},
+		{ImagePath: imagePath, Answer: "其他配置", Source: model.SourceImage, PromptProfile: "summarize"},
+	} {
+		record.Timestamp = processedAt
+		if !record.Title.Valid {
+			record.Title = sql.NullString{String: "旧标题", Valid: true}
+		}
+		if _, err := db.AddHistory(&record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 参数不合法时返回错误
+	for name, body := range map[string]string{
+		"未知的模式":      `{"mode":"unknown","all":true}`,
+		"日期格式错误":     `{"filter":{"from":"2026/01/01"}}`,
+		"频率过高":       `{"rate":1000,"all":true}`,
+		"配置不存在":      `{"profile":"missing","all":true}`,
+		"没有符合的记录":    `{"filter":{"profile":"missing"}}`,
+		"没有筛选条件":     `{"dry_run":true}`,
+		"只限制条数":      `{"filter":{"limit":5},"dry_run":true}`,
+		"指定模型但未指定方式": `{"filter":{"ids":[1]},"model":"gpt-4o","dry_run":true}`,
+		"自动模式指定模型":   `{"filter":{"ids":[1]},"mode":"auto","model":"gpt-4o","dry_run":true}`,
+	} {
+		if w := request(http.MethodPost, "/api/reprocess", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status=%d body=%s", name, w.Code, w.Body)
+		}
+	}
+
+	// 试运行只返回计划和预计用量，跳过追问记录和缺少截图的记录
+	w := request(http.MethodPost, "/api/reprocess", `{"filter":{"profile":"algorithm"},"mode":"vision","dry_run":true}`)
+	var dryRun struct {
+		ID   string                `json:"id"`
+		Plan service.ReprocessPlan `json:"plan"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &dryRun); err != nil || w.Code != http.StatusOK || dryRun.ID != "" {
+		t.Fatalf("试运行: status=%d body=%s", w.Code, w.Body)
+	}
+	plan := dryRun.Plan
+	if len(plan.Records) != 2 || plan.Records[0] != 1 || plan.Records[1] != 2 || len(plan.Skipped) != 2 ||
+		plan.Estimate.Requests != 2 || plan.Estimate.CompletionTokens != 700 || plan.Estimate.Cost <= 0 {
+		t.Fatalf("计划: %+v", plan)
+	}
+	if versions, _ := db.ListAnswerVersions(1); len(versions) != 0 {
+		t.Fatalf("试运行不应修改记录: %+v", versions)
+	}
+
+	// 按标题开头【】中的分类筛选，分类可以带括号
+	for _, category := range []string{"算法", "【算法】"} {
+		w := request(http.MethodPost, "/api/reprocess", `{"filter":{"category":"`+category+`"},"dry_run":true}`)
+		if err := json.Unmarshal(w.Body.Bytes(), &dryRun); err != nil || len(dryRun.Plan.Records) != 1 || dryRun.Plan.Records[0] != 2 {
+			t.Errorf("按分类 %s 筛选: status=%d body=%s", category, w.Code, w.Body)
+		}
+	}
+
+	// 预计用量超出限额时拒绝执行
+	settings.Limits = usage.Limits{DailyCost: plan.Estimate.Cost / 2}
+	pipeline.ApplySettings(settings)
+	if w := request(http.MethodPost, "/api/reprocess", `{"filter":{"profile":"algorithm"},"mode":"vision"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("超出限额: status=%d body=%s", w.Code, w.Body)
+	}
+	settings.Limits = usage.Limits{}
+	pipeline.ApplySettings(settings)
+
+	// 在后台依次处理，原回答保存为版本
+	dayBefore, err := db.GetUsage(processedAt.Add(-time.Hour), processedAt.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Now().Add(-time.Hour)
+	w = request(http.MethodPost, "/api/reprocess", `{"filter":{"profile":"algorithm"},"profile":"summarize","rate":600}`)
+	var started reprocessResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil || w.Code != http.StatusOK || !strings.HasPrefix(started.ID, "reprocess_") {
+		t.Fatalf("开始: status=%d body=%s", w.Code, w.Body)
+	}
+	if status := wait(started.ID); status.Done != 2 || status.Failed != 0 || status.Stopped != "" || status.Cost <= 0 {
+		t.Errorf("进度: %+v", status)
+	}
+	for _, id := range []int64{1, 2} {
+		record, _ := db.GetHistoryByID(id)
+		versions, _ := db.ListAnswerVersions(id)
+		if record.Answer != "新的回答" || record.PromptProfile != "summarize" || len(versions) != 1 || !strings.HasPrefix(versions[0].Answer, "旧回答") {
+			t.Errorf("记录 %d: %+v, 版本 %+v", id, record, versions)
+		}
+	}
+	// 原回答的用量仍计入原来的日期，新回答的用量按生成时间计入
+	if dayAfter, _ := db.GetUsage(processedAt.Add(-time.Hour), processedAt.Add(time.Hour)); *dayAfter != *dayBefore || dayAfter.CompletionTokens != 200 {
+		t.Errorf("原日期的用量: %+v, 之前 %+v", dayAfter, dayBefore)
+	}
+	if spent, _ := db.GetUsage(today, time.Now().Add(time.Hour)); spent.Requests != 2 || spent.PromptTokens != 200 ||
+		spent.CompletionTokens != 20 || spent.Cost <= 0 {
+		t.Errorf("今天的用量: %+v", spent)
+	}
+	w = request(http.MethodGet, "/api/reprocess/"+started.ID, "")
+	var status ReprocessStatus
+	if json.Unmarshal(w.Body.Bytes(), &status); !status.Finished || status.Total != 2 {
+		t.Errorf("查询进度: %s", w.Body)
+	}
+
+	// 可以换用视觉模型中的其他模型；没有筛选条件时需要指定 all
+	w = request(http.MethodPost, "/api/reprocess", `{"all":true,"filter":{"limit":1},"mode":"vision","model":"gpt-4o","rate":600}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("指定模型: status=%d body=%s", w.Code, w.Body)
+	}
+	status = wait(started.ID)
+	visionStub.mutex.Lock()
+	if status.Done != 1 || visionStub.model != "gpt-4o" {
+		t.Errorf("指定模型: %+v, 模型 %q", status, visionStub.model)
+	}
+	visionStub.mutex.Unlock()
+
+	// 取消后不再处理剩余的记录
+	w = request(http.MethodPost, "/api/reprocess", `{"filter":{"ids":[1,2,5]},"rate":1}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("开始: status=%d body=%s", w.Code, w.Body)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if status, _ := s.batches.get(started.ID); status.Done == 1 {
+			break
+		}
+	}
+	if w := request(http.MethodDelete, "/api/reprocess/"+started.ID, ""); w.Code != http.StatusNoContent {
+		t.Errorf("取消: status=%d", w.Code)
+	}
+	if status := wait(started.ID); status.Done != 1 || status.Stopped == "" {
+		t.Errorf("取消后的进度: %+v", status)
+	}
+	if w := request(http.MethodDelete, "/api/reprocess/unknown", ""); w.Code != http.StatusNotFound {
+		t.Errorf("不存在的任务: status=%d", w.Code)
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/qujing226/screen_sage/application/service"
+	"github.com/qujing226/screen_sage/domain/model"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+// regenerateRequest 重新生成回答的参数，请求体可以为空
+type regenerateRequest struct {
+	Mode    string `json:"mode"`    // ocr 使用识别文本和文本模型，vision 使用截图和视觉模型，为空时与原回答相同
+	Profile string `json:"profile"` // 提示词配置名称，为空时使用原回答的配置
+	Model   string `json:"model"`   // 模型名称，为空时使用配置中的模型，指定时 mode 必须为 ocr 或 vision
+}
+
+// handleRetry 从保存的截图重新执行记录中失败的阶段，进度与截图一样通过WebSocket推送
+func (s *Server) handleRetry(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "记录ID无效", http.StatusBadRequest)
+		return
+	}
+	processID, err := s.retry(id)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":     processID,
+		"status": "处理中",
+	})
+}
+
+// handleRegenerate 重新生成记录的回答，原回答保存为历史版本
+func (s *Server) handleRegenerate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "记录ID无效", http.StatusBadRequest)
+		return
+	}
+	var request regenerateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Printf("解析请求失败: %v", err)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	processID, err := s.regenerate(id, request)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":     processID,
+		"status": "处理中",
+	})
+}
+
+// handleAnswerVersions 返回记录重新生成前的回答，按时间从旧到新排列
+func (s *Server) handleAnswerVersions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "记录ID无效", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.record(id); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	versions, err := s.DBManager.ListAnswerVersions(id)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// retry 检查记录后开始重试失败的阶段，返回任务ID
+func (s *Server) retry(recordID int64) (string, error) {
+	record, err := s.record(recordID)
+	if err != nil {
+		return "", err
+	}
+	if err := service.CheckRetry(record); err != nil {
+		return "", err
+	}
+	return s.rerun(recordID, "retry", func(processID string) (*model.Screenshot, error) {
+		return s.ScreenshotService.Retry(recordID, s.ocrProgress(processID))
+	})
+}
+
+// regenerate 检查记录后开始重新生成回答，返回任务ID
+func (s *Server) regenerate(recordID int64, request regenerateRequest) (string, error) {
+	mode, err := service.ParsePipelineMode(request.Mode)
+	if err != nil {
+		return "", &protocolError{CodeBadRequest, err.Error()}
+	}
+	record, err := s.record(recordID)
+	if err != nil {
+		return "", err
+	}
+	if err := service.CheckRegenerate(record, mode); err != nil {
+		return "", err
+	}
+	if s.ScreenshotService == nil {
+		return "", errServiceUnavailable
+	}
+	if err := s.ScreenshotService.CheckModel(mode, request.Model); err != nil {
+		return "", &protocolError{CodeBadRequest, err.Error()}
+	}
+	return s.rerun(recordID, "regen", func(processID string) (*model.Screenshot, error) {
+		return s.ScreenshotService.Regenerate(recordID, service.RegenerateOptions{
+			Mode:          mode,
+			Profile:       request.Profile,
+			Model:         request.Model,
+			OnOCRComplete: s.ocrProgress(processID),
+		})
+	})
+}
+
+// rerun 异步重新处理一条记录并推送进度，同一条记录正在处理时拒绝
+func (s *Server) rerun(recordID int64, prefix string, process func(processID string) (*model.Screenshot, error)) (string, error) {
+	if s.ScreenshotService == nil {
+		return "", errServiceUnavailable
+	}
+	release, err := s.ScreenshotService.Claim(recordID)
+	if err != nil {
+		return "", err
+	}
+	_, done, ok := s.startJob()
+	if !ok {
+		release()
+		return "", errShuttingDown
+	}
+
+	processID := fmt.Sprintf("%s_%d_%d", prefix, time.Now().UnixNano(), s.jobSeq.Add(1))
+	s.broadcastJob(processID, TypeProcessStart, JobStarted{ID: processID, Status: "开始重新处理记录..."})
+	go func() {
+		defer done()
+		defer release()
+		screenshot, err := process(processID)
+		if err != nil {
+			log.Printf("重新处理记录 %d 失败: %v", recordID, err)
+			s.broadcastError(processID, err)
+			return
+		}
+		s.broadcastComplete(processID, screenshot)
+	}()
+	return processID, nil
+}
+
+// record 获取历史记录，不存在时返回 service.ErrRecordNotFound
+func (s *Server) record(id int64) (*storage.HistoryRecord, error) {
+	record, err := s.DBManager.GetHistoryByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrRecordNotFound
+	}
+	return record, err
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qujing226/screen_sage/application/service"
+	"github.com/qujing226/screen_sage/domain/model"
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/prompt"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+// stubModel 可以切换结果的OCR和视觉模型
+type stubModel struct {
+	mutex  sync.Mutex
+	result string
+	err    error
+	model  string // 最近一次按次指定的模型
+}
+
+func (m *stubModel) set(result string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.result, m.err = result, err
+}
+
+func (m *stubModel) RecognizeText(string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.result, m.err
+}
+
+func (m *stubModel) AnswerImage(_, _, _ string) (string, model.TokenUsage, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.result, model.TokenUsage{PromptTokens: 100, CompletionTokens: 10}, m.err
+}
+
+func (m *stubModel) AnswerImageWithModel(modelName, imageBase64, systemPrompt, userPrompt string) (string, model.TokenUsage, error) {
+	m.mutex.Lock()
+	m.model = modelName
+	m.mutex.Unlock()
+	return m.AnswerImage(imageBase64, systemPrompt, userPrompt)
+}
+
+func TestRetry(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.SeedPromptProfiles(prompt.DefaultProfiles()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未配置DeepSeek密钥，基于文本的回答很快失败
+	ocrStub, visionStub := &stubModel{}, &stubModel{}
+	pipeline := service.NewScreenshotService(db, ocrStub, visionStub, "")
+	pipeline.ImageDir = filepath.Join(dir, "images")
+	s := New(Deps{DB: db, Pipeline: pipeline})
+	post := func(target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+	// rerun 发起请求并等待任务结束，返回更新后的记录
+	rerun := func(target, body string) *storage.HistoryRecord {
+		t.Helper()
+		w := post(target, body)
+		var job map[string]string
+		if json.Unmarshal(w.Body.Bytes(), &job); w.Code != http.StatusOK {
+			t.Fatalf("%s: status=%d body=%s", target, w.Code, w.Body)
+		}
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			s.ClientsMux.Lock()
+			state := s.jobStates.states[job["id"]]
+			s.ClientsMux.Unlock()
+			if state == jobDone || state == jobError {
+				break
+			}
+		}
+		record, err := db.GetHistoryByID(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+
+	var pngData bytes.Buffer
+	png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+
+	// OCR失败时记录失败原因，回答阶段尚未执行
+	ocrStub.set("", errors.New("OCR服务超时"))
+	screenshot, err := pipeline.ProcessScreenshotWithOptions(pngData.Bytes(), service.ProcessOptions{Mode: service.ModeOCR})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if screenshot.OCRStatus != model.StageFailed || screenshot.OCRError != "OCR服务超时" || screenshot.Text != "" ||
+		screenshot.AnswerStatus != model.StagePending || screenshot.Answer != "" {
+		t.Fatalf("OCR失败: %+v", screenshot)
+	}
+
+	// 请求不合法时返回错误
+	for target, status := range map[string]int{
+		"/api/history/abc/retry":    http.StatusBadRequest,
+		"/api/history/99/retry":     http.StatusNotFound,
+		"/api/history/99/versions":  http.StatusNotFound,
+		"/api/history/1/regenerate": http.StatusBadRequest,
+	} {
+		method, body := http.MethodPost, `{"mode":"unknown"}`
+		if strings.HasSuffix(target, "/versions") {
+			method, body = http.MethodGet, ""
+		}
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		if w.Code != status {
+			t.Errorf("%s: status=%d, 期望 %d", target, w.Code, status)
+		}
+	}
+
+	// 重试重新识别保存的截图，随后生成回答，回答失败时记录原因
+	ocrStub.set("快速排序的平均时间复杂度是多少？", nil)
+	record := rerun("/api/history/1/retry", "")
+	if record.OCRStatus != model.StageOK || record.Text == "" || record.OCRCalls != 2 ||
+		record.AnswerStatus != model.StageFailed || !strings.Contains(record.AnswerError, "密钥") {
+		t.Fatalf("重试: %+v", record)
+	}
+
+	// 重新生成可以换用视觉模型，失败的回答不保存为版本
+	visionStub.set("O(n log n)\n快速排序", nil)
+	record = rerun("/api/history/1/regenerate", `{"mode":"vision","profile":"algorithm"}`)
+	if record.AnswerStatus != model.StageOK || record.Answer != "O(n log n)" || record.Title.String != "快速排序" ||
+		record.Source != model.SourceImage || record.AnswerError != "" || record.PromptTokens != 100 {
+		t.Fatalf("重新生成: %+v", record)
+	}
+
+	// 各阶段都已成功时没有可以重试的内容
+	if w := post("/api/history/1/retry", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("没有失败的阶段: status=%d", w.Code)
+	}
+
+	// 再次生成时原回答保存为版本；生成失败时保留当前回答
+	visionStub.set("平均 O(n log n)，最坏 O(n²)\n快速排序", nil)
+	record = rerun("/api/history/1/regenerate", "")
+	visionStub.set("", errors.New("视觉模型不可用"))
+	if after := rerun("/api/history/1/regenerate", ""); after.Answer != record.Answer || after.AnswerStatus != model.StageOK {
+		t.Errorf("生成失败后的记录: %+v", after)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/history/1/versions", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	var versions []storage.AnswerVersion
+	if err := json.Unmarshal(w.Body.Bytes(), &versions); err != nil || len(versions) != 1 ||
+		versions[0].Answer != "O(n log n)" || versions[0].Source != model.SourceImage || versions[0].PromptTokens != 100 {
+		t.Fatalf("回答版本: %s", w.Body)
+	}
+
+	// 记录更新失败时原回答不保存为版本，下次重新生成不会重复保存
+	if err := db.UpdateHistory(&storage.HistoryRecord{ID: 99}, &storage.AnswerVersion{Answer: "原回答"}, nil); err == nil {
+		t.Error("更新不存在的记录应该失败")
+	}
+	if versions, _ := db.ListAnswerVersions(99); len(versions) != 0 {
+		t.Errorf("更新失败后保存了版本: %+v", versions)
+	}
+
+	// 可以换用其他模型，指定模型时必须指定生成方式
+	if w := post("/api/history/1/regenerate", `{"model":"gpt-4o"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("未指定生成方式: status=%d", w.Code)
+	}
+	visionStub.set("O(n log n)\n快速排序", nil)
+	record = rerun("/api/history/1/regenerate", `{"mode":"vision","model":"gpt-4o"}`)
+	visionStub.mutex.Lock()
+	if record.Answer != "O(n log n)" || visionStub.model != "gpt-4o" {
+		t.Errorf("指定模型: %+v, 模型 %q", record, visionStub.model)
+	}
+	visionStub.mutex.Unlock()
+
+	// 追问记录不能单独重新生成
+	followUp, err := db.AddHistory(&storage.HistoryRecord{Timestamp: time.Now(), Source: model.SourceFollowUp, Answer: "回答"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := post("/api/history/"+strconv.FormatInt(followUp, 10)+"/regenerate", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("追问记录: status=%d", w.Code)
+	}
+
+	// 旧版本写入的失败文本迁移为阶段状态
+	legacy, err := db.AddHistory(&storage.HistoryRecord{Timestamp: time.Now(), Text: "OCR识别失败", Answer: "无法生成回答"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	migrated, err := reopened.GetHistoryByID(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.OCRStatus != model.StageFailed || migrated.Text != "" || migrated.AnswerStatus != model.StageFailed || migrated.Answer != "" {
+		t.Errorf("迁移: %+v", migrated)
+	}
+}
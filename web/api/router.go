@@ -0,0 +1,220 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/qujing226/screen_sage/internal/config"
+)
+
+// requestIDHeader 请求ID的请求头和响应头
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey 请求ID在context中的键
+type requestIDKey struct{}
+
+// routes 注册全部路由并包装中间件
+// 路由按方法匹配，不支持的方法由ServeMux返回405并在Allow头中列出支持的方法，GET路由同时处理HEAD；
+// 路径中的 {name} 可以通过 r.PathValue(name) 获取。/api/ 下的接口单独注册，
+// 避免静态文件的 GET / 匹配到方法不对的接口请求
+func (s *Server) routes() http.Handler {
+	mux, api := http.NewServeMux(), http.NewServeMux()
+	mux.Handle("/api/", api)
+
+	// 登录和扫码配对不需要认证
+	mux.HandleFunc("GET /login", s.handleLogin)
+	mux.HandleFunc("POST /login", s.handleLogin)
+	mux.HandleFunc("GET /pair", s.handlePairExchange)
+	mux.HandleFunc("POST /pair", s.handlePairExchange)
+
+	// 本机和已配对的设备都可以使用
+	api.HandleFunc("GET /api/history", s.requireAuth(s.handleHistory))
+	api.HandleFunc("GET /api/history/{id}", s.requireAuth(s.handleHistoryRecord))
+	api.HandleFunc("GET /api/history/{id}/versions", s.requireAuth(s.handleAnswerVersions))
+	api.HandleFunc("POST /api/history/{id}/retry", s.requireAuth(s.handleRetry))
+	api.HandleFunc("POST /api/history/{id}/regenerate", s.requireAuth(s.handleRegenerate))
+	api.HandleFunc("POST /api/upload", s.requireAuth(s.handleUpload))
+	api.HandleFunc("POST /api/ask", s.requireAuth(s.handleAsk))
+	api.HandleFunc("POST /api/capture", s.requireAuth(s.handleCapture))
+	api.HandleFunc("GET /api/prompts", s.requireAuth(s.handlePrompts))
+	api.HandleFunc("PUT /api/prompts", s.requireAuth(s.handlePrompts))
+	api.HandleFunc("GET /api/usage", s.requireAuth(s.handleUsage))
+	mux.HandleFunc("GET /ws", s.requireAuth(s.handleWebSocket))
+	api.HandleFunc("GET /api/events", s.requireAuth(s.handleEvents))
+	api.HandleFunc("GET /api/ws/schema", s.requireAuth(s.handleProtocolSchema))
+
+	// 只有本机可以修改设置、管理设备和退出程序
+	api.HandleFunc("GET /api/settings", s.requireOwner(s.handleSettings))
+	api.HandleFunc("PUT /api/settings", s.requireOwner(s.handleSettings))
+	api.HandleFunc("POST /api/settings/test", s.requireOwner(s.handleSettingsTest))
+	mux.HandleFunc("GET /settings", s.requireOwner(s.handleSettingsPage))
+	api.HandleFunc("POST /api/exit", s.requireOwner(s.handleExit))
+	api.HandleFunc("GET /api/ws/stats", s.requireOwner(s.handleWSStats))
+	api.HandleFunc("POST /api/reprocess", s.requireOwner(s.handleReprocess))
+	api.HandleFunc("GET /api/reprocess/{id}", s.requireOwner(s.handleReprocessBatch))
+	api.HandleFunc("DELETE /api/reprocess/{id}", s.requireOwner(s.handleReprocessBatch))
+	api.HandleFunc("GET /api/pair", s.requireOwner(s.handlePair))
+	api.HandleFunc("GET /api/devices", s.requireOwner(s.handleDevices))
+	api.HandleFunc("DELETE /api/devices", s.requireOwner(s.handleDevices))
+	api.HandleFunc("DELETE /api/devices/{id}", s.requireOwner(s.handleDevices))
+
+	// 其他路径由静态文件服务处理，只支持GET和HEAD
+	// 这里不能注册为 GET /，否则与 /api/ 冲突
+	static := s.requireAuth(s.handleStatic)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		static(w, r)
+	})
+
+	// 请求ID最先生成，访问日志记录恢复panic后的状态码
+	return withRequestID(withAccessLog(withRecovery(mux)))
+}
+
+// currentConfig 返回当前配置，未注入配置时使用全局配置
+func (s *Server) currentConfig() *config.Config {
+	if s.config == nil {
+		return config.GetConfig()
+	}
+	return s.config()
+}
+
+// RequestID 返回请求的ID，用于在日志中关联同一个请求
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID 为每个请求分配ID并写入响应头
+// 客户端提供了格式合理的ID时沿用，方便和客户端日志对应
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID 检查客户端提供的请求ID，只接受不超过64个字符的字母、数字和 -_.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID 生成随机的请求ID
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
+
+// withRecovery 处理请求时发生panic不会影响其他请求，记录调用栈后返回500
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// ErrAbortHandler 用于主动中断响应，交给http.Server处理
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("处理请求 %s %s 时发生panic [%s]: %v\n%s", r.Method, r.URL.Path, RequestID(r), err, debug.Stack())
+			if sw, ok := w.(*statusWriter); !ok || sw.status == 0 {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withAccessLog 记录每个请求的方法、路径、状态码、响应大小和耗时
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		log.Printf("%s %s %d %dB %s [%s]", r.Method, r.URL.Path, status, sw.bytes, time.Since(start).Round(time.Millisecond), RequestID(r))
+	})
+}
+
+// statusWriter 记录响应状态码和大小
+// 实现了Flusher和Hijacker，WebSocket升级和流式响应可以穿过中间件
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader 记录状态码
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write 记录写入的字节数
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush 立即发送已写入的数据
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack 接管底层连接，WebSocket升级时使用
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("连接不支持Hijack")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Unwrap 返回原始的ResponseWriter，供http.ResponseController使用
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
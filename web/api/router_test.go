@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+func TestHandler(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	id, err := db.AddHistory(&storage.HistoryRecord{Timestamp: time.Now(), Text: "问题", Answer: "回答"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 不监听端口，直接把Handler交给httptest
+	s := New(Deps{DB: db})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	do := func(method, path string) *http.Response {
+		r, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// 路由按方法匹配，不支持的方法返回405
+	if resp := do(http.MethodGet, "/api/exit"); resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "POST" {
+		t.Errorf("GET退出: status=%d allow=%s", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+
+	if resp := do(http.MethodPost, "/index.html"); resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, HEAD" {
+		t.Errorf("POST静态文件: status=%d allow=%s", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	if resp := do(http.MethodGet, "/api/unknown"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("不存在的接口: status=%d", resp.StatusCode)
+	}
+
+	// 路径参数
+	if resp := do(http.MethodGet, "/api/history/"+strconv.FormatInt(id, 10)); resp.StatusCode != http.StatusOK {
+		t.Errorf("获取记录: status=%d", resp.StatusCode)
+	}
+	if resp := do(http.MethodGet, "/api/history/999"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("不存在的记录: status=%d", resp.StatusCode)
+	}
+	if resp := do(http.MethodGet, "/api/history/abc"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("无效的记录ID: status=%d", resp.StatusCode)
+	}
+	device, err := db.AddDevice("iPhone", "", hashToken("device"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := do(http.MethodDelete, "/api/devices/"+strconv.FormatInt(device.ID, 10)); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("撤销设备: status=%d", resp.StatusCode)
+	}
+
+	// 每个响应都带有请求ID，客户端提供的ID会被沿用
+	resp := do(http.MethodGet, "/api/history")
+	if len(resp.Header.Get(requestIDHeader)) != 16 {
+		t.Errorf("请求ID: %q", resp.Header.Get(requestIDHeader))
+	}
+	r, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/history", nil)
+	r.Header.Set(requestIDHeader, "client-42")
+	if resp, err := http.DefaultClient.Do(r); err != nil || resp.Header.Get(requestIDHeader) != "client-42" {
+		t.Errorf("沿用请求ID: %v %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+
+	// WebSocket升级可以穿过中间件
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatalf("WebSocket连接失败: %v", err)
+	}
+	var msg BroadcastMessage
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != TypeHello {
+		t.Errorf("WebSocket消息: %v %v", msg, err)
+	}
+	conn.Close()
+}
+
+func TestMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	handler := withRequestID(withAccessLog(withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("出错了")
+	}))))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/history", nil))
+
+	// panic被恢复并返回500，日志中记录调用栈和请求ID
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("panic后 status=%d", w.Code)
+	}
+	id := w.Header().Get(requestIDHeader)
+	if id == "" || !strings.Contains(logs.String(), "出错了") || !strings.Contains(logs.String(), "GET /api/history 500") || !strings.Contains(logs.String(), id) {
+		t.Errorf("日志不完整: %s", logs.String())
+	}
+
+	// 不合法的请求ID被替换
+	if validRequestID("a b") || validRequestID(strings.Repeat("a", 65)) || !validRequestID("abc-1.2_3") {
+		t.Error("请求ID校验不正确")
+	}
+}
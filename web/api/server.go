@@ -1,182 +1,220 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/qujing226/screen_sage/application/service"
 	"github.com/qujing226/screen_sage/domain/model"
-	"github.com/qujing226/screen_sage/infrastructure/service/ocr"
 	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/lifecycle"
+	"github.com/qujing226/screen_sage/internal/prompt"
+	"github.com/qujing226/screen_sage/internal/screenshot"
 	"github.com/qujing226/screen_sage/internal/storage"
+	"github.com/qujing226/screen_sage/internal/usage"
 )
 
 // Server 表示Web服务器
 // 负责处理HTTP请求、WebSocket连接和广播消息
 type Server struct {
-	Port        int                      // 服务器监听端口
-	DBManager   *storage.DBManager       // 数据库管理器
-	OCRClient   *ocr.BaiduOCRProvider    // OCR客户端接口
-	DeepSeekKey string                   // DeepSeek API密钥
-	StaticPath  string                   // 静态文件路径
-	Clients     map[*websocket.Conn]bool // 已连接的WebSocket客户端
-	Broadcast   chan *BroadcastMessage   // 广播消息通道
-	ClientsMux  sync.Mutex               // 客户端列表互斥锁
-	Upgrader    websocket.Upgrader       // WebSocket升级器
+	Port              int                        // 服务器监听端口
+	BindAddress       string                     // 服务器监听地址
+	DBManager         *storage.DBManager         // 数据库管理器
+	StaticPath        string                     // 前端目录，为空时使用程序内嵌的前端
+	ScreenshotService *service.ScreenshotService // 截图处理服务
+	Broadcast         chan *BroadcastMessage     // 广播消息通道
+	ClientsMux        sync.Mutex                 // 保护WebSocket客户端列表和任务状态
+	Upgrader          websocket.Upgrader         // WebSocket升级器
+	App               *lifecycle.App             // 程序生命周期，处理退出请求并登记截图处理任务
+
+	httpServer *http.Server // 当前的HTTP服务，端口变更时替换
+	tlsConfig  *tls.Config  // HTTPS配置，未启用HTTPS时为nil
+	listenMux  sync.Mutex   // 保护Port、BindAddress、StaticPath、static、tlsConfig和httpServer
+	sessions   sessionStore // 登录会话
+	pairing    pairingStore // 尚未使用的配对码
+
+	clients    map[*subscriber]bool // 已连接的WebSocket和SSE客户端，由ClientsMux保护
+	jobStates  jobStates            // 最近任务的状态，订阅时返回，由ClientsMux保护
+	events     eventLog             // 最近的广播事件，重连时补发，由ClientsMux保护
+	wsCounters wsCounters           // WebSocket统计
+	pongWait   time.Duration        // WebSocket心跳超时
+	jobSeq     atomic.Int64         // 任务ID的序号
+	batches    reprocessBatches     // 批量重新生成任务
+
+	config  func() *config.Config // 返回当前配置
+	handler http.Handler          // 路由和中间件
+	static  *staticFiles          // 前端文件，由listenMux保护
 }
 
-// BroadcastMessage 表示广播消息的结构
-type BroadcastMessage struct {
-	Type    string      `json:"type"`
-	Payload interface{} `json:"payload"`
+// Deps Web服务依赖的组件，由调用方创建并负责关闭
+type Deps struct {
+	DB       *storage.DBManager         // 历史记录、提示词配置和设备等数据
+	Pipeline *service.ScreenshotService // 截图处理服务，为nil时上传和截图接口不可用
+	App      *lifecycle.App             // 程序生命周期，为nil时不支持退出请求
+	Config   func() *config.Config      // 返回当前配置，为nil时使用config.GetConfig
 }
 
-// NewServer 创建一个新的Web服务器
-func NewServer(port int, dbPath string, baiduAPIKey string, baiduSecretKey string, deepSeekKey string, staticPath string) (*Server, error) {
-	// 初始化数据库管理器
-	dbManager, err := storage.NewDBManager(dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("初始化数据库失败: %v", err)
+// New 创建Web服务器，只注册路由，不监听端口
+// 调用Start开始监听，测试时可以直接把Handler交给httptest
+func New(deps Deps) *Server {
+	if deps.Config == nil {
+		deps.Config = config.GetConfig
 	}
-
-	// 创建OCR客户端
-	ocrClient := ocr.NewBaiduOCRProvider(baiduAPIKey, baiduSecretKey)
-
-	// 创建服务器
+	cfg := deps.Config()
 	server := &Server{
-		Port:        port,
-		DBManager:   dbManager,
-		OCRClient:   ocrClient,
-		DeepSeekKey: deepSeekKey,
-		StaticPath:  staticPath,
-		Clients:     make(map[*websocket.Conn]bool),
-		Broadcast:   make(chan *BroadcastMessage),
+		Port:              cfg.Port,
+		BindAddress:       cfg.BindAddress,
+		DBManager:         deps.DB,
+		StaticPath:        cfg.StaticPath,
+		ScreenshotService: deps.Pipeline,
+		App:               deps.App,
+		clients:           make(map[*subscriber]bool),
+		Broadcast:         make(chan *BroadcastMessage, broadcastBuffer),
+		pongWait:          pongWait,
+		events:            newEventLog(),
 		Upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // 允许所有跨域请求，生产环境中应该更严格
-			},
+			CheckOrigin: sameOrigin, // 只允许本站页面建立WebSocket连接
 		},
+		config: deps.Config,
 	}
+	server.handler = server.routes()
+	server.static = staticFilesFor(server.StaticPath)
 
 	// 启动广播处理协程
 	go server.handleBroadcasts()
 
-	return server, nil
+	return server
 }
 
-// StartServer 启动Web服务器
-func StartServer() (*Server, error) {
-	// 获取配置
-	cfg := config.GetConfig()
-
-	// 创建服务器实例
-	server, err := NewServer(
-		cfg.Port,           // 端口
-		cfg.DBPath,         // 数据库路径
-		cfg.BaiduAPIKey,    // 百度API密钥
-		cfg.BaiduSecretKey, // 百度Secret密钥
-		cfg.DeepSeekAPIKey, // DeepSeek API密钥
-		cfg.StaticPath,     // 静态文件路径
-	)
+// Handler 返回处理全部请求的http.Handler，包含路由和中间件
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Start 按当前配置的地址、端口和证书开始监听
+func (s *Server) Start() error {
+	tlsConfig, err := newTLSConfig(s.config())
 	if err != nil {
-		return nil, err
+		return err
 	}
+	s.listenMux.Lock()
+	defer s.listenMux.Unlock()
+	return s.listen(s.BindAddress, s.Port, tlsConfig)
+}
 
-	// 确保数据目录存在
-	err = os.MkdirAll(filepath.Dir("./data/screensage.db"), 0755)
+// listen 在指定地址和端口启动HTTP服务，tlsConfig不为nil时使用HTTPS，调用方需持有listenMux
+func (s *Server) listen(bind string, port int, tlsConfig *tls.Config) error {
+	addr := net.JoinHostPort(bind, strconv.Itoa(port))
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("监听 %s 失败: %v", addr, err)
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+		scheme = "https"
+	}
+	log.Printf("Web服务器启动，监听地址 %s://%s", scheme, addr)
+	if ip := net.ParseIP(bind); ip != nil && !ip.IsLoopback() {
+		log.Printf("警告: Web服务器监听在非本机地址 %s，局域网内的设备可以访问（仍需访问令牌）", bind)
 	}
 
-	// 注册路由
-	http.HandleFunc("/api/history", server.handleHistory)
-	http.HandleFunc("/api/upload", server.handleUpload)
-	http.HandleFunc("/api/exit", server.handleExit)
-	http.HandleFunc("/ws", server.handleWebSocket)
-
-	// 静态文件服务
-	http.Handle("/", http.FileServer(http.Dir(server.StaticPath)))
-
-	// 启动服务器
-	addr := fmt.Sprintf(":%d", server.Port)
-	log.Printf("Web服务器启动，监听端口 %s", addr)
-
-	// 启动HTTP服务器
+	httpServer := &http.Server{Handler: s.handler}
 	go func() {
-		if err := http.ListenAndServe(addr, nil); err != nil {
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Printf("HTTP服务器错误: %v", err)
 		}
 	}()
-
-	return server, nil
+	s.httpServer = httpServer
+	s.BindAddress = bind
+	s.Port = port
+	s.tlsConfig = tlsConfig
+	return nil
 }
 
-// handleBroadcasts 处理广播消息
-func (s *Server) handleBroadcasts() {
-	for {
-		msg := <-s.Broadcast
-
-		// 向所有连接的客户端发送消息
-		s.ClientsMux.Lock()
-		for client := range s.Clients {
-			err := client.WriteJSON(msg)
-			if err != nil {
-				log.Printf("发送消息失败: %v", err)
-				client.Close()
-				delete(s.Clients, client)
-			}
-		}
-		s.ClientsMux.Unlock()
+// SetAddress 切换监听地址和端口，新地址监听成功后才关闭旧的服务
+func (s *Server) SetAddress(bind string, port int) error {
+	s.listenMux.Lock()
+	defer s.listenMux.Unlock()
+	if bind == s.BindAddress && port == s.Port {
+		return nil
 	}
+	return s.relisten(bind, port, s.tlsConfig)
 }
 
-// handleWebSocket 处理WebSocket连接
-func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// 升级HTTP连接为WebSocket
-	conn, err := s.Upgrader.Upgrade(w, r, nil)
+// SetTLS 按配置启用、关闭HTTPS或更换证书，证书加载失败时保持原有服务
+func (s *Server) SetTLS(cfg *config.Config) error {
+	tlsConfig, err := newTLSConfig(cfg)
 	if err != nil {
-		log.Printf("WebSocket升级失败: %v", err)
-		return
+		return err
 	}
+	s.listenMux.Lock()
+	defer s.listenMux.Unlock()
+	return s.relisten(s.BindAddress, s.Port, tlsConfig)
+}
 
-	// 注册新客户端
-	s.ClientsMux.Lock()
-	s.Clients[conn] = true
-	s.ClientsMux.Unlock()
-
-	// 处理连接关闭
-	defer func() {
-		conn.Close()
-		s.ClientsMux.Lock()
-		delete(s.Clients, conn)
-		s.ClientsMux.Unlock()
-	}()
-
-	// 发送历史记录
-	records, err := s.DBManager.GetHistory(10) // 最近10条记录
-	if err == nil && len(records) > 0 {
-		conn.WriteJSON(&BroadcastMessage{
-			Type:    "history",
-			Payload: records,
-		})
+// relisten 启动新的服务后关闭旧的服务，调用方需持有listenMux
+// 地址不变时旧服务占用着端口，需要先关闭旧服务
+func (s *Server) relisten(bind string, port int, tlsConfig *tls.Config) error {
+	old := s.httpServer
+	if old != nil && bind == s.BindAddress && port == s.Port {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := old.Shutdown(ctx); err != nil {
+			log.Printf("关闭HTTP服务失败: %v", err)
+		}
+		old = nil
 	}
-
-	// 监听消息
-	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			break
+	if err := s.listen(bind, port, tlsConfig); err != nil {
+		// 旧服务已关闭时尝试按原有配置恢复
+		if s.httpServer != nil && old == nil {
+			if restoreErr := s.listen(s.BindAddress, s.Port, s.tlsConfig); restoreErr != nil {
+				log.Printf("恢复HTTP服务失败: %v", restoreErr)
+			}
+		}
+		return err
+	}
+	if old != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := old.Shutdown(ctx); err != nil {
+			log.Printf("关闭旧端口的HTTP服务失败: %v", err)
 		}
-		// 目前只是保持连接活跃，不处理客户端消息
 	}
+	return nil
+}
+
+// SetStaticPath 更换前端目录，为空时使用程序内嵌的前端
+func (s *Server) SetStaticPath(path string) {
+	static := staticFilesFor(path)
+	s.listenMux.Lock()
+	defer s.listenMux.Unlock()
+	s.StaticPath = path
+	s.static = static
+}
+
+// handleStatic 提供前端页面和资源
+func (s *Server) handleStatic(w http.ResponseWriter, r *http.Request) {
+	s.listenMux.Lock()
+	if s.static == nil {
+		s.static = staticFilesFor(s.StaticPath)
+	}
+	static := s.static
+	s.listenMux.Unlock()
+	static.ServeHTTP(w, r)
 }
 
 // handleHistory 处理获取历史记录的请求
@@ -200,225 +238,335 @@ func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(records)
 }
 
-// handleUpload 处理上传截图的请求
-// 此函数处理从前端上传的截图，执行OCR识别，然后将结果发送给DeepSeek进行分析
-// 整个处理过程是异步的，通过WebSocket向客户端发送进度更新
-func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+// handleHistoryRecord 处理获取单条历史记录的请求
+func (s *Server) handleHistoryRecord(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "记录ID无效", http.StatusBadRequest)
+		return
+	}
+	record, err := s.DBManager.GetHistoryByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "记录不存在", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+// handleCapture 处理截取当前屏幕的请求
+// 与快捷键截图相同，但可以指定处理模式和提示词配置
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
 	// 只允许POST请求
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 解析请求
-	var request struct {
-		Image string `json:"image"` // Base64编码的图像
+	// 解析请求，请求体可以为空
+	var request CapturePayload
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Printf("解析请求失败: %v", err)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		log.Printf("解析请求失败: %v", err)
-		http.Error(w, "Bad request", http.StatusBadRequest)
+	processID, err := s.capture(request.Mode, request.Profile)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 
-	// 验证图像数据
-	if request.Image == "" {
-		log.Printf("请求中没有图像数据")
-		http.Error(w, "No image data", http.StatusBadRequest)
-		return
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":     processID,
+		"status": "处理中",
+	})
+}
+
+// capture 截取当前屏幕并开始处理，返回任务ID
+func (s *Server) capture(modeName, profile string) (string, error) {
+	mode, err := service.ParsePipelineMode(modeName)
+	if err != nil {
+		return "", &protocolError{CodeBadRequest, err.Error()}
+	}
+	if s.ScreenshotService == nil {
+		return "", errServiceUnavailable
 	}
 
-	// 创建处理ID
-	processID := fmt.Sprintf("proc_%d", time.Now().UnixNano())
+	imgBytes, err := screenshot.CaptureScreen()
+	if err != nil {
+		log.Printf("截图失败: %v", err)
+		return "", fmt.Errorf("截图失败: %v", err)
+	}
 
-	// 通知客户端处理开始
-	s.Broadcast <- &BroadcastMessage{
-		Type: "process_start",
-		Payload: map[string]string{
-			"id":     processID,
-			"status": "开始处理图像...",
-		},
+	processID, ok := s.startProcessing(imgBytes, mode, profile)
+	if !ok {
+		return "", errShuttingDown
+	}
+	return processID, nil
+}
+
+// startProcessing 异步处理截图并通过WebSocket推送进度，返回处理ID
+// 程序正在退出时不再处理，返回false
+func (s *Server) startProcessing(imgBytes []byte, mode service.PipelineMode, profile string) (string, bool) {
+	ctx, done, ok := s.startJob()
+	if !ok {
+		return "", false
 	}
 
+	// 创建处理ID，批量上传时同一时刻会开始多个任务，加上序号避免重复
+	processID := fmt.Sprintf("proc_%d_%d", time.Now().UnixNano(), s.jobSeq.Add(1))
+
+	// 通知客户端处理开始
+	s.broadcastJob(processID, TypeProcessStart, JobStarted{ID: processID, Status: "开始处理图像..."})
+
 	// 异步处理图像
 	go func() {
-		// 调用OCR服务
-		log.Printf("开始OCR识别，处理ID: %s", processID)
-		text, err := s.OCRClient.RecognizeText(request.Image)
+		defer done()
+		log.Printf("开始处理图像，处理ID: %s", processID)
+		screenshot, err := s.ScreenshotService.ProcessScreenshotWithOptions(imgBytes, service.ProcessOptions{
+			Mode:          mode,
+			Profile:       profile,
+			Context:       ctx,
+			OnOCRComplete: s.ocrProgress(processID),
+		})
 		if err != nil {
-			log.Printf("OCR识别失败: %v", err)
+			log.Printf("处理图像失败: %v", err)
 
 			// 通知客户端处理失败
-			s.Broadcast <- &BroadcastMessage{
-				Type: "process_error",
-				Payload: map[string]string{
-					"id":    processID,
-					"error": fmt.Sprintf("OCR识别失败: %v", err),
-				},
-			}
+			s.broadcastError(processID, err)
 			return
 		}
 
-		// 通知客户端OCR完成
+		// 通知客户端处理完成
+		s.broadcastComplete(processID, screenshot)
+	}()
+
+	return processID, true
+}
+
+// ocrProgress 返回OCR完成时通知客户端的回调
+func (s *Server) ocrProgress(processID string) func(text string) {
+	return func(text string) {
 		log.Printf("OCR识别完成，处理ID: %s，文本长度: %d", processID, len(text))
-		s.Broadcast <- &BroadcastMessage{
-			Type: "ocr_complete",
-			Payload: map[string]string{
-				"id":     processID,
-				"text":   text,
-				"status": "OCR识别完成，正在处理内容...",
-			},
-		}
+		s.broadcastJob(processID, TypeOCRComplete, OCRCompleted{
+			ID:     processID,
+			Text:   text,
+			Status: "OCR识别完成，正在处理内容...",
+		})
+	}
+}
+
+// startJob 向生命周期登记一个截图处理任务，未设置生命周期时总是允许
+func (s *Server) startJob() (context.Context, func(), bool) {
+	if s.App == nil {
+		return context.Background(), func() {}, true
+	}
+	return s.App.Track()
+}
+
+// Shutdown 停止接收新请求并断开WebSocket连接，在ctx期限内等待正在处理的请求
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.listenMux.Lock()
+	httpServer := s.httpServer
+	s.httpServer = nil
+	s.listenMux.Unlock()
 
-		// 调用DeepSeek处理文本
-		log.Printf("开始调用DeepSeek处理文本，处理ID: %s", processID)
-		//answer, err := ocr.ProcessWithDeepSeek(text, s.DeepSeekKey)
-		answer, err := "", nil
+	// WebSocket连接已被接管，SSE连接不会主动结束，http.Server.Shutdown不会关闭这些连接
+	s.ClientsMux.Lock()
+	for client := range s.clients {
+		client.close(websocket.CloseGoingAway, "服务器正在退出")
+	}
+	s.ClientsMux.Unlock()
+
+	if httpServer == nil {
+		return nil
+	}
+	return httpServer.Shutdown(ctx)
+}
+
+// handlePrompts 处理提示词配置的查询和修改
+// GET 返回全部配置；PUT 接受单个配置或配置数组，校验模板后保存
+func (s *Server) handlePrompts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		profiles, err := s.DBManager.ListPromptProfiles()
 		if err != nil {
-			log.Printf("DeepSeek处理失败: %v", err)
-			answer = "AI处理失败，但您仍然可以查看OCR识别的文本。"
+			log.Printf("获取提示词配置失败: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profiles)
+
+	case http.MethodPut:
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		var profiles []storage.PromptProfile
+		if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+			if err := json.Unmarshal(raw, &profiles); err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
 		} else {
-			log.Printf("DeepSeek处理成功，处理ID: %s，回答长度: %d", processID, len(answer))
+			var profile storage.PromptProfile
+			if err := json.Unmarshal(raw, &profile); err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			profiles = append(profiles, profile)
 		}
-		// 读取answer的最后一行
-		title := strings.TrimSpace(strings.Split(answer, "\n")[len(strings.Split(answer, "\n"))-1])
-		ans := strings.TrimSpace(strings.Split(answer, "\n")[len(strings.Split(answer, "\n"))-2])
-		// 保存历史记录
-		record := &storage.HistoryRecord{
-			Timestamp: time.Now(),
-			ImagePath: "", // TODO: 保存图像文件
-			Thumbnail: request.Image,
-			Text:      text,
-			Answer:    ans,
-			Title: sql.NullString{
-				String: title,
-				Valid:  true,
-			},
+
+		// 全部校验通过后再保存，避免只保存了一部分
+		for _, profile := range profiles {
+			if err := prompt.Validate(profile); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		saved := make([]*storage.PromptProfile, 0, len(profiles))
+		for _, profile := range profiles {
+			result, err := s.DBManager.SavePromptProfile(profile)
+			if err != nil {
+				log.Printf("保存提示词配置失败: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			saved = append(saved, result)
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(saved)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUsage 处理查询用量的请求
+// 查询参数 from、to 为闭区间的日期（格式 2006-01-02），默认返回最近30天
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	// 只允许GET请求
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-		id, err := s.DBManager.AddHistory(record)
+	to := usage.StartOfDay(time.Now())
+	if value := r.URL.Query().Get("to"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
 		if err != nil {
-			log.Printf("保存历史记录失败: %v", err)
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
 		}
-
-		// 通知客户端处理完成
-		s.Broadcast <- &BroadcastMessage{
-			Type: "process_complete",
-			Payload: map[string]interface{}{
-				"id":         id,
-				"process_id": processID,
-				"text":       text,
-				"answer":     answer,
-				"timestamp":  time.Now(),
-				"thumbnail":  request.Image, // 确保缩略图被传递到前端
-				"title":      title,
-			},
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -29)
+	if value := r.URL.Query().Get("from"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return
 		}
-	}()
+		from = parsed
+	}
+	if from.After(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+
+	// 结束日期包含当天
+	end := to.AddDate(0, 0, 1)
+	days, err := s.DBManager.GetDailyUsage(from, end)
+	if err != nil {
+		log.Printf("查询用量失败: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	total := storage.UsageSummary{}
+	for _, day := range days {
+		total.Add(day)
+	}
+	if days == nil {
+		days = []storage.UsageSummary{}
+	}
 
-	// 立即返回处理ID
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"id":     processID,
-		"status": "处理中",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":  from.Format("2006-01-02"),
+		"to":    to.Format("2006-01-02"),
+		"days":  days,
+		"total": total,
 	})
 }
 
 // handleExit 处理退出应用的请求
 func (s *Server) handleExit(w http.ResponseWriter, r *http.Request) {
-	// 只允许GET请求
-	if r.Method != http.MethodGet {
+	// 只允许POST请求，防止通过链接或图片触发退出
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if s.App == nil {
+		http.Error(w, "Exit not supported", http.StatusServiceUnavailable)
+		return
+	}
+
 	// 返回成功响应
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 
-	// 延迟退出，确保响应已发送
-	go func() {
-		time.Sleep(100 * time.Millisecond)
-		log.Println("收到退出请求，应用即将关闭")
-		os.Exit(0)
-	}()
+	// 由生命周期按顺序关闭各组件，Web服务会等待本次响应发送完成
+	log.Println("收到退出请求，应用即将关闭")
+	s.App.Shutdown("收到退出请求")
 }
 
-// BroadcastScreenshot 广播截图到客户端
+// BroadcastScreenshot 广播已处理完成的截图到客户端
+// 截图已由截图服务完成识别、回答和保存，这里只负责推送结果
 func (s *Server) BroadcastScreenshot(screenshot *model.Screenshot) {
 	// 创建处理ID
 	processID := fmt.Sprintf("screenshot_%d", time.Now().UnixNano())
 
-	// 广播到客户端
-	s.Broadcast <- &BroadcastMessage{
-		Type: "process_start",
-		Payload: map[string]string{
-			"id":     processID,
-			"status": "开始处理截图...",
-		},
-	}
-
-	// 异步处理图像
-	go func() {
-		// 将图像转换为Base64
-		imageBase64 := screenshot.Thumbnail
-
-		// 调用OCR服务
-		text, err := s.OCRClient.RecognizeText(imageBase64)
-		if err != nil {
-			log.Printf("OCR识别失败: %v", err)
+	s.broadcastComplete(processID, screenshot)
+}
 
-			// 通知客户端处理失败
-			s.Broadcast <- &BroadcastMessage{
-				Type: "process_error",
-				Payload: map[string]string{
-					"id":    processID,
-					"error": fmt.Sprintf("OCR识别失败: %v", err),
-				},
-			}
-			return
-		}
+// BroadcastError 向客户端推送截图处理失败的消息
+func (s *Server) BroadcastError(err error) {
+	processID := fmt.Sprintf("screenshot_%d", time.Now().UnixNano())
 
-		// 通知客户端OCR完成
-		s.Broadcast <- &BroadcastMessage{
-			Type: "ocr_complete",
-			Payload: map[string]string{
-				"id":     processID,
-				"text":   text,
-				"status": "OCR识别完成，正在处理内容...",
-			},
-		}
+	s.broadcastError(processID, err)
+}
 
-		// 保存历史记录
-		record := &storage.HistoryRecord{
-			Timestamp: time.Now(),
-			ImagePath: "", // TODO: 保存图像文件
-			Thumbnail: imageBase64,
-			Text:      screenshot.Text,
-			Answer:    screenshot.Answer,
-			Title: sql.NullString{
-				String: screenshot.Title,
-				Valid:  true,
-			},
-		}
+// broadcastError 向客户端推送处理失败的消息，附带错误码
+func (s *Server) broadcastError(processID string, err error) {
+	s.broadcastJob(processID, TypeProcessError, JobFailed{
+		ID:    processID,
+		Error: fmt.Sprintf("处理图像失败: %v", err),
+		Code:  errorCode(err),
+	})
+}
 
-		id, err := s.DBManager.AddHistory(record)
-		if err != nil {
-			log.Printf("保存历史记录失败: %v", err)
-		}
+// broadcastComplete 向客户端推送处理完成的消息
+func (s *Server) broadcastComplete(processID string, screenshot *model.Screenshot) {
+	s.broadcastJob(processID, TypeProcessComplete, jobCompleted(processID, screenshot))
+}
 
-		// 通知客户端处理完成
-		s.Broadcast <- &BroadcastMessage{
-			Type: "process_complete",
-			Payload: map[string]interface{}{
-				"id":         id,
-				"process_id": processID,
-				"text":       text,
-				"answer":     screenshot.Answer,
-				"timestamp":  time.Now(),
-				"thumbnail":  imageBase64,
-				"title":      screenshot.Title,
-			},
-		}
-	}()
+// broadcastJob 推送任务的进度消息
+func (s *Server) broadcastJob(processID, typ string, payload interface{}) {
+	msg := newMessage(typ, payload)
+	msg.job = processID
+	s.Broadcast <- msg
 }
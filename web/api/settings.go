@@ -0,0 +1,133 @@
+package api
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/doctor"
+)
+
+// settingsPage 设置页面，不依赖前端构建产物
+//
+//go:embed settings.html
+var settingsPage []byte
+
+// settingsErrorResponse 设置校验失败时的响应
+type settingsErrorResponse struct {
+	Error  string             `json:"error"`
+	Fields config.FieldErrors `json:"fields"`
+}
+
+// handleSettingsPage 返回设置页面
+func (s *Server) handleSettingsPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(settingsPage)
+}
+
+// handleSettings 读取或修改配置
+// GET 返回全部配置项，密钥已遮盖；PUT 接收 {名称: 值} 对象，只修改提交的配置项
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(config.Settings())
+
+	case http.MethodPut:
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		values := make(map[string]string, len(body))
+		errs := config.FieldErrors{}
+		for name, value := range body {
+			text, err := settingValue(value)
+			if err != nil {
+				errs[name] = err.Error()
+				continue
+			}
+			values[name] = text
+		}
+		// 提示词配置须已存在于数据库中
+		if name, ok := values["default_prompt_profile"]; ok && name != "" {
+			if _, err := s.DBManager.GetPromptProfile(name); errors.Is(err, sql.ErrNoRows) {
+				errs["default_prompt_profile"] = fmt.Sprintf("提示词配置不存在: %s", name)
+			} else if err != nil {
+				log.Printf("获取提示词配置失败: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		for name, msg := range config.Validate(values) {
+			errs[name] = msg
+		}
+		if len(errs) > 0 {
+			writeFieldErrors(w, errs)
+			return
+		}
+
+		// 全部校验通过后再保存
+		err := config.Update(values)
+		var fieldErrs config.FieldErrors
+		if errors.As(err, &fieldErrs) {
+			writeFieldErrors(w, fieldErrs)
+			return
+		}
+		if err != nil {
+			log.Printf("保存配置失败: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(config.Settings())
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSettingsTest 检查当前配置中各服务商的密钥和网络连接
+func (s *Server) handleSettingsTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report := doctor.NewChecker(s.currentConfig()).Run()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// writeFieldErrors 返回按配置项列出的校验错误
+func writeFieldErrors(w http.ResponseWriter, errs config.FieldErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(settingsErrorResponse{Error: "配置校验失败", Fields: errs})
+}
+
+// settingValue 将JSON中的值转换为配置项的字符串形式
+func settingValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("值应为字符串、数字或布尔值")
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/prompt"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+func TestHandleSettings(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.SeedPromptProfiles(prompt.DefaultProfiles()); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{DBManager: db}
+
+	put := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleSettings(w, httptest.NewRequest(http.MethodPut, "/api/settings", strings.NewReader(body)))
+		return w
+	}
+
+	// 每个出错的配置项都有对应的错误信息
+	w := put(`{"port": 70000, "default_prompt_profile": "missing", "language": ["x"]}`)
+	var failed settingsErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&failed); err != nil || w.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d err=%v", w.Code, err)
+	}
+	for _, name := range []string{"port", "default_prompt_profile", "language"} {
+		if failed.Fields[name] == "" {
+			t.Errorf("%s 应当报错: %v", name, failed.Fields)
+		}
+	}
+
+	w = put(`{"port": 9001, "deepseek_api_key": "sk-0123456789abcdef"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "sk-0123456789abcdef") {
+		t.Error("响应中不应包含明文密钥")
+	}
+	if cfg := config.GetConfig(); cfg.Port != 9001 || cfg.DeepSeekAPIKey != "sk-0123456789abcdef" {
+		t.Errorf("配置未保存: port=%d", cfg.Port)
+	}
+}
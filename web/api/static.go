@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qujing226/screen_sage/web/frontend"
+)
+
+const (
+	// immutableCache 文件名带内容哈希的资源，内容变化时文件名也会变化，可以一直缓存
+	immutableCache = "public, max-age=31536000, immutable"
+	// revalidateCache 其他文件每次使用前都向服务器确认是否有变化
+	revalidateCache = "no-cache"
+	// gzipMinSize 小于该大小的文件不值得压缩
+	gzipMinSize = 1024
+)
+
+// hashedName 匹配Vite输出的带内容哈希的文件名，例如 index-26c9247a.js
+var hashedName = regexp.MustCompile(`-[A-Za-z0-9_-]{8,}\.[A-Za-z0-9]+$`)
+
+// staticFiles 提供前端文件
+// 不存在的页面路径回退到 index.html 交给前端路由处理，
+// 优先返回构建时生成的 .br、.gz 预压缩文件（见 web/frontend/precompress.mjs），
+// 没有预压缩文件时内嵌的前端在首次请求时压缩并缓存
+type staticFiles struct {
+	fsys     fs.FS
+	embedded bool // 内嵌的文件不会变化，可以缓存ETag和压缩结果
+
+	mutex sync.Mutex
+	cache map[string]*staticFile
+}
+
+// staticFile 一个文件的某种编码
+type staticFile struct {
+	data     []byte
+	etag     string
+	encoding string // 为空表示未压缩
+}
+
+// newStaticFiles 创建前端文件服务
+func newStaticFiles(fsys fs.FS, embedded bool) *staticFiles {
+	return &staticFiles{fsys: fsys, embedded: embedded, cache: make(map[string]*staticFile)}
+}
+
+// staticFilesFor 返回前端文件服务，dir为空或不存在时使用程序内嵌的前端
+func staticFilesFor(dir string) *staticFiles {
+	if dir != "" {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			log.Printf("使用前端目录 %s", dir)
+			return newStaticFiles(os.DirFS(dir), false)
+		}
+		log.Printf("前端目录 %s 不存在，使用内嵌的前端", dir)
+	}
+	return newStaticFiles(frontend.Dist(), true)
+}
+
+// ServeHTTP 返回请求的文件
+func (sf *staticFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+	if info, err := fs.Stat(sf.fsys, name); err != nil || info.IsDir() {
+		// 带扩展名的路径是缺失的资源，返回HTML会让浏览器按脚本或样式解析出错
+		if err != nil && path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		name = "index.html"
+	}
+
+	file, err := sf.open(name, r.Header.Get("Accept-Encoding"))
+	if err != nil {
+		log.Printf("读取前端文件 %s 失败: %v", name, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	header := w.Header()
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(file.data)
+	}
+	header.Set("Content-Type", contentType)
+	header.Set("Vary", "Accept-Encoding")
+	header.Set("ETag", file.etag)
+	if file.encoding != "" {
+		header.Set("Content-Encoding", file.encoding)
+	}
+	if hashedName.MatchString(name) {
+		header.Set("Cache-Control", immutableCache)
+	} else {
+		header.Set("Cache-Control", revalidateCache)
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(file.data))
+}
+
+// open 按客户端支持的编码返回文件内容
+func (sf *staticFiles) open(name, acceptEncoding string) (*staticFile, error) {
+	if acceptsEncoding(acceptEncoding, "br") {
+		if file, err := sf.load(name+".br", "br"); err == nil {
+			return file, nil
+		}
+	}
+	if acceptsEncoding(acceptEncoding, "gzip") {
+		if file, err := sf.load(name+".gz", "gzip"); err == nil {
+			return file, nil
+		}
+		if sf.embedded {
+			if file, err := sf.gzip(name); err == nil && file != nil {
+				return file, nil
+			}
+		}
+	}
+	return sf.load(name, "")
+}
+
+// load 读取文件并计算ETag，内嵌的文件会被缓存
+func (sf *staticFiles) load(name, encoding string) (*staticFile, error) {
+	if sf.embedded {
+		sf.mutex.Lock()
+		file, ok := sf.cache[name]
+		sf.mutex.Unlock()
+		if ok {
+			return file, nil
+		}
+	}
+	data, err := fs.ReadFile(sf.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	file := &staticFile{data: data, etag: etag(data, encoding), encoding: encoding}
+	if sf.embedded {
+		sf.mutex.Lock()
+		sf.cache[name] = file
+		sf.mutex.Unlock()
+	}
+	return file, nil
+}
+
+// gzip 压缩内嵌的文件并缓存，文件太小或压缩后没有变小时返回nil
+func (sf *staticFiles) gzip(name string) (*staticFile, error) {
+	key := name + "\x00gzip"
+	sf.mutex.Lock()
+	file, ok := sf.cache[key]
+	sf.mutex.Unlock()
+	if ok {
+		return file, nil
+	}
+
+	original, err := sf.load(name, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(original.data) >= gzipMinSize {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		zw.Write(original.data)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if buf.Len() < len(original.data) {
+			file = &staticFile{data: buf.Bytes(), etag: etag(original.data, "gzip"), encoding: "gzip"}
+		}
+	}
+	sf.mutex.Lock()
+	sf.cache[key] = file
+	sf.mutex.Unlock()
+	return file, nil
+}
+
+// etag 根据内容生成ETag，不同编码的内容使用不同的ETag
+func etag(data []byte, encoding string) string {
+	sum := sha256.Sum256(data)
+	tag := hex.EncodeToString(sum[:8])
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return strconv.Quote(tag)
+}
+
+// acceptsEncoding 检查Accept-Encoding中是否接受指定的编码，q=0表示不接受
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		value, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		q, err := strconv.ParseFloat(value, 64)
+		return err == nil && q > 0
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/qujing226/screen_sage/web/frontend"
+)
+
+func TestStaticFiles(t *testing.T) {
+	script := strings.Repeat("console.log('screensage');\n", 100)
+	sf := newStaticFiles(fstest.MapFS{
+		"index.html":                   {Data: []byte("<!DOCTYPE html><title>ScreenSage</title>")},
+		"assets/index-26c9247a.js":     {Data: []byte(script)},
+		"assets/index-26c9247a.css":    {Data: []byte("body{}")},
+		"assets/index-26c9247a.css.br": {Data: []byte("br")},
+	}, true)
+	get := func(target, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		sf.ServeHTTP(w, r)
+		return w
+	}
+
+	// 前端路由的路径回退到 index.html，index.html 每次都需要确认
+	for _, target := range []string{"/", "/history/12", "/settings/"} {
+		w := get(target, "")
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || w.Header().Get("Cache-Control") != revalidateCache {
+			t.Errorf("%s: status=%d type=%s cache=%s", target, w.Code, w.Header().Get("Content-Type"), w.Header().Get("Cache-Control"))
+		}
+	}
+
+	// 缺失的资源返回404而不是HTML
+	if w := get("/assets/missing-12345678.js", ""); w.Code != http.StatusNotFound {
+		t.Errorf("缺失的资源: status=%d", w.Code)
+	}
+
+	// 带哈希的资源长期缓存
+	w := get("/assets/index-26c9247a.js", "")
+	if !strings.Contains(w.Header().Get("Content-Type"), "javascript") || w.Header().Get("Cache-Control") != immutableCache || w.Body.String() != script {
+		t.Errorf("脚本: type=%s cache=%s", w.Header().Get("Content-Type"), w.Header().Get("Cache-Control"))
+	}
+
+	// 支持gzip时返回压缩后的内容
+	w = get("/assets/index-26c9247a.js", "gzip, deflate")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("gzip: encoding=%s", w.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != script {
+		t.Error("gzip内容不正确")
+	}
+
+	// 优先使用预压缩的brotli文件，q=0表示不接受
+	if w := get("/assets/index-26c9247a.css", "gzip, br"); w.Header().Get("Content-Encoding") != "br" || w.Body.String() != "br" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("brotli: encoding=%s type=%s", w.Header().Get("Content-Encoding"), w.Header().Get("Content-Type"))
+	}
+	if w := get("/assets/index-26c9247a.css", "br;q=0"); w.Header().Get("Content-Encoding") != "" || w.Body.String() != "body{}" {
+		t.Errorf("q=0: encoding=%s", w.Header().Get("Content-Encoding"))
+	}
+
+	// ETag未变化时返回304
+	etag := get("/", "").Header().Get("ETag")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	sf.ServeHTTP(w, r)
+	if etag == "" || w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: etag=%s status=%d", etag, w.Code)
+	}
+
+	// 程序内嵌了前端编译产物
+	w = httptest.NewRecorder()
+	staticFilesFor("").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	index, err := fs.ReadFile(frontend.Dist(), "index.html")
+	if err != nil || w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), index) {
+		t.Errorf("内嵌前端: status=%d err=%v", w.Code, err)
+	}
+}
+
+func TestEmbeddedPrecompressed(t *testing.T) {
+	// 内嵌的前端带有构建时生成的预压缩文件，修改前端后需要重新执行 npm run build
+	dist := frontend.Dist()
+	entries, err := fs.ReadDir(dist, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var assets []string
+	for _, entry := range entries {
+		if ext := path.Ext(entry.Name()); ext == ".js" || ext == ".css" {
+			assets = append(assets, "assets/"+entry.Name())
+		}
+	}
+	if len(assets) == 0 {
+		t.Fatal("内嵌的前端没有脚本和样式文件")
+	}
+	for _, name := range assets {
+		for _, suffix := range []string{".br", ".gz"} {
+			if _, err := fs.Stat(dist, name+suffix); err != nil {
+				t.Errorf("缺少预压缩文件 %s%s", name, suffix)
+			}
+		}
+	}
+
+	sf := newStaticFiles(dist, true)
+	if file, err := sf.open(assets[0], "gzip, br"); err != nil || file.encoding != "br" {
+		t.Errorf("内嵌文件应返回brotli: %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"path/filepath"
+
+	"github.com/qujing226/screen_sage/internal/certs"
+	"github.com/qujing226/screen_sage/internal/config"
+)
+
+// CertManager 根据配置创建证书管理器，自动生成的证书保存在配置目录的 tls 子目录中
+func CertManager(cfg *config.Config) (*certs.Manager, error) {
+	return certs.NewManager(filepath.Join(config.Dirs().Config, "tls"), cfg.TLSCertFile, cfg.TLSKeyFile)
+}
+
+// newTLSConfig 根据配置创建HTTPS配置，未启用HTTPS时返回nil
+// 启动时先加载一次证书，配置错误时直接报错而不是等到第一次请求
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if !cfg.TLSEnabled {
+		return nil, nil
+	}
+	manager, err := CertManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := manager.Certificate(); err != nil {
+		return nil, fmt.Errorf("加载HTTPS证书失败: %v", err)
+	}
+	return manager.TLSConfig(), nil
+}
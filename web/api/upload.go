@@ -0,0 +1,327 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/qujing226/screen_sage/application/service"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// maxImageSize 单张图片的最大字节数
+	maxImageSize = 10 << 20
+	// maxUploadSize 一次上传请求的最大字节数
+	maxUploadSize = 50 << 20
+	// maxUploadImages 一次最多上传的图片数
+	maxUploadImages = 10
+	// maxImagePixels 图片的最大像素数，避免解码时占用过多内存
+	maxImagePixels = 50_000_000
+	// fetchTimeout 按地址下载图片的最长时间
+	fetchTimeout = 15 * time.Second
+)
+
+// errImageTooLarge 图片超过 maxImageSize
+var errImageTooLarge = fmt.Errorf("图片超过 %d MB", maxImageSize>>20)
+
+// errPrivateAddress 图片地址指向本机、局域网或链路本地地址
+var errPrivateAddress = errors.New("不允许下载本机或局域网地址的图片")
+
+// fetchClient 按地址下载图片使用的客户端
+// 已配对的设备可以提交任意地址，只允许连接公网地址，避免借服务器访问本机和局域网；
+// 地址在DNS解析后检查，重定向的目标同样检查，不使用代理以免绕过检查
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: checkPublicAddress}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("图片地址无效: %s", req.URL)
+		}
+		if len(via) >= 5 {
+			return errors.New("重定向次数过多")
+		}
+		return nil
+	},
+}
+
+// UploadResult 上传接口的返回，每张图片对应一个任务
+type UploadResult struct {
+	ID     string   `json:"id"` // 第一张图片的任务ID，兼容只上传一张图片的客户端
+	Status string   `json:"status"`
+	Jobs   []string `json:"jobs"` // 每张图片的任务ID，与上传顺序相同
+}
+
+// uploadRequest JSON格式的上传请求，image 和 url 二选一
+type uploadRequest struct {
+	Image   string `json:"image"`   // Base64编码的图像，可以带 data URL 前缀
+	URL     string `json:"url"`     // 图片地址，由服务器下载
+	Mode    string `json:"mode"`    // 处理模式: ocr、vision、auto，为空时使用默认模式
+	Profile string `json:"profile"` // 提示词配置名称，为空时使用默认配置
+}
+
+// handleUpload 处理上传截图的请求
+// 支持 multipart/form-data 上传一个或多个文件（mode、profile 为表单字段）、
+// 请求体直接为 PNG、JPEG 或 WebP 图片（mode、profile 为查询参数），
+// 以及 JSON 格式的 Base64 图片或图片地址。图片校验后与快捷键截图一样保存和处理，
+// 每张图片对应一个任务，进度通过WebSocket推送
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var images [][]byte
+	var request uploadRequest
+	var err error
+	switch mediaType {
+	case "multipart/form-data":
+		var fields url.Values
+		if images, fields, err = readMultipart(r); err == nil && len(images) == 0 {
+			err = errors.New("没有上传图片")
+		}
+		request.Mode = fields.Get("mode")
+		request.Profile = fields.Get("profile")
+	case "image/png", "image/jpeg", "image/webp":
+		var data []byte
+		if data, err = io.ReadAll(io.LimitReader(r.Body, maxImageSize+1)); err == nil {
+			var img []byte
+			if img, err = decodeImage(data); err == nil {
+				images = [][]byte{img}
+			}
+		}
+		request.Mode = r.URL.Query().Get("mode")
+		request.Profile = r.URL.Query().Get("profile")
+	case "application/json", "":
+		images, request, err = readJSONUpload(r)
+	default:
+		http.Error(w, "不支持的请求格式: "+mediaType, http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		log.Printf("读取上传的图片失败: %v", err)
+		uploadError(w, err)
+		return
+	}
+
+	mode, err := service.ParsePipelineMode(request.Mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if s.ScreenshotService == nil {
+		http.Error(w, "Screenshot service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	result := UploadResult{Status: "处理中"}
+	for _, imgBytes := range images {
+		processID, ok := s.startProcessing(imgBytes, mode, request.Profile)
+		if !ok {
+			break
+		}
+		result.Jobs = append(result.Jobs, processID)
+	}
+	if len(result.Jobs) == 0 {
+		http.Error(w, "程序正在退出", http.StatusServiceUnavailable)
+		return
+	}
+	result.ID = result.Jobs[0]
+
+	// 立即返回处理ID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// uploadError 返回读取请求失败的原因，超出大小限制时为413，其他为400
+func uploadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("请求超过 %d MB", maxUploadSize>>20), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errImageTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// readMultipart 读取表单中的全部文件并逐个校验，同时返回其他字段
+func readMultipart(r *http.Request) ([][]byte, url.Values, error) {
+	fields := url.Values{}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fields, err
+	}
+	var images [][]byte
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fields, err
+		}
+		data, err := io.ReadAll(io.LimitReader(part, maxImageSize+1))
+		if err != nil {
+			return nil, fields, err
+		}
+		if part.FileName() == "" {
+			fields.Add(part.FormName(), string(data))
+			continue
+		}
+		if len(images) == maxUploadImages {
+			return nil, fields, fmt.Errorf("一次最多上传 %d 张图片", maxUploadImages)
+		}
+		img, err := decodeImage(data)
+		if err != nil {
+			return nil, fields, fmt.Errorf("%s: %w", part.FileName(), err)
+		}
+		images = append(images, img)
+	}
+	return images, fields, nil
+}
+
+// readJSONUpload 读取JSON格式的上传请求，按地址上传时下载图片
+func readJSONUpload(r *http.Request) ([][]byte, uploadRequest, error) {
+	var request uploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, request, fmt.Errorf("解析请求失败: %v", err)
+	}
+
+	var data []byte
+	switch {
+	case request.Image != "" && request.URL != "":
+		return nil, request, errors.New("image 和 url 只能指定一个")
+	case request.Image != "":
+		decoded, err := decodeBase64Image(request.Image)
+		if err != nil {
+			return nil, request, err
+		}
+		data = decoded
+	case request.URL != "":
+		fetched, err := fetchImage(r.Context(), request.URL)
+		if err != nil {
+			return nil, request, err
+		}
+		data = fetched
+	default:
+		return nil, request, errors.New("请求中没有图像数据")
+	}
+
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, request, err
+	}
+	return [][]byte{img}, request, nil
+}
+
+// decodeBase64Image 解码Base64图片，可以带 data URL 前缀
+func decodeBase64Image(imageBase64 string) ([]byte, error) {
+	if idx := strings.Index(imageBase64, ","); strings.HasPrefix(imageBase64, "data:") && idx != -1 {
+		imageBase64 = imageBase64[idx+1:]
+	}
+	data, err := base64.StdEncoding.DecodeString(imageBase64)
+	if err != nil {
+		return nil, fmt.Errorf("解码图像数据失败: %v", err)
+	}
+	return data, nil
+}
+
+// decodeImage 校验并解码图片，返回交给截图服务的数据
+// PNG 和 JPEG 原样返回，WebP 转换为 PNG，以便OCR和视觉模型识别
+func decodeImage(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("图片为空")
+	}
+	if len(data) > maxImageSize {
+		return nil, errImageTooLarge
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "png" && format != "jpeg" && format != "webp") {
+		return nil, errors.New("无法识别的图片格式，只支持 PNG、JPEG 和 WebP")
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("图片尺寸 %dx%d 超出限制", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("图片数据已损坏: %v", err)
+	}
+	if format != "webp" {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("转换图片格式失败: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fetchImage 按地址下载图片，只支持 http 和 https
+func fetchImage(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("图片地址无效: %s", rawURL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("图片地址无效: %v", err)
+	}
+	resp, err := fetchClient.Do(req)
+	if errors.Is(err, errPrivateAddress) {
+		return nil, errPrivateAddress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("下载图片失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载图片失败: %s", resp.Status)
+	}
+	if resp.ContentLength > maxImageSize {
+		return nil, errImageTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("下载图片失败: %v", err)
+	}
+	return data, nil
+}
+
+// checkPublicAddress 在建立连接前检查解析后的地址，拒绝本机、局域网、链路本地和未指定地址
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// sharedAddressSpace 运营商级NAT使用的地址段，同样不属于公网
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
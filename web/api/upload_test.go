@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qujing226/screen_sage/application/service"
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/prompt"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+// webpImage 1x1的无损WebP图片
+const webpImage = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func TestUpload(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.SeedPromptProfiles(prompt.DefaultProfiles()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未配置视觉模型，任务很快失败并保存记录，不会调用外部接口
+	pipeline := service.NewScreenshotService(db, nil, nil, "")
+	pipeline.ImageDir = filepath.Join(dir, "images")
+	s := New(Deps{DB: db, Pipeline: pipeline})
+	upload := func(target, contentType string, body io.Reader) (*httptest.ResponseRecorder, UploadResult) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, target, body)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		var result UploadResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w, result
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var pngData, jpegData bytes.Buffer
+	png.Encode(&pngData, img)
+	jpeg.Encode(&jpegData, img, nil)
+	webpData, _ := base64.StdEncoding.DecodeString(webpImage)
+
+	// multipart上传多个文件，每个文件一个任务
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("mode", "vision")
+	for name, data := range map[string][]byte{"a.png": pngData.Bytes(), "b.jpg": jpegData.Bytes(), "c.webp": webpData} {
+		fw, _ := mw.CreateFormFile("files", name)
+		fw.Write(data)
+	}
+	mw.Close()
+	w, result := upload("/api/upload", mw.FormDataContentType(), &form)
+	if w.Code != http.StatusOK || len(result.Jobs) != 3 || result.ID != result.Jobs[0] {
+		t.Fatalf("multipart: status=%d body=%s", w.Code, w.Body)
+	}
+	if result.Jobs[0] == result.Jobs[1] {
+		t.Errorf("任务ID重复: %v", result.Jobs)
+	}
+
+	// 请求体直接为图片
+	if w, result := upload("/api/upload?mode=vision", "image/png", bytes.NewReader(pngData.Bytes())); w.Code != http.StatusOK || len(result.Jobs) != 1 {
+		t.Errorf("原始图片: status=%d body=%s", w.Code, w.Body)
+	}
+
+	// JSON中的Base64图片和图片地址
+	body := `{"mode":"vision","image":"data:image/png;base64,` + base64.StdEncoding.EncodeToString(pngData.Bytes()) + `"}`
+	if w, _ := upload("/api/upload", "application/json", strings.NewReader(body)); w.Code != http.StatusOK {
+		t.Errorf("Base64: status=%d body=%s", w.Code, w.Body)
+	}
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(jpegData.Bytes())
+	}))
+	defer images.Close()
+	// 不允许下载本机地址的图片，测试服务器监听在本机，换用不检查地址的客户端
+	if w, _ := upload("/api/upload", "application/json", strings.NewReader(`{"url":"`+images.URL+`/a.jpg"}`)); w.Code != http.StatusBadRequest ||
+		!strings.Contains(w.Body.String(), errPrivateAddress.Error()) {
+		t.Errorf("本机地址: status=%d body=%s", w.Code, w.Body)
+	}
+	for _, address := range []string{"127.0.0.1:80", "[::1]:443", "10.0.0.8:80", "192.168.1.1:80", "169.254.169.254:80", "0.0.0.0:80", "100.64.0.1:80"} {
+		if checkPublicAddress("tcp", address, nil) == nil {
+			t.Errorf("%s 应被拒绝", address)
+		}
+	}
+	if err := checkPublicAddress("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("公网地址: %v", err)
+	}
+	defer func(client *http.Client) { fetchClient = client }(fetchClient)
+	fetchClient = images.Client()
+	if w, _ := upload("/api/upload", "application/json", strings.NewReader(`{"mode":"vision","url":"`+images.URL+`/a.jpg"}`)); w.Code != http.StatusOK {
+		t.Errorf("图片地址: status=%d body=%s", w.Code, w.Body)
+	}
+
+	// 图片和请求不合法时返回错误
+	for name, c := range map[string]struct {
+		contentType, body string
+		status            int
+	}{
+		"非图片":    {"image/png", "not an image", http.StatusBadRequest},
+		"损坏的图片":  {"image/png", string(pngData.Bytes()[:pngData.Len()-20]), http.StatusBadRequest},
+		"下载失败":   {"application/json", `{"url":"` + images.URL + `/missing.png"}`, http.StatusBadRequest},
+		"不支持的协议": {"application/json", `{"url":"file:///etc/passwd"}`, http.StatusBadRequest},
+		"没有图片":   {"application/json", `{"mode":"vision"}`, http.StatusBadRequest},
+		"不支持的格式": {"text/plain", "hello", http.StatusUnsupportedMediaType},
+		"图片过大":   {"image/png", strings.Repeat("x", maxImageSize+1), http.StatusRequestEntityTooLarge},
+	} {
+		if w, _ := upload("/api/upload", c.contentType, strings.NewReader(c.body)); w.Code != c.status {
+			t.Errorf("%s: status=%d, 期望 %d, body=%s", name, w.Code, c.status, w.Body)
+		}
+	}
+
+	// 图片与快捷键截图保存在同一目录，扩展名与格式一致，WebP转换为PNG
+	var files []string
+	for deadline := time.Now().Add(5 * time.Second); len(files) < 6 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		files, _ = filepath.Glob(filepath.Join(pipeline.ImageDir, "*"))
+	}
+	var jpegs int
+	for _, file := range files {
+		if filepath.Ext(file) == ".jpg" {
+			jpegs++
+		}
+	}
+	if len(files) != 6 || jpegs != 2 {
+		t.Errorf("保存的图片: %v", files)
+	}
+}
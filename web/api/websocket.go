@@ -0,0 +1,506 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+const (
+	// writeWait 向客户端写入一条消息的最长时间
+	writeWait = 10 * time.Second
+	// sendQueueSize 每个客户端发送队列的长度，队列满时断开该客户端
+	sendQueueSize = 64
+	// broadcastBuffer 广播通道的缓冲长度
+	broadcastBuffer = 256
+	// pongWait 超过该时间没有收到客户端的任何消息或pong时断开连接，每隔其9/10发送一次ping
+	pongWait = 60 * time.Second
+	// maxCommandSize 客户端命令的最大长度
+	maxCommandSize = 64 * 1024
+	// initialHistory 连接建立后发送的历史记录条数
+	initialHistory = 10
+	// defaultHistoryLimit history 命令默认每页条数
+	defaultHistoryLimit = 20
+	// maxHistoryLimit history 命令每页最多条数
+	maxHistoryLimit = 100
+	// maxJobStates 保留状态的最近任务数
+	maxJobStates = 200
+)
+
+// 任务状态
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobError   = "error"
+)
+
+// subscriber 一个接收广播的WebSocket或SSE连接
+// 消息先放入发送队列，由单独的写协程发送，慢速客户端不会阻塞广播和其他客户端
+type subscriber struct {
+	conn      *websocket.Conn        // WebSocket连接，SSE连接为nil
+	addr      string                 // 客户端地址
+	device    int64                  // 配对设备的ID，本机连接为0
+	queue     chan *BroadcastMessage // 发送队列
+	done      chan struct{}          // 连接关闭时关闭
+	closeOnce sync.Once
+
+	// 以下字段由Server.ClientsMux保护
+	filtered bool            // 订阅过任务后只接收订阅的任务的进度
+	jobs     map[string]bool // 订阅的任务
+	types    map[string]bool // 只接收这些类型的消息，为nil时接收全部类型
+}
+
+// newSubscriber 创建客户端，conn为nil表示SSE连接
+func newSubscriber(conn *websocket.Conn, r *http.Request) *subscriber {
+	return &subscriber{
+		conn:   conn,
+		addr:   r.RemoteAddr,
+		device: requestDevice(r),
+		queue:  make(chan *BroadcastMessage, sendQueueSize),
+		done:   make(chan struct{}),
+		jobs:   make(map[string]bool),
+	}
+}
+
+// enqueue 把消息放入发送队列，队列已满或连接已关闭时返回false，不会阻塞
+func (c *subscriber) enqueue(msg *BroadcastMessage) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.queue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// close 关闭连接，code不为0时先向WebSocket连接发送关闭帧，可以重复调用
+// SSE连接的处理函数在done关闭后返回
+func (c *subscriber) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.conn == nil {
+			return
+		}
+		if code != 0 {
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+		}
+		c.conn.Close()
+	})
+}
+
+// wants 判断客户端是否需要这条消息，调用方需持有ClientsMux
+func (c *subscriber) wants(msg *BroadcastMessage) bool {
+	if c.types != nil && !c.types[msg.Type] {
+		return false
+	}
+	return msg.job == "" || !c.filtered || c.jobs[msg.job]
+}
+
+// WSStats WebSocket和SSE连接及消息的统计
+type WSStats struct {
+	Clients   int   `json:"clients"`   // 当前连接数
+	Connected int64 `json:"connected"` // 累计建立的连接数
+	Sent      int64 `json:"sent"`      // 已发送的消息数
+	Dropped   int64 `json:"dropped"`   // 因发送队列已满而丢弃的消息数
+	Evicted   int64 `json:"evicted"`   // 因发送队列已满而断开的连接数
+}
+
+// wsCounters WebSocket统计计数
+type wsCounters struct {
+	connected atomic.Int64
+	sent      atomic.Int64
+	dropped   atomic.Int64
+	evicted   atomic.Int64
+}
+
+// WSStats 返回WebSocket和SSE连接及消息的统计
+func (s *Server) WSStats() WSStats {
+	s.ClientsMux.Lock()
+	clients := len(s.clients)
+	s.ClientsMux.Unlock()
+	return WSStats{
+		Clients:   clients,
+		Connected: s.wsCounters.connected.Load(),
+		Sent:      s.wsCounters.sent.Load(),
+		Dropped:   s.wsCounters.dropped.Load(),
+		Evicted:   s.wsCounters.evicted.Load(),
+	}
+}
+
+// handleWSStats 返回WebSocket统计
+func (s *Server) handleWSStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.WSStats())
+}
+
+// jobStates 记录最近任务的状态，超出数量时丢弃最早的任务
+type jobStates struct {
+	states map[string]string
+	order  []string
+}
+
+// update 根据任务消息更新状态
+func (j *jobStates) update(msg *BroadcastMessage) {
+	if msg.job == "" {
+		return
+	}
+	var state string
+	switch msg.Type {
+	case TypeProcessStart, TypeOCRComplete:
+		state = jobRunning
+	case TypeProcessComplete:
+		state = jobDone
+	case TypeProcessError:
+		state = jobError
+	case TypeReprocess:
+		state = jobRunning
+		if status, ok := msg.Payload.(ReprocessStatus); ok && status.Finished {
+			state = jobDone
+		}
+	default:
+		return
+	}
+	if j.states == nil {
+		j.states = make(map[string]string)
+	}
+	if _, ok := j.states[msg.job]; !ok {
+		j.order = append(j.order, msg.job)
+		if len(j.order) > maxJobStates {
+			delete(j.states, j.order[0])
+			j.order = j.order[1:]
+		}
+	}
+	j.states[msg.job] = state
+}
+
+// handleBroadcasts 把广播放入订阅了消息的客户端的发送队列，发送队列已满的客户端会被断开
+func (s *Server) handleBroadcasts() {
+	for msg := range s.Broadcast {
+		s.ClientsMux.Lock()
+		s.jobStates.update(msg)
+		s.events.append(msg)
+		for client := range s.clients {
+			if client.wants(msg) && !client.enqueue(msg) {
+				s.evict(client)
+			}
+		}
+		s.ClientsMux.Unlock()
+	}
+}
+
+// evict 断开发送队列已满的客户端，调用方需持有ClientsMux
+func (s *Server) evict(client *subscriber) {
+	if !s.clients[client] {
+		return
+	}
+	delete(s.clients, client)
+	s.wsCounters.evicted.Add(1)
+	s.wsCounters.dropped.Add(int64(1 + len(client.queue)))
+	log.Printf("客户端 %s 接收过慢，发送队列已满，断开连接", client.addr)
+	// 发送关闭帧最多等待1秒，不在持有锁时等待
+	go client.close(websocket.CloseTryAgainLater, "发送队列已满")
+}
+
+// writePump 发送队列中的消息并定时发送ping，写入失败或超时时关闭连接
+func (s *Server) writePump(client *subscriber) {
+	ticker := time.NewTicker(s.pongWait * 9 / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-client.queue:
+			if err := s.write(client, msg); err != nil {
+				log.Printf("发送消息失败: %v", err)
+				client.close(0, "")
+				return
+			}
+		case <-ticker.C:
+			if err := client.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				client.close(0, "")
+				return
+			}
+		case <-client.done:
+			return
+		}
+	}
+}
+
+// write 向客户端写入一条消息，只能在写协程中或写协程启动前调用
+func (s *Server) write(client *subscriber, msg *BroadcastMessage) error {
+	client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := client.conn.WriteJSON(msg); err != nil {
+		return err
+	}
+	s.wsCounters.sent.Add(1)
+	return nil
+}
+
+// handleWebSocket 处理WebSocket连接
+// 连接建立后先发送 hello，带有 ?since=<seq> 时补发该序号之后的事件，
+// 否则或缺口过大时发送最近的历史记录快照，之后接收客户端命令并回复
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	since, resume, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 升级HTTP连接为WebSocket
+	conn, err := s.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket升级失败: %v", err)
+		return
+	}
+	client := newSubscriber(conn, r)
+
+	// 在同一次加锁中取出错过的事件并注册客户端，之后的事件进入发送队列，不会遗漏或重复
+	var missed []*BroadcastMessage
+	resumed := false
+	s.ClientsMux.Lock()
+	if resume {
+		missed, resumed = s.events.since(since)
+	}
+	latest := s.events.seq
+	s.clients[client] = true
+	s.ClientsMux.Unlock()
+	s.wsCounters.connected.Add(1)
+
+	// 处理连接关闭
+	defer func() {
+		client.close(0, "")
+		s.ClientsMux.Lock()
+		delete(s.clients, client)
+		s.ClientsMux.Unlock()
+	}()
+
+	// 写协程启动前直接写入，保证hello和补发的事件在新事件之前
+	messages := []*BroadcastMessage{newMessage(TypeHello, HelloPayload{
+		Version:  ProtocolVersion,
+		Commands: commands,
+		Seq:      latest,
+		Resumed:  resumed,
+	})}
+	if resumed {
+		messages = append(messages, missed...)
+	} else if page, err := s.historyPage(0, initialHistory); err == nil {
+		messages = append(messages, newMessage(TypeHistory, page))
+	}
+	for _, msg := range messages {
+		if err := s.write(client, msg); err != nil {
+			return
+		}
+	}
+	go s.writePump(client)
+
+	// 收到任何消息或pong都说明客户端仍然在线
+	conn.SetReadLimit(maxCommandSize)
+	conn.SetReadDeadline(time.Now().Add(s.pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(s.pongWait))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(s.pongWait))
+		if reply := s.handleCommand(client, data); reply != nil && !client.enqueue(reply) {
+			s.ClientsMux.Lock()
+			s.evict(client)
+			s.ClientsMux.Unlock()
+			break
+		}
+	}
+}
+
+// handleCommand 执行客户端命令，返回回复的消息
+func (s *Server) handleCommand(client *subscriber, data []byte) *BroadcastMessage {
+	var cmd Command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return errorMessage("", &protocolError{CodeBadRequest, "命令格式错误: " + err.Error()})
+	}
+	if cmd.Version > ProtocolVersion {
+		return errorMessage(cmd.ID, &protocolError{CodeUnsupportedVersion, fmt.Sprintf("服务器只支持协议版本 %d", ProtocolVersion)})
+	}
+
+	typ, payload, err := s.runCommand(client, cmd)
+	if err != nil {
+		return errorMessage(cmd.ID, err)
+	}
+	reply := newMessage(typ, payload)
+	reply.RequestID = cmd.ID
+	return reply
+}
+
+// runCommand 按类型执行命令，返回回复的类型和内容
+func (s *Server) runCommand(client *subscriber, cmd Command) (string, interface{}, error) {
+	switch cmd.Type {
+	case CommandCapture:
+		var p CapturePayload
+		if err := decodePayload(cmd.Payload, &p); err != nil {
+			return "", nil, err
+		}
+		job, err := s.capture(p.Mode, p.Profile)
+		if err != nil {
+			return "", nil, err
+		}
+		s.subscribe(client, job, false)
+		return TypeJob, JobAccepted{Job: job}, nil
+
+	case CommandAsk:
+		var p AskPayload
+		if err := decodePayload(cmd.Payload, &p); err != nil {
+			return "", nil, err
+		}
+		job, err := s.ask(p.RecordID, p.Question)
+		if err != nil {
+			return "", nil, err
+		}
+		s.subscribe(client, job, false)
+		return TypeJob, JobAccepted{Job: job}, nil
+
+	case CommandSubscribe:
+		var p SubscribePayload
+		if err := decodePayload(cmd.Payload, &p); err != nil {
+			return "", nil, err
+		}
+		if p.Job == "" {
+			return "", nil, &protocolError{CodeBadRequest, "缺少任务ID"}
+		}
+		s.ClientsMux.Lock()
+		state, ok := s.jobStates.states[p.Job]
+		s.ClientsMux.Unlock()
+		if !ok {
+			return "", nil, &protocolError{CodeNotFound, "任务不存在或已过期: " + p.Job}
+		}
+		s.subscribe(client, p.Job, true)
+		return TypeSubscribed, Subscription{Job: p.Job, State: state}, nil
+
+	case CommandUnsubscribe:
+		var p SubscribePayload
+		if err := decodePayload(cmd.Payload, &p); err != nil {
+			return "", nil, err
+		}
+		s.ClientsMux.Lock()
+		if p.Job == "" {
+			client.filtered = false
+			client.jobs = make(map[string]bool)
+		} else {
+			delete(client.jobs, p.Job)
+		}
+		all := !client.filtered
+		s.ClientsMux.Unlock()
+		return TypeSubscribed, Subscription{Job: p.Job, All: all}, nil
+
+	case CommandHistory:
+		var p HistoryRequest
+		if err := decodePayload(cmd.Payload, &p); err != nil {
+			return "", nil, err
+		}
+		if p.Before < 0 || p.Limit < 0 {
+			return "", nil, &protocolError{CodeBadRequest, "before 和 limit 不能为负数"}
+		}
+		if p.Limit == 0 {
+			p.Limit = defaultHistoryLimit
+		}
+		if p.Limit > maxHistoryLimit {
+			p.Limit = maxHistoryLimit
+		}
+		page, err := s.historyPage(p.Before, p.Limit)
+		if err != nil {
+			return "", nil, err
+		}
+		return TypeHistory, page, nil
+
+	default:
+		return "", nil, &protocolError{CodeUnknownCommand, "不支持的命令: " + cmd.Type}
+	}
+}
+
+// subscribe 订阅任务的进度，only为true时之后只接收订阅的任务
+func (s *Server) subscribe(client *subscriber, job string, only bool) {
+	s.ClientsMux.Lock()
+	defer s.ClientsMux.Unlock()
+	client.jobs[job] = true
+	if only {
+		client.filtered = true
+	}
+}
+
+// ask 开始针对已有记录的追问，返回任务ID，进度和结果通过任务消息推送
+func (s *Server) ask(recordID int64, question string) (string, error) {
+	if strings.TrimSpace(question) == "" {
+		return "", &protocolError{CodeBadRequest, "追问内容不能为空"}
+	}
+	if s.ScreenshotService == nil {
+		return "", errServiceUnavailable
+	}
+	if _, err := s.DBManager.GetHistoryByID(recordID); errors.Is(err, sql.ErrNoRows) {
+		return "", &protocolError{CodeNotFound, fmt.Sprintf("记录 %d 不存在", recordID)}
+	} else if err != nil {
+		return "", err
+	}
+
+	_, done, ok := s.startJob()
+	if !ok {
+		return "", errShuttingDown
+	}
+	processID := fmt.Sprintf("ask_%d", time.Now().UnixNano())
+	s.broadcastJob(processID, TypeProcessStart, JobStarted{ID: processID, Status: "正在生成回答..."})
+	go func() {
+		defer done()
+		screenshot, err := s.ScreenshotService.FollowUp(recordID, question)
+		if err != nil {
+			log.Printf("追问失败: %v", err)
+			s.broadcastError(processID, err)
+			return
+		}
+		s.broadcastComplete(processID, screenshot)
+	}()
+	return processID, nil
+}
+
+// historyPage 获取一页历史记录
+func (s *Server) historyPage(before int64, limit int) (*HistoryPage, error) {
+	// 多取一条判断是否还有更早的记录
+	records, err := s.DBManager.GetHistoryPage(before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &HistoryPage{Records: records}
+	if len(records) > limit {
+		page.Records = records[:limit]
+		page.HasMore = true
+	}
+	if page.Records == nil {
+		page.Records = []storage.HistoryRecord{}
+	}
+	return page, nil
+}
+
+// decodePayload 解析命令参数，没有参数时保持零值
+func decodePayload(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return &protocolError{CodeBadRequest, "命令参数错误: " + err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,433 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/qujing226/screen_sage/application/service"
+	"github.com/qujing226/screen_sage/internal/config"
+	"github.com/qujing226/screen_sage/internal/storage"
+)
+
+func TestProtocolSchema(t *testing.T) {
+	var schema struct {
+		Defs map[string]struct {
+			Properties map[string]struct {
+				Enum []string `json:"enum"`
+			} `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(protocolSchema, &schema); err != nil {
+		t.Fatalf("JSON Schema无效: %v", err)
+	}
+
+	// 消息类型和命令与代码一致
+	types := []string{TypeHello, TypeHistory, TypeProcessStart, TypeOCRComplete, TypeProcessComplete,
+		TypeProcessError, TypeReprocess, TypeJob, TypeSubscribed, TypeError}
+	if got := schema.Defs["ServerMessage"].Properties["type"].Enum; !sameSet(got, types) {
+		t.Errorf("消息类型 = %v, 期望 %v", got, types)
+	}
+	if got := schema.Defs["Command"].Properties["type"].Enum; !sameSet(got, commands) {
+		t.Errorf("命令 = %v, 期望 %v", got, commands)
+	}
+
+	// 每种payload的字段与结构体的JSON字段一致
+	for name, v := range map[string]interface{}{
+		"HelloPayload": HelloPayload{}, "HistoryPage": HistoryPage{}, "JobStarted": JobStarted{},
+		"OCRCompleted": OCRCompleted{}, "JobCompleted": JobCompleted{}, "JobFailed": JobFailed{}, "ReprocessStatus": ReprocessStatus{},
+		"JobAccepted": JobAccepted{}, "Subscription": Subscription{}, "ErrorPayload": ErrorPayload{},
+		"CapturePayload": CapturePayload{}, "AskPayload": AskPayload{}, "SubscribePayload": SubscribePayload{},
+		"HistoryRequest": HistoryRequest{}, "HistoryRecord": storage.HistoryRecord{},
+	} {
+		def, ok := schema.Defs[name]
+		if !ok {
+			t.Errorf("JSON Schema中缺少 %s", name)
+			continue
+		}
+		var props []string
+		for prop := range def.Properties {
+			props = append(props, prop)
+		}
+		if fields := jsonFields(reflect.TypeOf(v)); !sameSet(props, fields) {
+			t.Errorf("%s 的字段 = %v, 期望 %v", name, props, fields)
+		}
+	}
+}
+
+func TestWebSocketCommands(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := db.AddHistory(&storage.HistoryRecord{Timestamp: time.Now(), Answer: "回答"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New(Deps{DB: db, Pipeline: service.NewScreenshotService(db, nil, nil, "")})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws",
+		http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	type message struct {
+		Version   int             `json:"v"`
+		Type      string          `json:"type"`
+		RequestID string          `json:"request_id"`
+		Payload   json.RawMessage `json:"payload"`
+	}
+	read := func() message {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	call := func(cmd string) message {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(cmd)); err != nil {
+			t.Fatal(err)
+		}
+		return read()
+	}
+	errorCodeOf := func(msg message) string {
+		var payload ErrorPayload
+		json.Unmarshal(msg.Payload, &payload)
+		return payload.Code
+	}
+
+	// 连接后先收到hello，再收到最近的历史记录
+	if msg := read(); msg.Type != TypeHello || msg.Version != ProtocolVersion {
+		t.Fatalf("第一条消息: %+v", msg)
+	}
+	if msg := read(); msg.Type != TypeHistory {
+		t.Fatalf("第二条消息: %+v", msg)
+	}
+
+	// 分页获取历史记录，回复带有命令的ID
+	msg := call(`{"v":1,"id":"h1","type":"history","payload":{"limit":2}}`)
+	var page HistoryPage
+	json.Unmarshal(msg.Payload, &page)
+	if msg.Type != TypeHistory || msg.RequestID != "h1" || len(page.Records) != 2 || !page.HasMore {
+		t.Fatalf("第一页: %+v %+v", msg, page)
+	}
+	msg = call(`{"id":"h2","type":"history","payload":{"before":` + strconv.FormatInt(page.Records[1].ID, 10) + `,"limit":2}}`)
+	json.Unmarshal(msg.Payload, &page)
+	if msg.RequestID != "h2" || len(page.Records) != 1 || page.HasMore {
+		t.Fatalf("第二页: %+v", page)
+	}
+
+	// 错误以带错误码的消息返回
+	for cmd, code := range map[string]string{
+		`not json`:                                                          CodeBadRequest,
+		`{"id":"x","type":"dance"}`:                                         CodeUnknownCommand,
+		`{"v":2,"id":"x","type":"history"}`:                                 CodeUnsupportedVersion,
+		`{"id":"x","type":"history","payload":{"limit":"2"}}`:               CodeBadRequest,
+		`{"id":"x","type":"subscribe","payload":{"job":"proc_1"}}`:          CodeNotFound,
+		`{"id":"x","type":"ask","payload":{"record_id":99,"question":"?"}}`: CodeNotFound,
+		`{"id":"x","type":"ask","payload":{"record_id":1,"question":" "}}`:  CodeBadRequest,
+	} {
+		if msg := call(cmd); msg.Type != TypeError || errorCodeOf(msg) != code {
+			t.Errorf("%s: %+v", cmd, msg)
+		}
+	}
+
+	// 订阅任务后只接收该任务的进度
+	s.broadcastJob("job_1", TypeProcessStart, JobStarted{ID: "job_1", Status: "开始"})
+	if msg := read(); msg.Type != TypeProcessStart {
+		t.Fatalf("未订阅时应收到全部任务: %+v", msg)
+	}
+	msg = call(`{"id":"s1","type":"subscribe","payload":{"job":"job_1"}}`)
+	var sub Subscription
+	json.Unmarshal(msg.Payload, &sub)
+	if msg.Type != TypeSubscribed || sub.State != jobRunning || sub.All {
+		t.Fatalf("订阅: %+v %+v", msg, sub)
+	}
+	s.broadcastJob("job_2", TypeProcessStart, JobStarted{ID: "job_2", Status: "开始"})
+	s.broadcastJob("job_1", TypeProcessError, JobFailed{ID: "job_1", Error: "失败", Code: CodeInternal})
+	msg = read()
+	var failed JobFailed
+	json.Unmarshal(msg.Payload, &failed)
+	if msg.Type != TypeProcessError || failed.ID != "job_1" {
+		t.Errorf("应只收到订阅的任务: %+v", msg)
+	}
+	msg = call(`{"id":"u1","type":"unsubscribe"}`)
+	json.Unmarshal(msg.Payload, &sub)
+	if msg.Type != TypeSubscribed || !sub.All {
+		t.Errorf("取消订阅: %+v", msg)
+	}
+}
+
+func TestWebSocketBackpressure(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// start 启动服务器，返回建立连接的函数
+	start := func(s *Server) func() *websocket.Conn {
+		ts := httptest.NewServer(s.Handler())
+		t.Cleanup(ts.Close)
+		return func() *websocket.Conn {
+			t.Helper()
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws",
+				http.Header{"Authorization": {"Bearer " + token}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { conn.Close() })
+			return conn
+		}
+	}
+	// waitFor 等待条件成立
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("等待%s超时", what)
+			}
+		}
+	}
+	// readAll 在后台读取消息，返回收到的消息类型，连接断开时关闭
+	readAll := func(conn *websocket.Conn) <-chan string {
+		received := make(chan string, 1000)
+		go func() {
+			defer close(received)
+			for {
+				var msg BroadcastMessage
+				if err := conn.ReadJSON(&msg); err != nil {
+					return
+				}
+				received <- msg.Type
+			}
+		}()
+		return received
+	}
+
+	// 读取消息的客户端会回复ping，超过心跳超时仍保持连接；不回复的客户端被断开
+	s := New(Deps{DB: db})
+	s.pongWait = 300 * time.Millisecond
+	dial := start(s)
+	var pings atomic.Int64
+	alive := dial()
+	alive.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return alive.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	readAll(alive)
+	dial()
+	waitFor("客户端连接", func() bool { return s.WSStats().Connected == 2 })
+	time.Sleep(3 * s.pongWait)
+	if stats := s.WSStats(); pings.Load() == 0 || stats.Clients != 1 {
+		t.Fatalf("心跳: pings=%d stats=%+v", pings.Load(), stats)
+	}
+
+	// 不读取消息的客户端不会阻塞广播，发送队列满后被断开，正常的客户端不受影响
+	s = New(Deps{DB: db})
+	dial = start(s)
+	received := readAll(dial())
+	dial()
+	waitFor("客户端连接", func() bool { return s.WSStats().Clients == 2 })
+	receive := func(typ string) {
+		t.Helper()
+		for {
+			select {
+			case got, ok := <-received:
+				if !ok {
+					t.Fatal("正常的客户端被断开")
+				}
+				if got == typ {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("正常的客户端没有收到 %s", typ)
+			}
+		}
+	}
+	status := strings.Repeat("x", 64*1024)
+	for i := 0; i < 1000 && s.WSStats().Evicted == 0; i++ {
+		s.broadcastJob("job_1", TypeProcessStart, JobStarted{ID: "job_1", Status: status})
+		receive(TypeProcessStart)
+	}
+	waitFor("断开慢速客户端", func() bool { return s.WSStats().Evicted > 0 })
+	if stats := s.WSStats(); stats.Evicted != 1 || stats.Dropped == 0 || stats.Clients != 1 || stats.Connected != 2 {
+		t.Fatalf("统计: %+v", stats)
+	}
+	s.broadcastJob("job_2", TypeProcessComplete, JobCompleted{ProcessID: "job_2"})
+	receive(TypeProcessComplete)
+
+	// 统计接口只允许本机访问
+	r := httptest.NewRequest(http.MethodGet, "/api/ws/stats", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	var got WSStats
+	if err := json.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || err != nil || got.Evicted != 1 {
+		t.Errorf("统计接口: status=%d body=%s", w.Code, w.Body)
+	}
+}
+
+func TestWebSocketReplay(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Options{Path: filepath.Join(dir, "config.json")})
+	token, err := config.EnsureAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := storage.NewDBManager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := New(Deps{DB: db})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	type message struct {
+		Type    string          `json:"type"`
+		Seq     int64           `json:"seq"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	dial := func(query string) (*websocket.Conn, HelloPayload) {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws"+query,
+			http.Header{"Authorization": {"Bearer " + token}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg message
+		var hello HelloPayload
+		if err := conn.ReadJSON(&msg); err != nil || msg.Type != TypeHello || json.Unmarshal(msg.Payload, &hello) != nil {
+			t.Fatalf("hello: %+v %v", msg, err)
+		}
+		return conn, hello
+	}
+	read := func(conn *websocket.Conn) message {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	// 广播的事件带有递增的序号
+	conn, hello := dial("")
+	if hello.Resumed || read(conn).Type != TypeHistory {
+		t.Fatalf("首次连接应收到历史记录快照: %+v", hello)
+	}
+	s.broadcastJob("job_1", TypeProcessStart, JobStarted{ID: "job_1"})
+	first := read(conn)
+	if first.Seq != hello.Seq+1 {
+		t.Fatalf("序号 = %d, 期望 %d", first.Seq, hello.Seq+1)
+	}
+	conn.Close()
+
+	// 断线期间的事件在重连时按顺序补发，之后的事件正常接收
+	s.broadcastJob("job_1", TypeOCRComplete, OCRCompleted{ID: "job_1"})
+	s.broadcastJob("job_1", TypeProcessComplete, JobCompleted{ProcessID: "job_1"})
+	conn, hello = dial("?since=" + strconv.FormatInt(first.Seq, 10))
+	defer conn.Close()
+	if !hello.Resumed || hello.Seq != first.Seq+2 {
+		t.Fatalf("重连: %+v", hello)
+	}
+	for i, typ := range []string{TypeOCRComplete, TypeProcessComplete} {
+		if msg := read(conn); msg.Type != typ || msg.Seq != first.Seq+int64(i)+1 {
+			t.Errorf("补发的第%d个事件: %+v", i+1, msg)
+		}
+	}
+	s.broadcastJob("job_2", TypeProcessStart, JobStarted{ID: "job_2"})
+	if msg := read(conn); msg.Seq != first.Seq+3 {
+		t.Errorf("新事件: %+v", msg)
+	}
+
+	// 序号过旧或不是本次启动分配的，发送历史记录快照
+	for _, since := range []int64{0, first.Seq + 100} {
+		conn, hello := dial("?since=" + strconv.FormatInt(since, 10))
+		if hello.Resumed || read(conn).Type != TypeHistory {
+			t.Errorf("since=%d: %+v", since, hello)
+		}
+		conn.Close()
+	}
+	r := httptest.NewRequest(http.MethodGet, "/ws?since=abc", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("无效的since: status=%d", w.Code)
+	}
+
+	// 只保留最近的maxEvents个事件
+	var el eventLog
+	for i := 0; i < maxEvents+10; i++ {
+		el.append(newMessage(TypeProcessStart, nil))
+	}
+	if _, ok := el.since(5); ok {
+		t.Error("已丢弃的事件不应补发")
+	}
+	if events, ok := el.since(10); !ok || len(events) != maxEvents || events[0].Seq != 11 {
+		t.Errorf("since(10): %d %v", len(events), ok)
+	}
+}
+
+// jsonFields 返回结构体导出字段的JSON名称
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// sameSet 判断两个字符串列表包含的元素是否相同
+func sameSet(a, b []string) bool {
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
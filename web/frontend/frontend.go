@@ -0,0 +1,21 @@
+// Package frontend 嵌入编译好的Vue前端，程序可以在任意目录启动
+package frontend
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// dist 是 npm run build 的产物，修改前端后需要重新编译再构建程序
+//
+//go:embed dist
+var dist embed.FS
+
+// Dist 返回前端编译产物，根目录即 dist 目录
+func Dist() fs.FS {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,54 @@
+import { brotliCompressSync, constants, gzipSync } from 'zlib'
+import { readdirSync, readFileSync, statSync, writeFileSync } from 'fs'
+import { extname, join, resolve } from 'path'
+
+// 需要预压缩的文本资源，图片和字体本身已经压缩过
+const extensions = new Set(['.html', '.js', '.css', '.svg', '.json'])
+// 小于该大小的文件不值得压缩，与 Go 服务中的 gzipMinSize 相同
+const minSize = 1024
+
+// compressDir 为目录中较大的文本资源生成 .br 和 .gz 文件，压缩后没有变小时不生成
+export function compressDir(dir) {
+  for (const name of readdirSync(dir)) {
+    const file = join(dir, name)
+    if (statSync(file).isDirectory()) {
+      compressDir(file)
+      continue
+    }
+    if (!extensions.has(extname(name))) {
+      continue
+    }
+    const data = readFileSync(file)
+    if (data.length < minSize) {
+      continue
+    }
+    const br = brotliCompressSync(data, {
+      params: {
+        [constants.BROTLI_PARAM_QUALITY]: constants.BROTLI_MAX_QUALITY,
+        [constants.BROTLI_PARAM_SIZE_HINT]: data.length
+      }
+    })
+    if (br.length < data.length) {
+      writeFileSync(file + '.br', br)
+    }
+    const gz = gzipSync(data, { level: 9 })
+    if (gz.length < data.length) {
+      writeFileSync(file + '.gz', gz)
+    }
+  }
+}
+
+// precompress 构建结束后生成预压缩文件，Go 服务按 Accept-Encoding 直接返回
+export default function precompress() {
+  let outDir
+  return {
+    name: 'precompress',
+    apply: 'build',
+    configResolved(config) {
+      outDir = resolve(config.root, config.build.outDir)
+    },
+    closeBundle() {
+      compressDir(outDir)
+    }
+  }
+}
@@ -1,13 +1,25 @@
 // WebSocket客户端实现
+// 消息格式见 web/api/protocol.schema.json，服务器可通过 GET /api/ws/schema 获取
+
+// 客户端使用的协议版本
+export const PROTOCOL_VERSION = 1;
+
+// 等待命令回复的最长时间
+const REQUEST_TIMEOUT = 30000;
 
 class WebSocketClient {
   constructor(url) {
-    this.url = url || `ws://${window.location.host}/ws`;
+    const scheme = window.location.protocol === 'https:' ? 'wss' : 'ws';
+    this.url = url || `${scheme}://${window.location.host}/ws`;
     this.socket = null;
     this.isConnected = false;
     this.reconnectAttempts = 0;
     this.maxReconnectAttempts = 5;
     this.reconnectInterval = 3000; // 3秒
+    this.nextRequestId = 1;
+    this.pending = new Map(); // 命令ID → { resolve, reject, timer }
+    this.serverVersion = null;
+    this.lastSeq = null; // 最后收到的事件序号，重连时补收之后的事件
     this.listeners = {
       message: [],
       connect: [],
@@ -17,7 +29,8 @@ class WebSocketClient {
       processStart: [],
       processComplete: [],
       processError: [],
-      ocrComplete: []
+      ocrComplete: [],
+      hello: []
     };
   }
 
@@ -28,7 +41,8 @@ class WebSocketClient {
     }
 
     try {
-      this.socket = new WebSocket(this.url);
+      const url = this.lastSeq === null ? this.url : `${this.url}?since=${this.lastSeq}`;
+      this.socket = new WebSocket(url);
 
       this.socket.onopen = () => {
         console.log('WebSocket连接已建立');
@@ -40,10 +54,39 @@ class WebSocketClient {
       this.socket.onmessage = (event) => {
         try {
           const data = JSON.parse(event.data);
+          if (data.seq) {
+            this.lastSeq = data.seq;
+          }
           this._trigger('message', data);
 
+          // 命令的回复交给等待中的请求
+          if (data.request_id && this.pending.has(data.request_id)) {
+            const { resolve, reject, timer } = this.pending.get(data.request_id);
+            clearTimeout(timer);
+            this.pending.delete(data.request_id);
+            if (data.type === 'error') {
+              const error = new Error(data.payload.message);
+              error.code = data.payload.code;
+              reject(error);
+            } else {
+              resolve(data.payload);
+            }
+            return;
+          }
+
           // 根据消息类型触发特定事件
           switch (data.type) {
+            case 'hello':
+              this.serverVersion = data.payload.version;
+              // 没有补发时随后会收到历史记录快照，从最新的序号继续
+              if (!data.payload.resumed) {
+                this.lastSeq = data.payload.seq;
+              }
+              if (this.serverVersion !== PROTOCOL_VERSION) {
+                console.warn(`服务器协议版本 ${this.serverVersion} 与客户端版本 ${PROTOCOL_VERSION} 不同`);
+              }
+              this._trigger('hello', data.payload);
+              break;
             case 'history':
               this._trigger('history', data.payload);
               break;
@@ -77,6 +120,7 @@ class WebSocketClient {
       this.socket.onclose = () => {
         console.log('WebSocket连接已关闭');
         this.isConnected = false;
+        this._rejectPending(new Error('WebSocket连接已关闭'));
         this._trigger('disconnect');
         this._attemptReconnect();
       };
@@ -126,6 +170,36 @@ class WebSocketClient {
     }
   }
 
+  // 发送命令并等待回复，回复为错误时Promise以带code的Error拒绝
+  // 例如 request('history', { before: 120, limit: 20 })、request('ask', { record_id: 12, question: '...' })
+  request(type, payload) {
+    if (!this.isConnected) {
+      return Promise.reject(new Error('WebSocket未连接'));
+    }
+    const id = `req_${this.nextRequestId++}`;
+    return new Promise((resolve, reject) => {
+      const timer = setTimeout(() => {
+        this.pending.delete(id);
+        reject(new Error(`命令 ${type} 超时`));
+      }, REQUEST_TIMEOUT);
+      this.pending.set(id, { resolve, reject, timer });
+      if (!this.send({ v: PROTOCOL_VERSION, id, type, payload })) {
+        clearTimeout(timer);
+        this.pending.delete(id);
+        reject(new Error('发送命令失败'));
+      }
+    });
+  }
+
+  // 连接断开时拒绝全部等待中的请求
+  _rejectPending(error) {
+    this.pending.forEach(({ reject, timer }) => {
+      clearTimeout(timer);
+      reject(error);
+    });
+    this.pending.clear();
+  }
+
   // 添加事件监听器
   on(event, callback) {
     if (this.listeners[event]) {